@@ -6,6 +6,7 @@ import (
 	"strings"
 	"soriano-mediadores/internal/api"
 	"soriano-mediadores/internal/auth"
+	"soriano-mediadores/internal/bots"
 	"soriano-mediadores/internal/db"
 	"soriano-mediadores/internal/scraper"
 
@@ -32,6 +33,15 @@ func main() {
 		log.Fatalf("❌ Error iniciando PostgreSQL: %v", err)
 	}
 
+	// Marcar importaciones que quedaron a medias en el último apagado
+	if n, err := api.RecoverInterruptedImports(); err != nil {
+		log.Printf("⚠️  Error recuperando import jobs: %v", err)
+	} else if n > 0 {
+		log.Printf("⚠️  %d import jobs marcados como interrumpidos", n)
+	}
+	// Y los de instancias que se caigan con esta en marcha
+	go api.WatchInterruptedImports()
+
 	if err := db.InitRedis(); err != nil {
 		log.Printf("⚠️  Error iniciando Redis: %v (continuando sin cache)", err)
 	}
@@ -43,7 +53,7 @@ func main() {
 	// Inicializar bots
 	log.Println("\n🤖 Inicializando bots AI...")
 	api.InitBots()
-	log.Println("✅ 6 bots inicializados correctamente")
+	log.Printf("✅ %d bots inicializados correctamente", len(bots.ListaBots()))
 
 	// Inicializar scraper GCO
 	log.Println("\n🔄 Inicializando scraper GCO...")
@@ -73,7 +83,7 @@ func main() {
 	app.Use(cors.New(cors.Config{
 		AllowOrigins: "*",
 		AllowMethods: "GET,POST,PUT,DELETE",
-		AllowHeaders: "Origin, Content-Type, Accept",
+		AllowHeaders: "Origin, Content-Type, Accept, Authorization, X-API-Key, X-Timestamp, X-Signature",
 	}))
 
 	// Rutas de autenticación (antes del middleware de auth)
@@ -100,18 +110,21 @@ func main() {
 	log.Println("   GET  /api/clientes?q=     - Buscar clientes")
 	log.Println("   GET  /api/clientes/:id    - Obtener cliente")
 	log.Println("   GET  /api/clientes/:id/polizas - Pólizas del cliente")
-	log.Println("   POST /api/chat/atencion   - Chat con Bot Atención")
-	log.Println("   POST /api/chat/cobranza   - Chat con Bot Cobranza")
-	log.Println("   POST /api/chat/siniestros - Chat con Bot Siniestros")
-	log.Println("   POST /api/chat/agente     - Chat con Bot Agente")
-	log.Println("   POST /api/chat/analista   - Chat con Bot Analista")
-	log.Println("   POST /api/chat/auditor    - Chat con Bot Auditor")
+	log.Println("   POST /api/chat            - Chat con enrutado automático al bot adecuado")
+	log.Println("   POST /api/chat/:bot       - Chat con un bot concreto (atencion, cobranza, ...)")
+	log.Println("   POST /api/chat/stream     - Chat en streaming (SSE; también /api/chat/:bot/stream)")
+	log.Println("   GET  /api/chat/ws         - Chat en streaming por WebSocket")
+	log.Println("   GET  /api/chat/sessions   - Conversaciones del usuario")
+	log.Println("   GET  /api/chat/sessions/:id - Transcripción de una conversación")
+	log.Println("   DELETE /api/chat/sessions/:id - Borrar una conversación")
 	log.Println("\n📥 Importación CSV:")
 	log.Println("   POST /api/admin/import/preview  - Previsualizar CSV")
 	log.Println("   POST /api/admin/import/start    - Iniciar importación")
 	log.Println("   GET  /api/admin/import/status/:id - Estado de importación")
 	log.Println("   GET  /api/admin/import/history  - Historial de importaciones")
+	log.Println("   GET  /api/admin/import/profiles - Perfiles de mapeo de columnas")
 	log.Println("\n🔗 N8N Webhooks:")
+	log.Println("   (con sesión o cabecera X-API-Key; claves en /api/api-keys)")
 	log.Println("   POST /api/n8n/cliente/creado    - Crear cliente desde N8N")
 	log.Println("   POST /api/n8n/poliza/creada     - Crear póliza desde N8N")
 	log.Println("   POST /api/n8n/cliente/consulta  - Consultar cliente desde N8N")
@@ -142,6 +155,9 @@ func main() {
 	log.Println("   POST /api/scraper/stop          - Detener scraper")
 	log.Println("   GET  /api/scraper/schedule      - Estado del scheduler")
 	log.Println("   POST /api/scraper/schedule      - Configurar scheduler")
+	log.Println("   GET  /api/scraper/schedules     - Schedules programados")
+	log.Println("   POST /api/scraper/schedules     - Crear schedule")
+	log.Println("   GET  /api/scraper/notifications - Avisos de ejecuciones programadas")
 	log.Println("   POST /api/scraper/test-login    - Probar login en GCO")
 	log.Println()
 
@@ -162,33 +178,44 @@ func setupRoutes(app *fiber.App) {
 	// API v1
 	v1 := app.Group("/api")
 
+	// Permisos por ruta según los roles del usuario (grupos de Entra ID)
+	clientesRead := auth.RequirePermission(auth.PermClientesRead)
+	clientesWrite := auth.RequirePermission(auth.PermClientesWrite)
+	statsRead := auth.RequirePermission(auth.PermStatsRead)
+	recobrosRead := auth.RequirePermission(auth.PermRecobrosRead)
+	recobrosSend := auth.RequirePermission(auth.PermRecobrosSend)
+
 	// Estadísticas
-	v1.Get("/stats", api.Estadisticas)
+	v1.Get("/stats", statsRead, api.Estadisticas)
 
 	// Bots
-	v1.Get("/bots", api.ListarBots)
+	v1.Get("/bots", auth.RequirePermission(auth.PermChat), api.ListarBots)
 
 	// Clientes - CRM
-	v1.Get("/clientes", api.BuscarClientes)
-	v1.Post("/clientes", api.CrearCliente)           // CRM - Crear nuevo cliente
-	v1.Get("/clientes/:id", api.ObtenerCliente)
-	v1.Put("/clientes/:id", api.ActualizarCliente)   // CRM - Actualizar cliente
-	v1.Get("/clientes/:id/polizas", api.ObtenerPolizasCliente)
+	v1.Get("/clientes", clientesRead, api.BuscarClientes)
+	v1.Post("/clientes", clientesWrite, api.CrearCliente) // CRM - Crear nuevo cliente
+	v1.Get("/clientes/:id", clientesRead, api.ObtenerCliente)
+	v1.Put("/clientes/:id", clientesWrite, api.ActualizarCliente) // CRM - Actualizar cliente
+	v1.Get("/clientes/:id/polizas", clientesRead, api.ObtenerPolizasCliente)
 
 	// Catálogos
-	v1.Get("/ramos", api.GetRamos)                   // Obtener tipos de póliza
+	v1.Get("/ramos", clientesRead, api.GetRamos) // Obtener tipos de póliza
 
 	// Chat con bots
-	chat := v1.Group("/chat")
-	chat.Post("/atencion", api.ChatBotAtencion)
-	chat.Post("/cobranza", api.ChatBotCobranza)
-	chat.Post("/siniestros", api.ChatBotSiniestros)
-	chat.Post("/agente", api.ChatBotAgente)
-	chat.Post("/analista", api.ChatBotAnalista)
-	chat.Post("/auditor", api.ChatBotAuditor)
+	chat := v1.Group("/chat", auth.RequirePermission(auth.PermChat))
+	chat.Post("", api.Chat) // Enrutador: elige el bot según el mensaje
+	chat.Get("/stream", api.ChatStream)
+	chat.Post("/stream", api.ChatStream)
+	chat.Get("/ws", api.ChatWebSocket)
+	chat.Get("/sessions", api.ListarConversaciones)
+	chat.Get("/sessions/:id", api.ObtenerConversacion)
+	chat.Delete("/sessions/:id", api.BorrarConversacion)
+	chat.Post("/:bot", api.Chat)
+	chat.Get("/:bot/stream", api.ChatStream)
+	chat.Post("/:bot/stream", api.ChatStream)
 
 	// Admin - CSV Import
-	admin := v1.Group("/admin")
+	admin := v1.Group("/admin", auth.RequirePermission(auth.PermImport))
 	importRoutes := admin.Group("/import")
 	importRoutes.Post("/preview", api.PreviewCSV)
 	importRoutes.Post("/start", api.StartImport)
@@ -198,9 +225,14 @@ func setupRoutes(app *fiber.App) {
 	importRoutes.Post("/revert/:id", api.RevertImport)
 	importRoutes.Post("/validate", api.ValidateImport)
 	importRoutes.Get("/template", api.GetImportTemplate)
+	importRoutes.Get("/profiles", api.GetImportProfiles)
+	importRoutes.Get("/profiles/:id", api.GetImportProfile)
+	importRoutes.Post("/profiles", api.CreateImportProfile)
+	importRoutes.Put("/profiles/:id", api.UpdateImportProfile)
+	importRoutes.Delete("/profiles/:id", api.DeleteImportProfile)
 
 	// N8N Webhooks
-	n8n := v1.Group("/n8n")
+	n8n := v1.Group("/n8n", auth.RequirePermission(auth.PermN8N))
 	n8n.Post("/cliente/creado", api.N8NClienteCreado)
 	n8n.Post("/poliza/creada", api.N8NPolizaCreada)
 	n8n.Post("/recibo/creado", api.N8NReciboCreado)
@@ -213,25 +245,31 @@ func setupRoutes(app *fiber.App) {
 	n8n.Post("/cliente/notificar", api.N8NNotificarCliente)
 	n8n.Post("/webhook", api.N8NWebhookGenerico)
 
+	// Claves de API para N8N y otras integraciones
+	apiKeys := v1.Group("/api-keys", auth.RequirePermission(auth.PermAPIKeysAdmin))
+	apiKeys.Get("/", auth.ListAPIKeysHandler)
+	apiKeys.Post("/", auth.CreateAPIKeyHandler)
+	apiKeys.Delete("/:id", auth.RevokeAPIKeyHandler)
+
 	// Recobros - Microsoft Graph Email
 	recobros := v1.Group("/recobros")
-	recobros.Post("/send-email", api.SendReciboEmail)
-	recobros.Post("/send-email-template", api.SendReciboEmailWithTemplate) // NUEVO - Enviar con plantilla
-	recobros.Post("/send-bulk", api.SendBulkReciboEmails)
-	recobros.Post("/test-email", api.SendTestEmail)
-	recobros.Get("/test-graph", api.TestGraphConnection)
-	recobros.Get("/templates", api.GetEmailTemplates)
-	recobros.Get("/devueltos", api.GetRecibosDevueltos)
-	recobros.Get("/clientes-deuda", api.GetClientesConDeuda)
+	recobros.Post("/send-email", recobrosSend, api.SendReciboEmail)
+	recobros.Post("/send-email-template", recobrosSend, api.SendReciboEmailWithTemplate) // NUEVO - Enviar con plantilla
+	recobros.Post("/send-bulk", recobrosSend, api.SendBulkReciboEmails)
+	recobros.Post("/test-email", recobrosSend, api.SendTestEmail)
+	recobros.Get("/test-graph", recobrosSend, api.TestGraphConnection)
+	recobros.Get("/templates", recobrosRead, api.GetEmailTemplates)
+	recobros.Get("/devueltos", recobrosRead, api.GetRecibosDevueltos)
+	recobros.Get("/clientes-deuda", recobrosRead, api.GetClientesConDeuda)
 
 	// Estadísticas y Analytics
-	v1.Get("/stats/general", api.GetStats)
-	v1.Get("/stats/recibos-devueltos", api.GetRecibosDevueltos)
-	v1.Get("/stats/clientes-deuda", api.GetClientesConDeuda)
-	v1.Get("/stats/recibos-kpi", api.GetRecibosKPI) // KPIs completos + historial
+	v1.Get("/stats/general", statsRead, api.GetStats)
+	v1.Get("/stats/recibos-devueltos", statsRead, api.GetRecibosDevueltos)
+	v1.Get("/stats/clientes-deuda", statsRead, api.GetClientesConDeuda)
+	v1.Get("/stats/recibos-kpi", statsRead, api.GetRecibosKPI) // KPIs completos + historial
 
 	// Analytics - Business Intelligence
-	analytics := v1.Group("/analytics")
+	analytics := v1.Group("/analytics", auth.RequirePermission(auth.PermAnalyticsRead))
 	analytics.Get("/financial-kpis", api.GetFinancialKPIs)
 	analytics.Get("/portfolio-analysis", api.GetPortfolioAnalysis)
 	analytics.Get("/collections-performance", api.GetCollectionsPerformance)
@@ -263,4 +301,7 @@ func setupAuthRoutes(app *fiber.App) {
 	authGroup.Get("/callback", auth.CallbackHandler)
 	authGroup.Get("/logout", auth.LogoutHandler)
 	authGroup.Get("/me", auth.MeHandler)
+	authGroup.Get("/sessions", auth.ListSessionsHandler)
+	authGroup.Delete("/sessions", auth.RevokeOtherSessionsHandler)
+	authGroup.Delete("/sessions/:id", auth.RevokeSessionHandler)
 }
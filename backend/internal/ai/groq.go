@@ -1,102 +1,86 @@
 package ai
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
+	"time"
 )
 
-type GroqRequest struct {
-	Model       string    `json:"model"`
-	Messages    []Message `json:"messages"`
-	Temperature float64   `json:"temperature"`
-	MaxTokens   int       `json:"max_tokens"`
-}
-
 type Message struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
+	// ToolCalls herramientas que pide el modelo (role assistant)
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	// ToolCallID llamada a la que responde un mensaje role tool
+	ToolCallID string `json:"tool_call_id,omitempty"`
 }
 
-type GroqResponse struct {
-	Choices []struct {
-		Message Message `json:"message"`
-	} `json:"choices"`
+// groqProvider Groq es compatible con OpenAI; la clave se lee en cada
+// llamada para avisar si falta
+type groqProvider struct {
+	OpenAICompatibleProvider
 }
 
-// ConsultarGroq realiza una consulta a la API de Groq
-func ConsultarGroq(prompt string, systemPrompt string, maxTokens int) (string, error) {
-	apiKey := os.Getenv("GROQ_API_KEY")
-	if apiKey == "" {
-		return "", fmt.Errorf("GROQ_API_KEY no configurada")
-	}
-
+// NewGroqProvider proveedor de la API de Groq (GROQ_API_KEY, GROQ_MODEL)
+func NewGroqProvider(timeout time.Duration, maxRetries int) LLMProvider {
 	model := os.Getenv("GROQ_MODEL")
 	if model == "" {
 		model = "llama-3.3-70b-versatile"
 	}
+	return &groqProvider{OpenAICompatibleProvider{
+		ProviderName: "groq",
+		BaseURL:      "https://api.groq.com/openai/v1",
+		Model:        model,
+		Timeout:      timeout,
+		MaxRetries:   maxRetries,
+	}}
+}
 
-	if maxTokens == 0 {
-		maxTokens = 1024
-	}
-
-	messages := []Message{
-		{Role: "system", Content: systemPrompt},
-		{Role: "user", Content: prompt},
-	}
-
-	reqBody := GroqRequest{
-		Model:       model,
-		Messages:    messages,
-		Temperature: 0.3,
-		MaxTokens:   maxTokens,
-	}
-
-	jsonData, err := json.Marshal(reqBody)
+func (g *groqProvider) Chat(ctx context.Context, req ChatRequest) (*ChatResponse, error) {
+	p, err := g.withKey()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	return p.Chat(ctx, req)
+}
 
-	req, err := http.NewRequest("POST", "https://api.groq.com/openai/v1/chat/completions", bytes.NewBuffer(jsonData))
+func (g *groqProvider) ChatStream(ctx context.Context, req ChatRequest, onDelta func(string)) (*ChatResponse, error) {
+	p, err := g.withKey()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	return p.ChatStream(ctx, req, onDelta)
+}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+apiKey)
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", err
+func (g *groqProvider) withKey() (*OpenAICompatibleProvider, error) {
+	apiKey := os.Getenv("GROQ_API_KEY")
+	if apiKey == "" {
+		return nil, fmt.Errorf("GROQ_API_KEY no configurada")
 	}
-	defer resp.Body.Close()
+	p := g.OpenAICompatibleProvider
+	p.APIKey = apiKey
+	return &p, nil
+}
 
-	body, err := io.ReadAll(resp.Body)
+// ConsultarGroq realiza una consulta a la API de Groq
+func ConsultarGroq(prompt string, systemPrompt string, maxTokens int) (string, error) {
+	providersOnce.Do(initProviders)
+	resp, err := providers["groq"].Chat(context.Background(), ChatRequest{
+		Messages: []Message{
+			{Role: "system", Content: systemPrompt},
+			{Role: "user", Content: prompt},
+		},
+		Temperature: 0.3,
+		MaxTokens:   maxTokens,
+	})
 	if err != nil {
 		return "", err
 	}
-
-	if resp.StatusCode != 200 {
-		return "", fmt.Errorf("Groq API error: %s", string(body))
-	}
-
-	var groqResp GroqResponse
-	if err := json.Unmarshal(body, &groqResp); err != nil {
-		return "", err
-	}
-
-	if len(groqResp.Choices) == 0 {
-		return "", fmt.Errorf("no se recibió respuesta de Groq")
-	}
-
-	return groqResp.Choices[0].Message.Content, nil
+	return resp.Content, nil
 }
 
-// ConsultarAI es un alias de ConsultarGroq para compatibilidad
+// ConsultarAI consulta al proveedor de LLM por defecto (LLM_PROVIDER)
 func ConsultarAI(prompt string, systemPrompt string) (string, error) {
-	return ConsultarGroq(prompt, systemPrompt, 1024)
+	return ConsultarAIBot("", prompt, systemPrompt)
 }
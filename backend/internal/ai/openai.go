@@ -0,0 +1,186 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OpenAICompatibleProvider cualquier API con /chat/completions al estilo de
+// OpenAI: Groq, llama.cpp server, Ollama (/v1), vLLM...
+type OpenAICompatibleProvider struct {
+	ProviderName string
+	BaseURL      string // p. ej. http://localhost:11434/v1
+	APIKey       string // opcional en servidores locales
+	Model        string
+	Timeout      time.Duration // por intento
+	MaxRetries   int           // reintentos en 429, 5xx y errores de red
+	HTTPClient   *http.Client
+}
+
+type chatCompletionRequest struct {
+	Model       string     `json:"model,omitempty"`
+	Messages    []Message  `json:"messages"`
+	Temperature float64    `json:"temperature"`
+	MaxTokens   int        `json:"max_tokens"`
+	Tools       []toolJSON `json:"tools,omitempty"`
+	ToolChoice  string     `json:"tool_choice,omitempty"`
+	Stream      bool       `json:"stream,omitempty"`
+}
+
+type toolJSON struct {
+	Type     string   `json:"type"`
+	Function ToolSpec `json:"function"`
+}
+
+type chatCompletionResponse struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Message Message `json:"message"`
+	} `json:"choices"`
+}
+
+// retryBaseDelay espera antes del primer reintento (se duplica en cada uno)
+var retryBaseDelay = 500 * time.Millisecond
+
+func (p *OpenAICompatibleProvider) Name() string { return p.ProviderName }
+
+// Chat envía la conversación y reintenta los fallos transitorios
+func (p *OpenAICompatibleProvider) Chat(ctx context.Context, req ChatRequest) (*ChatResponse, error) {
+	payload, err := p.payload(req, false)
+	if err != nil {
+		return nil, err
+	}
+	return p.retry(ctx, func() (*ChatResponse, error) {
+		return p.do(ctx, payload)
+	})
+}
+
+func (p *OpenAICompatibleProvider) payload(req ChatRequest, stream bool) ([]byte, error) {
+	if req.MaxTokens == 0 {
+		req.MaxTokens = 1024
+	}
+	body := chatCompletionRequest{
+		Model:       p.Model,
+		Messages:    req.Messages,
+		Temperature: req.Temperature,
+		MaxTokens:   req.MaxTokens,
+		Stream:      stream,
+	}
+	for _, tool := range req.Tools {
+		body.Tools = append(body.Tools, toolJSON{Type: "function", Function: tool})
+	}
+	if len(body.Tools) > 0 {
+		body.ToolChoice = "auto"
+	}
+	return json.Marshal(body)
+}
+
+// retry repite el intento con espera exponencial en 429, 5xx y errores de red
+func (p *OpenAICompatibleProvider) retry(ctx context.Context, attempt func() (*ChatResponse, error)) (*ChatResponse, error) {
+	delay := retryBaseDelay
+	for n := 0; ; n++ {
+		resp, err := attempt()
+		if err == nil {
+			return resp, nil
+		}
+		var apiErr *APIError
+		var partial *partialStreamError
+		retryable := (!errors.As(err, &apiErr) || apiErr.Retryable()) && !errors.As(err, &partial)
+		if !retryable || n >= p.MaxRetries || ctx.Err() != nil {
+			return nil, err
+		}
+
+		wait := delay
+		if apiErr != nil && apiErr.RetryAfter > 0 {
+			wait = apiErr.RetryAfter
+		}
+		log.Printf("⚠️  LLM %s: reintento %d/%d en %v: %v", p.ProviderName, n+1, p.MaxRetries, wait, err)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, err
+		}
+		delay *= 2
+	}
+}
+
+// do un intento con su propio timeout
+func (p *OpenAICompatibleProvider) do(ctx context.Context, payload []byte) (*ChatResponse, error) {
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+
+	resp, err := p.send(ctx, payload)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", p.ProviderName, err)
+	}
+
+	var out chatCompletionResponse
+	if err := json.Unmarshal(body, &out); err != nil {
+		return nil, fmt.Errorf("%s: respuesta inválida: %w", p.ProviderName, err)
+	}
+	if len(out.Choices) == 0 {
+		return nil, fmt.Errorf("no se recibió respuesta de %s", p.ProviderName)
+	}
+
+	msg := out.Choices[0].Message
+	return &ChatResponse{Content: msg.Content, ToolCalls: msg.ToolCalls, Provider: p.ProviderName, Model: p.model(out.Model)}, nil
+}
+
+// send envía la petición; las respuestas que no son 200 se devuelven como
+// *APIError
+func (p *OpenAICompatibleProvider) send(ctx context.Context, payload []byte) (*http.Response, error) {
+	url := strings.TrimRight(p.BaseURL, "/") + "/chat/completions"
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.APIKey)
+	}
+
+	client := p.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", p.ProviderName, err)
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	apiErr := &APIError{Provider: p.ProviderName, StatusCode: resp.StatusCode, Body: string(body)}
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+		apiErr.RetryAfter = time.Duration(secs) * time.Second
+	}
+	return nil, apiErr
+}
+
+func (p *OpenAICompatibleProvider) model(model string) string {
+	if model == "" {
+		return p.Model
+	}
+	return model
+}
@@ -0,0 +1,268 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ChatRequest petición de chat a un proveedor de LLM
+type ChatRequest struct {
+	Messages    []Message
+	Temperature float64
+	MaxTokens   int
+	// Tools herramientas que el modelo puede pedir (function calling)
+	Tools []ToolSpec
+}
+
+// ChatResponse respuesta de un proveedor de LLM: texto o llamadas a herramientas
+type ChatResponse struct {
+	Content   string
+	ToolCalls []ToolCall
+	Provider  string
+	Model     string
+}
+
+// ToolSpec herramienta ofrecida al modelo, con sus parámetros en JSON Schema
+type ToolSpec struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	Parameters  map[string]interface{} `json:"parameters"`
+}
+
+// ToolCall llamada a una herramienta pedida por el modelo; Arguments es el
+// JSON de los argumentos
+type ToolCall struct {
+	ID       string           `json:"id"`
+	Type     string           `json:"type"`
+	Function ToolCallFunction `json:"function"`
+}
+
+type ToolCallFunction struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// LLMProvider un backend de LLM (Groq, servidor local compatible con
+// OpenAI, stub de tests...)
+type LLMProvider interface {
+	Name() string
+	Chat(ctx context.Context, req ChatRequest) (*ChatResponse, error)
+}
+
+// APIError error HTTP de un proveedor
+type APIError struct {
+	Provider   string
+	StatusCode int
+	Body       string
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s API error (%d): %s", e.Provider, e.StatusCode, e.Body)
+}
+
+// Retryable los 429 y 5xx se reintentan; el resto (400, 401...) no
+func (e *APIError) Retryable() bool {
+	return e.StatusCode == 429 || e.StatusCode >= 500
+}
+
+// FailoverProvider prueba los proveedores en orden hasta que uno responde
+type FailoverProvider struct {
+	Providers []LLMProvider
+}
+
+func (f *FailoverProvider) Name() string {
+	names := make([]string, len(f.Providers))
+	for i, p := range f.Providers {
+		names[i] = p.Name()
+	}
+	return strings.Join(names, ",")
+}
+
+func (f *FailoverProvider) Chat(ctx context.Context, req ChatRequest) (*ChatResponse, error) {
+	var errs []error
+	for i, p := range f.Providers {
+		resp, err := p.Chat(ctx, req)
+		if err == nil {
+			return resp, nil
+		}
+		errs = append(errs, err)
+		if ctx.Err() != nil {
+			break
+		}
+		if i < len(f.Providers)-1 {
+			log.Printf("⚠️  LLM %s falló, probando %s: %v", p.Name(), f.Providers[i+1].Name(), err)
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// ChatStream el primer proveedor que responde entrega los fragmentos; solo
+// se pasa al siguiente si el anterior falla antes de entregar nada
+func (f *FailoverProvider) ChatStream(ctx context.Context, req ChatRequest, onDelta func(string)) (*ChatResponse, error) {
+	var errs []error
+	for i, p := range f.Providers {
+		started := false
+		resp, err := ChatStream(ctx, p, req, func(delta string) {
+			started = true
+			onDelta(delta)
+		})
+		if err == nil {
+			return resp, nil
+		}
+		errs = append(errs, err)
+		if started || ctx.Err() != nil {
+			break
+		}
+		if i < len(f.Providers)-1 {
+			log.Printf("⚠️  LLM %s falló, probando %s: %v", p.Name(), f.Providers[i+1].Name(), err)
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+var (
+	providersOnce sync.Once
+	providersMu   sync.RWMutex
+	// providers proveedores configurados por nombre
+	providers map[string]LLMProvider
+	// botProviders proveedor de cada bot (LLM_PROVIDER_<BOT>)
+	botProviders map[string]LLMProvider
+	// defaultProvider proveedor de los bots sin configuración propia (LLM_PROVIDER)
+	defaultProvider LLMProvider
+)
+
+// initProviders crea los proveedores a partir del entorno:
+//
+//	LLM_PROVIDER=groq                     proveedor por defecto
+//	LLM_PROVIDER_BOT_ATENCION=local,groq  por bot, con failover en orden
+//	LLM_LOCAL_BASE_URL / _MODEL / _API_KEY servidor compatible con OpenAI
+//	LLM_TIMEOUT_SECONDS, LLM_MAX_RETRIES
+func initProviders() {
+	timeout := time.Duration(envInt("LLM_TIMEOUT_SECONDS", 30)) * time.Second
+	retries := envInt("LLM_MAX_RETRIES", 2)
+
+	providers = map[string]LLMProvider{
+		"groq": NewGroqProvider(timeout, retries),
+		"stub": &StubProvider{},
+	}
+	if baseURL := os.Getenv("LLM_LOCAL_BASE_URL"); baseURL != "" {
+		providers["local"] = &OpenAICompatibleProvider{
+			ProviderName: "local",
+			BaseURL:      baseURL,
+			APIKey:       os.Getenv("LLM_LOCAL_API_KEY"),
+			Model:        os.Getenv("LLM_LOCAL_MODEL"),
+			Timeout:      timeout,
+			MaxRetries:   retries,
+		}
+	}
+
+	defaultProvider = providerChain(os.Getenv("LLM_PROVIDER"))
+	if defaultProvider == nil {
+		defaultProvider = providers["groq"]
+	}
+
+	botProviders = make(map[string]LLMProvider)
+	for _, env := range os.Environ() {
+		key, value, _ := strings.Cut(env, "=")
+		if !strings.HasPrefix(key, "LLM_PROVIDER_") {
+			continue
+		}
+		botID := strings.ToLower(strings.TrimPrefix(key, "LLM_PROVIDER_"))
+		if p := providerChain(value); p != nil {
+			botProviders[botID] = p
+			log.Printf("🤖 %s usa el proveedor LLM %s", botID, p.Name())
+		}
+	}
+}
+
+// providerChain proveedor de una lista "a,b,c" (failover si hay varios)
+func providerChain(names string) LLMProvider {
+	var chain []LLMProvider
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(strings.ToLower(name))
+		if name == "" {
+			continue
+		}
+		p, ok := providers[name]
+		if !ok {
+			log.Printf("⚠️  Proveedor LLM desconocido o sin configurar: %s", name)
+			continue
+		}
+		chain = append(chain, p)
+	}
+	switch len(chain) {
+	case 0:
+		return nil
+	case 1:
+		return chain[0]
+	}
+	return &FailoverProvider{Providers: chain}
+}
+
+func envInt(key string, def int) int {
+	if n, err := strconv.Atoi(os.Getenv(key)); err == nil && n >= 0 {
+		return n
+	}
+	return def
+}
+
+// ProviderFor proveedor de un bot ("" = el proveedor por defecto)
+func ProviderFor(botID string) LLMProvider {
+	providersOnce.Do(initProviders)
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	if p, ok := botProviders[botID]; ok {
+		return p
+	}
+	return defaultProvider
+}
+
+// SetProvider fija el proveedor de un bot ("" = el proveedor por defecto)
+func SetProvider(botID string, p LLMProvider) {
+	providersOnce.Do(initProviders)
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	if botID == "" {
+		defaultProvider = p
+		return
+	}
+	botProviders[botID] = p
+}
+
+// ConsultarAIBot consulta al proveedor de LLM del bot
+func ConsultarAIBot(botID, prompt, systemPrompt string) (string, error) {
+	return ConsultarAIConHistorial(botID, systemPrompt, nil, prompt)
+}
+
+// ConsultarAIConHistorial consulta al proveedor del bot con los mensajes
+// previos de la conversación entre el system prompt y la consulta
+func ConsultarAIConHistorial(botID, systemPrompt string, historial []Message, prompt string) (string, error) {
+	return ConsultarAIConHistorialStream(botID, systemPrompt, historial, prompt, nil)
+}
+
+// ConsultarAIConHistorialStream como ConsultarAIConHistorial, entregando
+// la respuesta a onDelta a medida que llega (nil = sin streaming)
+func ConsultarAIConHistorialStream(botID, systemPrompt string, historial []Message, prompt string, onDelta func(string)) (string, error) {
+	messages := make([]Message, 0, len(historial)+2)
+	messages = append(messages, Message{Role: "system", Content: systemPrompt})
+	messages = append(messages, historial...)
+	messages = append(messages, Message{Role: "user", Content: prompt})
+
+	resp, err := ChatStream(context.Background(), ProviderFor(botID), ChatRequest{
+		Messages:    messages,
+		Temperature: 0.3,
+		MaxTokens:   1024,
+	}, onDelta)
+	if err != nil {
+		return "", err
+	}
+	return resp.Content, nil
+}
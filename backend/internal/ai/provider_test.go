@@ -0,0 +1,196 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOpenAICompatibleRetries(t *testing.T) {
+	retryBaseDelay = time.Millisecond
+	t.Cleanup(func() { retryBaseDelay = 500 * time.Millisecond })
+
+	var calls int32
+	status := []int{429, 503, 200}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		var req chatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Model != "qwen2.5" || r.Header.Get("Authorization") != "" {
+			t.Errorf("petición = %+v, auth %q", req, r.Header.Get("Authorization"))
+		}
+		code := status[(int(n)-1)%len(status)]
+		w.WriteHeader(code)
+		if code == 200 {
+			fmt.Fprint(w, `{"model": "qwen2.5", "choices": [{"message": {"role": "assistant", "content": "hola"}}]}`)
+		}
+	}))
+	defer server.Close()
+
+	local := &OpenAICompatibleProvider{ProviderName: "local", BaseURL: server.URL + "/v1/", Model: "qwen2.5", MaxRetries: 2}
+	resp, err := local.Chat(context.Background(), ChatRequest{Messages: []Message{{Role: "user", Content: "hola"}}})
+	if err != nil || resp.Content != "hola" || resp.Provider != "local" {
+		t.Fatalf("respuesta: %+v, %v", resp, err)
+	}
+	if calls != 3 {
+		t.Fatalf("intentos = %d", calls)
+	}
+
+	// Los errores del cliente no se reintentan
+	calls = 0
+	status = []int{400}
+	if _, err := local.Chat(context.Background(), ChatRequest{}); err == nil || calls != 1 {
+		t.Fatalf("400: %v tras %d intentos", err, calls)
+	}
+
+	// Cada intento tiene su timeout
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	})
+	local.Timeout, local.MaxRetries = 20*time.Millisecond, 0
+	start := time.Now()
+	if _, err := local.Chat(context.Background(), ChatRequest{}); err == nil || time.Since(start) > 150*time.Millisecond {
+		t.Fatalf("timeout: %v tras %v", err, time.Since(start))
+	}
+}
+
+func TestProviderFailoverAndSelection(t *testing.T) {
+	t.Setenv("LLM_LOCAL_BASE_URL", "http://127.0.0.1:1/v1")
+	t.Setenv("LLM_PROVIDER", "stub")
+	t.Setenv("LLM_PROVIDER_BOT_ATENCION", "local,stub")
+	t.Setenv("LLM_MAX_RETRIES", "0")
+	initProviders()
+
+	if got := ProviderFor("bot_agente").Name(); got != "stub" {
+		t.Fatalf("proveedor por defecto = %s", got)
+	}
+	// El servidor local no responde: se pasa al siguiente de la cadena
+	failover := ProviderFor("bot_atencion")
+	if failover.Name() != "local,stub" {
+		t.Fatalf("proveedor de bot_atencion = %s", failover.Name())
+	}
+	resp, err := failover.Chat(context.Background(), ChatRequest{Messages: []Message{{Role: "user", Content: "¿mi póliza?"}}})
+	if err != nil || resp.Provider != "stub" || resp.Content != "[stub] ¿mi póliza?" {
+		t.Fatalf("failover: %+v, %v", resp, err)
+	}
+
+	stub := &StubProvider{Replies: map[string]string{"hola": "BUSCAR_CLIENTE"}}
+	SetProvider("bot_atencion", stub)
+	if out, err := ConsultarAIBot("bot_atencion", "hola", "clasifica"); err != nil || out != "BUSCAR_CLIENTE" {
+		t.Fatalf("stub: %q, %v", out, err)
+	}
+	if stub.Calls() != 1 || stub.Requests[0].Messages[0].Content != "clasifica" {
+		t.Fatalf("peticiones al stub = %+v", stub.Requests)
+	}
+
+	// Si todos fallan se devuelven todos los errores
+	all := &FailoverProvider{Providers: []LLMProvider{&StubProvider{Err: fmt.Errorf("uno")}, &StubProvider{Err: fmt.Errorf("dos")}}}
+	if _, err := all.Chat(context.Background(), ChatRequest{}); err == nil || err.Error() != "uno\ndos" {
+		t.Fatalf("todos fallan: %v", err)
+	}
+}
+
+func TestOpenAICompatibleToolCalls(t *testing.T) {
+	var body map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+		fmt.Fprint(w, `{"choices": [{"message": {"role": "assistant", "content": null, "tool_calls": [
+			{"id": "call_1", "type": "function", "function": {"name": "buscar_clientes", "arguments": "{\"termino\":\"Ana\"}"}}]}}]}`)
+	}))
+	defer server.Close()
+
+	local := &OpenAICompatibleProvider{ProviderName: "local", BaseURL: server.URL}
+	resp, err := local.Chat(context.Background(), ChatRequest{
+		Messages: []Message{
+			{Role: "user", Content: "Busca a Ana"},
+			{Role: "tool", ToolCallID: "call_0", Content: "[]"},
+		},
+		Tools: []ToolSpec{{Name: "buscar_clientes", Description: "Busca clientes", Parameters: map[string]interface{}{"type": "object"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.ToolCalls) != 1 || resp.ToolCalls[0].ID != "call_1" || resp.ToolCalls[0].Function.Arguments != `{"termino":"Ana"}` {
+		t.Fatalf("tool_calls = %+v", resp.ToolCalls)
+	}
+
+	tools := body["tools"].([]interface{})
+	tool := tools[0].(map[string]interface{})
+	if tool["type"] != "function" || tool["function"].(map[string]interface{})["name"] != "buscar_clientes" || body["tool_choice"] != "auto" {
+		t.Fatalf("herramientas enviadas = %v", body)
+	}
+	if m := body["messages"].([]interface{})[1].(map[string]interface{}); m["tool_call_id"] != "call_0" {
+		t.Fatalf("mensaje tool = %v", m)
+	}
+}
+
+func TestOpenAICompatibleStream(t *testing.T) {
+	retryBaseDelay = time.Millisecond
+	t.Cleanup(func() { retryBaseDelay = 500 * time.Millisecond })
+
+	var calls int32
+	chunks := []string{
+		`{"model": "llama", "choices": [{"delta": {"role": "assistant", "content": "Estimado "}}]}`,
+		`{"choices": [{"delta": {"content": "cliente"}}]}`,
+		`{"choices": [{"delta": {"tool_calls": [{"index": 0, "id": "call_1", "type": "function", "function": {"name": "resumen_cobranza", "arguments": "{\"li"}}]}}]}`,
+		`{"choices": [{"delta": {"tool_calls": [{"index": 0, "function": {"arguments": "mite\":5}"}}]}}]}`,
+		`[DONE]`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		var req chatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if !req.Stream {
+			t.Errorf("petición sin stream")
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range chunks {
+			fmt.Fprintf(w, "data: %s\n\n", chunk)
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer server.Close()
+
+	local := &OpenAICompatibleProvider{ProviderName: "local", BaseURL: server.URL, MaxRetries: 2, Timeout: time.Second}
+	var deltas []string
+	resp, err := local.ChatStream(context.Background(), ChatRequest{}, func(d string) { deltas = append(deltas, d) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deltas) != 2 || resp.Content != "Estimado cliente" || resp.Model != "llama" {
+		t.Fatalf("deltas = %q, respuesta = %+v", deltas, resp)
+	}
+	if len(resp.ToolCalls) != 1 || resp.ToolCalls[0].ID != "call_1" || resp.ToolCalls[0].Function.Arguments != `{"limite":5}` {
+		t.Fatalf("tool_calls = %+v", resp.ToolCalls)
+	}
+
+	// Cortado a mitad de respuesta: no se reintenta (duplicaría el texto)
+	calls = 0
+	chunks = chunks[:2]
+	if _, err := local.ChatStream(context.Background(), ChatRequest{}, func(string) {}); !IsPartialStream(err) || calls != 1 {
+		t.Fatalf("stream cortado: %v tras %d intentos", err, calls)
+	}
+
+	// El primero falla antes de entregar nada: responde el siguiente
+	stub := &StubProvider{Reply: "hola"}
+	deltas = nil
+	failover := &FailoverProvider{Providers: []LLMProvider{&StubProvider{Err: fmt.Errorf("caído")}, stub}}
+	if _, err := ChatStream(context.Background(), failover, ChatRequest{}, func(d string) { deltas = append(deltas, d) }); err != nil || len(deltas) != 1 || deltas[0] != "hola" {
+		t.Fatalf("failover: %q, %v", deltas, err)
+	}
+	if IsPartialStream(fmt.Errorf("caído")) {
+		t.Fatal("un error sin fragmentos no es un stream cortado")
+	}
+
+	// Cortado a medias en el primero: no se pasa al siguiente y el error lo indica
+	deltas = nil
+	failover = &FailoverProvider{Providers: []LLMProvider{&StubProvider{Reply: "hola a todos", StreamErr: fmt.Errorf("cerrado")}, stub}}
+	if _, err := ChatStream(context.Background(), failover, ChatRequest{}, func(d string) { deltas = append(deltas, d) }); !IsPartialStream(err) || len(deltas) != 1 {
+		t.Fatalf("failover cortado: %q, %v", deltas, err)
+	}
+}
@@ -0,0 +1,182 @@
+package ai
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// StreamingProvider proveedor que entrega la respuesta por fragmentos a
+// medida que el modelo la genera
+type StreamingProvider interface {
+	LLMProvider
+	// ChatStream llama a onDelta con cada fragmento de texto y devuelve la
+	// respuesta completa (texto y llamadas a herramientas) al terminar
+	ChatStream(ctx context.Context, req ChatRequest, onDelta func(string)) (*ChatResponse, error)
+}
+
+// ChatStream usa el streaming del proveedor si lo tiene; si no, entrega el
+// texto completo en un único fragmento. Con onDelta nil equivale a Chat
+func ChatStream(ctx context.Context, p LLMProvider, req ChatRequest, onDelta func(string)) (*ChatResponse, error) {
+	if onDelta == nil {
+		return p.Chat(ctx, req)
+	}
+	if sp, ok := p.(StreamingProvider); ok {
+		return sp.ChatStream(ctx, req, onDelta)
+	}
+	resp, err := p.Chat(ctx, req)
+	if err == nil && resp.Content != "" {
+		onDelta(resp.Content)
+	}
+	return resp, err
+}
+
+// partialStreamError el stream se cortó después de entregar fragmentos: no
+// se reintenta ni se pasa a otro proveedor para no duplicar el texto
+type partialStreamError struct {
+	err error
+}
+
+func (e *partialStreamError) Error() string { return e.err.Error() }
+func (e *partialStreamError) Unwrap() error { return e.err }
+
+// IsPartialStream indica si el error cortó un stream que ya había entregado
+// fragmentos (el cliente tiene una respuesta a medias)
+func IsPartialStream(err error) bool {
+	var partial *partialStreamError
+	return errors.As(err, &partial)
+}
+
+type chatCompletionChunk struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Delta struct {
+			Content   string `json:"content"`
+			ToolCalls []struct {
+				Index    int              `json:"index"`
+				ID       string           `json:"id"`
+				Type     string           `json:"type"`
+				Function ToolCallFunction `json:"function"`
+			} `json:"tool_calls"`
+		} `json:"delta"`
+	} `json:"choices"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// ChatStream petición con "stream": true; los fragmentos llegan como
+// eventos SSE "data: {...}" hasta "data: [DONE]"
+func (p *OpenAICompatibleProvider) ChatStream(ctx context.Context, req ChatRequest, onDelta func(string)) (*ChatResponse, error) {
+	payload, err := p.payload(req, true)
+	if err != nil {
+		return nil, err
+	}
+	return p.retry(ctx, func() (*ChatResponse, error) {
+		return p.doStream(ctx, payload, onDelta)
+	})
+}
+
+// doStream un intento; el timeout cuenta hasta la respuesta y entre
+// fragmentos, no para la respuesta completa
+func (p *OpenAICompatibleProvider) doStream(ctx context.Context, payload []byte, onDelta func(string)) (*ChatResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var idle *time.Timer
+	if p.Timeout > 0 {
+		idle = time.AfterFunc(p.Timeout, cancel)
+		defer idle.Stop()
+	}
+
+	resp, err := p.send(ctx, payload)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var content strings.Builder
+	var model string
+	calls := map[int]*ToolCall{}
+	fail := func(err error) (*ChatResponse, error) {
+		err = fmt.Errorf("%s: %w", p.ProviderName, err)
+		if content.Len() > 0 {
+			return nil, &partialStreamError{err}
+		}
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	done := false
+	for !done && scanner.Scan() {
+		if idle != nil {
+			idle.Reset(p.Timeout)
+		}
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			done = true
+			continue
+		}
+
+		var chunk chatCompletionChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return fail(fmt.Errorf("fragmento inválido: %w", err))
+		}
+		if chunk.Error != nil {
+			return fail(fmt.Errorf("error en el stream: %s", chunk.Error.Message))
+		}
+		if chunk.Model != "" {
+			model = chunk.Model
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+		delta := chunk.Choices[0].Delta
+		if delta.Content != "" {
+			content.WriteString(delta.Content)
+			onDelta(delta.Content)
+		}
+		// Las llamadas a herramientas llegan troceadas por índice
+		for _, tc := range delta.ToolCalls {
+			call, ok := calls[tc.Index]
+			if !ok {
+				call = &ToolCall{Type: "function"}
+				calls[tc.Index] = call
+			}
+			if tc.ID != "" {
+				call.ID = tc.ID
+			}
+			if tc.Type != "" {
+				call.Type = tc.Type
+			}
+			call.Function.Name += tc.Function.Name
+			call.Function.Arguments += tc.Function.Arguments
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fail(err)
+	}
+	if !done {
+		return fail(fmt.Errorf("stream incompleto"))
+	}
+
+	out := &ChatResponse{Content: content.String(), Provider: p.ProviderName, Model: p.model(model)}
+	indexes := make([]int, 0, len(calls))
+	for i := range calls {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	for _, i := range indexes {
+		out.ToolCalls = append(out.ToolCalls, *calls[i])
+	}
+	return out, nil
+}
@@ -0,0 +1,85 @@
+package ai
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// StubProvider proveedor determinista para tests y desarrollo sin LLM:
+// devuelve las respuestas de Script en orden y, agotado el guion,
+// Replies[último mensaje del usuario], o Reply, o un eco del mensaje
+type StubProvider struct {
+	Reply   string
+	Replies map[string]string
+	Script  []ChatResponse
+	Err     error
+	// StreamErr corta ChatStream después del primer fragmento
+	StreamErr error
+
+	mu       sync.Mutex
+	Requests []ChatRequest
+}
+
+func (s *StubProvider) Name() string { return "stub" }
+
+func (s *StubProvider) Chat(ctx context.Context, req ChatRequest) (*ChatResponse, error) {
+	s.mu.Lock()
+	s.Requests = append(s.Requests, req)
+	var scripted *ChatResponse
+	if len(s.Script) > 0 {
+		scripted = &s.Script[0]
+		s.Script = s.Script[1:]
+	}
+	s.mu.Unlock()
+
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	if scripted != nil {
+		resp := *scripted
+		resp.Provider, resp.Model = "stub", "stub"
+		return &resp, nil
+	}
+
+	var last string
+	for i := len(req.Messages) - 1; i >= 0; i-- {
+		if req.Messages[i].Role == "user" {
+			last = req.Messages[i].Content
+			break
+		}
+	}
+
+	content := s.Reply
+	if reply, ok := s.Replies[strings.TrimSpace(last)]; ok {
+		content = reply
+	} else if content == "" {
+		content = "[stub] " + last
+	}
+	return &ChatResponse{Content: content, Provider: "stub", Model: "stub"}, nil
+}
+
+// ChatStream entrega la respuesta de Chat palabra a palabra
+func (s *StubProvider) ChatStream(ctx context.Context, req ChatRequest, onDelta func(string)) (*ChatResponse, error) {
+	resp, err := s.Chat(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	for _, word := range strings.SplitAfter(resp.Content, " ") {
+		if word == "" {
+			continue
+		}
+		onDelta(word)
+		if s.StreamErr != nil {
+			return nil, &partialStreamError{s.StreamErr}
+		}
+	}
+	return resp, nil
+}
+
+// Calls número de peticiones recibidas
+func (s *StubProvider) Calls() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.Requests)
+}
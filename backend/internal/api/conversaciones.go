@@ -0,0 +1,109 @@
+package api
+
+import (
+	"log"
+
+	"soriano-mediadores/internal/auth"
+	"soriano-mediadores/internal/bots"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// abrirConversacion asocia el session_id del chat al usuario autenticado; el
+// único error es que la conversación sea de otro usuario. Recibe la sesión ya
+// extraída porque las conexiones WebSocket siguen vivas después del handler
+func abrirConversacion(session *auth.Session, sessionID, botID string) error {
+	if session == nil {
+		return nil
+	}
+	err := bots.AbrirConversacion(sessionID, session.UserInfo.ID, session.UserInfo.Mail, botID)
+	if err == bots.ErrConversacionAjena {
+		return err
+	}
+	if err != nil {
+		log.Printf("⚠️  Error abriendo conversación %s: %v", sessionID, err)
+	}
+	return nil
+}
+
+// conversacionPropia carga la conversación de :id si es del usuario o este
+// tiene chat:admin
+func conversacionPropia(c *fiber.Ctx) (*bots.Conversacion, []bots.Turno, error) {
+	conv, turnos, err := bots.ObtenerConversacion(c.Params("id"))
+	if err != nil {
+		if err == bots.ErrConversacionNoEncontrada {
+			return nil, nil, c.Status(404).JSON(fiber.Map{"success": false, "error": err.Error()})
+		}
+		return nil, nil, c.Status(500).JSON(fiber.Map{"success": false, "error": err.Error()})
+	}
+	session, _ := c.Locals("session").(*auth.Session)
+	if session == nil || (conv.UserID != session.UserInfo.ID && !session.Can(auth.PermChatAdmin)) {
+		// Sin distinguir de una conversación inexistente
+		return nil, nil, c.Status(404).JSON(fiber.Map{"success": false, "error": bots.ErrConversacionNoEncontrada.Error()})
+	}
+	return conv, turnos, nil
+}
+
+// ListarConversaciones conversaciones del usuario (de todos con chat:admin y ?all=true)
+// GET /api/chat/sessions?limit=50
+func ListarConversaciones(c *fiber.Ctx) error {
+	session, _ := c.Locals("session").(*auth.Session)
+	if session == nil {
+		return c.Status(401).JSON(fiber.Map{"success": false, "error": "No autenticado"})
+	}
+	userID := session.UserInfo.ID
+	if c.QueryBool("all") {
+		if !session.Can(auth.PermChatAdmin) {
+			return c.Status(403).JSON(fiber.Map{
+				"success":    false,
+				"error":      "No tienes permiso para esta acción",
+				"permission": auth.PermChatAdmin,
+			})
+		}
+		userID = ""
+	}
+
+	convs, err := bots.ListarConversaciones(userID, c.QueryInt("limit", 50))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"success": false, "error": "Error obteniendo conversaciones: " + err.Error()})
+	}
+	if convs == nil {
+		convs = []*bots.Conversacion{}
+	}
+	return c.JSON(fiber.Map{
+		"success":        true,
+		"total":          len(convs),
+		"conversaciones": convs,
+	})
+}
+
+// ObtenerConversacion transcripción completa de una conversación
+// GET /api/chat/sessions/:id
+func ObtenerConversacion(c *fiber.Ctx) error {
+	conv, turnos, err := conversacionPropia(c)
+	if conv == nil {
+		return err
+	}
+	if turnos == nil {
+		turnos = []bots.Turno{}
+	}
+	return c.JSON(fiber.Map{
+		"success":      true,
+		"conversacion": conv,
+		"turnos":       turnos,
+	})
+}
+
+// BorrarConversacion borra una conversación y su transcripción
+// DELETE /api/chat/sessions/:id
+func BorrarConversacion(c *fiber.Ctx) error {
+	conv, _, err := conversacionPropia(c)
+	if conv == nil {
+		return err
+	}
+	if err := bots.BorrarConversacion(conv.SessionID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"success": false, "error": err.Error()})
+	}
+	log.Printf("🗑️  Conversación %s borrada", conv.SessionID)
+	return c.JSON(fiber.Map{"success": true})
+}
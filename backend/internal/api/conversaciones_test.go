@@ -0,0 +1,86 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"soriano-mediadores/internal/auth"
+	"soriano-mediadores/internal/bots"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestConversacionesEndpoints(t *testing.T) {
+	usuarios := map[string]*auth.Session{
+		"ana":   {UserInfo: auth.UserInfo{ID: "u-ana", Mail: "ana@sorianomediadores.es"}, Roles: []auth.Role{auth.RoleLectura}},
+		"luis":  {UserInfo: auth.UserInfo{ID: "u-luis", Mail: "luis@sorianomediadores.es"}, Roles: []auth.Role{auth.RoleLectura}},
+		"admin": {UserInfo: auth.UserInfo{ID: "u-it", Mail: "it@sorianomediadores.es"}, Roles: []auth.Role{auth.RoleAdmin}},
+	}
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("session", usuarios[c.Get("X-Test-User")])
+		return c.Next()
+	})
+	app.Get("/api/chat/sessions", ListarConversaciones)
+	app.Get("/api/chat/sessions/:id", ObtenerConversacion)
+	app.Delete("/api/chat/sessions/:id", BorrarConversacion)
+	// Lo que hace Chat con el session_id antes de llamar al bot
+	app.Post("/abrir/:id", func(c *fiber.Ctx) error {
+		req := peticionChat{Bot: "atencion", SessionID: c.Params("id"), Mensaje: "Busca a Héctor Pérez"}
+		if bot, _, err := elegirBot(c, &req); bot == nil {
+			return err
+		}
+		return c.JSON(fiber.Map{"success": true})
+	})
+	call := func(user, method, url string) (int, map[string]interface{}) {
+		req := httptest.NewRequest(method, url, nil)
+		req.Header.Set("X-Test-User", user)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, body
+	}
+
+	// Conversación de ana; luis no puede continuarla con el mismo session_id
+	if code, _ := call("ana", "POST", "/abrir/conv-ana"); code != 200 {
+		t.Fatalf("abrir: %d", code)
+	}
+	if code, body := call("luis", "POST", "/abrir/conv-ana"); code != 403 || body["error"] != bots.ErrConversacionAjena.Error() {
+		t.Fatalf("luis abre la conversación de ana: %d %v", code, body)
+	}
+	bots.Registrar("conv-ana", "bot_atencion", bots.RolUsuario, "Busca a Héctor Pérez")
+	bots.Registrar("conv-ana", "bot_atencion", bots.RolAsistente, "Héctor Pérez, IdAccount 20777103/000")
+
+	if code, body := call("ana", "GET", "/api/chat/sessions"); code != 200 || body["total"] != float64(1) {
+		t.Fatalf("listar: %d %v", code, body)
+	}
+	code, body := call("ana", "GET", "/api/chat/sessions/conv-ana")
+	if code != 200 || len(body["turnos"].([]interface{})) != 2 {
+		t.Fatalf("transcripción: %d %v", code, body)
+	}
+
+	// Otro usuario no la ve; con chat:admin sí
+	if code, _ := call("luis", "GET", "/api/chat/sessions/conv-ana"); code != 404 {
+		t.Fatalf("luis ve la conversación: %d", code)
+	}
+	if code, _ := call("luis", "GET", "/api/chat/sessions?all=true"); code != 403 {
+		t.Fatalf("luis lista todas: %d", code)
+	}
+	if code, body := call("admin", "GET", "/api/chat/sessions?all=true"); code != 200 || body["total"] != float64(1) {
+		t.Fatalf("admin lista todas: %d %v", code, body)
+	}
+
+	if code, _ := call("luis", "DELETE", "/api/chat/sessions/conv-ana"); code != 404 {
+		t.Fatalf("luis borra: %d", code)
+	}
+	if code, _ := call("ana", "DELETE", "/api/chat/sessions/conv-ana"); code != 200 {
+		t.Fatalf("ana borra: %d", code)
+	}
+	if code, _ := call("ana", "GET", "/api/chat/sessions/conv-ana"); code != 404 {
+		t.Fatalf("conversación borrada: %d", code)
+	}
+}
@@ -1,33 +1,27 @@
 package api
 
 import (
+	"fmt"
 	"log"
 	"net/url"
+	"soriano-mediadores/internal/auth"
 	"soriano-mediadores/internal/bots"
 	"soriano-mediadores/internal/db"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 )
 
-var (
-	botAtencion   *bots.BotAtencion
-	botCobranza   *bots.BotCobranza
-	botSiniestros *bots.BotSiniestros
-	botAgente     *bots.BotAgente
-	botAnalista   *bots.BotAnalista
-	botAuditor    *bots.BotAuditor
-)
-
-// InitBots inicializa todos los bots
+// InitBots prepara los bots registrados (cada uno se registra en su init)
 func InitBots() {
-	botAtencion = bots.NewBotAtencion()
-	botCobranza = bots.NewBotCobranza()
-	botSiniestros = bots.NewBotSiniestros()
-	botAgente = bots.NewBotAgente()
-	botAnalista = bots.NewBotAnalista()
-	botAuditor = bots.NewBotAuditor()
+	// Memoria de las conversaciones (MongoDB)
+	bots.InitMemoria()
+
+	for _, b := range bots.ListaBots() {
+		log.Printf("🤖 Bot %s: %s", b.Info().ID, b.Info().Nombre)
+	}
 }
 
 // HealthCheck verifica el estado del sistema
@@ -139,245 +133,124 @@ func ObtenerPolizasCliente(c *fiber.Ctx) error {
 	})
 }
 
-// ChatBotAtencion endpoint para chatear con Bot de Atención
-func ChatBotAtencion(c *fiber.Ctx) error {
-	var req struct {
-		SessionID string `json:"session_id"`
-		Mensaje   string `json:"mensaje"`
-	}
-
-	if err := c.BodyParser(&req); err != nil {
-		return c.Status(400).JSON(fiber.Map{
-			"error": "JSON inválido",
-		})
-	}
-
-	if req.SessionID == "" {
-		req.SessionID = uuid.New().String()
-	}
-
-	respuesta, err := botAtencion.ProcesarConsulta(req.SessionID, req.Mensaje)
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{
-			"error": err.Error(),
-		})
-	}
-
-	return c.JSON(fiber.Map{
-		"session_id": req.SessionID,
-		"bot":        "atencion",
-		"respuesta":  respuesta,
-		"timestamp":  time.Now().Format(time.RFC3339),
-	})
+// peticionChat cuerpo de las peticiones de chat; Bot es opcional en
+// /api/chat (sin él decide el enrutador)
+type peticionChat struct {
+	Bot       string `json:"bot"`
+	SessionID string `json:"session_id"`
+	Mensaje   string `json:"mensaje"`
 }
 
-// ChatBotCobranza endpoint para chatear con Bot de Cobranza
-func ChatBotCobranza(c *fiber.Ctx) error {
-	var req struct {
-		SessionID string `json:"session_id"`
-		Mensaje   string `json:"mensaje"`
-	}
-
-	if err := c.BodyParser(&req); err != nil {
-		return c.Status(400).JSON(fiber.Map{
-			"error": "JSON inválido",
-		})
+// elegirBot valida la petición, abre la conversación y devuelve el bot de la
+// ruta (o del campo bot) o el que elige el enrutador. Si el bot es nil ya se
+// respondió con el error
+func elegirBot(c *fiber.Ctx, req *peticionChat) (bots.Bot, *bots.Enrutado, error) {
+	if strings.TrimSpace(req.Mensaje) == "" {
+		return nil, nil, c.Status(400).JSON(fiber.Map{"success": false, "error": "El mensaje es obligatorio"})
 	}
-
 	if req.SessionID == "" {
 		req.SessionID = uuid.New().String()
 	}
+	req.Bot = c.Params("bot", req.Bot)
 
-	respuesta, err := botCobranza.ProcesarConsulta(req.SessionID, req.Mensaje)
+	session, _ := c.Locals("session").(*auth.Session)
+	bot, enrutado, status, err := seleccionarBot(session, req)
 	if err != nil {
-		return c.Status(500).JSON(fiber.Map{
-			"error": err.Error(),
-		})
+		return nil, nil, c.Status(status).JSON(fiber.Map{"success": false, "error": err.Error()})
 	}
-
-	return c.JSON(fiber.Map{
-		"session_id": req.SessionID,
-		"bot":        "cobranza",
-		"respuesta":  respuesta,
-		"timestamp":  time.Now().Format(time.RFC3339),
-	})
+	return bot, enrutado, nil
 }
 
-// ChatBotSiniestros endpoint para chatear con Bot de Siniestros
-func ChatBotSiniestros(c *fiber.Ctx) error {
-	var req struct {
-		SessionID string `json:"session_id"`
-		Mensaje   string `json:"mensaje"`
+// seleccionarBot elegirBot sin contexto de Fiber (también para los mensajes
+// del WebSocket): devuelve el código HTTP del error
+func seleccionarBot(session *auth.Session, req *peticionChat) (bots.Bot, *bots.Enrutado, int, error) {
+	var bot bots.Bot
+	botID := ""
+	if req.Bot != "" {
+		b, ok := bots.ObtenerBot(req.Bot)
+		if !ok {
+			return nil, nil, 404, fmt.Errorf("Bot no encontrado: %s", req.Bot)
+		}
+		bot, botID = b, b.Info().BotID
 	}
-
-	if err := c.BodyParser(&req); err != nil {
-		return c.Status(400).JSON(fiber.Map{
-			"error": "JSON inválido",
-		})
+	if err := abrirConversacion(session, req.SessionID, botID); err != nil {
+		return nil, nil, 403, err
 	}
-
-	if req.SessionID == "" {
-		req.SessionID = uuid.New().String()
+	if bot != nil {
+		return bot, nil, 0, nil
 	}
 
-	respuesta, err := botSiniestros.ProcesarConsulta(req.SessionID, req.Mensaje)
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{
-			"error": err.Error(),
-		})
+	enrutado := bots.Enrutar(req.SessionID, req.Mensaje)
+	if enrutado.Bot == nil {
+		return nil, nil, 503, fmt.Errorf("No hay bots disponibles")
 	}
-
-	return c.JSON(fiber.Map{
-		"session_id": req.SessionID,
-		"bot":        "siniestros",
-		"respuesta":  respuesta,
-		"timestamp":  time.Now().Format(time.RFC3339),
-	})
+	return enrutado.Bot, &enrutado, 0, nil
 }
 
-// ChatBotAgente endpoint para chatear con Bot Agente/Comercial
-func ChatBotAgente(c *fiber.Ctx) error {
-	var req struct {
-		SessionID string `json:"session_id"`
-		Mensaje   string `json:"mensaje"`
-	}
-
-	if err := c.BodyParser(&req); err != nil {
-		return c.Status(400).JSON(fiber.Map{
-			"error": "JSON inválido",
-		})
-	}
-
-	if req.SessionID == "" {
-		req.SessionID = uuid.New().String()
-	}
-
-	respuesta, err := botAgente.ProcesarConsulta(req.SessionID, req.Mensaje)
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{
-			"error": err.Error(),
-		})
-	}
-
-	return c.JSON(fiber.Map{
-		"session_id": req.SessionID,
-		"bot":        "agente",
-		"respuesta":  respuesta,
+// respuestaChat respuesta de los endpoints de chat: qué bot respondió y,
+// con el enrutador, por qué y si la conversación cambió de bot
+func respuestaChat(sessionID string, bot bots.Bot, enrutado *bots.Enrutado, respuesta *bots.Respuesta) fiber.Map {
+	m := fiber.Map{
+		"session_id": sessionID,
+		"bot":        bot.Info().ID,
+		"bot_nombre": bot.Info().Nombre,
+		"respuesta":  respuesta.Texto,
+		"traza":      respuesta.Traza,
 		"timestamp":  time.Now().Format(time.RFC3339),
-	})
-}
-
-// ChatBotAnalista endpoint para chatear con Bot Analista
-func ChatBotAnalista(c *fiber.Ctx) error {
-	var req struct {
-		SessionID string `json:"session_id"`
-		Mensaje   string `json:"mensaje"`
 	}
-
-	if err := c.BodyParser(&req); err != nil {
-		return c.Status(400).JSON(fiber.Map{
-			"error": "JSON inválido",
-		})
+	if enrutado != nil {
+		m["enrutado"] = infoEnrutado(enrutado)
 	}
-
-	if req.SessionID == "" {
-		req.SessionID = uuid.New().String()
-	}
-
-	respuesta, err := botAnalista.ProcesarConsulta(req.SessionID, req.Mensaje)
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{
-			"error": err.Error(),
-		})
-	}
-
-	return c.JSON(fiber.Map{
-		"session_id": req.SessionID,
-		"bot":        "analista",
-		"respuesta":  respuesta,
-		"timestamp":  time.Now().Format(time.RFC3339),
-	})
+	return m
 }
 
-// ChatBotAuditor endpoint para chatear con Bot Auditor
-func ChatBotAuditor(c *fiber.Ctx) error {
-	var req struct {
-		SessionID string `json:"session_id"`
-		Mensaje   string `json:"mensaje"`
+func infoEnrutado(e *bots.Enrutado) fiber.Map {
+	return fiber.Map{
+		"motivo":       e.Motivo,
+		"traspaso":     e.Traspaso(),
+		"bot_anterior": e.Anterior,
 	}
+}
 
+// Chat chat con los bots: en /api/chat el enrutador elige el bot según la
+// intención (y puede traspasar la conversación a otro); en /api/chat/:bot
+// responde el bot indicado
+// POST /api/chat {"session_id", "mensaje", "bot"}
+func Chat(c *fiber.Ctx) error {
+	var req peticionChat
 	if err := c.BodyParser(&req); err != nil {
-		return c.Status(400).JSON(fiber.Map{
-			"error": "JSON inválido",
-		})
+		return c.Status(400).JSON(fiber.Map{"success": false, "error": "JSON inválido"})
 	}
-
-	if req.SessionID == "" {
-		req.SessionID = uuid.New().String()
+	bot, enrutado, err := elegirBot(c, &req)
+	if bot == nil {
+		return err
 	}
 
-	respuesta, err := botAuditor.ProcesarConsulta(req.SessionID, req.Mensaje)
+	respuesta, err := bot.ProcesarConsulta(req.SessionID, req.Mensaje, nil)
 	if err != nil {
-		return c.Status(500).JSON(fiber.Map{
-			"error": err.Error(),
-		})
+		return c.Status(500).JSON(fiber.Map{"success": false, "error": err.Error()})
 	}
-
-	return c.JSON(fiber.Map{
-		"session_id": req.SessionID,
-		"bot":        "auditor",
-		"respuesta":  respuesta,
-		"timestamp":  time.Now().Format(time.RFC3339),
-	})
+	return c.JSON(respuestaChat(req.SessionID, bot, enrutado, respuesta))
 }
 
-// ListarBots lista todos los bots disponibles
+// ListarBots lista los bots registrados
 func ListarBots(c *fiber.Ctx) error {
+	lista := []fiber.Map{}
+	for _, b := range bots.ListaBots() {
+		info := b.Info()
+		lista = append(lista, fiber.Map{
+			"id":          info.ID,
+			"nombre":      info.Nombre,
+			"descripcion": info.Descripcion,
+			"endpoint":    "/api/chat/" + info.ID,
+			"stream":      "/api/chat/" + info.ID + "/stream",
+			"ejemplos":    info.Ejemplos,
+		})
+	}
 	return c.JSON(fiber.Map{
-		"bots": []fiber.Map{
-			{
-				"id":          "atencion",
-				"nombre":      "Atención al Cliente",
-				"descripcion": "Busca clientes por nombre/NIF, consulta pólizas, recibos y siniestros de un cliente específico. Pregunta: 'Busca a Juan García' o 'Pólizas del cliente 12345678A'",
-				"endpoint":    "/api/chat/atencion",
-				"ejemplos":    []string{"Busca a Héctor Pérez", "Pólizas del cliente 20777103/000", "¿Qué productos ofrece Occident?"},
-			},
-			{
-				"id":          "cobranza",
-				"nombre":      "Gestor de Cobranza",
-				"descripcion": "Lista clientes morosos, recibos pendientes/vencidos, genera mensajes de recobro. Pregunta: 'Clientes que deben' o 'Recibos vencidos'",
-				"endpoint":    "/api/chat/cobranza",
-				"ejemplos":    []string{"Recibos pendientes", "Clientes morosos", "Genera carta de recobro nivel 2", "Lista de contacto para llamar"},
-			},
-			{
-				"id":          "siniestros",
-				"nombre":      "Gestor de Siniestros",
-				"descripcion": "Lista siniestros abiertos, estadísticas por tramitador, documentación necesaria para partes. Pregunta: 'Siniestros abiertos' o '¿Qué documentos necesito para un parte de auto?'",
-				"endpoint":    "/api/chat/siniestros",
-				"ejemplos":    []string{"Siniestros abiertos", "Estadísticas por tramitador", "¿Qué documentos necesito para un siniestro de hogar?"},
-			},
-			{
-				"id":          "agente",
-				"nombre":      "Agente Comercial",
-				"descripcion": "Información sobre productos Occident, precios orientativos, comparativas. Pregunta sobre seguros de auto, hogar, vida, salud, PIAS, etc.",
-				"endpoint":    "/api/chat/agente",
-				"ejemplos":    []string{"¿Qué seguros de vida tenéis?", "Información sobre PIAS de Seguros Bilbao", "Diferencia entre todo riesgo con y sin franquicia"},
-			},
-			{
-				"id":          "analista",
-				"nombre":      "Analista de Datos",
-				"descripcion": "Estadísticas de cartera, top clientes por primas, distribución por ramos, análisis de tendencias.",
-				"endpoint":    "/api/chat/analista",
-				"ejemplos":    []string{"Top 20 clientes", "Distribución por ramos", "Estadísticas generales"},
-			},
-			{
-				"id":          "auditor",
-				"nombre":      "Auditor de Calidad",
-				"descripcion": "Detecta clientes duplicados, datos incompletos, problemas de integridad referencial.",
-				"endpoint":    "/api/chat/auditor",
-				"ejemplos":    []string{"Busca duplicados", "Análisis de calidad de datos", "Datos huérfanos"},
-			},
-		},
+		"bots": lista,
+		// Sin bot: el enrutador elige según el mensaje
+		"endpoint": "/api/chat",
 	})
 }
 
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"soriano-mediadores/internal/ai"
+	"soriano-mediadores/internal/bots"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestChatEnrutado(t *testing.T) {
+	useStreamBots(t)
+	ai.SetProvider(bots.RouterID, &ai.StubProvider{Reply: "agente"})
+	// La conversación la llevaba atención: el enrutador la pasa al agente
+	bots.Registrar("chat-1", "bot_atencion", bots.RolUsuario, "Busca a Ana García")
+
+	app := fiber.New()
+	app.Post("/api/chat", Chat)
+	app.Post("/api/chat/:bot", Chat)
+
+	enviar := func(ruta, body string) (int, map[string]interface{}) {
+		req := httptest.NewRequest("POST", ruta, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		var m map[string]interface{}
+		json.Unmarshal(data, &m)
+		return resp.StatusCode, m
+	}
+
+	status, m := enviar("/api/chat", `{"session_id": "chat-1", "mensaje": "¿Qué es un PIAS?"}`)
+	if status != 200 || m["bot"] != "agente" || m["respuesta"] != respuestaPIAS {
+		t.Fatalf("%d: %v", status, m)
+	}
+	enrutado, _ := m["enrutado"].(map[string]interface{})
+	if enrutado["motivo"] != bots.MotivoModelo || enrutado["traspaso"] != true || enrutado["bot_anterior"] != "atencion" {
+		t.Fatalf("enrutado = %v", m["enrutado"])
+	}
+
+	// Con el bot en la ruta no hay enrutado
+	bots.Registrar("chat-2", "bot_agente", bots.RolUsuario, "Hola")
+	if status, m := enviar("/api/chat/agente", `{"session_id": "chat-2", "mensaje": "¿Qué es un PIAS?"}`); status != 200 || m["enrutado"] != nil {
+		t.Fatalf("%d: %v", status, m)
+	}
+	if status, _ := enviar("/api/chat/desconocido", `{"mensaje": "hola"}`); status != 404 {
+		t.Fatalf("bot desconocido: %d", status)
+	}
+	if status, _ := enviar("/api/chat", `{"mensaje": " "}`); status != 400 {
+		t.Fatalf("sin mensaje: %d", status)
+	}
+}
+
+func TestListarBots(t *testing.T) {
+	app := fiber.New()
+	app.Get("/api/bots", ListarBots)
+	resp, err := app.Test(httptest.NewRequest("GET", "/api/bots", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body struct {
+		Bots []struct {
+			ID       string `json:"id"`
+			Endpoint string `json:"endpoint"`
+		} `json:"bots"`
+		Endpoint string `json:"endpoint"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	if len(body.Bots) != len(bots.ListaBots()) || len(body.Bots) != 6 || body.Endpoint != "/api/chat" {
+		t.Fatalf("bots = %+v", body)
+	}
+	if body.Bots[0].Endpoint != "/api/chat/"+body.Bots[0].ID {
+		t.Fatalf("endpoint = %q", body.Bots[0].Endpoint)
+	}
+}
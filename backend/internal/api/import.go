@@ -1,13 +1,13 @@
 package api
 
 import (
-	"database/sql"
-	"encoding/csv"
-	"encoding/json"
+	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"log"
-	"soriano-mediadores/internal/db"
+	"os"
 	"strconv"
 	"strings"
 	"sync"
@@ -40,11 +40,14 @@ const (
 type ImportStatus string
 
 const (
-	StatusPending    ImportStatus = "pending"
-	StatusProcessing ImportStatus = "processing"
-	StatusCompleted  ImportStatus = "completed"
-	StatusFailed     ImportStatus = "failed"
-	StatusCancelled  ImportStatus = "cancelled"
+	StatusPending     ImportStatus = "pending"
+	StatusProcessing  ImportStatus = "processing"
+	StatusCompleted   ImportStatus = "completed"
+	StatusFailed      ImportStatus = "failed"
+	StatusCancelled   ImportStatus = "cancelled"
+	StatusInterrupted ImportStatus = "interrupted" // El servidor se detuvo durante el proceso
+	StatusReverted    ImportStatus = "reverted"    // Cambios deshechos con RevertImport
+	StatusValidated   ImportStatus = "validated"   // Validación (dry_run) terminada sin escribir nada
 )
 
 // ImportJob representa un trabajo de importación
@@ -59,13 +62,24 @@ type ImportJob struct {
 	FailedRows        int          `json:"failed_rows"`
 	DuplicateRows     int          `json:"duplicate_rows"`
 	SkippedRows       int          `json:"skipped_rows"`
+	DeactivatedRows   int          `json:"deactivated_rows"` // Modo replace: registros ausentes desactivados
 	Errors            []ImportError `json:"errors"`
 	StartedAt         time.Time    `json:"started_at"`
 	CompletedAt       *time.Time   `json:"completed_at,omitempty"`
 	ValidateFirst     bool         `json:"validate_first"`
+	DryRun            bool         `json:"dry_run"`       // Solo validar, nunca escribir
+	AcceptErrors      bool         `json:"accept_errors"` // Importar las filas válidas aunque la validación tenga errores
 	DuplicateHandling string       `json:"duplicate_handling"` // skip, update, error
 	Username          string       `json:"username,omitempty"`
 	Filename          string       `json:"filename,omitempty"`
+	CommitMode        string       `json:"commit_mode"`          // job, chunk
+	ChunkSize         int          `json:"chunk_size,omitempty"` // Filas por tramo en modo chunk
+	MaxDeactivatePct  float64      `json:"max_deactivate_pct,omitempty"` // Modo replace: % máximo de activos a desactivar
+	ProfileID         int          `json:"profile_id,omitempty"`         // Perfil de mapeo (0 = formato Occident)
+	Sheet             string       `json:"sheet,omitempty"`              // Hoja importada (solo .xlsx)
+	Instance          string       `json:"instance,omitempty"`           // Instancia del servidor que lo procesa
+	HeartbeatAt       *time.Time   `json:"heartbeat_at,omitempty"`       // Última señal de vida (lo fija el store)
+	format            importFormat
 	mu                sync.RWMutex
 	cancel            chan bool
 }
@@ -78,12 +92,63 @@ type ImportError struct {
 	Value   string `json:"value,omitempty"`
 }
 
-// Almacenamiento de jobs en memoria (en producción usar Redis/DB)
+// importProgressFlushRows cada cuántas filas se persiste el progreso en import_jobs
+const importProgressFlushRows = 250
+
+// importHeartbeatInterval cada cuánto renueva su heartbeat un job en proceso
+// aunque no avance (p. ej. aplicando un tramo grande). Un job sin heartbeat
+// en importHeartbeatStale se da por interrumpido (ver RecoverInterruptedImports).
+const (
+	importHeartbeatInterval = 30 * time.Second
+	importHeartbeatStale    = 4 * importHeartbeatInterval
+)
+
+// importInstanceID identifica esta instancia del servidor en import_jobs
+var importInstanceID = func() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s:%d", host, os.Getpid())
+}()
+
+// Jobs en ejecución en este proceso (para cancelarlos). El estado se lee de import_jobs.
 var (
-	importJobs   = make(map[string]*ImportJob)
-	importJobsMu sync.RWMutex
+	activeImports   = make(map[string]*ImportJob)
+	activeImportsMu sync.RWMutex
 )
 
+func registerActiveImport(job *ImportJob) {
+	activeImportsMu.Lock()
+	activeImports[job.ID] = job
+	activeImportsMu.Unlock()
+}
+
+func unregisterActiveImport(id string) {
+	activeImportsMu.Lock()
+	delete(activeImports, id)
+	activeImportsMu.Unlock()
+}
+
+func getActiveImport(id string) *ImportJob {
+	activeImportsMu.RLock()
+	defer activeImportsMu.RUnlock()
+	return activeImports[id]
+}
+
+// loadImportJob obtiene un job del store y responde 404/500 si no se puede
+func loadImportJob(c *fiber.Ctx, importID string) (*ImportJob, error) {
+	job, err := importJobStore.Get(importID)
+	if err == ErrImportJobNotFound {
+		return nil, c.Status(404).JSON(fiber.Map{
+			"error": "Import job no encontrado",
+		})
+	}
+	if err != nil {
+		return nil, c.Status(500).JSON(fiber.Map{
+			"error": "Error obteniendo import job: " + err.Error(),
+		})
+	}
+	return job, nil
+}
+
 // PreviewCSV procesa las primeras 10 filas del CSV
 func PreviewCSV(c *fiber.Ctx) error {
 	file, err := c.FormFile("file")
@@ -102,11 +167,28 @@ func PreviewCSV(c *fiber.Ctx) error {
 	}
 	defer fileContent.Close()
 
-	// Leer CSV
-	reader := csv.NewReader(fileContent)
-	reader.Comma = ';' // Occident usa punto y coma
-	reader.LazyQuotes = true
-	reader.TrimLeadingSpace = true
+	// Perfil elegido o, si no, formato Occident con el delimitador detectado
+	profile, err := importProfileParam(c, ImportType(c.FormValue("type")))
+	if err != nil {
+		return err
+	}
+	format := occidentFormat
+	input := bufio.NewReader(fileContent)
+	if profile != nil {
+		format = profile.format()
+	}
+	format = format.withWorkbook(file.Filename, c.FormValue("sheet"))
+	if profile == nil && !format.Workbook {
+		format.Comma = sniffDelimiter(peekFirstLine(input))
+	}
+
+	// Leer CSV o libro Excel
+	reader, err := format.rowReader(input, ImportType(c.FormValue("type")))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
 
 	// Leer encabezados
 	headers, err := reader.Read()
@@ -116,6 +198,14 @@ func PreviewCSV(c *fiber.Ctx) error {
 		})
 	}
 
+	// Sugerir perfil por fingerprint de los encabezados
+	var suggestion *ProfileSuggestion
+	if profiles, err := listImportProfiles(""); err != nil {
+		log.Printf("⚠️ Error cargando perfiles de importación: %v", err)
+	} else {
+		suggestion = suggestImportProfile(profiles, headers)
+	}
+
 	// Leer hasta 10 filas
 	var rows [][]string
 	for i := 0; i < 10; i++ {
@@ -141,15 +231,26 @@ func PreviewCSV(c *fiber.Ctx) error {
 		}
 	}
 
+	data := fiber.Map{
+		"fileName":         file.Filename,
+		"fileSize":         file.Size,
+		"headers":          headers,
+		"rows":             rows,
+		"totalRows":        totalRows,
+		"delimiter":        string(format.Comma),
+		"mappedHeaders":    format.mapHeaders(headers),
+		"suggestedProfile": suggestion,
+	}
+	if workbook, ok := reader.(*xlsxRowReader); ok {
+		delete(data, "delimiter")
+		data["sheets"] = workbook.Sheets
+		data["sheet"] = workbook.Sheet
+		data["headerRow"] = workbook.HeaderRow
+	}
+
 	return c.JSON(fiber.Map{
 		"success": true,
-		"data": fiber.Map{
-			"fileName":  file.Filename,
-			"fileSize":  file.Size,
-			"headers":   headers,
-			"rows":      rows,
-			"totalRows": totalRows,
-		},
+		"data":    data,
 	})
 }
 
@@ -167,8 +268,12 @@ func StartImport(c *fiber.Ctx) error {
 	importType := ImportType(c.FormValue("type", "clientes"))
 	importMode := ImportMode(c.FormValue("mode", "add"))
 	validateFirst := c.FormValue("validate_first") == "true"
+	dryRun := c.FormValue("dry_run") == "true"
+	acceptErrors := c.FormValue("accept_errors") == "true"
 	duplicateHandling := c.FormValue("duplicate_handling", "skip")
 	username := c.FormValue("username", "admin")
+	commitMode := c.FormValue("commit_mode", CommitJob)
+	chunkSize, _ := strconv.Atoi(c.FormValue("chunk_size"))
 
 	// Validar tipo
 	if !isValidImportType(importType) {
@@ -177,6 +282,55 @@ func StartImport(c *fiber.Ctx) error {
 		})
 	}
 
+	// Perfil de mapeo (otras compañías)
+	profile, err := importProfileParam(c, importType)
+	if err != nil {
+		return err
+	}
+	if commitMode != CommitJob && commitMode != CommitChunk {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "commit_mode inválido (job o chunk)",
+		})
+	}
+	if !IsValidImportMode(importMode) {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Modo de importación inválido",
+		})
+	}
+
+	// Replace trata el archivo como snapshot completo: tiene que aplicarse entero
+	maxDeactivatePct := 0.0
+	if importMode == ModeReplace {
+		if commitMode == CommitChunk {
+			return c.Status(400).JSON(fiber.Map{
+				"error": "El modo replace requiere commit_mode=job",
+			})
+		}
+		if acceptErrors {
+			return c.Status(400).JSON(fiber.Map{
+				"error": "El modo replace no admite accept_errors: las filas rechazadas se desactivarían",
+			})
+		}
+		maxDeactivatePct = replaceMaxDeactivatePctFromEnv()
+		if v := c.FormValue("max_deactivate_pct"); v != "" {
+			pct, err := strconv.ParseFloat(v, 64)
+			if err != nil || pct < 0 || pct > 100 {
+				return c.Status(400).JSON(fiber.Map{
+					"error": "max_deactivate_pct debe ser un porcentaje entre 0 y 100",
+				})
+			}
+			maxDeactivatePct = pct
+		}
+	}
+
+	if dryRun {
+		// Un dry run es la validación previa sin la fase de escritura
+		validateFirst = true
+	}
+	if commitMode == CommitChunk && chunkSize <= 0 {
+		chunkSize = importChunkSizeFromEnv()
+	}
+
 	// Crear job
 	job := &ImportJob{
 		ID:                uuid.New().String(),
@@ -185,31 +339,52 @@ func StartImport(c *fiber.Ctx) error {
 		Status:            StatusPending,
 		StartedAt:         time.Now(),
 		ValidateFirst:     validateFirst,
+		DryRun:            dryRun,
+		AcceptErrors:      acceptErrors,
 		DuplicateHandling: duplicateHandling,
 		Username:          username,
 		Filename:          file.Filename,
+		CommitMode:        commitMode,
+		ChunkSize:         chunkSize,
+		MaxDeactivatePct:  maxDeactivatePct,
+		Instance:          importInstanceID,
+		format:            occidentFormat,
+		Errors:            []ImportError{},
 		cancel:            make(chan bool),
 	}
 
-	// Guardar job
-	importJobsMu.Lock()
-	importJobs[job.ID] = job
-	importJobsMu.Unlock()
+	if profile != nil {
+		job.ProfileID = profile.ID
+		job.format = profile.format()
+	}
+	job.format = job.format.withWorkbook(file.Filename, c.FormValue("sheet"))
+	job.Sheet = job.format.Sheet
+
+	// Guardar job (pending)
+	if err := importJobStore.Save(job); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error registrando import job: " + err.Error(),
+		})
+	}
 
 	// Abrir archivo
 	fileContent, err := file.Open()
 	if err != nil {
+		now := time.Now()
 		job.Status = StatusFailed
+		job.CompletedAt = &now
 		job.Errors = append(job.Errors, ImportError{
 			Row:     0,
 			Message: "Error abriendo archivo: " + err.Error(),
 		})
+		persistImportJob(job)
 		return c.Status(500).JSON(fiber.Map{
 			"error": "Error abriendo archivo",
 		})
 	}
 
 	// Procesar asíncronamente
+	registerActiveImport(job)
 	go processImport(job, fileContent)
 
 	return c.JSON(fiber.Map{
@@ -223,19 +398,11 @@ func StartImport(c *fiber.Ctx) error {
 func GetImportStatus(c *fiber.Ctx) error {
 	importID := c.Params("id")
 
-	importJobsMu.RLock()
-	job, exists := importJobs[importID]
-	importJobsMu.RUnlock()
-
-	if !exists {
-		return c.Status(404).JSON(fiber.Map{
-			"error": "Import job no encontrado",
-		})
+	job, err := loadImportJob(c, importID)
+	if job == nil {
+		return err
 	}
 
-	job.mu.RLock()
-	defer job.mu.RUnlock()
-
 	return c.JSON(job)
 }
 
@@ -243,22 +410,48 @@ func GetImportStatus(c *fiber.Ctx) error {
 func CancelImport(c *fiber.Ctx) error {
 	importID := c.Params("id")
 
-	importJobsMu.RLock()
-	job, exists := importJobs[importID]
-	importJobsMu.RUnlock()
+	// Si el job corre en este proceso, señalizar al goroutine
+	if active := getActiveImport(importID); active != nil {
+		cancelled := active.requestCancel()
+		persistImportJob(active)
 
-	if !exists {
-		return c.Status(404).JSON(fiber.Map{
-			"error": "Import job no encontrado",
+		active.mu.RLock()
+		status := active.Status
+		active.mu.RUnlock()
+
+		if !cancelled {
+			return c.Status(400).JSON(fiber.Map{
+				"error":  "El import job ya no está en proceso",
+				"status": status,
+			})
+		}
+		return c.JSON(fiber.Map{
+			"message": "Import job cancelado",
+			"status":  status,
 		})
 	}
 
-	job.mu.Lock()
-	if job.Status == StatusProcessing {
-		job.Status = StatusCancelled
-		close(job.cancel)
+	job, err := loadImportJob(c, importID)
+	if job == nil {
+		return err
+	}
+
+	if job.Status != StatusPending && job.Status != StatusProcessing {
+		return c.Status(400).JSON(fiber.Map{
+			"error":  "El import job ya no está en proceso",
+			"status": job.Status,
+		})
+	}
+
+	// Job huérfano (otra instancia o reinicio): solo actualizar el registro
+	now := time.Now()
+	job.Status = StatusCancelled
+	job.CompletedAt = &now
+	if err := importJobStore.Save(job); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error cancelando import job: " + err.Error(),
+		})
 	}
-	job.mu.Unlock()
 
 	return c.JSON(fiber.Map{
 		"message": "Import job cancelado",
@@ -266,66 +459,151 @@ func CancelImport(c *fiber.Ctx) error {
 	})
 }
 
-// GetImportHistory obtiene el historial de importaciones
-func GetImportHistory(c *fiber.Ctx) error {
-	limit := c.QueryInt("limit", 50)
+// requestCancel marca el job como cancelado y cierra el canal una sola vez.
+// Devuelve false si el job ya había terminado.
+func (job *ImportJob) requestCancel() bool {
+	job.mu.Lock()
+	defer job.mu.Unlock()
 
-	// Obtener todos los jobs ordenados por fecha
-	importJobsMu.RLock()
-	defer importJobsMu.RUnlock()
+	if job.Status != StatusPending && job.Status != StatusProcessing {
+		return false
+	}
+	now := time.Now()
+	job.Status = StatusCancelled
+	job.CompletedAt = &now
+	close(job.cancel)
+	return true
+}
 
-	var jobs []*ImportJob
-	for _, job := range importJobs {
-		jobs = append(jobs, job)
+// markInterrupted detiene el job porque otra instancia lo marcó como
+// interrumpido (su heartbeat llegó a parecer caducado)
+func (job *ImportJob) markInterrupted() {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	if job.Status != StatusPending && job.Status != StatusProcessing {
+		return
 	}
+	job.Status = StatusInterrupted
+	close(job.cancel)
+}
 
-	// Ordenar por fecha (más reciente primero)
-	// En producción, esto debería venir de la base de datos ordenado
-	for i := 0; i < len(jobs)-1; i++ {
-		for j := i + 1; j < len(jobs); j++ {
-			if jobs[j].StartedAt.After(jobs[i].StartedAt) {
-				jobs[i], jobs[j] = jobs[j], jobs[i]
+// startImportHeartbeat renueva el heartbeat del job mientras se procesa;
+// devuelve la función que lo detiene
+func startImportHeartbeat(job *ImportJob) func() {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(importHeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				persistImportJob(job)
 			}
 		}
+	}()
+	return func() { close(done) }
+}
+
+// GetImportHistory obtiene el historial de importaciones
+// Filtros: type, status (separados por coma), username, fecha_desde, fecha_hasta, limit, offset
+func GetImportHistory(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 50)
+	if limit <= 0 || limit > 500 {
+		limit = 50
+	}
+	offset := c.QueryInt("offset", 0)
+	if offset < 0 {
+		offset = 0
 	}
 
-	// Limitar resultados
-	if len(jobs) > limit {
-		jobs = jobs[:limit]
+	filter := ImportHistoryFilter{
+		Type:       ImportType(c.Query("type")),
+		Username:   c.Query("username"),
+		FechaDesde: c.Query("fecha_desde"),
+		FechaHasta: c.Query("fecha_hasta"),
+		Limit:      limit,
+		Offset:     offset,
+	}
+	if filter.Type != "" && !isValidImportType(filter.Type) {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Tipo de importación inválido",
+		})
+	}
+	for _, dateParam := range []string{filter.FechaDesde, filter.FechaHasta} {
+		if dateParam == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", dateParam); err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"error": "Fecha inválida (formato YYYY-MM-DD): " + dateParam,
+			})
+		}
+	}
+	if statuses := c.Query("status"); statuses != "" {
+		for _, st := range strings.Split(statuses, ",") {
+			filter.Status = append(filter.Status, ImportStatus(strings.TrimSpace(st)))
+		}
+	}
+
+	jobs, total, err := importJobStore.List(filter)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error obteniendo historial: " + err.Error(),
+		})
 	}
 
 	return c.JSON(fiber.Map{
-		"total":   len(jobs),
+		"total":   total,
+		"limit":   limit,
+		"offset":  offset,
 		"imports": jobs,
 	})
 }
 
-// RevertImport revierte una importación (marca registros como inactivos)
+// RevertImport revierte una importación: desactiva los registros insertados y
+// restaura los actualizados a su estado previo (?force=true ignora conflictos)
 func RevertImport(c *fiber.Ctx) error {
 	importID := c.Params("id")
 
-	importJobsMu.RLock()
-	job, exists := importJobs[importID]
-	importJobsMu.RUnlock()
-
-	if !exists {
-		return c.Status(404).JSON(fiber.Map{
-			"error": "Import job no encontrado",
-		})
+	job, err := loadImportJob(c, importID)
+	if job == nil {
+		return err
 	}
 
 	if job.Status != StatusCompleted {
 		return c.Status(400).JSON(fiber.Map{
-			"error": "Solo se pueden revertir importaciones completadas",
+			"error":  "Solo se pueden revertir importaciones completadas",
+			"status": job.Status,
+		})
+	}
+
+	results, err := revertImport(importID, c.QueryBool("force", false))
+	if errors.Is(err, ErrImportRevertConflict) {
+		return c.Status(409).JSON(fiber.Map{
+			"error": "No se puede revertir: " + err.Error() + " (usar ?force=true para forzar)",
+		})
+	}
+	if errors.Is(err, ErrImportNotRevertible) {
+		return c.Status(409).JSON(fiber.Map{
+			"error": "No se puede revertir: " + err.Error(),
+		})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error revirtiendo importación: " + err.Error(),
 		})
 	}
 
-	// En producción, esto marcaría los registros importados como inactivos
-	// usando un campo import_id en cada tabla
+	log.Printf("↩️  Import job %s revertido: %+v", importID, results)
 
 	return c.JSON(fiber.Map{
-		"message": "Importación revertida exitosamente",
+		"message":   "Importación revertida exitosamente",
 		"import_id": importID,
+		"status":    StatusReverted,
+		"tables":    results,
 	})
 }
 
@@ -346,6 +624,16 @@ func ValidateImport(c *fiber.Ctx) error {
 		})
 	}
 
+	profile, err := importProfileParam(c, importType)
+	if err != nil {
+		return err
+	}
+	format := occidentFormat
+	if profile != nil {
+		format = profile.format()
+	}
+	format = format.withWorkbook(file.Filename, c.FormValue("sheet"))
+
 	fileContent, err := file.Open()
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{
@@ -355,7 +643,7 @@ func ValidateImport(c *fiber.Ctx) error {
 	defer fileContent.Close()
 
 	// Validar estructura
-	errors := validateCSVStructure(fileContent, importType)
+	errors := validateCSVStructure(fileContent, importType, format)
 
 	return c.JSON(fiber.Map{
 		"valid":  len(errors) == 0,
@@ -403,542 +691,367 @@ func GetImportTemplate(c *fiber.Ctx) error {
 	return c.SendString(strings.Join(headers, ";") + "\n")
 }
 
-// processImport procesa el import job asíncronamente
+// processImport procesa el import job asíncronamente: las filas se parsean, se
+// cargan por tramos con COPY y se aplican en transacción (ver import_pipeline.go).
+// Con validate_first el archivo pasa antes por una validación completa sin escrituras.
 func processImport(job *ImportJob, file io.ReadCloser) {
 	defer file.Close()
+	defer unregisterActiveImport(job.ID)
 
 	job.mu.Lock()
+	if job.Status != StatusPending {
+		// Cancelado antes de empezar
+		job.mu.Unlock()
+		return
+	}
 	job.Status = StatusProcessing
 	job.mu.Unlock()
+	persistImportJob(job)
+	defer startImportHeartbeat(job)()
 
-	reader := csv.NewReader(file)
-	reader.Comma = ';'
-	reader.LazyQuotes = true
-	reader.TrimLeadingSpace = true
+	var input io.Reader = file
+	var rejected map[int]bool
 
-	// Leer encabezados
-	headers, err := reader.Read()
-	if err != nil {
+	if job.ValidateFirst {
+		// La validación previa necesita leer el archivo dos veces
+		data, err := io.ReadAll(file)
+		if err != nil {
+			job.finish(StatusFailed, ImportError{
+				Row:     0,
+				Message: "Error leyendo archivo: " + err.Error(),
+			})
+			return
+		}
+
+		var ok bool
+		if rejected, ok = validateImport(job, bytes.NewReader(data)); !ok {
+			return
+		}
+
+		if job.DryRun {
+			job.finish(StatusValidated)
+			log.Printf("🔎 Import job %s validado: %d filas, %d con errores", job.ID, job.TotalRows, len(rejected))
+			return
+		}
+		if len(rejected) > 0 && !job.AcceptErrors {
+			job.finish(StatusFailed, ImportError{
+				Row:     0,
+				Message: fmt.Sprintf("La validación previa encontró %d filas con errores; no se ha importado nada", len(rejected)),
+			})
+			return
+		}
+
+		// Las filas rechazadas ya cuentan como fallidas; el resto se recuenta al escribir
 		job.mu.Lock()
-		job.Status = StatusFailed
-		job.Errors = append(job.Errors, ImportError{
-			Row:     0,
-			Message: "Error leyendo encabezados: " + err.Error(),
-		})
+		job.TotalRows = 0
+		job.ProcessedRows = 0
+		job.DeactivatedRows = 0
 		job.mu.Unlock()
+		input = bytes.NewReader(data)
+	}
+
+	run := &importRun{job: job, spec: importSpecs[job.Type], chunkSize: job.ChunkSize}
+	if job.CommitMode != CommitChunk {
+		run.chunkSize = 0
+	}
+
+	err := scanImportRows(job, input, func(rowNum int, values []interface{}, rowErrors []ImportError) error {
+		if run.size == 0 {
+			run.firstRow = rowNum
+		}
+		run.size++
+
+		switch {
+		case rejected[rowNum]:
+			// Aceptada como errónea en la validación previa: no se importa
+		case len(rowErrors) > 0:
+			run.rowFailed(rowErrors...)
+		default:
+			if err := run.add(rowNum, values); err != nil {
+				return err
+			}
+		}
+
+		if run.chunkSize > 0 && run.size >= run.chunkSize {
+			run.flush()
+		}
+		return nil
+	})
+	if err != nil {
+		run.abort()
+		if errors.Is(err, errImportCancelled) {
+			persistImportJob(job)
+			log.Printf("🛑 Import job %s detenido (%s) en fila %d", job.ID, job.Status, job.ProcessedRows)
+			return
+		}
+		job.finish(StatusFailed, ImportError{Row: 0, Message: err.Error()})
 		return
 	}
 
+	run.flush()
+
+	// Finalizar
+	job.mu.RLock()
+	failed := run.failed > 0 && (job.SuccessfulRows == 0 || job.CommitMode != CommitChunk)
+	job.mu.RUnlock()
+
+	if failed {
+		job.finish(StatusFailed)
+	} else {
+		job.finish(StatusCompleted)
+	}
+
+	log.Printf("✅ Import job %s terminado (%s): %d exitosos, %d fallidos, %d desactivados",
+		job.ID, job.Status, job.SuccessfulRows, job.FailedRows, job.DeactivatedRows)
+}
+
+// errImportCancelled corta la lectura del CSV cuando se cancela el job
+var errImportCancelled = errors.New("importación cancelada")
+
+// scanImportRows lee el CSV, parsea cada fila con la especificación del tipo y
+// la pasa a fn junto con sus errores de campo. Actualiza los contadores de
+// progreso y devuelve errImportCancelled si el job se cancela.
+func scanImportRows(job *ImportJob, r io.Reader, fn func(rowNum int, values []interface{}, rowErrors []ImportError) error) error {
+	format := job.importFormat()
+	reader, err := format.rowReader(r, job.Type)
+	if err != nil {
+		return err
+	}
+	if workbook, ok := reader.(*xlsxRowReader); ok {
+		job.mu.Lock()
+		job.Sheet = workbook.Sheet
+		job.mu.Unlock()
+	}
+
+	// Leer encabezados (renombrados a los campos canónicos si hay perfil)
+	headers, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("Error leyendo encabezados: %v", err)
+	}
+	headers = format.mapHeaders(headers)
+
+	spec := importSpecs[job.Type]
 	rowNum := 1
 	for {
 		// Verificar cancelación
 		select {
 		case <-job.cancel:
-			return
+			return errImportCancelled
 		default:
 		}
 
 		row, err := reader.Read()
 		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			job.mu.Lock()
-			job.Errors = append(job.Errors, ImportError{
-				Row:     rowNum,
-				Message: "Error leyendo fila: " + err.Error(),
-			})
-			job.FailedRows++
-			job.mu.Unlock()
-			rowNum++
-			continue
+			return nil
 		}
 
 		job.mu.Lock()
 		job.TotalRows++
+		job.ProcessedRows++
+		processed := job.ProcessedRows
 		job.mu.Unlock()
 
-		// Procesar fila según tipo
-		isDuplicate, err := processRow(job, headers, row, rowNum)
-
-		job.mu.Lock()
-		job.ProcessedRows++
+		var values []interface{}
+		var rowErrors []ImportError
 		if err != nil {
-			job.FailedRows++
-			job.Errors = append(job.Errors, ImportError{
-				Row:     rowNum,
-				Message: err.Error(),
-			})
-		} else if isDuplicate {
-			job.DuplicateRows++
-			job.SkippedRows++
+			rowErrors = []ImportError{{Row: rowNum, Message: "Error leyendo fila: " + err.Error()}}
 		} else {
-			job.SuccessfulRows++
+			values, rowErrors = parseImportRow(spec, format, rowToMap(headers, row), rowNum)
+		}
+
+		if err := fn(rowNum, values, rowErrors); err != nil {
+			return err
+		}
+		if processed%importProgressFlushRows == 0 {
+			persistImportJob(job)
 		}
-		job.mu.Unlock()
 
 		rowNum++
 	}
+}
 
-	// Finalizar
-	job.mu.Lock()
-	if len(job.Errors) > 0 && job.SuccessfulRows == 0 {
-		job.Status = StatusFailed
-	} else {
-		job.Status = StatusCompleted
+// importFormat formato de lectura del job (Occident si no se eligió perfil)
+func (job *ImportJob) importFormat() importFormat {
+	if job.format.Comma == 0 {
+		return occidentFormat
 	}
-	now := time.Now()
-	job.CompletedAt = &now
-	job.mu.Unlock()
-
-	// Guardar en base de datos
-	saveImportJobToDB(job)
-
-	log.Printf("✅ Import job %s completado: %d exitosos, %d fallidos",
-		job.ID, job.SuccessfulRows, job.FailedRows)
+	return job.format
 }
 
-// processRow procesa una fila según el tipo de importación
-// Retorna (isDuplicate, error)
-func processRow(job *ImportJob, headers []string, row []string, rowNum int) (bool, error) {
-	// Convertir a mapa
-	data := make(map[string]string)
-	for i, header := range headers {
-		if i < len(row) {
-			data[strings.TrimSpace(header)] = strings.TrimSpace(row[i])
-		}
+// importProfileParam resuelve el parámetro "profile" (ID o nombre) y comprueba
+// que corresponde al tipo. Devuelve nil si no se indicó; en error ya respondió.
+func importProfileParam(c *fiber.Ctx, importType ImportType) (*ImportProfile, error) {
+	ref := c.FormValue("profile", c.Query("profile"))
+	if ref == "" {
+		return nil, nil
 	}
 
-	switch job.Type {
-	case ImportClientes:
-		return importCliente(data, job.Mode, job.DuplicateHandling)
-	case ImportPolizas:
-		return importPoliza(data, job.Mode, job.DuplicateHandling)
-	case ImportRecibos:
-		return importRecibo(data, job.Mode, job.DuplicateHandling)
-	case ImportSiniestros:
-		return importSiniestro(data, job.Mode, job.DuplicateHandling)
-	default:
-		return false, fmt.Errorf("tipo de importación no soportado: %s", job.Type)
+	profile, err := findImportProfile(ref)
+	if err != nil {
+		return nil, c.Status(400).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if importType != "" && profile.Type != importType {
+		return nil, c.Status(400).JSON(fiber.Map{
+			"error": fmt.Sprintf("El perfil %s es de %s, no de %s", profile.Name, profile.Type, importType),
+		})
 	}
+	return profile, nil
 }
 
-// importCliente importa un cliente desde CSV de Occident
-// Campos Occident: NIF, Nombre completo, Nombre, Apellidos, Fecha nacimiento, Sexo,
-// Domicilio, Teléfono contacto, 2º Teléfono contacto, Población, Código postal,
-// Email contacto, Total primas en cartera, Total primas relación, Mediador, Provincia, IdAccount
-func importCliente(data map[string]string, mode ImportMode, dupHandling string) (bool, error) {
-	// Mapear campos de Occident (case insensitive con BOM)
-	nif := getField(data, "NIF", "nif")
-	idAccount := getField(data, "IdAccount", "id_account")
-
-	if nif == "" && idAccount == "" {
-		return false, fmt.Errorf("NIF o ID Account requerido")
+// finish cierra el job con el estado final (salvo que ya esté cancelado o
+// interrumpido) y lo persiste
+func (job *ImportJob) finish(status ImportStatus, errs ...ImportError) {
+	job.mu.Lock()
+	if job.Status != StatusCancelled && job.Status != StatusInterrupted {
+		now := time.Now()
+		job.Status = status
+		job.CompletedAt = &now
 	}
+	job.mu.Unlock()
+	job.addErrors(errs...)
+	persistImportJob(job)
+}
 
-	// Verificar si existe
-	var existingID int
-	query := "SELECT id FROM clientes WHERE nif = $1 OR id_account = $2"
-	err := db.PostgresDB.QueryRow(query, nif, idAccount).Scan(&existingID)
-	exists := (err != sql.ErrNoRows)
+// addErrors añade errores respetando el límite maxStoredImportErrors
+func (job *ImportJob) addErrors(errs ...ImportError) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
 
-	if exists {
-		if dupHandling == "skip" {
-			return true, nil
-		}
-		if dupHandling == "error" {
-			return false, fmt.Errorf("cliente duplicado: %s", nif)
-		}
-		if mode == ModeAdd {
-			return true, nil
+	for _, e := range errs {
+		if len(job.Errors) >= maxStoredImportErrors {
+			return
 		}
+		job.Errors = append(job.Errors, e)
+	}
+}
 
-		// Update con todos los campos de Occident
-		updateSQL := `
-			UPDATE clientes SET
-				nombre_completo = COALESCE(NULLIF($1, ''), nombre_completo),
-				nombre = COALESCE(NULLIF($2, ''), nombre),
-				apellidos = COALESCE(NULLIF($3, ''), apellidos),
-				fecha_nacimiento = COALESCE(NULLIF($4, ''), fecha_nacimiento),
-				sexo = COALESCE(NULLIF($5, ''), sexo),
-				domicilio = COALESCE(NULLIF($6, ''), domicilio),
-				poblacion = COALESCE(NULLIF($7, ''), poblacion),
-				codigo_postal = COALESCE(NULLIF($8, ''), codigo_postal),
-				provincia = COALESCE(NULLIF($9, ''), provincia),
-				email_contacto = COALESCE(NULLIF($10, ''), email_contacto),
-				telefono_contacto = COALESCE(NULLIF($11, ''), telefono_contacto),
-				telefono2_contacto = COALESCE(NULLIF($12, ''), telefono2_contacto),
-				total_primas_cartera = COALESCE(NULLIF($13, 0), total_primas_cartera),
-				total_primas_relacion = COALESCE(NULLIF($14, 0), total_primas_relacion),
-				num_polizas_totales = COALESCE(NULLIF($15, 0), num_polizas_totales),
-				mediador = COALESCE(NULLIF($16, ''), mediador),
-				actualizado_en = NOW()
-			WHERE id = $17
-		`
-		_, err = db.PostgresDB.Exec(updateSQL,
-			getField(data, "Nombre completo"),
-			getField(data, "Nombre"),
-			getField(data, "Apellidos"),
-			getField(data, "Fecha nacimiento"),
-			getField(data, "Sexo"),
-			getField(data, "Domicilio"),
-			getField(data, "Población"),
-			getField(data, "Código postal"),
-			getField(data, "Provincia"),
-			getField(data, "Email contacto"),
-			getField(data, "Teléfono contacto"),
-			getField(data, "2º Teléfono contacto"),
-			parseFloat(getField(data, "Total primas en cartera")),
-			parseFloat(getField(data, "Total primas relación")),
-			parseInt(getField(data, "Número de pólizas totales")),
-			getField(data, "Mediador"),
-			existingID,
-		)
-		return false, err
-	}
-
-	if mode == ModeUpdate {
-		return false, fmt.Errorf("cliente no existe para actualizar: %s", nif)
-	}
-
-	// Insertar nuevo con todos los campos de Occident
-	insertSQL := `
-		INSERT INTO clientes (
-			nif, id_account, nombre_completo, nombre, apellidos,
-			fecha_nacimiento, sexo, domicilio, poblacion, codigo_postal,
-			provincia, email_contacto, telefono_contacto, telefono2_contacto,
-			total_primas_cartera, total_primas_relacion, num_polizas_totales,
-			mediador, activo, creado_en
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, TRUE, NOW())
-	`
-	_, err = db.PostgresDB.Exec(insertSQL,
-		nif,
-		idAccount,
-		getField(data, "Nombre completo"),
-		getField(data, "Nombre"),
-		getField(data, "Apellidos"),
-		getField(data, "Fecha nacimiento"),
-		getField(data, "Sexo"),
-		getField(data, "Domicilio"),
-		getField(data, "Población"),
-		getField(data, "Código postal"),
-		getField(data, "Provincia"),
-		getField(data, "Email contacto"),
-		getField(data, "Teléfono contacto"),
-		getField(data, "2º Teléfono contacto"),
-		parseFloat(getField(data, "Total primas en cartera")),
-		parseFloat(getField(data, "Total primas relación")),
-		parseInt(getField(data, "Número de pólizas totales")),
-		getField(data, "Mediador"),
-	)
-	return false, err
+// importRun estado del tramo en curso de un import job
+type importRun struct {
+	job       *ImportJob
+	spec      importSpec
+	chunkSize int // 0 = un único tramo para todo el archivo
+	batch     importBatch
+	size      int  // Filas leídas en el tramo actual (válidas o no)
+	pending   int  // Filas válidas enviadas al tramo actual
+	failed    int  // Filas fallidas en esta pasada (sin contar las aceptadas en la validación)
+	broken    bool // El tramo actual tiene filas con error y no se aplicará
+	firstRow  int
 }
 
-// importPoliza importa una póliza desde CSV de Occident
-// Campos Occident: Número de la póliza, Ramo, Mediador, Domicilio de la póliza,
-// Prima anual, Fecha de efecto, Fecha de vencimiento, Gestora, Descripción del riesgo,
-// Matricula, Situación de la póliza, Nombre del cliente, IdAccount
-func importPoliza(data map[string]string, mode ImportMode, dupHandling string) (bool, error) {
-	numeroPoliza := getField(data, "Número de la póliza", "numero_poliza")
-	if numeroPoliza == "" {
-		return false, fmt.Errorf("número de póliza requerido")
-	}
-
-	// Verificar si existe
-	var existingID int
-	query := "SELECT id FROM polizas WHERE numero_poliza = $1"
-	err := db.PostgresDB.QueryRow(query, numeroPoliza).Scan(&existingID)
-	exists := (err != sql.ErrNoRows)
-
-	if exists {
-		if dupHandling == "skip" {
-			return true, nil
-		}
-		if dupHandling == "error" {
-			return false, fmt.Errorf("póliza duplicada: %s", numeroPoliza)
-		}
-		if mode == ModeAdd {
-			return true, nil
+// add envía una fila válida al tramo actual (abriéndolo si hace falta)
+func (r *importRun) add(rowNum int, values []interface{}) error {
+	r.pending++
+	if r.broken {
+		// El tramo ya no se aplicará: solo se siguen validando filas
+		return nil
+	}
+
+	if r.batch == nil {
+		batch, err := newImportBatch(r.job, r.spec)
+		if err != nil {
+			return fmt.Errorf("error iniciando importación: %w", err)
 		}
+		r.batch = batch
+	}
+	if err := r.batch.Add(rowNum, values); err != nil {
+		r.broken = true
+		r.rowFailed(ImportError{Row: rowNum, Message: "Error cargando fila: " + err.Error()})
+		r.pending--
+	}
+	return nil
+}
 
-		// Update con todos los campos de Occident
-		updateSQL := `
-			UPDATE polizas SET
-				id_account = COALESCE(NULLIF($1, ''), id_account),
-				nombre_cliente = COALESCE(NULLIF($2, ''), nombre_cliente),
-				ramo = COALESCE(NULLIF($3, ''), ramo),
-				gestora = COALESCE(NULLIF($4, ''), gestora),
-				mediador = COALESCE(NULLIF($5, ''), mediador),
-				situacion_poliza = COALESCE(NULLIF($6, ''), situacion_poliza),
-				prima_anual = COALESCE(NULLIF($7, ''), prima_anual),
-				fecha_efecto = COALESCE(NULLIF($8, ''), fecha_efecto),
-				fecha_vencimiento = COALESCE(NULLIF($9, ''), fecha_vencimiento),
-				domicilio_poliza = COALESCE(NULLIF($10, ''), domicilio_poliza),
-				descripcion_riesgo = COALESCE(NULLIF($11, ''), descripcion_riesgo),
-				matricula = COALESCE(NULLIF($12, ''), matricula),
-				actualizado_en = NOW()
-			WHERE id = $13
-		`
-		_, err = db.PostgresDB.Exec(updateSQL,
-			getField(data, "IdAccount"),
-			getField(data, "Nombre del cliente"),
-			getField(data, "Ramo"),
-			getField(data, "Gestora"),
-			getField(data, "Mediador"),
-			getField(data, "Situación de la póliza"),
-			getField(data, "Prima anual"),
-			getField(data, "Fecha de efecto"),
-			getField(data, "Fecha de vencimiento"),
-			getField(data, "Domicilio de la póliza"),
-			getField(data, "Descripción del riesgo"),
-			getField(data, "Matricula"),
-			existingID,
-		)
-		return false, err
-	}
-
-	if mode == ModeUpdate {
-		return false, fmt.Errorf("póliza no existe para actualizar: %s", numeroPoliza)
-	}
-
-	// Insertar nuevo con todos los campos de Occident
-	insertSQL := `
-		INSERT INTO polizas (
-			numero_poliza, id_account, nombre_cliente, ramo, gestora,
-			mediador, situacion_poliza, prima_anual, fecha_efecto, fecha_vencimiento,
-			domicilio_poliza, descripcion_riesgo, matricula, activo, creado_en
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, TRUE, NOW())
-	`
-	_, err = db.PostgresDB.Exec(insertSQL,
-		numeroPoliza,
-		getField(data, "IdAccount"),
-		getField(data, "Nombre del cliente"),
-		getField(data, "Ramo"),
-		getField(data, "Gestora"),
-		getField(data, "Mediador"),
-		getField(data, "Situación de la póliza"),
-		getField(data, "Prima anual"),
-		getField(data, "Fecha de efecto"),
-		getField(data, "Fecha de vencimiento"),
-		getField(data, "Domicilio de la póliza"),
-		getField(data, "Descripción del riesgo"),
-		getField(data, "Matricula"),
-	)
-	return false, err
+// rowFailed registra una fila con error; el tramo actual ya no se aplicará
+func (r *importRun) rowFailed(errs ...ImportError) {
+	r.broken = true
+	r.failed++
+	r.job.mu.Lock()
+	r.job.FailedRows++
+	r.job.mu.Unlock()
+	r.job.addErrors(errs...)
 }
 
-// importRecibo importa un recibo desde CSV de Occident
-// Campos Occident: Nº recibo, Mediador, Ramo, Origen del recibo, Prima total,
-// Fecha inicio cobertura, Situación del recibo, Fecha emisión, Fecha situación,
-// Fecha fin cobertura, Gestora del recibo, Gestión de cobro, Detalle del recibo,
-// Comisión bruta, Cliente, Nº póliza, Comisión neta, Forma de pago, Descripción riesgo, IdAccount
-func importRecibo(data map[string]string, mode ImportMode, dupHandling string) (bool, error) {
-	numeroRecibo := getField(data, "Nº recibo", "numero_recibo")
-	if numeroRecibo == "" {
-		return false, fmt.Errorf("número de recibo requerido")
-	}
-
-	// Verificar si existe
-	var existingID int
-	query := "SELECT id FROM recibos WHERE numero_recibo = $1"
-	err := db.PostgresDB.QueryRow(query, numeroRecibo).Scan(&existingID)
-	exists := (err != sql.ErrNoRows)
-
-	if exists {
-		if dupHandling == "skip" {
-			return true, nil
-		}
-		if dupHandling == "error" {
-			return false, fmt.Errorf("recibo duplicado: %s", numeroRecibo)
-		}
-		if mode == ModeAdd {
-			return true, nil
+// flush aplica el tramo actual, o lo descarta si alguna fila falló
+func (r *importRun) flush() {
+	job := r.job
+	defer func() {
+		r.batch = nil
+		r.size = 0
+		r.pending = 0
+		r.broken = false
+	}()
+
+	if r.broken {
+		r.abort()
+		if r.pending > 0 {
+			job.mu.Lock()
+			job.SkippedRows += r.pending
+			job.mu.Unlock()
+			job.addErrors(ImportError{
+				Row:     r.firstRow,
+				Message: fmt.Sprintf("Tramo revertido por errores: %d filas válidas no se importaron", r.pending),
+			})
 		}
+		return
+	}
+	if r.batch == nil {
+		return
+	}
 
-		// Update con todos los campos de Occident
-		updateSQL := `
-			UPDATE recibos SET
-				numero_poliza = COALESCE(NULLIF($1, ''), numero_poliza),
-				id_account = COALESCE(NULLIF($2, ''), id_account),
-				nombre_cliente = COALESCE(NULLIF($3, ''), nombre_cliente),
-				ramo = COALESCE(NULLIF($4, ''), ramo),
-				mediador = COALESCE(NULLIF($5, ''), mediador),
-				prima_total = COALESCE(NULLIF($6, 0), prima_total),
-				comision_bruta = COALESCE(NULLIF($7, 0), comision_bruta),
-				comision_neta = COALESCE(NULLIF($8, 0), comision_neta),
-				situacion_recibo = COALESCE(NULLIF($9, ''), situacion_recibo),
-				fecha_emision = $10,
-				fecha_situacion = $11,
-				fecha_inicio_cobertura = $12,
-				fecha_fin_cobertura = $13,
-				forma_pago = COALESCE(NULLIF($14, ''), forma_pago),
-				gestora_recibo = COALESCE(NULLIF($15, ''), gestora_recibo),
-				gestion_cobro = COALESCE(NULLIF($16, ''), gestion_cobro),
-				detalle_recibo = COALESCE(NULLIF($17, ''), detalle_recibo),
-				descripcion_riesgo = COALESCE(NULLIF($18, ''), descripcion_riesgo),
-				actualizado_en = NOW()
-			WHERE id = $19
-		`
-		_, err = db.PostgresDB.Exec(updateSQL,
-			getField(data, "Nº póliza"),
-			getField(data, "IdAccount"),
-			getField(data, "Cliente"),
-			getField(data, "Ramo"),
-			getField(data, "Mediador"),
-			parseFloat(getField(data, "Prima total")),
-			parseFloat(getField(data, "Comisión bruta")),
-			parseFloat(getField(data, "Comisión neta")),
-			getField(data, "Situación del recibo"),
-			parseDate(getField(data, "Fecha emisión")),
-			parseDate(getField(data, "Fecha situación")),
-			parseDate(getField(data, "Fecha inicio cobertura")),
-			parseDate(getField(data, "Fecha fin cobertura")),
-			getField(data, "Forma de pago"),
-			getField(data, "Gestora del recibo"),
-			getField(data, "Gestión de cobro"),
-			getField(data, "Detalle del recibo"),
-			getField(data, "Descripción riesgo"),
-			existingID,
-		)
-		return false, err
-	}
-
-	if mode == ModeUpdate {
-		return false, fmt.Errorf("recibo no existe para actualizar: %s", numeroRecibo)
-	}
-
-	// Insertar nuevo con todos los campos de Occident
-	insertSQL := `
-		INSERT INTO recibos (
-			numero_recibo, numero_poliza, id_account, nombre_cliente, ramo, mediador,
-			prima_total, comision_bruta, comision_neta, situacion_recibo,
-			fecha_emision, fecha_situacion, fecha_inicio_cobertura, fecha_fin_cobertura,
-			forma_pago, gestora_recibo, gestion_cobro, detalle_recibo, descripcion_riesgo,
-			activo, creado_en
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, TRUE, NOW())
-	`
-	_, err = db.PostgresDB.Exec(insertSQL,
-		numeroRecibo,
-		getField(data, "Nº póliza"),
-		getField(data, "IdAccount"),
-		getField(data, "Cliente"),
-		getField(data, "Ramo"),
-		getField(data, "Mediador"),
-		parseFloat(getField(data, "Prima total")),
-		parseFloat(getField(data, "Comisión bruta")),
-		parseFloat(getField(data, "Comisión neta")),
-		getField(data, "Situación del recibo"),
-		parseDate(getField(data, "Fecha emisión")),
-		parseDate(getField(data, "Fecha situación")),
-		parseDate(getField(data, "Fecha inicio cobertura")),
-		parseDate(getField(data, "Fecha fin cobertura")),
-		getField(data, "Forma de pago"),
-		getField(data, "Gestora del recibo"),
-		getField(data, "Gestión de cobro"),
-		getField(data, "Detalle del recibo"),
-		getField(data, "Descripción riesgo"),
-	)
-	return false, err
-}
+	result, err := r.batch.Apply()
 
-// importSiniestro importa un siniestro
-func importSiniestro(data map[string]string, mode ImportMode, dupHandling string) (bool, error) {
-	// Campos de Occident SINIESTROS.csv:
-	// Número de póliza, Número de siniestro, Mediador, Situación del siniestro,
-	// Fecha de ocurrencia, Fecha de cierre, Fecha de apertura, Tramitador,
-	// Gestionado, Cliente, Centro de tramitación, IdAccount
-
-	numeroSiniestro := getField(data, "Número de siniestro", "numero_siniestro", "Numero de siniestro")
-	if numeroSiniestro == "" {
-		return false, fmt.Errorf("número de siniestro requerido")
-	}
-
-	// Extraer todos los campos de Occident
-	numeroPoliza := getField(data, "Número de póliza", "numero_poliza", "Numero de poliza")
-	idAccount := getField(data, "IdAccount", "id_account", "ID Account")
-	cliente := getField(data, "Cliente", "cliente", "nombre_cliente")
-	situacion := getField(data, "Situación del siniestro", "situacion_siniestro", "Situacion del siniestro")
-	fechaOcurrencia := getField(data, "Fecha de ocurrencia", "fecha_ocurrencia")
-	fechaApertura := getField(data, "Fecha de apertura", "fecha_apertura")
-	fechaCierre := getField(data, "Fecha de cierre", "fecha_cierre")
-	tramitador := getField(data, "Tramitador", "tramitador")
-	centroTramitacion := getField(data, "Centro de tramitación", "centro_tramitacion", "Centro de tramitacion")
-	mediador := getField(data, "Mediador", "mediador")
-	gestionado := getField(data, "Gestionado", "gestionado")
-
-	// Verificar si existe
-	var existingID int
-	query := "SELECT id FROM siniestros WHERE numero_siniestro = $1"
-	err := db.PostgresDB.QueryRow(query, numeroSiniestro).Scan(&existingID)
-	exists := (err != sql.ErrNoRows)
-
-	if exists {
-		if dupHandling == "skip" {
-			log.Printf("⏭️  Siniestro duplicado omitido: Número=%s", numeroSiniestro)
-			return true, nil
-		}
-		if dupHandling == "error" {
-			return false, fmt.Errorf("siniestro duplicado: %s", numeroSiniestro)
-		}
-		if mode == ModeAdd {
-			log.Printf("⏭️  Siniestro duplicado omitido (modo ADD): Número=%s", numeroSiniestro)
-			return true, nil
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	if err != nil {
+		job.FailedRows += r.pending
+		r.failed += r.pending
+		job.Errors = append(job.Errors, ImportError{
+			Row:     r.firstRow,
+			Message: "Error aplicando importación: " + err.Error(),
+		})
+		return
+	}
+	if !result.Committed {
+		job.FailedRows += result.Failed
+		r.failed += result.Failed
+		job.SkippedRows += r.pending - result.Failed
+		for _, e := range result.Errors {
+			if len(job.Errors) < maxStoredImportErrors {
+				job.Errors = append(job.Errors, e)
+			}
 		}
+		return
+	}
 
-		log.Printf("🔄 Actualizando siniestro existente: Número=%s", numeroSiniestro)
-		updateSQL := `
-			UPDATE siniestros SET
-				numero_poliza = COALESCE(NULLIF($1, ''), numero_poliza),
-				id_account = COALESCE(NULLIF($2, ''), id_account),
-				cliente = COALESCE(NULLIF($3, ''), cliente),
-				situacion_siniestro = COALESCE(NULLIF($4, ''), situacion_siniestro),
-				fecha_ocurrencia = COALESCE(NULLIF($5, ''), fecha_ocurrencia),
-				fecha_apertura = COALESCE(NULLIF($6, ''), fecha_apertura),
-				fecha_cierre = COALESCE(NULLIF($7, ''), fecha_cierre),
-				tramitador = COALESCE(NULLIF($8, ''), tramitador),
-				centro_tramitacion = COALESCE(NULLIF($9, ''), centro_tramitacion),
-				mediador = COALESCE(NULLIF($10, ''), mediador),
-				gestionado = COALESCE(NULLIF($11, ''), gestionado),
-				actualizado_en = NOW()
-			WHERE id = $12
-		`
-		_, err = db.PostgresDB.Exec(updateSQL,
-			numeroPoliza, idAccount, cliente, situacion,
-			fechaOcurrencia, fechaApertura, fechaCierre,
-			tramitador, centroTramitacion, mediador, gestionado,
-			existingID,
-		)
-		return false, err
-	}
-
-	if mode == ModeUpdate {
-		return false, fmt.Errorf("siniestro no existe para actualizar: %s", numeroSiniestro)
-	}
-
-	// Insertar nuevo
-	log.Printf("➕ Insertando nuevo siniestro: Número=%s, Póliza=%s, Cliente=%s", numeroSiniestro, numeroPoliza, cliente)
-	insertSQL := `
-		INSERT INTO siniestros (
-			numero_siniestro, numero_poliza, id_account, cliente, situacion_siniestro,
-			fecha_ocurrencia, fecha_apertura, fecha_cierre, tramitador,
-			centro_tramitacion, mediador, gestionado, activo, creado_en
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, TRUE, NOW())
-	`
-	_, err = db.PostgresDB.Exec(insertSQL,
-		numeroSiniestro, numeroPoliza, idAccount, cliente, situacion,
-		nullIfEmpty(fechaOcurrencia), nullIfEmpty(fechaApertura), nullIfEmpty(fechaCierre),
-		tramitador, centroTramitacion, mediador, gestionado,
-	)
-	return false, err
+	job.SuccessfulRows += result.Inserted + result.Updated
+	job.DeactivatedRows += result.Deactivated
+	job.DuplicateRows += result.Duplicates
+	job.SkippedRows += result.Duplicates
+}
+
+// abort descarta el tramo actual sin aplicarlo
+func (r *importRun) abort() {
+	if r.batch != nil {
+		r.batch.Rollback()
+		r.batch = nil
+	}
 }
 
 // validateCSVStructure valida la estructura del CSV
-func validateCSVStructure(file io.Reader, importType ImportType) []ImportError {
+func validateCSVStructure(file io.Reader, importType ImportType, format importFormat) []ImportError {
 	var errors []ImportError
 
-	reader := csv.NewReader(file)
-	reader.Comma = ';'
-	reader.LazyQuotes = true
+	reader, err := format.rowReader(file, importType)
+	if err != nil {
+		errors = append(errors, ImportError{
+			Row:     0,
+			Message: err.Error(),
+		})
+		return errors
+	}
 
 	headers, err := reader.Read()
 	if err != nil {
@@ -948,6 +1061,7 @@ func validateCSVStructure(file io.Reader, importType ImportType) []ImportError {
 		})
 		return errors
 	}
+	headers = format.mapHeaders(headers)
 
 	// Validar encabezados requeridos
 	requiredHeaders := getRequiredHeaders(importType)
@@ -987,40 +1101,6 @@ func getRequiredHeaders(importType ImportType) []string {
 	}
 }
 
-// saveImportJobToDB guarda el job en la base de datos
-func saveImportJobToDB(job *ImportJob) {
-	errorsJSON, _ := json.Marshal(job.Errors)
-
-	sql := `
-		INSERT INTO import_jobs (
-			id, type, mode, status, total_rows, processed_rows,
-			successful_rows, failed_rows, duplicate_rows, skipped_rows,
-			errors, started_at, completed_at, validate_first,
-			duplicate_handling, username, filename
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)
-		ON CONFLICT (id) DO UPDATE SET
-			status = EXCLUDED.status,
-			processed_rows = EXCLUDED.processed_rows,
-			successful_rows = EXCLUDED.successful_rows,
-			failed_rows = EXCLUDED.failed_rows,
-			duplicate_rows = EXCLUDED.duplicate_rows,
-			skipped_rows = EXCLUDED.skipped_rows,
-			errors = EXCLUDED.errors,
-			completed_at = EXCLUDED.completed_at
-	`
-
-	_, err := db.PostgresDB.Exec(sql,
-		job.ID, job.Type, job.Mode, job.Status, job.TotalRows, job.ProcessedRows,
-		job.SuccessfulRows, job.FailedRows, job.DuplicateRows, job.SkippedRows,
-		string(errorsJSON), job.StartedAt, job.CompletedAt, job.ValidateFirst,
-		job.DuplicateHandling, job.Username, job.Filename,
-	)
-
-	if err != nil {
-		log.Printf("⚠️  Error guardando import job en DB: %v", err)
-	}
-}
-
 // Helper functions
 func isValidImportType(t ImportType) bool {
 	return t == ImportClientes || t == ImportPolizas || t == ImportRecibos || t == ImportSiniestros
@@ -1051,8 +1131,15 @@ func getField(data map[string]string, names ...string) string {
 
 // parseFloat convierte string a float64, manejando formato español (coma decimal)
 func parseFloat(s string) float64 {
+	val, _ := parseFloatStrict(s)
+	return val
+}
+
+// parseFloatStrict como parseFloat pero indica si el texto era un número válido
+// (vacío cuenta como válido = 0)
+func parseFloatStrict(s string) (float64, bool) {
 	if s == "" {
-		return 0
+		return 0, true
 	}
 	// Limpiar el string
 	s = strings.TrimSpace(s)
@@ -1066,18 +1153,24 @@ func parseFloat(s string) float64 {
 	} else if strings.Contains(s, ",") {
 		s = strings.ReplaceAll(s, ",", ".")
 	}
-	val, _ := strconv.ParseFloat(s, 64)
-	return val
+	val, err := strconv.ParseFloat(s, 64)
+	return val, err == nil
 }
 
 // parseInt convierte string a int
 func parseInt(s string) int {
+	val, _ := parseIntStrict(s)
+	return val
+}
+
+// parseIntStrict como parseInt pero indica si el texto era un entero válido
+func parseIntStrict(s string) (int, bool) {
 	if s == "" {
-		return 0
+		return 0, true
 	}
 	s = strings.TrimSpace(s)
-	val, _ := strconv.Atoi(s)
-	return val
+	val, err := strconv.Atoi(s)
+	return val, err == nil
 }
 
 // parseDate convierte fecha de Occident (DD/MM/YYYY o YYYY-MM-DD) a formato SQL
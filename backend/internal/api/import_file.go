@@ -0,0 +1,190 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ImportFileOptions parámetros de una importación lanzada desde el servidor
+// (por ejemplo, con los archivos descargados por el scraper GCO)
+type ImportFileOptions struct {
+	Type          ImportType
+	Mode          ImportMode
+	Username      string
+	ValidateFirst bool
+	AcceptErrors  bool // Importar las filas válidas aunque la validación previa rechace otras
+}
+
+// IsValidImportMode indica si el modo de importación existe
+func IsValidImportMode(mode ImportMode) bool {
+	return mode == ModeAdd || mode == ModeUpdate || mode == ModeReplace
+}
+
+// ImportFile importa un archivo del disco con el mismo flujo que StartImport
+// (CSV Occident o .xlsx, commit por job) y espera a que termine. Si ctx se
+// cancela, el job se cancela. Devuelve el job con su estado final.
+func ImportFile(ctx context.Context, path string, opts ImportFileOptions) (*ImportJob, error) {
+	job, file, err := newFileImportJob(path, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	registerActiveImport(job)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			if job.requestCancel() {
+				persistImportJob(job)
+			}
+		case <-done:
+		}
+	}()
+
+	processImport(job, file)
+	close(done)
+
+	return job, nil
+}
+
+// StartImportFile como ImportFile pero en segundo plano: devuelve el job pendiente
+// (se consulta y cancela con los endpoints de /api/admin/import)
+func StartImportFile(path string, opts ImportFileOptions) (*ImportJob, error) {
+	job, file, err := newFileImportJob(path, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	registerActiveImport(job)
+	go processImport(job, file)
+
+	return job, nil
+}
+
+// newFileImportJob valida las opciones, abre el archivo y registra el job (pending)
+func newFileImportJob(path string, opts ImportFileOptions) (*ImportJob, *os.File, error) {
+	if !isValidImportType(opts.Type) {
+		return nil, nil, fmt.Errorf("tipo de importación inválido: %s", opts.Type)
+	}
+	if !IsValidImportMode(opts.Mode) {
+		return nil, nil, fmt.Errorf("modo de importación inválido: %s", opts.Mode)
+	}
+	if opts.Mode == ModeReplace && opts.AcceptErrors {
+		return nil, nil, fmt.Errorf("el modo replace no admite accept_errors")
+	}
+	if opts.Username == "" {
+		opts.Username = "system"
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error abriendo %s: %w", path, err)
+	}
+
+	job := &ImportJob{
+		ID:                uuid.New().String(),
+		Type:              opts.Type,
+		Mode:              opts.Mode,
+		Status:            StatusPending,
+		StartedAt:         time.Now(),
+		ValidateFirst:     opts.ValidateFirst || opts.AcceptErrors,
+		AcceptErrors:      opts.AcceptErrors,
+		DuplicateHandling: "skip",
+		Username:          opts.Username,
+		Filename:          filepath.Base(path),
+		CommitMode:        CommitJob,
+		Instance:          importInstanceID,
+		format:            occidentFormat.withWorkbook(path, ""),
+		Errors:            []ImportError{},
+		cancel:            make(chan bool),
+	}
+	if opts.Mode == ModeReplace {
+		job.MaxDeactivatePct = replaceMaxDeactivatePctFromEnv()
+	}
+
+	if err := importJobStore.Save(job); err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("error registrando import job: %w", err)
+	}
+	return job, file, nil
+}
+
+// Snapshot devuelve una copia del estado del job (sin errores por fila)
+func (job *ImportJob) Snapshot() ImportJobSummary {
+	job.mu.RLock()
+	defer job.mu.RUnlock()
+
+	return ImportJobSummary{
+		ID:              job.ID,
+		Type:            job.Type,
+		Mode:            job.Mode,
+		Status:          job.Status,
+		TotalRows:       job.TotalRows,
+		SuccessfulRows:  job.SuccessfulRows,
+		FailedRows:      job.FailedRows,
+		DeactivatedRows: job.DeactivatedRows,
+	}
+}
+
+// ImportJobSummary resumen de un import job para otras vistas (scraper)
+type ImportJobSummary struct {
+	ID              string       `json:"id"`
+	Type            ImportType   `json:"type"`
+	Mode            ImportMode   `json:"mode"`
+	Status          ImportStatus `json:"status"`
+	TotalRows       int          `json:"total_rows"`
+	SuccessfulRows  int          `json:"successful_rows"`
+	FailedRows      int          `json:"failed_rows"`
+	DeactivatedRows int          `json:"deactivated_rows"`
+}
+
+// ScanImportFile lee un archivo (CSV Occident o .xlsx) y pasa a fn cada fila con
+// los valores sin convertir indexados por el campo canónico del tipo
+func ScanImportFile(path string, importType ImportType, fn func(row map[string]string) error) error {
+	spec, ok := importSpecs[importType]
+	if !ok {
+		return fmt.Errorf("tipo de importación inválido: %s", importType)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	format := occidentFormat.withWorkbook(path, "")
+	reader, err := format.rowReader(file, importType)
+	if err != nil {
+		return err
+	}
+	headers, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("Error leyendo encabezados: %v", err)
+	}
+	headers = format.mapHeaders(headers)
+
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			continue
+		}
+
+		data := rowToMap(headers, row)
+		values := make(map[string]string, len(spec.Fields))
+		for _, f := range spec.Fields {
+			values[f.Column] = getField(data, append(f.Headers, f.Column)...)
+		}
+		if err := fn(values); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestImportFile(t *testing.T) {
+	store := useMemoryImportStore(t)
+	batches := useFakeImportBatches(nil)
+
+	path := filepath.Join(t.TempDir(), "CLIENTES.csv")
+	data := "NIF;IdAccount;Nombre completo\n12345678Z;A1;Ana\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	job, err := ImportFile(context.Background(), path, ImportFileOptions{Type: ImportClientes, Mode: ModeUpdate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := store.Get(job.ID)
+	if got.Status != StatusCompleted || got.SuccessfulRows != 1 || got.Filename != "CLIENTES.csv" || got.Username != "system" {
+		t.Fatalf("job = %+v", got)
+	}
+	if len(*batches) != 1 || !(*batches)[0].applied {
+		t.Fatal("el tramo no se aplicó")
+	}
+
+	if _, err := ImportFile(context.Background(), path, ImportFileOptions{Type: ImportClientes, Mode: ModeReplace, AcceptErrors: true}); err == nil {
+		t.Fatal("replace con accept_errors aceptado")
+	}
+}
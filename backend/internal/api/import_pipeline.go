@@ -0,0 +1,503 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"soriano-mediadores/internal/db"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Modos de commit de una importación
+const (
+	CommitJob   = "job"   // Todo o nada: cualquier fila con error revierte la importación
+	CommitChunk = "chunk" // Cada tramo de ChunkSize filas se confirma por separado
+)
+
+// defaultReplaceMaxDeactivatePct porcentaje máximo de registros activos que un
+// import en modo replace puede desactivar si no se indica max_deactivate_pct
+// ni IMPORT_REPLACE_MAX_DEACTIVATE_PCT
+const defaultReplaceMaxDeactivatePct = 10.0
+
+// ErrReplaceThreshold el modo replace desactivaría más registros de los permitidos
+var ErrReplaceThreshold = fmt.Errorf("umbral de desactivación superado")
+
+// defaultImportChunkSize tamaño de tramo si no se indica chunk_size ni IMPORT_CHUNK_SIZE
+const defaultImportChunkSize = 5000
+
+// maxStoredImportErrors límite de errores guardados por job (el contador sigue siendo exacto)
+const maxStoredImportErrors = 1000
+
+// Acciones asignadas a cada fila de la staging
+const (
+	stagingInsert       = "insert"
+	stagingUpdate       = "update"
+	stagingSkip         = "skip"
+	stagingErrDuplicate = "error_duplicate"
+	stagingErrNotExists = "error_missing"
+)
+
+// importChunkSizeFromEnv tamaño de tramo configurado en IMPORT_CHUNK_SIZE
+func importChunkSizeFromEnv() int {
+	if v, err := strconv.Atoi(os.Getenv("IMPORT_CHUNK_SIZE")); err == nil && v > 0 {
+		return v
+	}
+	return defaultImportChunkSize
+}
+
+// replaceMaxDeactivatePctFromEnv umbral configurado en IMPORT_REPLACE_MAX_DEACTIVATE_PCT
+func replaceMaxDeactivatePctFromEnv() float64 {
+	if v, err := strconv.ParseFloat(os.Getenv("IMPORT_REPLACE_MAX_DEACTIVATE_PCT"), 64); err == nil && v >= 0 {
+		return v
+	}
+	return defaultReplaceMaxDeactivatePct
+}
+
+// importBatchResult resultado de aplicar un tramo
+type importBatchResult struct {
+	Committed   bool
+	Inserted    int
+	Updated     int
+	Duplicates  int
+	Deactivated int // Modo replace: registros ausentes del archivo desactivados (o a desactivar en Validate)
+	Failed      int // Filas con error (Errors puede venir truncado)
+	Errors      []ImportError
+	Rows        []int // Números de las filas con error (completo)
+}
+
+// importBatch recibe las filas válidas de un tramo y las aplica en una transacción
+type importBatch interface {
+	Add(rowNum int, values []interface{}) error
+	// Apply clasifica y aplica las filas. Si alguna fila da error revierte el tramo
+	// y devuelve los errores con Committed = false.
+	Apply() (importBatchResult, error)
+	// Validate hace la misma clasificación que Apply más las comprobaciones
+	// referenciales y siempre revierte: nunca escribe.
+	Validate() (importBatchResult, error)
+	Rollback()
+}
+
+// newImportBatch abre un tramo (sustituible en tests)
+var newImportBatch = func(job *ImportJob, spec importSpec) (importBatch, error) {
+	return newPostgresImportBatch(job, spec)
+}
+
+// postgresImportBatch carga las filas con COPY en una tabla temporal con los mismos
+// tipos que la tabla destino y hace el upsert con sentencias set-based
+type postgresImportBatch struct {
+	job  *ImportJob
+	spec importSpec
+	tx   *sql.Tx
+	copy *sql.Stmt
+}
+
+func newPostgresImportBatch(job *ImportJob, spec importSpec) (*postgresImportBatch, error) {
+	tx, err := db.PostgresDB.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	b := &postgresImportBatch{job: job, spec: spec, tx: tx}
+
+	stmts := []string{
+		`CREATE TEMP TABLE import_staging ON COMMIT DROP AS
+			SELECT ` + strings.Join(spec.columns(), ", ") + ` FROM ` + spec.Table + ` WITH NO DATA`,
+		`ALTER TABLE import_staging
+			ADD COLUMN row_num INTEGER,
+			ADD COLUMN target_id INTEGER,
+			ADD COLUMN action VARCHAR(20)`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("error creando staging: %w", err)
+		}
+	}
+
+	b.copy, err = tx.Prepare(pq.CopyIn("import_staging", append(spec.columns(), "row_num")...))
+	if err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("error iniciando COPY: %w", err)
+	}
+
+	return b, nil
+}
+
+// Add envía una fila al COPY
+func (b *postgresImportBatch) Add(rowNum int, values []interface{}) error {
+	_, err := b.copy.Exec(append(values, rowNum)...)
+	return err
+}
+
+// Rollback descarta el tramo
+func (b *postgresImportBatch) Rollback() {
+	if b.copy != nil {
+		b.copy.Close()
+		b.copy = nil
+	}
+	b.tx.Rollback()
+}
+
+// Apply termina el COPY, clasifica cada fila y aplica inserts/updates
+func (b *postgresImportBatch) Apply() (importBatchResult, error) {
+	var result importBatchResult
+
+	if err := b.load(); err != nil {
+		b.Rollback()
+		return result, err
+	}
+
+	counts, err := b.countActions()
+	if err != nil {
+		b.Rollback()
+		return result, err
+	}
+	result.Duplicates = counts[stagingSkip]
+
+	result.Failed = counts[stagingErrDuplicate] + counts[stagingErrNotExists]
+	if result.Failed > 0 {
+		result.Errors, result.Rows, err = b.rowErrors()
+		b.Rollback()
+		return result, err
+	}
+
+	// En modo replace se desactivan antes de insertar (las filas nuevas no están en la staging)
+	if b.job.Mode == ModeReplace {
+		if result.Deactivated, err = b.applyReplace(); err != nil {
+			b.Rollback()
+			return result, err
+		}
+	}
+
+	if counts[stagingUpdate] > 0 {
+		if result.Updated, err = b.applyUpdates(); err != nil {
+			b.Rollback()
+			return result, err
+		}
+	}
+	if counts[stagingInsert] > 0 {
+		if result.Inserted, err = b.applyInserts(); err != nil {
+			b.Rollback()
+			return result, err
+		}
+	}
+
+	if err := b.tx.Commit(); err != nil {
+		return result, err
+	}
+	result.Committed = true
+	return result, nil
+}
+
+// Validate clasifica las filas como Apply y además comprueba las referencias
+// (pólizas y clientes existentes). La transacción se revierte siempre.
+func (b *postgresImportBatch) Validate() (importBatchResult, error) {
+	var result importBatchResult
+	defer b.Rollback()
+
+	if err := b.load(); err != nil {
+		return result, err
+	}
+
+	errs, rows, err := b.rowErrors()
+	if err != nil {
+		return result, err
+	}
+	refErrs, refRows, err := b.referenceErrors()
+	if err != nil {
+		return result, err
+	}
+
+	failed := make(map[int]bool)
+	for _, row := range append(rows, refRows...) {
+		if !failed[row] {
+			failed[row] = true
+			result.Rows = append(result.Rows, row)
+		}
+	}
+	sort.Ints(result.Rows)
+	result.Failed = len(result.Rows)
+
+	result.Errors = append(errs, refErrs...)
+	sort.SliceStable(result.Errors, func(i, j int) bool { return result.Errors[i].Row < result.Errors[j].Row })
+	if len(result.Errors) > maxStoredImportErrors {
+		result.Errors = result.Errors[:maxStoredImportErrors]
+	}
+
+	// Modo replace: cuántos registros desaparecerían y si se supera el umbral
+	if b.job.Mode == ModeReplace {
+		missing, active, err := b.countMissing()
+		if err != nil {
+			return result, fmt.Errorf("error contando registros ausentes: %w", err)
+		}
+		result.Deactivated = missing
+		if missing > 0 && float64(missing)*100/float64(active) > b.job.MaxDeactivatePct {
+			return result, fmt.Errorf("%w: se desactivarían %d de %d %s activos (máximo %.1f%%)",
+				ErrReplaceThreshold, missing, active, b.spec.Table, b.job.MaxDeactivatePct)
+		}
+	}
+	return result, nil
+}
+
+// load termina el COPY y clasifica las filas
+func (b *postgresImportBatch) load() error {
+	// Vaciar el buffer del COPY
+	if _, err := b.copy.Exec(); err != nil {
+		return fmt.Errorf("error en COPY: %w", err)
+	}
+	b.copy.Close()
+	b.copy = nil
+
+	return b.classify()
+}
+
+// classify asigna target_id y la acción de cada fila según modo y duplicate_handling
+func (b *postgresImportBatch) classify() error {
+	spec := b.spec
+
+	insertAction := stagingInsert
+	if b.job.Mode == ModeUpdate {
+		insertAction = stagingErrNotExists
+	}
+
+	existingAction := stagingUpdate
+	fileDupAction := stagingSkip
+	switch {
+	case b.job.DuplicateHandling == "error":
+		existingAction = stagingErrDuplicate
+		fileDupAction = stagingErrDuplicate
+	case b.job.Mode == ModeReplace:
+		// El snapshot manda: los existentes se actualizan aunque duplicate_handling sea skip
+	case b.job.DuplicateHandling == "skip" || b.job.Mode == ModeAdd:
+		existingAction = stagingSkip
+	}
+
+	stmts := []struct {
+		sql  string
+		args []interface{}
+	}{
+		{`UPDATE import_staging s SET target_id = t.id FROM ` + spec.Table + ` t WHERE ` + spec.MatchSQL, nil},
+		{`WITH d AS (
+			SELECT row_num, ROW_NUMBER() OVER (PARTITION BY ` + spec.DedupSQL + ` ORDER BY row_num) AS rn
+			FROM import_staging
+		)
+		UPDATE import_staging s SET action = $1 FROM d WHERE d.row_num = s.row_num AND d.rn > 1`,
+			[]interface{}{fileDupAction}},
+		{`UPDATE import_staging SET action = CASE WHEN target_id IS NULL THEN $1 ELSE $2 END
+		WHERE action IS NULL`, []interface{}{insertAction, existingAction}},
+	}
+	for _, stmt := range stmts {
+		if _, err := b.tx.Exec(stmt.sql, stmt.args...); err != nil {
+			return fmt.Errorf("error clasificando filas: %w", err)
+		}
+	}
+	return nil
+}
+
+func (b *postgresImportBatch) countActions() (map[string]int, error) {
+	rows, err := b.tx.Query(`SELECT action, COUNT(*) FROM import_staging GROUP BY action`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var action string
+		var n int
+		if err := rows.Scan(&action, &n); err != nil {
+			return nil, err
+		}
+		counts[action] = n
+	}
+	return counts, rows.Err()
+}
+
+// rowErrors errores por fila (duplicados con duplicate_handling=error o inexistentes
+// en modo update). Devuelve hasta maxStoredImportErrors errores y todas las filas.
+func (b *postgresImportBatch) rowErrors() ([]ImportError, []int, error) {
+	rows, err := b.tx.Query(`
+		SELECT row_num, `+b.spec.KeyDisplaySQL+`, action FROM import_staging
+		WHERE action IN ($1, $2) ORDER BY row_num
+	`, stagingErrDuplicate, stagingErrNotExists)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var errs []ImportError
+	var rowNums []int
+	for rows.Next() {
+		var rowNum int
+		var key, action string
+		if err := rows.Scan(&rowNum, &key, &action); err != nil {
+			return nil, nil, err
+		}
+		rowNums = append(rowNums, rowNum)
+		if len(errs) >= maxStoredImportErrors {
+			continue
+		}
+		msg := fmt.Sprintf("%s duplicado: %s", b.spec.Label, key)
+		if action == stagingErrNotExists {
+			msg = fmt.Sprintf("%s no existe para actualizar: %s", b.spec.Label, key)
+		}
+		errs = append(errs, ImportError{Row: rowNum, Message: msg, Value: key})
+	}
+	return errs, rowNums, rows.Err()
+}
+
+// referenceErrors filas cuyas referencias (IdAccount, número de póliza) no existen
+func (b *postgresImportBatch) referenceErrors() ([]ImportError, []int, error) {
+	var errs []ImportError
+	var rowNums []int
+
+	for _, ref := range b.spec.References {
+		rows, err := b.tx.Query(`
+			SELECT s.row_num, s.` + ref.Column + ` FROM import_staging s
+			WHERE s.` + ref.Column + ` <> ''
+			  AND NOT EXISTS (SELECT 1 FROM ` + ref.Table + ` r WHERE r.` + ref.TargetColumn + ` = s.` + ref.Column + `)
+			ORDER BY s.row_num
+		`)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error comprobando %s: %w", ref.Table, err)
+		}
+		for rows.Next() {
+			var rowNum int
+			var value string
+			if err := rows.Scan(&rowNum, &value); err != nil {
+				rows.Close()
+				return nil, nil, err
+			}
+			rowNums = append(rowNums, rowNum)
+			if len(errs) < maxStoredImportErrors {
+				errs = append(errs, ImportError{
+					Row:     rowNum,
+					Field:   ref.Column,
+					Value:   value,
+					Message: fmt.Sprintf("%s no existe: %s", ref.Label, value),
+				})
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, nil, err
+		}
+	}
+	return errs, rowNums, nil
+}
+
+// applyUpdates guarda la imagen previa (para RevertImport) y actualiza las filas
+// existentes. En modo replace las reactiva: un registro desactivado por un
+// snapshot anterior que vuelve a aparecer está otra vez en cartera.
+func (b *postgresImportBatch) applyUpdates() (int, error) {
+	spec := b.spec
+
+	reactivar := ""
+	if b.job.Mode == ModeReplace {
+		reactivar = "activo = TRUE, "
+	}
+
+	_, err := b.tx.Exec(`
+		INSERT INTO import_row_snapshots (import_id, table_name, row_id, action, before_data)
+		SELECT $1, $2, t.id, $3, to_jsonb(t)
+		FROM `+spec.Table+` t JOIN import_staging s ON s.target_id = t.id
+		WHERE s.action = $4
+		ON CONFLICT (import_id, table_name, row_id) DO NOTHING
+	`, b.job.ID, spec.Table, snapshotUpdate, stagingUpdate)
+	if err != nil {
+		return 0, fmt.Errorf("error guardando imagen previa: %w", err)
+	}
+
+	res, err := b.tx.Exec(`
+		UPDATE `+spec.Table+` t SET `+strings.Join(spec.updateAssignments(), ", ")+`,
+			`+reactivar+`import_id = $1,
+			actualizado_en = NOW()
+		FROM import_staging s
+		WHERE s.target_id = t.id AND s.action = $2
+	`, b.job.ID, stagingUpdate)
+	if err != nil {
+		return 0, fmt.Errorf("error actualizando %s: %w", spec.Table, err)
+	}
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
+
+// applyInserts inserta las filas nuevas y las registra para RevertImport
+func (b *postgresImportBatch) applyInserts() (int, error) {
+	spec := b.spec
+
+	res, err := b.tx.Exec(`
+		WITH ins AS (
+			INSERT INTO `+spec.Table+` (`+strings.Join(spec.columns(), ", ")+`, import_id, activo, creado_en)
+			SELECT `+strings.Join(spec.insertExpressions(), ", ")+`, $1, TRUE, NOW()
+			FROM import_staging s
+			WHERE s.action = $3
+			ORDER BY s.row_num
+			RETURNING id
+		)
+		INSERT INTO import_row_snapshots (import_id, table_name, row_id, action)
+		SELECT $1, $2, id, $4 FROM ins
+	`, b.job.ID, spec.Table, stagingInsert, snapshotInsert)
+	if err != nil {
+		return 0, fmt.Errorf("error insertando en %s: %w", spec.Table, err)
+	}
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
+
+// missingFromStagingSQL registros activos de la tabla que no aparecen en el archivo
+const missingFromStagingSQL = `t.activo = TRUE
+	AND NOT EXISTS (SELECT 1 FROM import_staging s WHERE s.target_id = t.id)`
+
+// countMissing devuelve cuántos registros activos desaparecerían y el total de activos
+func (b *postgresImportBatch) countMissing() (missing int, active int, err error) {
+	err = b.tx.QueryRow(`
+		SELECT COUNT(*) FILTER (WHERE NOT EXISTS (SELECT 1 FROM import_staging s WHERE s.target_id = t.id)),
+		       COUNT(*)
+		FROM `+b.spec.Table+` t WHERE t.activo = TRUE
+	`).Scan(&missing, &active)
+	return missing, active, err
+}
+
+// applyReplace el archivo es un snapshot completo: los registros activos que no
+// aparecen se desactivan (soft delete) marcados con el import, guardando su imagen
+// previa para que RevertImport los reactive. Aborta si superan el umbral.
+func (b *postgresImportBatch) applyReplace() (int, error) {
+	spec := b.spec
+
+	missing, active, err := b.countMissing()
+	if err != nil {
+		return 0, fmt.Errorf("error contando registros ausentes: %w", err)
+	}
+	if missing == 0 {
+		return 0, nil
+	}
+	if pct := float64(missing) * 100 / float64(active); pct > b.job.MaxDeactivatePct {
+		return 0, fmt.Errorf("%w: se desactivarían %d de %d %s activos (%.1f%%, máximo %.1f%%)",
+			ErrReplaceThreshold, missing, active, spec.Table, pct, b.job.MaxDeactivatePct)
+	}
+
+	_, err = b.tx.Exec(`
+		INSERT INTO import_row_snapshots (import_id, table_name, row_id, action, before_data)
+		SELECT $1, $2, t.id, $3, to_jsonb(t)
+		FROM `+spec.Table+` t
+		WHERE `+missingFromStagingSQL+`
+		ON CONFLICT (import_id, table_name, row_id) DO NOTHING
+	`, b.job.ID, spec.Table, snapshotUpdate)
+	if err != nil {
+		return 0, fmt.Errorf("error guardando imagen previa: %w", err)
+	}
+
+	res, err := b.tx.Exec(`
+		UPDATE `+spec.Table+` t SET activo = FALSE, import_id = $1, actualizado_en = NOW()
+		WHERE `+missingFromStagingSQL, b.job.ID)
+	if err != nil {
+		return 0, fmt.Errorf("error desactivando %s: %w", spec.Table, err)
+	}
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
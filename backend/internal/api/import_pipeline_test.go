@@ -0,0 +1,335 @@
+package api
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func expectStagingBatch(mock sqlmock.Sqlmock, rows int) {
+	mock.ExpectBegin()
+	mock.ExpectExec(`CREATE TEMP TABLE import_staging ON COMMIT DROP AS\s+SELECT nif, id_account, .* FROM clientes WITH NO DATA`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`ALTER TABLE import_staging`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectPrepare(`COPY "import_staging" \("nif", "id_account", .*"row_num"\) FROM STDIN`)
+	for i := 0; i < rows; i++ {
+		mock.ExpectExec(`COPY`).WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	// Flush del COPY
+	mock.ExpectExec(`COPY`).WillReturnResult(sqlmock.NewResult(0, int64(rows)))
+	mock.ExpectExec(`UPDATE import_staging s SET target_id = t.id FROM clientes t`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`WITH d AS .*ROW_NUMBER\(\) OVER \(PARTITION BY COALESCE\(NULLIF\(nif, ''\), id_account\)`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func expectReplaceCount(mock sqlmock.Sqlmock, missing, active int) {
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FILTER \(WHERE NOT EXISTS \(SELECT 1 FROM import_staging s WHERE s.target_id = t.id\)\),\s+COUNT\(\*\)\s+FROM clientes t WHERE t.activo = TRUE`).
+		WillReturnRows(sqlmock.NewRows([]string{"missing", "active"}).AddRow(missing, active))
+}
+
+func clienteValues(t *testing.T, nif string) []interface{} {
+	t.Helper()
+	values, errs := parseImportRow(importSpecs[ImportClientes], occidentFormat,
+		map[string]string{"NIF": nif, "Nombre completo": "Cliente " + nif}, 1)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	return values
+}
+
+func TestPostgresImportBatchAppliesSetBasedUpsert(t *testing.T) {
+	mock := useMockDB(t)
+	job := newTestImportJob("imp-1")
+	job.Mode = ModeReplace
+	job.DuplicateHandling = "update"
+
+	expectStagingBatch(mock, 2)
+	mock.ExpectExec(`UPDATE import_staging SET action = CASE`).
+		WithArgs(stagingInsert, stagingUpdate).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectQuery(`SELECT action, COUNT\(\*\) FROM import_staging GROUP BY action`).
+		WillReturnRows(sqlmock.NewRows([]string{"action", "count"}).
+			AddRow(stagingInsert, 1).AddRow(stagingUpdate, 1))
+	// Modo replace sin registros ausentes: no se desactiva nada
+	expectReplaceCount(mock, 0, 10)
+	mock.ExpectExec(`INSERT INTO import_row_snapshots .*to_jsonb\(t\)\s+FROM clientes t JOIN import_staging s`).
+		WithArgs("imp-1", "clientes", snapshotUpdate, stagingUpdate).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE clientes t SET nombre_completo = COALESCE\(NULLIF\(s.nombre_completo, ''\), t.nombre_completo\).*import_id = \$1`).
+		WithArgs("imp-1", stagingUpdate).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`WITH ins AS \(\s+INSERT INTO clientes \(nif, id_account, .*import_id, activo, creado_en\)`).
+		WithArgs("imp-1", "clientes", stagingInsert, snapshotInsert).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	batch, err := newPostgresImportBatch(job, importSpecs[ImportClientes])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, nif := range []string{"12345678Z", "87654321X"} {
+		if err := batch.Add(i+1, clienteValues(t, nif)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	result, err := batch.Apply()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Committed || result.Inserted != 1 || result.Updated != 1 {
+		t.Fatalf("resultado inesperado: %+v", result)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPostgresImportBatchRollsBackOnRowErrors(t *testing.T) {
+	mock := useMockDB(t)
+	job := newTestImportJob("imp-1")
+	job.Mode = ModeUpdate
+	job.DuplicateHandling = "update"
+
+	expectStagingBatch(mock, 2)
+	mock.ExpectExec(`UPDATE import_staging SET action = CASE`).
+		WithArgs(stagingErrNotExists, stagingUpdate).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectQuery(`SELECT action, COUNT\(\*\) FROM import_staging GROUP BY action`).
+		WillReturnRows(sqlmock.NewRows([]string{"action", "count"}).
+			AddRow(stagingUpdate, 1).AddRow(stagingErrNotExists, 1))
+	mock.ExpectQuery(`SELECT row_num, .* FROM import_staging\s+WHERE action IN`).
+		WithArgs(stagingErrDuplicate, stagingErrNotExists).
+		WillReturnRows(sqlmock.NewRows([]string{"row_num", "key", "action"}).
+			AddRow(2, "87654321X", stagingErrNotExists))
+	// Ninguna escritura en clientes: el tramo entero se revierte
+	mock.ExpectRollback()
+
+	batch, err := newPostgresImportBatch(job, importSpecs[ImportClientes])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, nif := range []string{"12345678Z", "87654321X"} {
+		if err := batch.Add(i+1, clienteValues(t, nif)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	result, err := batch.Apply()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Committed || result.Failed != 1 {
+		t.Fatalf("resultado inesperado: %+v", result)
+	}
+	if len(result.Errors) != 1 || result.Errors[0].Row != 2 {
+		t.Fatalf("errores inesperados: %+v", result.Errors)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPostgresImportBatchValidateReportsReferencesWithoutWriting(t *testing.T) {
+	mock := useMockDB(t)
+	job := newTestImportJob("imp-1")
+	job.Type = ImportPolizas
+	job.Mode = ModeAdd
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`CREATE TEMP TABLE import_staging .* FROM polizas WITH NO DATA`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`ALTER TABLE import_staging`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectPrepare(`COPY "import_staging"`)
+	mock.ExpectExec(`COPY`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`COPY`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`COPY`).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`UPDATE import_staging s SET target_id = t.id FROM polizas t`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`WITH d AS`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`UPDATE import_staging SET action = CASE`).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectQuery(`SELECT row_num, numero_poliza, action FROM import_staging`).
+		WillReturnRows(sqlmock.NewRows([]string{"row_num", "key", "action"}))
+	mock.ExpectQuery(`SELECT s.row_num, s.id_account FROM import_staging s\s+WHERE s.id_account <> ''\s+AND NOT EXISTS \(SELECT 1 FROM clientes r WHERE r.id_account = s.id_account\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"row_num", "id_account"}).AddRow(2, "ACC-X"))
+	// Nunca se confirma
+	mock.ExpectRollback()
+
+	spec := importSpecs[ImportPolizas]
+	batch, err := newPostgresImportBatch(job, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, acc := range []string{"ACC-1", "ACC-X"} {
+		values, errs := parseImportRow(spec, occidentFormat, map[string]string{"Número de la póliza": acc + "-P", "IdAccount": acc}, i+1)
+		if len(errs) > 0 {
+			t.Fatal(errs)
+		}
+		if err := batch.Add(i+1, values); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err := batch.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Committed || result.Failed != 1 || len(result.Rows) != 1 || result.Rows[0] != 2 {
+		t.Fatalf("resultado inesperado: %+v", result)
+	}
+	if e := result.Errors[0]; e.Field != "id_account" || e.Value != "ACC-X" {
+		t.Fatalf("error inesperado: %+v", e)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPostgresImportBatchReplaceDeactivatesMissing(t *testing.T) {
+	mock := useMockDB(t)
+	job := newTestImportJob("imp-1")
+	job.Mode = ModeReplace
+	job.DuplicateHandling = "update"
+	job.MaxDeactivatePct = 10
+
+	expectStagingBatch(mock, 1)
+	mock.ExpectExec(`UPDATE import_staging SET action = CASE`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT action, COUNT\(\*\) FROM import_staging GROUP BY action`).
+		WillReturnRows(sqlmock.NewRows([]string{"action", "count"}).AddRow(stagingUpdate, 1))
+	// 3 de 40 activos (7,5%) no vienen en el snapshot: se desactivan con imagen previa
+	expectReplaceCount(mock, 3, 40)
+	mock.ExpectExec(`INSERT INTO import_row_snapshots .*FROM clientes t\s+WHERE t.activo = TRUE\s+AND NOT EXISTS`).
+		WithArgs("imp-1", "clientes", snapshotUpdate).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(`UPDATE clientes t SET activo = FALSE, import_id = \$1, actualizado_en = NOW\(\)\s+WHERE t.activo = TRUE\s+AND NOT EXISTS`).
+		WithArgs("imp-1").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(`INSERT INTO import_row_snapshots .*to_jsonb\(t\)\s+FROM clientes t JOIN import_staging s`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE clientes t SET nombre_completo`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	batch, err := newPostgresImportBatch(job, importSpecs[ImportClientes])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.Add(1, clienteValues(t, "12345678Z")); err != nil {
+		t.Fatal(err)
+	}
+	result, err := batch.Apply()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Committed || result.Deactivated != 3 || result.Updated != 1 {
+		t.Fatalf("resultado inesperado: %+v", result)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPostgresImportBatchReplaceReactivatesReturningRows(t *testing.T) {
+	mock := useMockDB(t)
+	job := newTestImportJob("imp-2")
+	job.Mode = ModeReplace
+	job.DuplicateHandling = "skip" // Valor por defecto del formulario
+	job.MaxDeactivatePct = 10
+
+	// 12345678Z lo desactivó un replace anterior; MatchSQL lo encuentra igualmente
+	expectStagingBatch(mock, 1)
+	mock.ExpectExec(`UPDATE import_staging SET action = CASE`).
+		WithArgs(stagingInsert, stagingUpdate).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT action, COUNT\(\*\) FROM import_staging GROUP BY action`).
+		WillReturnRows(sqlmock.NewRows([]string{"action", "count"}).AddRow(stagingUpdate, 1))
+	expectReplaceCount(mock, 0, 40)
+	mock.ExpectExec(`INSERT INTO import_row_snapshots .*to_jsonb\(t\)\s+FROM clientes t JOIN import_staging s`).
+		WithArgs("imp-2", "clientes", snapshotUpdate, stagingUpdate).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE clientes t SET nombre_completo = .*activo = TRUE, import_id = \$1`).
+		WithArgs("imp-2", stagingUpdate).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	batch, err := newPostgresImportBatch(job, importSpecs[ImportClientes])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.Add(1, clienteValues(t, "12345678Z")); err != nil {
+		t.Fatal(err)
+	}
+	result, err := batch.Apply()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Committed || result.Updated != 1 || result.Inserted != 0 {
+		t.Fatalf("resultado inesperado: %+v", result)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPostgresImportBatchReplaceAbortsOverThreshold(t *testing.T) {
+	mock := useMockDB(t)
+	job := newTestImportJob("imp-1")
+	job.Mode = ModeReplace
+	job.DuplicateHandling = "update"
+	job.MaxDeactivatePct = 10
+
+	expectStagingBatch(mock, 1)
+	mock.ExpectExec(`UPDATE import_staging SET action = CASE`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT action, COUNT\(\*\) FROM import_staging GROUP BY action`).
+		WillReturnRows(sqlmock.NewRows([]string{"action", "count"}).AddRow(stagingUpdate, 1))
+	// Un archivo truncado: 39 de 40 activos desaparecerían
+	expectReplaceCount(mock, 39, 40)
+	mock.ExpectRollback()
+
+	batch, err := newPostgresImportBatch(job, importSpecs[ImportClientes])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.Add(1, clienteValues(t, "12345678Z")); err != nil {
+		t.Fatal(err)
+	}
+	result, err := batch.Apply()
+	if !errors.Is(err, ErrReplaceThreshold) {
+		t.Fatalf("err = %v, want ErrReplaceThreshold", err)
+	}
+	if result.Committed {
+		t.Fatal("no debe confirmarse")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProcessImportReplaceReportsDeactivated(t *testing.T) {
+	store := useMemoryImportStore(t)
+	newImportBatch = func(job *ImportJob, spec importSpec) (importBatch, error) {
+		return &replaceFakeBatch{}, nil
+	}
+
+	job := newTestImportJob("job-replace")
+	job.Mode = ModeReplace
+	store.Save(job)
+	processImport(job, csvBody("NIF", "12345678Z"))
+
+	got, _ := store.Get("job-replace")
+	if got.Status != StatusCompleted || got.DeactivatedRows != 5 {
+		t.Fatalf("status = %s, deactivated_rows = %d", got.Status, got.DeactivatedRows)
+	}
+}
+
+// replaceFakeBatch tramo que desactiva 5 registros ausentes
+type replaceFakeBatch struct{ fakeImportBatch }
+
+func (b *replaceFakeBatch) Apply() (importBatchResult, error) {
+	return importBatchResult{Committed: true, Updated: len(b.rows), Deactivated: 5}, nil
+}
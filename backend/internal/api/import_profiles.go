@@ -0,0 +1,519 @@
+package api
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"soriano-mediadores/internal/db"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// ImportProfile perfil de mapeo para CSV de compañías con un formato distinto al de Occident
+type ImportProfile struct {
+	ID                int               `json:"id"`
+	Name              string            `json:"name"`
+	Type              ImportType        `json:"type"`
+	Description       string            `json:"description,omitempty"`
+	ColumnMap         map[string]string `json:"column_map"`        // Columna del CSV → campo canónico (columna de la tabla)
+	Delimiter         string            `json:"delimiter"`         // ; , | o \t
+	DecimalSeparator  string            `json:"decimal_separator"` // , o .
+	DateFormat        string            `json:"date_format"`       // DD/MM/YYYY, YYYY-MM-DD, MM/DD/YYYY, DD-MM-YYYY, DD.MM.YYYY
+	Encoding          string            `json:"encoding"`          // utf-8, latin1, windows-1252
+	HeaderFingerprint string            `json:"header_fingerprint"`
+	SampleHeaders     []string          `json:"sample_headers,omitempty"` // Encabezados del archivo de ejemplo (para el fingerprint)
+	CreatedAt         time.Time         `json:"created_at"`
+	UpdatedAt         time.Time         `json:"updated_at"`
+}
+
+// importFormat formato efectivo con el que se lee un archivo
+type importFormat struct {
+	Comma            rune
+	DecimalSeparator byte
+	DateLayout       string // Layout Go; vacío = DD/MM/YYYY o YYYY-MM-DD (parseDate)
+	Encoding         string
+	ColumnMap        map[string]string
+	Workbook         bool   // Libro Excel (.xlsx) en lugar de CSV
+	Sheet            string // Hoja del libro (nombre o número); vacío = hoja activa
+}
+
+// occidentFormat formato de los CSV de Occident (por defecto, sin perfil)
+var occidentFormat = importFormat{Comma: ';', DecimalSeparator: ','}
+
+// importDateFormats formatos de fecha admitidos en los perfiles
+var importDateFormats = map[string]string{
+	"DD/MM/YYYY": "02/01/2006",
+	"YYYY-MM-DD": "2006-01-02",
+	"MM/DD/YYYY": "01/02/2006",
+	"DD-MM-YYYY": "02-01-2006",
+	"DD.MM.YYYY": "02.01.2006",
+}
+
+// format convierte el perfil en el formato de lectura
+func (p *ImportProfile) format() importFormat {
+	f := importFormat{
+		Comma:            ';',
+		DecimalSeparator: ',',
+		DateLayout:       importDateFormats[p.DateFormat],
+		Encoding:         p.Encoding,
+		ColumnMap:        p.ColumnMap,
+	}
+	if p.Delimiter == `\t` {
+		f.Comma = '\t'
+	} else if p.Delimiter != "" {
+		f.Comma = []rune(p.Delimiter)[0]
+	}
+	if p.DecimalSeparator == "." {
+		f.DecimalSeparator = '.'
+	}
+	return f
+}
+
+// reader decodifica el archivo según el encoding del perfil
+func (f importFormat) reader(r io.Reader) io.Reader {
+	switch strings.ToLower(f.Encoding) {
+	case "latin1", "iso-8859-1":
+		return charmap.ISO8859_1.NewDecoder().Reader(r)
+	case "windows-1252", "cp1252":
+		return charmap.Windows1252.NewDecoder().Reader(r)
+	}
+	return r
+}
+
+// csvReader lector CSV configurado con el delimitador y el encoding del formato
+func (f importFormat) csvReader(r io.Reader) *csv.Reader {
+	reader := csv.NewReader(f.reader(r))
+	reader.Comma = f.Comma
+	reader.LazyQuotes = true
+	reader.TrimLeadingSpace = true
+	return reader
+}
+
+// mapHeaders renombra los encabezados del archivo a los campos canónicos del perfil
+func (f importFormat) mapHeaders(headers []string) []string {
+	if len(f.ColumnMap) == 0 {
+		return headers
+	}
+	columnMap := make(map[string]string, len(f.ColumnMap))
+	for source, canonical := range f.ColumnMap {
+		columnMap[normalizeHeader(source)] = canonical
+	}
+
+	mapped := make([]string, len(headers))
+	for i, h := range headers {
+		name := strings.TrimSpace(strings.TrimPrefix(h, "\ufeff"))
+		if canonical, ok := columnMap[normalizeHeader(h)]; ok {
+			mapped[i] = canonical
+		} else {
+			mapped[i] = name
+		}
+	}
+	return mapped
+}
+
+// parseNumber convierte un importe según el separador decimal del formato
+func (f importFormat) parseNumber(s string) (float64, bool) {
+	if f.DecimalSeparator != '.' {
+		return parseFloatStrict(s)
+	}
+	if s == "" {
+		return 0, true
+	}
+	s = strings.NewReplacer("€", "", " ", "", ",", "").Replace(strings.TrimSpace(s))
+	val, err := strconv.ParseFloat(s, 64)
+	return val, err == nil
+}
+
+// parseDate convierte una fecha a YYYY-MM-DD según el formato (nil si viene vacía)
+func (f importFormat) parseDate(s string) (interface{}, bool) {
+	if f.DateLayout == "" {
+		date := parseDate(s)
+		if date == nil {
+			return nil, true
+		}
+		_, err := time.Parse("2006-01-02", date.(string))
+		return date, err == nil
+	}
+
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, true
+	}
+	// Si tiene hora, quitarla
+	if i := strings.Index(s, " "); i > 0 {
+		s = s[:i]
+	}
+	t, err := time.Parse(f.DateLayout, s)
+	if err != nil {
+		// Las celdas de fecha de los libros Excel llegan ya como YYYY-MM-DD
+		if t, err = time.Parse("2006-01-02", s); err != nil {
+			return nil, false
+		}
+	}
+	return t.Format("2006-01-02"), true
+}
+
+// normalizeHeader forma de comparar encabezados y claves de ColumnMap: sin
+// BOM, sin espacios alrededor y en minúsculas
+func normalizeHeader(h string) string {
+	return strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+}
+
+// headerFingerprint huella de un conjunto de encabezados (independiente del orden y mayúsculas)
+func headerFingerprint(headers []string) string {
+	normalized := make([]string, 0, len(headers))
+	for _, h := range headers {
+		h = normalizeHeader(h)
+		if h != "" {
+			normalized = append(normalized, h)
+		}
+	}
+	sort.Strings(normalized)
+	sum := sha1.Sum([]byte(strings.Join(normalized, "\x1f")))
+	return hex.EncodeToString(sum[:])
+}
+
+// sniffDelimiter elige el delimitador más frecuente en la primera línea
+func sniffDelimiter(line string) rune {
+	best, bestCount := ';', 0
+	for _, d := range []rune{';', ',', '\t', '|'} {
+		if n := strings.Count(line, string(d)); n > bestCount {
+			best, bestCount = d, n
+		}
+	}
+	return best
+}
+
+// peekFirstLine devuelve la primera línea sin consumirla del reader
+func peekFirstLine(r *bufio.Reader) string {
+	buf, _ := r.Peek(64 * 1024)
+	line := string(buf)
+	if i := strings.IndexAny(line, "\r\n"); i >= 0 {
+		line = line[:i]
+	}
+	return line
+}
+
+// ProfileSuggestion perfil propuesto para unos encabezados
+type ProfileSuggestion struct {
+	ID       int        `json:"id"`
+	Name     string     `json:"name"`
+	Type     ImportType `json:"type"`
+	Match    string     `json:"match"`    // exact (mismo fingerprint) o partial
+	Coverage float64    `json:"coverage"` // Fracción de columnas del perfil presentes en el archivo
+}
+
+// minProfileCoverage cobertura mínima para proponer un perfil sin fingerprint exacto
+const minProfileCoverage = 0.8
+
+// suggestImportProfile busca el perfil que mejor encaja con los encabezados
+func suggestImportProfile(profiles []*ImportProfile, headers []string) *ProfileSuggestion {
+	fingerprint := headerFingerprint(headers)
+	present := make(map[string]bool, len(headers))
+	for _, h := range headers {
+		present[normalizeHeader(h)] = true
+	}
+
+	var best *ProfileSuggestion
+	bestColumns := 0
+	for _, p := range profiles {
+		if p.HeaderFingerprint == fingerprint {
+			return &ProfileSuggestion{ID: p.ID, Name: p.Name, Type: p.Type, Match: "exact", Coverage: 1}
+		}
+		if len(p.ColumnMap) == 0 {
+			continue
+		}
+		found := 0
+		for source := range p.ColumnMap {
+			if present[normalizeHeader(source)] {
+				found++
+			}
+		}
+		coverage := float64(found) / float64(len(p.ColumnMap))
+		if coverage < minProfileCoverage {
+			continue
+		}
+		if best == nil || coverage > best.Coverage || (coverage == best.Coverage && found > bestColumns) {
+			best = &ProfileSuggestion{ID: p.ID, Name: p.Name, Type: p.Type, Match: "partial", Coverage: coverage}
+			bestColumns = found
+		}
+	}
+	return best
+}
+
+// validate comprueba el perfil antes de guardarlo
+func (p *ImportProfile) validate() error {
+	if strings.TrimSpace(p.Name) == "" {
+		return fmt.Errorf("el nombre del perfil es obligatorio")
+	}
+	if !isValidImportType(p.Type) {
+		return fmt.Errorf("tipo de importación inválido: %s", p.Type)
+	}
+	if p.Delimiter == "" {
+		p.Delimiter = ";"
+	}
+	if p.Delimiter != `\t` && len([]rune(p.Delimiter)) != 1 {
+		return fmt.Errorf("el delimitador debe ser un único carácter")
+	}
+	if p.DecimalSeparator == "" {
+		p.DecimalSeparator = ","
+	}
+	if p.DecimalSeparator != "," && p.DecimalSeparator != "." {
+		return fmt.Errorf("separador decimal inválido (, o .)")
+	}
+	if p.DateFormat == "" {
+		p.DateFormat = "DD/MM/YYYY"
+	}
+	if _, ok := importDateFormats[p.DateFormat]; !ok {
+		return fmt.Errorf("formato de fecha no soportado: %s", p.DateFormat)
+	}
+	switch strings.ToLower(p.Encoding) {
+	case "":
+		p.Encoding = "utf-8"
+	case "utf-8", "utf8", "latin1", "iso-8859-1", "windows-1252", "cp1252":
+	default:
+		return fmt.Errorf("encoding no soportado: %s", p.Encoding)
+	}
+	if len(p.ColumnMap) == 0 {
+		return fmt.Errorf("column_map no puede estar vacío")
+	}
+
+	canonical := make(map[string]bool)
+	for _, f := range importSpecs[p.Type].Fields {
+		canonical[f.Column] = true
+	}
+	for source, target := range p.ColumnMap {
+		if !canonical[target] {
+			return fmt.Errorf("campo canónico desconocido para %s: %s (columna %s)", p.Type, target, source)
+		}
+	}
+
+	headers := p.SampleHeaders
+	if len(headers) == 0 {
+		for source := range p.ColumnMap {
+			headers = append(headers, source)
+		}
+	}
+	p.HeaderFingerprint = headerFingerprint(headers)
+	return nil
+}
+
+const importProfileColumns = `
+	id, name, type, COALESCE(description, ''), column_map, delimiter, decimal_separator,
+	date_format, encoding, header_fingerprint, created_at, updated_at
+`
+
+func scanImportProfile(row rowScanner) (*ImportProfile, error) {
+	var p ImportProfile
+	var columnMap []byte
+	err := row.Scan(&p.ID, &p.Name, &p.Type, &p.Description, &columnMap, &p.Delimiter,
+		&p.DecimalSeparator, &p.DateFormat, &p.Encoding, &p.HeaderFingerprint, &p.CreatedAt, &p.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(columnMap, &p.ColumnMap); err != nil {
+		return nil, fmt.Errorf("column_map corrupto en perfil %d: %w", p.ID, err)
+	}
+	return &p, nil
+}
+
+// listImportProfiles perfiles guardados (todos o los de un tipo)
+func listImportProfiles(importType ImportType) ([]*ImportProfile, error) {
+	query := "SELECT " + importProfileColumns + " FROM import_mapping_profiles"
+	var args []interface{}
+	if importType != "" {
+		query += " WHERE type = $1"
+		args = append(args, importType)
+	}
+	query += " ORDER BY name"
+
+	rows, err := db.PostgresDB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	profiles := []*ImportProfile{}
+	for rows.Next() {
+		p, err := scanImportProfile(rows)
+		if err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, p)
+	}
+	return profiles, rows.Err()
+}
+
+// findImportProfile busca un perfil por ID o por nombre
+func findImportProfile(ref string) (*ImportProfile, error) {
+	query := "SELECT " + importProfileColumns + " FROM import_mapping_profiles WHERE name = $1"
+	var arg interface{} = ref
+	if id, err := strconv.Atoi(ref); err == nil {
+		query = "SELECT " + importProfileColumns + " FROM import_mapping_profiles WHERE id = $1"
+		arg = id
+	}
+	p, err := scanImportProfile(db.PostgresDB.QueryRow(query, arg))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("perfil de importación no encontrado: %s", ref)
+	}
+	return p, err
+}
+
+func saveImportProfile(p *ImportProfile) error {
+	columnMap, err := json.Marshal(p.ColumnMap)
+	if err != nil {
+		return err
+	}
+	if p.ID == 0 {
+		return db.PostgresDB.QueryRow(`
+			INSERT INTO import_mapping_profiles (
+				name, type, description, column_map, delimiter, decimal_separator,
+				date_format, encoding, header_fingerprint
+			) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			RETURNING id, created_at, updated_at
+		`, p.Name, p.Type, p.Description, string(columnMap), p.Delimiter, p.DecimalSeparator,
+			p.DateFormat, p.Encoding, p.HeaderFingerprint).Scan(&p.ID, &p.CreatedAt, &p.UpdatedAt)
+	}
+
+	err = db.PostgresDB.QueryRow(`
+		UPDATE import_mapping_profiles SET
+			name = $1, type = $2, description = $3, column_map = $4, delimiter = $5,
+			decimal_separator = $6, date_format = $7, encoding = $8, header_fingerprint = $9,
+			updated_at = NOW()
+		WHERE id = $10
+		RETURNING created_at, updated_at
+	`, p.Name, p.Type, p.Description, string(columnMap), p.Delimiter, p.DecimalSeparator,
+		p.DateFormat, p.Encoding, p.HeaderFingerprint, p.ID).Scan(&p.CreatedAt, &p.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("perfil de importación no encontrado: %d", p.ID)
+	}
+	return err
+}
+
+// GetImportProfiles lista los perfiles de mapeo (?type= filtra por tipo)
+func GetImportProfiles(c *fiber.Ctx) error {
+	importType := ImportType(c.Query("type"))
+	if importType != "" && !isValidImportType(importType) {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Tipo de importación inválido",
+		})
+	}
+
+	profiles, err := listImportProfiles(importType)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error obteniendo perfiles: " + err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"total":    len(profiles),
+		"profiles": profiles,
+	})
+}
+
+// GetImportProfile obtiene un perfil por ID o nombre
+func GetImportProfile(c *fiber.Ctx) error {
+	profile, err := findImportProfile(c.Params("id"))
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.JSON(profile)
+}
+
+// CreateImportProfile crea un perfil de mapeo
+func CreateImportProfile(c *fiber.Ctx) error {
+	var profile ImportProfile
+	if err := c.BodyParser(&profile); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "JSON inválido",
+		})
+	}
+	profile.ID = 0
+
+	if err := profile.validate(); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err := saveImportProfile(&profile); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error guardando perfil: " + err.Error(),
+		})
+	}
+
+	return c.Status(201).JSON(profile)
+}
+
+// UpdateImportProfile actualiza un perfil de mapeo
+func UpdateImportProfile(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "ID de perfil inválido",
+		})
+	}
+
+	var profile ImportProfile
+	if err := c.BodyParser(&profile); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "JSON inválido",
+		})
+	}
+	profile.ID = id
+
+	if err := profile.validate(); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err := saveImportProfile(&profile); err != nil {
+		status := 500
+		if strings.Contains(err.Error(), "no encontrado") {
+			status = 404
+		}
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(profile)
+}
+
+// DeleteImportProfile elimina un perfil de mapeo
+func DeleteImportProfile(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "ID de perfil inválido",
+		})
+	}
+
+	res, err := db.PostgresDB.Exec("DELETE FROM import_mapping_profiles WHERE id = $1", id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error eliminando perfil: " + err.Error(),
+		})
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "Perfil de importación no encontrado",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Perfil eliminado",
+	})
+}
@@ -0,0 +1,148 @@
+package api
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+func TestSuggestImportProfileByFingerprint(t *testing.T) {
+	mapfre := &ImportProfile{ID: 1, Name: "mapfre-clientes", Type: ImportClientes,
+		ColumnMap: map[string]string{"DNI": "nif", "Nombre y apellidos": "nombre_completo", "Cod. cliente": "id_account"}}
+	axa := &ImportProfile{ID: 2, Name: "axa-polizas", Type: ImportPolizas,
+		ColumnMap:     map[string]string{"Policy": "numero_poliza", "Customer": "id_account"},
+		SampleHeaders: []string{"Policy", "Customer", "Branch"}}
+	for _, p := range []*ImportProfile{mapfre, axa} {
+		if err := p.validate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	profiles := []*ImportProfile{mapfre, axa}
+
+	// Mismos encabezados en otro orden y con BOM: coincidencia exacta
+	got := suggestImportProfile(profiles, []string{"\ufeffbranch", "CUSTOMER", "Policy"})
+	if got == nil || got.ID != 2 || got.Match != "exact" {
+		t.Fatalf("sugerencia = %+v, want axa exacto", got)
+	}
+
+	// Columnas extra que el perfil no mapea: coincidencia parcial
+	got = suggestImportProfile(profiles, []string{"DNI", "Nombre y apellidos", "Cod. cliente", "Teléfono"})
+	if got == nil || got.ID != 1 || got.Match != "partial" || got.Coverage != 1 {
+		t.Fatalf("sugerencia = %+v, want mapfre parcial", got)
+	}
+
+	if got := suggestImportProfile(profiles, []string{"NIF", "Nombre completo"}); got != nil {
+		t.Fatalf("no debería sugerir perfil: %+v", got)
+	}
+}
+
+func TestSuggestImportProfileNormalizesColumnMap(t *testing.T) {
+	// Perfil guardado con los encabezados tal cual venían: BOM y espacios
+	generali := &ImportProfile{ID: 3, Name: "generali-clientes", Type: ImportClientes,
+		ColumnMap: map[string]string{"\ufeffDNI": "nif", " Nombre y apellidos ": "nombre_completo"}}
+	headers := []string{"\ufeffdni", "NOMBRE Y APELLIDOS", "Teléfono"}
+
+	got := suggestImportProfile([]*ImportProfile{generali}, headers)
+	if got == nil || got.ID != 3 || got.Match != "partial" || got.Coverage != 1 {
+		t.Fatalf("sugerencia = %+v, want generali parcial", got)
+	}
+
+	// Y el mapeo de columnas usa la misma normalización
+	mapped := generali.format().mapHeaders(headers)
+	if strings.Join(mapped, ",") != "nif,nombre_completo,Teléfono" {
+		t.Fatalf("encabezados mapeados = %v", mapped)
+	}
+}
+
+func TestImportProfileValidate(t *testing.T) {
+	p := &ImportProfile{Name: "x", Type: ImportRecibos, ColumnMap: map[string]string{"Recibo": "numero_recib"}}
+	if err := p.validate(); err == nil || !strings.Contains(err.Error(), "numero_recib") {
+		t.Fatalf("err = %v, want campo canónico desconocido", err)
+	}
+
+	p = &ImportProfile{Name: "x", Type: ImportRecibos, ColumnMap: map[string]string{"Recibo": "numero_recibo"}, DateFormat: "YY/MM/DD"}
+	if err := p.validate(); err == nil {
+		t.Fatal("formato de fecha no soportado aceptado")
+	}
+
+	p = &ImportProfile{Name: "x", Type: ImportRecibos, ColumnMap: map[string]string{"Recibo": "numero_recibo"}}
+	if err := p.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if p.Delimiter != ";" || p.DecimalSeparator != "," || p.DateFormat != "DD/MM/YYYY" || p.Encoding != "utf-8" {
+		t.Fatalf("valores por defecto inesperados: %+v", p)
+	}
+}
+
+func TestProcessImportWithProfile(t *testing.T) {
+	store := useMemoryImportStore(t)
+
+	var added [][]interface{}
+	newImportBatch = func(job *ImportJob, spec importSpec) (importBatch, error) {
+		return &recordingBatch{added: &added}, nil
+	}
+
+	profile := &ImportProfile{
+		Name: "generali-recibos", Type: ImportRecibos,
+		ColumnMap: map[string]string{
+			"Receipt":   "numero_recibo",
+			"Policy":    "numero_poliza",
+			"Premium":   "prima_total",
+			"Issued on": "fecha_emision",
+			"Holder":    "nombre_cliente",
+		},
+		Delimiter: ",", DecimalSeparator: ".", DateFormat: "MM/DD/YYYY", Encoding: "latin1",
+	}
+	if err := profile.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	csv := "Receipt,Policy,Premium,Issued on,Holder\n" +
+		"R1,P1,\"1,234.50\",12/31/2025,Muñoz\n"
+	latin1, err := charmap.ISO8859_1.NewEncoder().String(csv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job := newTestImportJob("job-profile")
+	job.Type = ImportRecibos
+	job.format = profile.format()
+	store.Save(job)
+	processImport(job, csvBody(strings.TrimSuffix(latin1, "\n")))
+
+	if got, _ := store.Get("job-profile"); got.Status != StatusCompleted || got.SuccessfulRows != 1 {
+		t.Fatalf("job = %+v", got)
+	}
+	if len(added) != 1 {
+		t.Fatalf("filas = %d", len(added))
+	}
+
+	values := map[string]interface{}{}
+	for i, f := range importSpecs[ImportRecibos].Fields {
+		values[f.Column] = added[0][i]
+	}
+	if values["numero_recibo"] != "R1" || values["numero_poliza"] != "P1" {
+		t.Fatalf("claves = %v / %v", values["numero_recibo"], values["numero_poliza"])
+	}
+	if values["prima_total"] != 1234.5 {
+		t.Fatalf("prima_total = %v, want 1234.5", values["prima_total"])
+	}
+	if values["fecha_emision"] != "2025-12-31" {
+		t.Fatalf("fecha_emision = %v", values["fecha_emision"])
+	}
+	if values["nombre_cliente"] != "Muñoz" {
+		t.Fatalf("nombre_cliente = %q (encoding)", values["nombre_cliente"])
+	}
+}
+
+// recordingBatch guarda los valores recibidos
+type recordingBatch struct {
+	fakeImportBatch
+	added *[][]interface{}
+}
+
+func (b *recordingBatch) Add(rowNum int, values []interface{}) error {
+	*b.added = append(*b.added, values)
+	return b.fakeImportBatch.Add(rowNum, values)
+}
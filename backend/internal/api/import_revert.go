@@ -0,0 +1,115 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"soriano-mediadores/internal/db"
+	"strings"
+)
+
+// Acciones registradas en import_row_snapshots
+const (
+	snapshotInsert = "insert" // Fila creada por el import
+	snapshotUpdate = "update" // Fila modificada por el import (before_data = imagen previa)
+)
+
+// ErrImportRevertConflict indica que importaciones posteriores modificaron filas de este import
+var ErrImportRevertConflict = fmt.Errorf("filas modificadas por importaciones posteriores")
+
+// ErrImportNotRevertible el job dejó de estar completado antes de revertirlo
+// (p. ej. otra reversión simultánea ganó la carrera)
+var ErrImportNotRevertible = fmt.Errorf("el import job ya no está completado")
+
+// RevertTableResult conteos de una reversión para una tabla
+type RevertTableResult struct {
+	Deactivated int64 `json:"deactivated"` // Filas insertadas que se desactivan
+	Restored    int64 `json:"restored"`    // Filas actualizadas que vuelven a su estado previo
+}
+
+// revertImport deshace un import en una sola transacción: desactiva las filas
+// insertadas y restaura la imagen previa de las actualizadas. Si otra importación
+// posterior tocó las mismas filas se rechaza salvo que force sea true.
+func revertImport(importID string, force bool) (map[string]RevertTableResult, error) {
+	tx, err := db.PostgresDB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Bloquear el job: solo se revierte una vez y solo si está completado
+	res, err := tx.Exec(`UPDATE import_jobs SET status = $1 WHERE id = $2 AND status = $3`,
+		StatusReverted, importID, StatusCompleted)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrImportNotRevertible, importID)
+	}
+
+	results := make(map[string]RevertTableResult)
+	for _, importType := range []ImportType{ImportClientes, ImportPolizas, ImportRecibos, ImportSiniestros} {
+		spec := importSpecs[importType]
+
+		if !force {
+			var overwritten int
+			err := tx.QueryRow(`
+				SELECT COUNT(*) FROM import_row_snapshots s
+				JOIN `+spec.Table+` t ON t.id = s.row_id
+				WHERE s.import_id = $1 AND s.table_name = $2
+				  AND t.import_id IS DISTINCT FROM $1
+			`, importID, spec.Table).Scan(&overwritten)
+			if err != nil {
+				return nil, err
+			}
+			if overwritten > 0 {
+				return nil, fmt.Errorf("%w: %d en %s", ErrImportRevertConflict, overwritten, spec.Table)
+			}
+		}
+
+		result, err := revertImportTable(tx, importID, spec)
+		if err != nil {
+			return nil, fmt.Errorf("error revirtiendo %s: %w", spec.Table, err)
+		}
+		if result.Deactivated > 0 || result.Restored > 0 {
+			results[spec.Table] = result
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func revertImportTable(tx *sql.Tx, importID string, spec importSpec) (RevertTableResult, error) {
+	var result RevertTableResult
+
+	res, err := tx.Exec(`
+		UPDATE `+spec.Table+` t SET activo = FALSE, actualizado_en = NOW()
+		FROM import_row_snapshots s
+		WHERE s.import_id = $1 AND s.table_name = $2 AND s.action = $3
+		  AND t.id = s.row_id AND t.activo = TRUE
+	`, importID, spec.Table, snapshotInsert)
+	if err != nil {
+		return result, err
+	}
+	result.Deactivated, _ = res.RowsAffected()
+
+	columns := spec.restoreColumns()
+	assignments := make([]string, len(columns))
+	for i, col := range columns {
+		assignments[i] = col + " = r." + col
+	}
+	res, err = tx.Exec(`
+		UPDATE `+spec.Table+` t SET `+strings.Join(assignments, ", ")+`
+		FROM import_row_snapshots s, jsonb_populate_record(NULL::`+spec.Table+`, s.before_data) r
+		WHERE s.import_id = $1 AND s.table_name = $2 AND s.action = $3
+		  AND t.id = s.row_id
+	`, importID, spec.Table, snapshotUpdate)
+	if err != nil {
+		return result, err
+	}
+	result.Restored, _ = res.RowsAffected()
+
+	return result, nil
+}
@@ -0,0 +1,158 @@
+package api
+
+import (
+	"errors"
+	"net/http/httptest"
+	"regexp"
+	"soriano-mediadores/internal/db"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+)
+
+func useMockDB(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := db.PostgresDB
+	db.PostgresDB = mockDB
+	t.Cleanup(func() {
+		db.PostgresDB = prev
+		mockDB.Close()
+	})
+	return mock
+}
+
+func expectRevertTable(mock sqlmock.Sqlmock, table string, overwritten int, deactivated, restored int64) {
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM import_row_snapshots s\s+JOIN `+table).
+		WithArgs("imp-1", table).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(overwritten))
+	if overwritten > 0 {
+		return
+	}
+	mock.ExpectExec(`UPDATE `+table+` t SET activo = FALSE`).
+		WithArgs("imp-1", table, snapshotInsert).
+		WillReturnResult(sqlmock.NewResult(0, deactivated))
+	mock.ExpectExec(`UPDATE `+table+` t SET .*jsonb_populate_record\(NULL::`+table).
+		WithArgs("imp-1", table, snapshotUpdate).
+		WillReturnResult(sqlmock.NewResult(0, restored))
+}
+
+func TestRevertImportDeactivatesInsertsAndRestoresUpdates(t *testing.T) {
+	mock := useMockDB(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE import_jobs SET status = $1 WHERE id = $2 AND status = $3`)).
+		WithArgs(StatusReverted, "imp-1", StatusCompleted).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectRevertTable(mock, "clientes", 0, 0, 0)
+	expectRevertTable(mock, "polizas", 0, 0, 0)
+	expectRevertTable(mock, "recibos", 0, 12, 3)
+	expectRevertTable(mock, "siniestros", 0, 0, 0)
+	mock.ExpectCommit()
+
+	results, err := revertImport("imp-1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("tablas afectadas = %v, want solo recibos", results)
+	}
+	if got := results["recibos"]; got.Deactivated != 12 || got.Restored != 3 {
+		t.Fatalf("recibos = %+v", got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRevertImportRefusesWhenLaterImportTouchedRows(t *testing.T) {
+	mock := useMockDB(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE import_jobs SET status`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectRevertTable(mock, "clientes", 4, 0, 0)
+	mock.ExpectRollback()
+
+	_, err := revertImport("imp-1", false)
+	if !errors.Is(err, ErrImportRevertConflict) {
+		t.Fatalf("err = %v, want ErrImportRevertConflict", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRevertImportRefusesJobNotCompleted(t *testing.T) {
+	mock := useMockDB(t)
+
+	// Otro proceso ya lo revirtió entre la lectura y la transacción
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE import_jobs SET status`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	if _, err := revertImport("imp-1", false); !errors.Is(err, ErrImportNotRevertible) {
+		t.Fatalf("err = %v, want ErrImportNotRevertible", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRevertImportHandlerRejectsInvalidJobs(t *testing.T) {
+	store := useMemoryImportStore(t)
+	useMockDB(t) // cualquier acceso a la BD fallaría el test
+
+	failed := newTestImportJob("imp-failed")
+	failed.Status = StatusFailed
+	reverted := newTestImportJob("imp-reverted")
+	reverted.Status = StatusReverted
+	store.Save(failed)
+	store.Save(reverted)
+
+	app := fiber.New()
+	app.Post("/revert/:id", RevertImport)
+
+	for id, want := range map[string]int{"imp-failed": 400, "imp-reverted": 400, "no-existe": 404} {
+		resp, err := app.Test(httptest.NewRequest("POST", "/revert/"+id, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != want {
+			t.Errorf("%s: status = %d, want %d", id, resp.StatusCode, want)
+		}
+	}
+}
+
+func TestRevertImportHandlerConcurrentRevert(t *testing.T) {
+	store := useMemoryImportStore(t)
+	mock := useMockDB(t)
+
+	job := newTestImportJob("imp-1")
+	job.Status = StatusCompleted
+	store.Save(job)
+
+	// El store aún lo ve completado, pero otra reversión lo bloqueó antes
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE import_jobs SET status`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	app := fiber.New()
+	app.Post("/revert/:id", RevertImport)
+	resp, err := app.Test(httptest.NewRequest("POST", "/revert/imp-1", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 409 {
+		t.Fatalf("status = %d, want 409", resp.StatusCode)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,303 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+)
+
+// fieldKind indica cómo se interpreta y se actualiza cada columna importada
+type fieldKind int
+
+const (
+	kindKey          fieldKind = iota // Clave de negocio: se usa para buscar, nunca se actualiza
+	kindText                          // Texto: vacío conserva el valor actual
+	kindTextNullable                  // Texto que se inserta como NULL si viene vacío
+	kindNumeric                       // Decimal (formato español): 0 conserva el valor actual
+	kindInt                           // Entero: 0 conserva el valor actual
+	kindDate                          // Fecha DD/MM/YYYY o YYYY-MM-DD: siempre se sobrescribe
+)
+
+// importField relaciona una columna de la tabla con los encabezados del CSV de Occident
+type importField struct {
+	Column  string
+	Headers []string
+	Kind    fieldKind
+}
+
+// importSpec describe cómo se importa cada tipo de datos
+type importSpec struct {
+	Type   ImportType
+	Table  string
+	Label  string // Para mensajes de error ("cliente", "póliza"...)
+	Fields []importField
+	// MatchSQL une la staging "s" con la tabla destino "t"
+	MatchSQL string
+	// DedupSQL identifica la misma entidad dentro del archivo
+	DedupSQL string
+	// KeyDisplaySQL valor de la clave para mensajes de error
+	KeyDisplaySQL string
+	// RequiredMessage error cuando la fila no trae clave
+	RequiredMessage string
+	// NIFColumn columna con NIF/NIE/CIF que la validación previa comprueba
+	NIFColumn string
+	// References columnas que deben existir en otra tabla (validación previa)
+	References []importReference
+}
+
+// importReference comprobación referencial de una columna contra otra tabla
+type importReference struct {
+	Column       string
+	Table        string
+	TargetColumn string
+	Label        string
+}
+
+// refCliente la columna debe ser el IdAccount de un cliente existente
+func refCliente(col string) importReference {
+	return importReference{Column: col, Table: "clientes", TargetColumn: "id_account", Label: "Cliente (IdAccount)"}
+}
+
+// refPoliza la columna debe ser el número de una póliza existente
+func refPoliza(col string) importReference {
+	return importReference{Column: col, Table: "polizas", TargetColumn: "numero_poliza", Label: "Póliza"}
+}
+
+var importSpecs = map[ImportType]importSpec{
+	// Campos Occident: NIF, Nombre completo, Nombre, Apellidos, Fecha nacimiento, Sexo,
+	// Domicilio, Teléfono contacto, 2º Teléfono contacto, Población, Código postal,
+	// Email contacto, Total primas en cartera, Total primas relación, Mediador, Provincia, IdAccount
+	ImportClientes: {
+		Type:  ImportClientes,
+		Table: "clientes",
+		Label: "cliente",
+		Fields: []importField{
+			{"nif", []string{"NIF", "nif"}, kindKey},
+			{"id_account", []string{"IdAccount", "id_account"}, kindKey},
+			{"nombre_completo", []string{"Nombre completo"}, kindText},
+			{"nombre", []string{"Nombre"}, kindText},
+			{"apellidos", []string{"Apellidos"}, kindText},
+			{"fecha_nacimiento", []string{"Fecha nacimiento"}, kindText},
+			{"sexo", []string{"Sexo"}, kindText},
+			{"domicilio", []string{"Domicilio"}, kindText},
+			{"poblacion", []string{"Población"}, kindText},
+			{"codigo_postal", []string{"Código postal"}, kindText},
+			{"provincia", []string{"Provincia"}, kindText},
+			{"email_contacto", []string{"Email contacto"}, kindText},
+			{"telefono_contacto", []string{"Teléfono contacto"}, kindText},
+			{"telefono2_contacto", []string{"2º Teléfono contacto"}, kindText},
+			{"total_primas_cartera", []string{"Total primas en cartera"}, kindNumeric},
+			{"total_primas_relacion", []string{"Total primas relación"}, kindNumeric},
+			{"num_polizas_totales", []string{"Número de pólizas totales"}, kindInt},
+			{"mediador", []string{"Mediador"}, kindText},
+		},
+		MatchSQL:        "((s.nif <> '' AND t.nif = s.nif) OR (s.id_account <> '' AND t.id_account = s.id_account))",
+		DedupSQL:        "COALESCE(NULLIF(nif, ''), id_account)",
+		KeyDisplaySQL:   "COALESCE(NULLIF(nif, ''), id_account)",
+		RequiredMessage: "NIF o ID Account requerido",
+		NIFColumn:       "nif",
+	},
+	// Campos Occident: Número de la póliza, Ramo, Mediador, Domicilio de la póliza,
+	// Prima anual, Fecha de efecto, Fecha de vencimiento, Gestora, Descripción del riesgo,
+	// Matricula, Situación de la póliza, Nombre del cliente, IdAccount
+	ImportPolizas: {
+		Type:  ImportPolizas,
+		Table: "polizas",
+		Label: "póliza",
+		Fields: []importField{
+			{"numero_poliza", []string{"Número de la póliza", "numero_poliza"}, kindKey},
+			{"id_account", []string{"IdAccount"}, kindText},
+			{"nombre_cliente", []string{"Nombre del cliente"}, kindText},
+			{"ramo", []string{"Ramo"}, kindText},
+			{"gestora", []string{"Gestora"}, kindText},
+			{"mediador", []string{"Mediador"}, kindText},
+			{"situacion_poliza", []string{"Situación de la póliza"}, kindText},
+			{"prima_anual", []string{"Prima anual"}, kindText},
+			{"fecha_efecto", []string{"Fecha de efecto"}, kindText},
+			{"fecha_vencimiento", []string{"Fecha de vencimiento"}, kindText},
+			{"domicilio_poliza", []string{"Domicilio de la póliza"}, kindText},
+			{"descripcion_riesgo", []string{"Descripción del riesgo"}, kindText},
+			{"matricula", []string{"Matricula"}, kindText},
+		},
+		MatchSQL:        "t.numero_poliza = s.numero_poliza",
+		DedupSQL:        "numero_poliza",
+		KeyDisplaySQL:   "numero_poliza",
+		RequiredMessage: "número de póliza requerido",
+		References:      []importReference{refCliente("id_account")},
+	},
+	// Campos Occident: Nº recibo, Mediador, Ramo, Origen del recibo, Prima total,
+	// Fecha inicio cobertura, Situación del recibo, Fecha emisión, Fecha situación,
+	// Fecha fin cobertura, Gestora del recibo, Gestión de cobro, Detalle del recibo,
+	// Comisión bruta, Cliente, Nº póliza, Comisión neta, Forma de pago, Descripción riesgo, IdAccount
+	ImportRecibos: {
+		Type:  ImportRecibos,
+		Table: "recibos",
+		Label: "recibo",
+		Fields: []importField{
+			{"numero_recibo", []string{"Nº recibo", "numero_recibo"}, kindKey},
+			{"numero_poliza", []string{"Nº póliza"}, kindText},
+			{"id_account", []string{"IdAccount"}, kindText},
+			{"nombre_cliente", []string{"Cliente"}, kindText},
+			{"ramo", []string{"Ramo"}, kindText},
+			{"mediador", []string{"Mediador"}, kindText},
+			{"prima_total", []string{"Prima total"}, kindNumeric},
+			{"comision_bruta", []string{"Comisión bruta"}, kindNumeric},
+			{"comision_neta", []string{"Comisión neta"}, kindNumeric},
+			{"situacion_recibo", []string{"Situación del recibo"}, kindText},
+			{"fecha_emision", []string{"Fecha emisión"}, kindDate},
+			{"fecha_situacion", []string{"Fecha situación"}, kindDate},
+			{"fecha_inicio_cobertura", []string{"Fecha inicio cobertura"}, kindDate},
+			{"fecha_fin_cobertura", []string{"Fecha fin cobertura"}, kindDate},
+			{"forma_pago", []string{"Forma de pago"}, kindText},
+			{"gestora_recibo", []string{"Gestora del recibo"}, kindText},
+			{"gestion_cobro", []string{"Gestión de cobro"}, kindText},
+			{"detalle_recibo", []string{"Detalle del recibo"}, kindText},
+			{"descripcion_riesgo", []string{"Descripción riesgo"}, kindText},
+		},
+		MatchSQL:        "t.numero_recibo = s.numero_recibo",
+		DedupSQL:        "numero_recibo",
+		KeyDisplaySQL:   "numero_recibo",
+		RequiredMessage: "número de recibo requerido",
+		References:      []importReference{refPoliza("numero_poliza"), refCliente("id_account")},
+	},
+	// Campos de Occident SINIESTROS.csv:
+	// Número de póliza, Número de siniestro, Mediador, Situación del siniestro,
+	// Fecha de ocurrencia, Fecha de cierre, Fecha de apertura, Tramitador,
+	// Gestionado, Cliente, Centro de tramitación, IdAccount
+	ImportSiniestros: {
+		Type:  ImportSiniestros,
+		Table: "siniestros",
+		Label: "siniestro",
+		Fields: []importField{
+			{"numero_siniestro", []string{"Número de siniestro", "numero_siniestro", "Numero de siniestro"}, kindKey},
+			{"numero_poliza", []string{"Número de póliza", "numero_poliza", "Numero de poliza"}, kindText},
+			{"id_account", []string{"IdAccount", "id_account", "ID Account"}, kindText},
+			{"cliente", []string{"Cliente", "cliente", "nombre_cliente"}, kindText},
+			{"situacion_siniestro", []string{"Situación del siniestro", "situacion_siniestro", "Situacion del siniestro"}, kindText},
+			{"fecha_ocurrencia", []string{"Fecha de ocurrencia", "fecha_ocurrencia"}, kindTextNullable},
+			{"fecha_apertura", []string{"Fecha de apertura", "fecha_apertura"}, kindTextNullable},
+			{"fecha_cierre", []string{"Fecha de cierre", "fecha_cierre"}, kindTextNullable},
+			{"tramitador", []string{"Tramitador", "tramitador"}, kindText},
+			{"centro_tramitacion", []string{"Centro de tramitación", "centro_tramitacion", "Centro de tramitacion"}, kindText},
+			{"mediador", []string{"Mediador", "mediador"}, kindText},
+			{"gestionado", []string{"Gestionado", "gestionado"}, kindText},
+		},
+		MatchSQL:        "t.numero_siniestro = s.numero_siniestro",
+		DedupSQL:        "numero_siniestro",
+		KeyDisplaySQL:   "numero_siniestro",
+		RequiredMessage: "número de siniestro requerido",
+		References:      []importReference{refPoliza("numero_poliza"), refCliente("id_account")},
+	},
+}
+
+// columns devuelve las columnas de datos en orden
+func (spec importSpec) columns() []string {
+	cols := make([]string, len(spec.Fields))
+	for i, f := range spec.Fields {
+		cols[i] = f.Column
+	}
+	return cols
+}
+
+// restoreColumns columnas que el import puede modificar (las que se restauran al revertir)
+func (spec importSpec) restoreColumns() []string {
+	var cols []string
+	for _, f := range spec.Fields {
+		if f.Kind != kindKey {
+			cols = append(cols, f.Column)
+		}
+	}
+	return append(cols, "activo", "import_id", "actualizado_en")
+}
+
+// updateAssignments SET de la actualización desde la staging "s"
+func (spec importSpec) updateAssignments() []string {
+	var sets []string
+	for _, f := range spec.Fields {
+		switch f.Kind {
+		case kindKey:
+			continue
+		case kindText, kindTextNullable:
+			sets = append(sets, fmt.Sprintf("%s = COALESCE(NULLIF(s.%s, ''), t.%s)", f.Column, f.Column, f.Column))
+		case kindNumeric, kindInt:
+			sets = append(sets, fmt.Sprintf("%s = COALESCE(NULLIF(s.%s, 0), t.%s)", f.Column, f.Column, f.Column))
+		case kindDate:
+			sets = append(sets, fmt.Sprintf("%s = s.%s", f.Column, f.Column))
+		}
+	}
+	return sets
+}
+
+// insertExpressions expresiones del INSERT ... SELECT desde la staging "s"
+func (spec importSpec) insertExpressions() []string {
+	exprs := make([]string, len(spec.Fields))
+	for i, f := range spec.Fields {
+		if f.Kind == kindTextNullable {
+			exprs[i] = fmt.Sprintf("NULLIF(s.%s, '')", f.Column)
+		} else {
+			exprs[i] = "s." + f.Column
+		}
+	}
+	return exprs
+}
+
+// parseImportRow convierte una fila del CSV en los valores de la staging (mismo orden
+// que spec.Fields) aplicando el separador decimal y formato de fecha del perfil.
+// Devuelve los errores de la fila con campo y valor.
+func parseImportRow(spec importSpec, format importFormat, data map[string]string, rowNum int) ([]interface{}, []ImportError) {
+	values := make([]interface{}, len(spec.Fields))
+	var rowErrors []ImportError
+	hasKey := false
+
+	for i, f := range spec.Fields {
+		raw := getField(data, append(f.Headers, f.Column)...)
+
+		switch f.Kind {
+		case kindKey:
+			if raw != "" {
+				hasKey = true
+			}
+			values[i] = raw
+		case kindText, kindTextNullable:
+			values[i] = raw
+		case kindNumeric:
+			val, ok := format.parseNumber(raw)
+			if !ok {
+				rowErrors = append(rowErrors, ImportError{Row: rowNum, Field: f.Column, Value: raw,
+					Message: "Importe no numérico"})
+			}
+			values[i] = val
+		case kindInt:
+			val, ok := parseIntStrict(raw)
+			if !ok {
+				rowErrors = append(rowErrors, ImportError{Row: rowNum, Field: f.Column, Value: raw,
+					Message: "Número entero inválido"})
+			}
+			values[i] = val
+		case kindDate:
+			date, ok := format.parseDate(raw)
+			if !ok {
+				rowErrors = append(rowErrors, ImportError{Row: rowNum, Field: f.Column, Value: raw,
+					Message: "Fecha inválida (se espera DD/MM/YYYY o YYYY-MM-DD)"})
+				date = nil
+			}
+			values[i] = date
+		}
+	}
+
+	if !hasKey {
+		rowErrors = append(rowErrors, ImportError{Row: rowNum, Message: spec.RequiredMessage})
+	}
+
+	return values, rowErrors
+}
+
+// rowToMap convierte una fila en mapa encabezado → valor
+func rowToMap(headers []string, row []string) map[string]string {
+	data := make(map[string]string, len(headers))
+	for i, header := range headers {
+		if i < len(row) {
+			data[strings.TrimSpace(header)] = strings.TrimSpace(row[i])
+		}
+	}
+	return data
+}
@@ -0,0 +1,300 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"soriano-mediadores/internal/db"
+	"strings"
+	"time"
+)
+
+// ImportJobStore persiste el ciclo de vida de los import jobs
+type ImportJobStore interface {
+	Save(job *ImportJob) error
+	Get(id string) (*ImportJob, error)
+	List(filter ImportHistoryFilter) ([]*ImportJob, int, error)
+}
+
+// ImportHistoryFilter filtros para el historial de importaciones
+type ImportHistoryFilter struct {
+	Type       ImportType
+	Status     []ImportStatus
+	Username   string
+	FechaDesde string // YYYY-MM-DD
+	FechaHasta string // YYYY-MM-DD
+	Limit      int
+	Offset     int
+}
+
+// ErrImportJobNotFound se devuelve cuando el job no existe en el store
+var ErrImportJobNotFound = fmt.Errorf("import job no encontrado")
+
+// ErrImportJobInterrupted Save rechazado: otra instancia marcó el job como
+// interrumpido y solo otro Save con ese estado puede sobrescribirlo
+var ErrImportJobInterrupted = fmt.Errorf("import job marcado como interrumpido")
+
+// importJobStore es el store activo (Postgres por defecto, sustituible en tests)
+var importJobStore ImportJobStore = &postgresImportJobStore{}
+
+// postgresImportJobStore guarda los jobs en la tabla import_jobs (migración 004)
+type postgresImportJobStore struct{}
+
+// Save inserta o actualiza el job completo y renueva su heartbeat. Un job
+// interrumpido solo se sobrescribe con el mismo estado (ErrImportJobInterrupted)
+func (s *postgresImportJobStore) Save(job *ImportJob) error {
+	errorsJSON, err := json.Marshal(job.Errors)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO import_jobs (
+			id, type, mode, status, total_rows, processed_rows,
+			successful_rows, failed_rows, duplicate_rows, skipped_rows,
+			errors, started_at, completed_at, validate_first,
+			duplicate_handling, username, filename, commit_mode, chunk_size,
+			dry_run, accept_errors, deactivated_rows, max_deactivate_pct, profile_id, sheet,
+			instance_id, heartbeat_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26, NOW())
+		ON CONFLICT (id) DO UPDATE SET
+			status = EXCLUDED.status,
+			total_rows = EXCLUDED.total_rows,
+			processed_rows = EXCLUDED.processed_rows,
+			successful_rows = EXCLUDED.successful_rows,
+			failed_rows = EXCLUDED.failed_rows,
+			duplicate_rows = EXCLUDED.duplicate_rows,
+			skipped_rows = EXCLUDED.skipped_rows,
+			deactivated_rows = EXCLUDED.deactivated_rows,
+			errors = EXCLUDED.errors,
+			completed_at = EXCLUDED.completed_at,
+			sheet = EXCLUDED.sheet,
+			instance_id = EXCLUDED.instance_id,
+			heartbeat_at = EXCLUDED.heartbeat_at
+		WHERE import_jobs.status <> 'interrupted' OR EXCLUDED.status = 'interrupted'
+	`
+
+	res, err := db.PostgresDB.Exec(query,
+		job.ID, job.Type, job.Mode, job.Status, job.TotalRows, job.ProcessedRows,
+		job.SuccessfulRows, job.FailedRows, job.DuplicateRows, job.SkippedRows,
+		string(errorsJSON), job.StartedAt, job.CompletedAt, job.ValidateFirst,
+		job.DuplicateHandling, job.Username, job.Filename, job.CommitMode, job.ChunkSize,
+		job.DryRun, job.AcceptErrors, job.DeactivatedRows, job.MaxDeactivatePct,
+		sql.NullInt64{Int64: int64(job.ProfileID), Valid: job.ProfileID > 0},
+		sql.NullString{String: job.Sheet, Valid: job.Sheet != ""},
+		sql.NullString{String: job.Instance, Valid: job.Instance != ""},
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrImportJobInterrupted
+	}
+	return nil
+}
+
+const importJobColumns = `
+	id, type, mode, status, total_rows, processed_rows,
+	successful_rows, failed_rows, duplicate_rows, skipped_rows,
+	errors, started_at, completed_at, COALESCE(validate_first, FALSE),
+	COALESCE(duplicate_handling, ''), COALESCE(username, ''), COALESCE(filename, ''),
+	COALESCE(commit_mode, 'job'), COALESCE(chunk_size, 0),
+	COALESCE(dry_run, FALSE), COALESCE(accept_errors, FALSE),
+	COALESCE(deactivated_rows, 0), COALESCE(max_deactivate_pct, 0), COALESCE(profile_id, 0),
+	COALESCE(sheet, ''), COALESCE(instance_id, ''), COALESCE(heartbeat_at, updated_at)
+`
+
+// Get obtiene un job por ID
+func (s *postgresImportJobStore) Get(id string) (*ImportJob, error) {
+	row := db.PostgresDB.QueryRow("SELECT "+importJobColumns+" FROM import_jobs WHERE id = $1", id)
+	job, err := scanImportJob(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrImportJobNotFound
+	}
+	return job, err
+}
+
+// List devuelve los jobs que cumplen el filtro (más recientes primero) y el total
+func (s *postgresImportJobStore) List(filter ImportHistoryFilter) ([]*ImportJob, int, error) {
+	var whereClauses []string
+	var args []interface{}
+
+	addArg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if filter.Type != "" {
+		whereClauses = append(whereClauses, "type = "+addArg(filter.Type))
+	}
+	if len(filter.Status) > 0 {
+		placeholders := make([]string, len(filter.Status))
+		for i, st := range filter.Status {
+			placeholders[i] = addArg(st)
+		}
+		whereClauses = append(whereClauses, "status IN ("+strings.Join(placeholders, ",")+")")
+	}
+	if filter.Username != "" {
+		whereClauses = append(whereClauses, "username = "+addArg(filter.Username))
+	}
+	if filter.FechaDesde != "" {
+		whereClauses = append(whereClauses, "started_at >= "+addArg(filter.FechaDesde)+"::date")
+	}
+	if filter.FechaHasta != "" {
+		whereClauses = append(whereClauses, "started_at < "+addArg(filter.FechaHasta)+"::date + INTERVAL '1 day'")
+	}
+
+	where := ""
+	if len(whereClauses) > 0 {
+		where = "WHERE " + strings.Join(whereClauses, " AND ")
+	}
+
+	var total int
+	if err := db.PostgresDB.QueryRow("SELECT COUNT(*) FROM import_jobs "+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := "SELECT " + importJobColumns + " FROM import_jobs " + where + " ORDER BY started_at DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT " + addArg(filter.Limit)
+	}
+	if filter.Offset > 0 {
+		query += " OFFSET " + addArg(filter.Offset)
+	}
+
+	rows, err := db.PostgresDB.Query(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	jobs := []*ImportJob{}
+	for rows.Next() {
+		job, err := scanImportJob(rows)
+		if err != nil {
+			log.Printf("⚠️  Error escaneando import job: %v", err)
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+
+	return jobs, total, rows.Err()
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanImportJob(row rowScanner) (*ImportJob, error) {
+	var job ImportJob
+	var errorsJSON []byte
+	var completedAt, heartbeatAt sql.NullTime
+
+	err := row.Scan(
+		&job.ID, &job.Type, &job.Mode, &job.Status, &job.TotalRows, &job.ProcessedRows,
+		&job.SuccessfulRows, &job.FailedRows, &job.DuplicateRows, &job.SkippedRows,
+		&errorsJSON, &job.StartedAt, &completedAt, &job.ValidateFirst,
+		&job.DuplicateHandling, &job.Username, &job.Filename,
+		&job.CommitMode, &job.ChunkSize,
+		&job.DryRun, &job.AcceptErrors,
+		&job.DeactivatedRows, &job.MaxDeactivatePct, &job.ProfileID,
+		&job.Sheet, &job.Instance, &heartbeatAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if completedAt.Valid {
+		t := completedAt.Time
+		job.CompletedAt = &t
+	}
+	if heartbeatAt.Valid {
+		t := heartbeatAt.Time
+		job.HeartbeatAt = &t
+	}
+	if len(errorsJSON) > 0 {
+		if err := json.Unmarshal(errorsJSON, &job.Errors); err != nil {
+			log.Printf("⚠️  Errores ilegibles en import job %s: %v", job.ID, err)
+		}
+	}
+	if job.Errors == nil {
+		job.Errors = []ImportError{}
+	}
+
+	return &job, nil
+}
+
+// persistImportJob guarda el estado actual del job (toma el lock de lectura).
+// Si otra instancia lo dio por interrumpido, el job se detiene.
+func persistImportJob(job *ImportJob) {
+	job.mu.RLock()
+	err := importJobStore.Save(job)
+	job.mu.RUnlock()
+
+	if errors.Is(err, ErrImportJobInterrupted) {
+		log.Printf("⚠️  Import job %s marcado como interrumpido por otra instancia (heartbeat caducado): se detiene", job.ID)
+		job.markInterrupted()
+		return
+	}
+	if err != nil {
+		log.Printf("⚠️  Error guardando import job %s en DB: %v", job.ID, err)
+	}
+}
+
+// RecoverInterruptedImports marca como interrumpidos los jobs pendientes o en
+// proceso cuya instancia dejó de dar señales (heartbeat de más de
+// importHeartbeatStale). Los de otras instancias vivas no se tocan. Llamar al
+// arrancar y periódicamente (WatchInterruptedImports).
+func RecoverInterruptedImports() (int, error) {
+	jobs, _, err := importJobStore.List(ImportHistoryFilter{
+		Status: []ImportStatus{StatusPending, StatusProcessing},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	recovered := 0
+	for _, job := range jobs {
+		// Jobs de este mismo proceso siguen vivos, no tocarlos
+		if getActiveImport(job.ID) != nil {
+			continue
+		}
+		// Otra instancia lo está procesando
+		if job.HeartbeatAt != nil && time.Since(*job.HeartbeatAt) < importHeartbeatStale {
+			continue
+		}
+
+		now := time.Now()
+		job.Status = StatusInterrupted
+		job.CompletedAt = &now
+		message := "Importación interrumpida por reinicio del servidor"
+		if job.Instance != "" {
+			message = fmt.Sprintf("Importación interrumpida: la instancia %s dejó de responder", job.Instance)
+		}
+		job.Errors = append(job.Errors, ImportError{Row: 0, Message: message})
+
+		if err := importJobStore.Save(job); err != nil {
+			log.Printf("⚠️  Error marcando import job %s como interrumpido: %v", job.ID, err)
+			continue
+		}
+		log.Printf("⚠️  Import job %s (instancia %s) marcado como interrumpido", job.ID, job.Instance)
+		recovered++
+	}
+
+	return recovered, nil
+}
+
+// WatchInterruptedImports repite RecoverInterruptedImports mientras el
+// servidor corre: los jobs de una instancia que se cae con las demás en
+// marcha no esperan al siguiente arranque
+func WatchInterruptedImports() {
+	ticker := time.NewTicker(importHeartbeatStale)
+	defer ticker.Stop()
+	for range ticker.C {
+		if _, err := RecoverInterruptedImports(); err != nil {
+			log.Printf("⚠️  Error recuperando import jobs: %v", err)
+		}
+	}
+}
@@ -0,0 +1,541 @@
+package api
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+)
+
+// memoryImportJobStore guarda copias de los jobs, como lo haría la tabla import_jobs
+type memoryImportJobStore struct {
+	mu      sync.Mutex
+	jobs    map[string]*ImportJob
+	history map[string][]ImportStatus
+}
+
+func newMemoryImportJobStore() *memoryImportJobStore {
+	return &memoryImportJobStore{
+		jobs:    make(map[string]*ImportJob),
+		history: make(map[string][]ImportStatus),
+	}
+}
+
+func copyImportJob(job *ImportJob) *ImportJob {
+	cp := &ImportJob{
+		ID:                job.ID,
+		Type:              job.Type,
+		Mode:              job.Mode,
+		Status:            job.Status,
+		TotalRows:         job.TotalRows,
+		ProcessedRows:     job.ProcessedRows,
+		SuccessfulRows:    job.SuccessfulRows,
+		FailedRows:        job.FailedRows,
+		DuplicateRows:     job.DuplicateRows,
+		SkippedRows:       job.SkippedRows,
+		DeactivatedRows:   job.DeactivatedRows,
+		Errors:            append([]ImportError{}, job.Errors...),
+		StartedAt:         job.StartedAt,
+		ValidateFirst:     job.ValidateFirst,
+		DryRun:            job.DryRun,
+		AcceptErrors:      job.AcceptErrors,
+		DuplicateHandling: job.DuplicateHandling,
+		Username:          job.Username,
+		Filename:          job.Filename,
+		CommitMode:        job.CommitMode,
+		ChunkSize:         job.ChunkSize,
+		MaxDeactivatePct:  job.MaxDeactivatePct,
+		ProfileID:         job.ProfileID,
+		Sheet:             job.Sheet,
+		Instance:          job.Instance,
+	}
+	if job.CompletedAt != nil {
+		t := *job.CompletedAt
+		cp.CompletedAt = &t
+	}
+	if job.HeartbeatAt != nil {
+		t := *job.HeartbeatAt
+		cp.HeartbeatAt = &t
+	}
+	return cp
+}
+
+func (s *memoryImportJobStore) Save(job *ImportJob) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if prev, ok := s.jobs[job.ID]; ok && prev.Status == StatusInterrupted && job.Status != StatusInterrupted {
+		return ErrImportJobInterrupted
+	}
+	saved := copyImportJob(job)
+	now := time.Now()
+	saved.HeartbeatAt = &now
+	s.jobs[job.ID] = saved
+	h := s.history[job.ID]
+	if len(h) == 0 || h[len(h)-1] != job.Status {
+		s.history[job.ID] = append(h, job.Status)
+	}
+	return nil
+}
+
+func (s *memoryImportJobStore) Get(id string) (*ImportJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, ErrImportJobNotFound
+	}
+	return copyImportJob(job), nil
+}
+
+func (s *memoryImportJobStore) List(filter ImportHistoryFilter) ([]*ImportJob, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var jobs []*ImportJob
+	for _, job := range s.jobs {
+		if filter.Type != "" && job.Type != filter.Type {
+			continue
+		}
+		if filter.Username != "" && job.Username != filter.Username {
+			continue
+		}
+		if len(filter.Status) > 0 {
+			match := false
+			for _, st := range filter.Status {
+				if job.Status == st {
+					match = true
+				}
+			}
+			if !match {
+				continue
+			}
+		}
+		jobs = append(jobs, copyImportJob(job))
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].StartedAt.After(jobs[j].StartedAt) })
+	return jobs, len(jobs), nil
+}
+
+func useMemoryImportStore(t *testing.T) *memoryImportJobStore {
+	t.Helper()
+	store := newMemoryImportJobStore()
+	prevStore, prevBatch := importJobStore, newImportBatch
+	importJobStore = store
+	t.Cleanup(func() {
+		importJobStore = prevStore
+		newImportBatch = prevBatch
+	})
+	return store
+}
+
+func newTestImportJob(id string) *ImportJob {
+	return &ImportJob{
+		ID:                id,
+		Type:              ImportClientes,
+		Mode:              ModeAdd,
+		Status:            StatusPending,
+		StartedAt:         time.Now(),
+		DuplicateHandling: "skip",
+		CommitMode:        CommitJob,
+		Errors:            []ImportError{},
+		cancel:            make(chan bool),
+	}
+}
+
+func csvBody(lines ...string) io.ReadCloser {
+	return io.NopCloser(strings.NewReader(strings.Join(lines, "\n") + "\n"))
+}
+
+// fakeImportBatch simula un tramo que inserta todas sus filas. En la validación
+// las filas con clave fakeMissingRef dan error referencial.
+type fakeImportBatch struct {
+	rows       []int
+	keys       map[int]interface{}
+	validated  bool
+	applied    bool
+	rolledBack bool
+	onAdd      func(rowNum int)
+}
+
+func (b *fakeImportBatch) Add(rowNum int, values []interface{}) error {
+	b.rows = append(b.rows, rowNum)
+	if b.keys == nil {
+		b.keys = make(map[int]interface{})
+	}
+	b.keys[rowNum] = values[0]
+	if b.onAdd != nil {
+		b.onAdd(rowNum)
+	}
+	return nil
+}
+
+func (b *fakeImportBatch) Apply() (importBatchResult, error) {
+	b.applied = true
+	return importBatchResult{Committed: true, Inserted: len(b.rows)}, nil
+}
+
+func (b *fakeImportBatch) Validate() (importBatchResult, error) {
+	b.validated = true
+	b.rolledBack = true
+	var result importBatchResult
+	for _, row := range b.rows {
+		if b.keys[row] == fakeMissingRef {
+			result.Rows = append(result.Rows, row)
+			result.Errors = append(result.Errors, ImportError{Row: row, Field: "id_account", Message: "Cliente (IdAccount) no existe"})
+		}
+	}
+	result.Failed = len(result.Rows)
+	return result, nil
+}
+
+func (b *fakeImportBatch) Rollback() { b.rolledBack = true }
+
+const fakeMissingRef = "00000000T"
+
+// useFakeImportBatches sustituye newImportBatch y devuelve los tramos abiertos
+func useFakeImportBatches(onAdd func(job *ImportJob, rowNum int)) *[]*fakeImportBatch {
+	var batches []*fakeImportBatch
+	newImportBatch = func(job *ImportJob, spec importSpec) (importBatch, error) {
+		b := &fakeImportBatch{}
+		if onAdd != nil {
+			b.onAdd = func(rowNum int) { onAdd(job, rowNum) }
+		}
+		batches = append(batches, b)
+		return b, nil
+	}
+	return &batches
+}
+
+func TestProcessImportPersistsLifecycle(t *testing.T) {
+	store := useMemoryImportStore(t)
+	batches := useFakeImportBatches(nil)
+
+	job := newTestImportJob("job-ok")
+	if err := store.Save(job); err != nil {
+		t.Fatal(err)
+	}
+	registerActiveImport(job)
+	processImport(job, csvBody("NIF;Nombre completo", "1;A", "2;B", "3;C"))
+
+	got, err := store.Get("job-ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != StatusCompleted {
+		t.Fatalf("status = %s, want completed", got.Status)
+	}
+	if got.CompletedAt == nil {
+		t.Fatal("completed_at no persistido")
+	}
+	if got.TotalRows != 3 || got.SuccessfulRows != 3 || got.FailedRows != 0 {
+		t.Fatalf("contadores inesperados: %+v", got)
+	}
+	if len(*batches) != 1 || !(*batches)[0].applied {
+		t.Fatalf("en modo job todo el archivo va en un único tramo: %+v", *batches)
+	}
+
+	want := []ImportStatus{StatusPending, StatusProcessing, StatusCompleted}
+	if h := store.history["job-ok"]; fmt.Sprint(h) != fmt.Sprint(want) {
+		t.Fatalf("transiciones = %v, want %v", h, want)
+	}
+	if getActiveImport("job-ok") != nil {
+		t.Fatal("el job sigue registrado como activo")
+	}
+}
+
+func TestProcessImportJobModeRollsBackOnBadRow(t *testing.T) {
+	store := useMemoryImportStore(t)
+	batches := useFakeImportBatches(nil)
+
+	job := newTestImportJob("job-bad")
+	store.Save(job)
+	processImport(job, csvBody("NIF;Total primas en cartera", "1;10", "2;abc", "3;30"))
+
+	got, _ := store.Get("job-bad")
+	if got.Status != StatusFailed {
+		t.Fatalf("status = %s, want failed", got.Status)
+	}
+	if got.SuccessfulRows != 0 || got.FailedRows != 1 || got.SkippedRows != 2 {
+		t.Fatalf("contadores inesperados: %+v", got)
+	}
+	if len(*batches) != 1 || (*batches)[0].applied || !(*batches)[0].rolledBack {
+		t.Fatal("el tramo debe revertirse sin aplicarse")
+	}
+	if len(got.Errors) == 0 || got.Errors[0].Row != 2 || got.Errors[0].Field != "total_primas_cartera" {
+		t.Fatalf("errores inesperados: %+v", got.Errors)
+	}
+}
+
+func TestProcessImportChunkModeCommitsGoodChunks(t *testing.T) {
+	store := useMemoryImportStore(t)
+	batches := useFakeImportBatches(nil)
+
+	job := newTestImportJob("job-chunk")
+	job.CommitMode = CommitChunk
+	job.ChunkSize = 2
+	store.Save(job)
+	// Tramos: [1,2] ok, [3,4] con la fila 4 sin clave, [5] ok
+	processImport(job, csvBody("NIF;Nombre completo", "1;A", "2;B", "3;C", ";D", "5;E"))
+
+	got, _ := store.Get("job-chunk")
+	if got.Status != StatusCompleted {
+		t.Fatalf("status = %s, want completed", got.Status)
+	}
+	if got.SuccessfulRows != 3 || got.FailedRows != 1 || got.SkippedRows != 1 {
+		t.Fatalf("contadores inesperados: %+v", got)
+	}
+	var applied, rolledBack int
+	for _, b := range *batches {
+		if b.applied {
+			applied++
+		}
+		if b.rolledBack {
+			rolledBack++
+		}
+	}
+	if applied != 2 || rolledBack != 1 {
+		t.Fatalf("tramos aplicados = %d, revertidos = %d", applied, rolledBack)
+	}
+}
+
+func TestProcessImportFailsOnUnreadableHeaders(t *testing.T) {
+	store := useMemoryImportStore(t)
+
+	job := newTestImportJob("job-empty")
+	store.Save(job)
+	processImport(job, io.NopCloser(strings.NewReader("")))
+
+	got, _ := store.Get("job-empty")
+	if got.Status != StatusFailed || got.CompletedAt == nil {
+		t.Fatalf("status = %s, completed_at = %v", got.Status, got.CompletedAt)
+	}
+	want := []ImportStatus{StatusPending, StatusProcessing, StatusFailed}
+	if h := store.history["job-empty"]; fmt.Sprint(h) != fmt.Sprint(want) {
+		t.Fatalf("transiciones = %v, want %v", h, want)
+	}
+}
+
+func TestProcessImportCancelled(t *testing.T) {
+	store := useMemoryImportStore(t)
+
+	job := newTestImportJob("job-cancel")
+	store.Save(job)
+	registerActiveImport(job)
+
+	batches := useFakeImportBatches(func(j *ImportJob, rowNum int) {
+		if rowNum == 1 {
+			j.requestCancel()
+		}
+	})
+	processImport(job, csvBody("NIF", "1", "2", "3"))
+
+	got, _ := store.Get("job-cancel")
+	if got.Status != StatusCancelled {
+		t.Fatalf("status = %s, want cancelled", got.Status)
+	}
+	if got.ProcessedRows != 1 {
+		t.Fatalf("processed_rows = %d, want 1", got.ProcessedRows)
+	}
+	if b := (*batches)[0]; b.applied || !b.rolledBack {
+		t.Fatal("al cancelar el tramo abierto debe revertirse")
+	}
+	if job.requestCancel() {
+		t.Fatal("un job cancelado no debe poder cancelarse otra vez")
+	}
+}
+
+// staleHeartbeat simula una instancia que dejó de dar señales
+func (s *memoryImportJobStore) staleHeartbeat(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old := time.Now().Add(-2 * importHeartbeatStale)
+	s.jobs[id].HeartbeatAt = &old
+}
+
+func TestRecoverInterruptedImports(t *testing.T) {
+	store := useMemoryImportStore(t)
+
+	// Estado que dejó una instancia al morir: sin heartbeat reciente
+	processing := newTestImportJob("job-processing")
+	processing.Status = StatusProcessing
+	processing.ProcessedRows = 120
+	processing.Instance = "srv-a:101"
+	pending := newTestImportJob("job-pending")
+	done := newTestImportJob("job-done")
+	done.Status = StatusCompleted
+	for _, j := range []*ImportJob{processing, pending, done} {
+		store.Save(j)
+		store.staleHeartbeat(j.ID)
+	}
+
+	// Un job vivo en este proceso no debe tocarse
+	alive := newTestImportJob("job-alive")
+	alive.Status = StatusProcessing
+	store.Save(alive)
+	store.staleHeartbeat(alive.ID)
+	registerActiveImport(alive)
+	defer unregisterActiveImport(alive.ID)
+
+	// Ni uno de otra instancia con heartbeat reciente
+	other := newTestImportJob("job-other-instance")
+	other.Status = StatusProcessing
+	other.Instance = "srv-b:202"
+	store.Save(other)
+
+	n, err := RecoverInterruptedImports()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("recuperados = %d, want 2", n)
+	}
+
+	for _, id := range []string{"job-processing", "job-pending"} {
+		got, _ := store.Get(id)
+		if got.Status != StatusInterrupted || got.CompletedAt == nil {
+			t.Fatalf("%s: status = %s, completed_at = %v", id, got.Status, got.CompletedAt)
+		}
+		if len(got.Errors) == 0 || !strings.Contains(got.Errors[len(got.Errors)-1].Message, "interrumpida") {
+			t.Fatalf("%s: falta el error de interrupción: %+v", id, got.Errors)
+		}
+	}
+	if got, _ := store.Get("job-processing"); got.ProcessedRows != 120 || !strings.Contains(got.Errors[0].Message, "srv-a:101") {
+		t.Fatalf("se perdió el progreso o la instancia: %d, %+v", got.ProcessedRows, got.Errors)
+	}
+	if got, _ := store.Get("job-done"); got.Status != StatusCompleted {
+		t.Fatalf("job terminado modificado: %s", got.Status)
+	}
+	for _, id := range []string{"job-alive", "job-other-instance"} {
+		if got, _ := store.Get(id); got.Status != StatusProcessing {
+			t.Fatalf("%s modificado: %s", id, got.Status)
+		}
+	}
+}
+
+func TestInterruptedImportIsNotOverwritten(t *testing.T) {
+	store := useMemoryImportStore(t)
+
+	// La instancia dueña estuvo sin dar señales y otra lo dio por interrumpido
+	job := newTestImportJob("job-slow")
+	job.Status = StatusProcessing
+	store.Save(job)
+	store.staleHeartbeat(job.ID)
+	if n, _ := RecoverInterruptedImports(); n != 1 {
+		t.Fatalf("recuperados = %d", n)
+	}
+
+	// Su siguiente Save no deshace el estado: el job se detiene
+	job.ProcessedRows = 500
+	persistImportJob(job)
+	if got, _ := store.Get(job.ID); got.Status != StatusInterrupted || got.ProcessedRows != 0 {
+		t.Fatalf("status = %s, processed_rows = %d", got.Status, got.ProcessedRows)
+	}
+	select {
+	case <-job.cancel:
+	default:
+		t.Fatal("el job debe detenerse")
+	}
+	job.finish(StatusCompleted)
+	if job.Status != StatusInterrupted {
+		t.Fatalf("status en memoria = %s", job.Status)
+	}
+	if got, _ := store.Get(job.ID); got.Status != StatusInterrupted || got.ProcessedRows != 500 {
+		t.Fatalf("status = %s, processed_rows = %d", got.Status, got.ProcessedRows)
+	}
+}
+
+func TestImportHandlersReadFromStore(t *testing.T) {
+	store := useMemoryImportStore(t)
+
+	// Job en proceso registrado por otra instancia (no está activo aquí)
+	orphan := newTestImportJob("job-orphan")
+	orphan.Status = StatusProcessing
+	store.Save(orphan)
+
+	app := fiber.New()
+	app.Get("/status/:id", GetImportStatus)
+	app.Post("/cancel/:id", CancelImport)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/status/job-orphan", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var status ImportJob
+	json.NewDecoder(resp.Body).Decode(&status)
+	if resp.StatusCode != 200 || status.Status != StatusProcessing {
+		t.Fatalf("GET status = %d %s", resp.StatusCode, status.Status)
+	}
+
+	resp, _ = app.Test(httptest.NewRequest("POST", "/cancel/job-orphan", nil))
+	if resp.StatusCode != 200 {
+		t.Fatalf("POST cancel = %d", resp.StatusCode)
+	}
+	if got, _ := store.Get("job-orphan"); got.Status != StatusCancelled {
+		t.Fatalf("status tras cancelar = %s", got.Status)
+	}
+
+	resp, _ = app.Test(httptest.NewRequest("POST", "/cancel/job-orphan", nil))
+	if resp.StatusCode != 400 {
+		t.Fatalf("cancelar dos veces = %d, want 400", resp.StatusCode)
+	}
+
+	resp, _ = app.Test(httptest.NewRequest("GET", "/status/no-existe", nil))
+	if resp.StatusCode != 404 {
+		t.Fatalf("GET status inexistente = %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestPostgresImportJobStoreSaveUpdatesSheet(t *testing.T) {
+	mock := useMockDB(t)
+	store := &postgresImportJobStore{}
+	job := newTestImportJob("job-xlsx")
+
+	// Primer Save sin hoja: se elige al leer el libro, después de crear el job
+	mock.ExpectExec(`INSERT INTO import_jobs`).WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := store.Save(job); err != nil {
+		t.Fatal(err)
+	}
+
+	job.Sheet = "Recibos"
+	job.Status = StatusProcessing
+	args := make([]driver.Value, 26)
+	for i := range args {
+		args[i] = sqlmock.AnyArg()
+	}
+	args[24] = "Recibos" // sheet
+	mock.ExpectExec(`ON CONFLICT \(id\) DO UPDATE SET .*sheet = EXCLUDED.sheet`).
+		WithArgs(args...).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := store.Save(job); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPostgresImportJobStoreSaveKeepsInterrupted(t *testing.T) {
+	mock := useMockDB(t)
+	job := newTestImportJob("job-interrupted")
+	job.Status = StatusCompleted
+
+	// El upsert condicional no toca la fila interrumpida por otra instancia
+	mock.ExpectExec(`heartbeat_at = EXCLUDED.heartbeat_at\s+WHERE import_jobs.status <> 'interrupted' OR EXCLUDED.status = 'interrupted'`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	if err := (&postgresImportJobStore{}).Save(job); !errors.Is(err, ErrImportJobInterrupted) {
+		t.Fatalf("err = %v, want ErrImportJobInterrupted", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,168 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+)
+
+// validateImport pasada de validación previa (validate_first / dry_run): cada fila
+// pasa por el mismo parseo que la importación, la comprobación de NIF y, en una
+// transacción que siempre se revierte, la clasificación y las comprobaciones
+// referenciales contra clientes/pólizas. No escribe nada.
+// Devuelve las filas rechazadas; ok = false si el job terminó (fallo o cancelación).
+func validateImport(job *ImportJob, r io.Reader) (rejected map[int]bool, ok bool) {
+	spec := importSpecs[job.Type]
+	rejected = make(map[int]bool)
+	var batch importBatch
+
+	err := scanImportRows(job, r, func(rowNum int, values []interface{}, rowErrors []ImportError) error {
+		if values != nil {
+			rowErrors = append(rowErrors, checkImportRow(spec, values, rowNum)...)
+		}
+		if len(rowErrors) > 0 {
+			rejected[rowNum] = true
+			job.addErrors(rowErrors...)
+			return nil
+		}
+
+		if batch == nil {
+			b, err := newImportBatch(job, spec)
+			if err != nil {
+				return fmt.Errorf("Error iniciando validación: %v", err)
+			}
+			batch = b
+		}
+		if err := batch.Add(rowNum, values); err != nil {
+			return fmt.Errorf("Error cargando fila %d: %v", rowNum, err)
+		}
+		return nil
+	})
+	if err != nil {
+		if batch != nil {
+			batch.Rollback()
+		}
+		if errors.Is(err, errImportCancelled) {
+			persistImportJob(job)
+			log.Printf("🛑 Import job %s cancelado durante la validación", job.ID)
+			return nil, false
+		}
+		job.finish(StatusFailed, ImportError{Row: 0, Message: err.Error()})
+		return nil, false
+	}
+
+	if batch != nil {
+		result, err := batch.Validate()
+		if errors.Is(err, ErrReplaceThreshold) {
+			job.addErrors(result.Errors...)
+			job.finish(StatusFailed, ImportError{Row: 0, Message: err.Error()})
+			return nil, false
+		}
+		if err != nil {
+			job.finish(StatusFailed, ImportError{Row: 0, Message: "Error en la validación: " + err.Error()})
+			return nil, false
+		}
+		for _, row := range result.Rows {
+			rejected[row] = true
+		}
+		job.addErrors(result.Errors...)
+
+		// En un dry run indica cuántos registros desactivaría el modo replace
+		job.mu.Lock()
+		job.DeactivatedRows = result.Deactivated
+		job.mu.Unlock()
+	}
+
+	job.mu.Lock()
+	job.FailedRows = len(rejected)
+	job.mu.Unlock()
+	persistImportJob(job)
+
+	return rejected, true
+}
+
+// checkImportRow comprobaciones de la validación previa que no dependen de la BD
+func checkImportRow(spec importSpec, values []interface{}, rowNum int) []ImportError {
+	var rowErrors []ImportError
+
+	if spec.NIFColumn != "" {
+		for i, f := range spec.Fields {
+			if f.Column != spec.NIFColumn {
+				continue
+			}
+			if nif, _ := values[i].(string); nif != "" && !validNIF(nif) {
+				rowErrors = append(rowErrors, ImportError{
+					Row:     rowNum,
+					Field:   f.Column,
+					Value:   nif,
+					Message: "NIF/NIE/CIF inválido (formato o dígito de control)",
+				})
+			}
+		}
+	}
+
+	return rowErrors
+}
+
+// nifLetters letras de control de DNI/NIE (posición = número módulo 23)
+const nifLetters = "TRWAGMYFPDXBNJZSQVHLCKE"
+
+// validNIF comprueba formato y dígito de control de un DNI, NIE o CIF
+func validNIF(s string) bool {
+	s = strings.ToUpper(strings.NewReplacer(" ", "", "-", "", ".", "").Replace(s))
+	if len(s) != 9 {
+		return false
+	}
+
+	switch first := s[0]; {
+	case first >= '0' && first <= '9':
+		return dniLetterOK(s[:8], s[8])
+	case first == 'X' || first == 'Y' || first == 'Z':
+		// NIE: X=0, Y=1, Z=2 y se calcula como un DNI
+		prefix := string(rune('0' + strings.IndexByte("XYZ", first)))
+		return dniLetterOK(prefix+s[1:8], s[8])
+	case strings.IndexByte("ABCDEFGHJNPQRSUVW", first) >= 0:
+		return validCIF(s)
+	}
+	return false
+}
+
+func dniLetterOK(digits string, letter byte) bool {
+	n := 0
+	for i := 0; i < len(digits); i++ {
+		if digits[i] < '0' || digits[i] > '9' {
+			return false
+		}
+		n = n*10 + int(digits[i]-'0')
+	}
+	return nifLetters[n%23] == letter
+}
+
+func validCIF(s string) bool {
+	sum := 0
+	for i := 1; i <= 7; i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+		d := int(s[i] - '0')
+		if i%2 == 1 {
+			// Posiciones impares: se dobla y se suman las cifras
+			d *= 2
+			d = d/10 + d%10
+		}
+		sum += d
+	}
+	control := (10 - sum%10) % 10
+	digit := byte('0' + control)
+	letter := "JABCDEFGHI"[control]
+
+	switch s[0] {
+	case 'P', 'Q', 'R', 'S', 'N', 'W':
+		return s[8] == letter
+	case 'A', 'B', 'E', 'H':
+		return s[8] == digit
+	}
+	return s[8] == digit || s[8] == letter
+}
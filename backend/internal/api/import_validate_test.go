@@ -0,0 +1,100 @@
+package api
+
+import "testing"
+
+func TestValidNIF(t *testing.T) {
+	for nif, want := range map[string]bool{
+		"12345678Z":  true,
+		"12345678-z": true,
+		"12345678A":  false,
+		"X1234567L":  true,
+		"X1234567A":  false,
+		"B12345674":  true,
+		"B1234567D":  false,
+		"P1234567D":  true,
+		"1234567Z":   false,
+		"ABCDEFGHI":  false,
+	} {
+		if got := validNIF(nif); got != want {
+			t.Errorf("validNIF(%q) = %v, want %v", nif, got, want)
+		}
+	}
+}
+
+func TestProcessImportDryRunNeverWrites(t *testing.T) {
+	store := useMemoryImportStore(t)
+	batches := useFakeImportBatches(nil)
+
+	job := newTestImportJob("job-dry")
+	job.ValidateFirst = true
+	job.DryRun = true
+	store.Save(job)
+	processImport(job, csvBody("NIF;Total primas en cartera",
+		"12345678Z;10",
+		"12345678A;20",  // letra de control incorrecta
+		"87654321X;abc", // importe no numérico
+		fakeMissingRef+";40",
+	))
+
+	got, _ := store.Get("job-dry")
+	if got.Status != StatusValidated {
+		t.Fatalf("status = %s, want validated", got.Status)
+	}
+	if got.TotalRows != 4 || got.FailedRows != 3 || got.SuccessfulRows != 0 {
+		t.Fatalf("contadores inesperados: %+v", got)
+	}
+	wantFields := map[int]string{2: "nif", 3: "total_primas_cartera", 4: "id_account"}
+	for _, e := range got.Errors {
+		if wantFields[e.Row] != e.Field {
+			t.Errorf("error inesperado: %+v", e)
+		}
+	}
+	for _, b := range *batches {
+		if b.applied {
+			t.Fatal("un dry run no debe aplicar ningún tramo")
+		}
+	}
+}
+
+func TestProcessImportValidateFirstBlocksOnErrors(t *testing.T) {
+	store := useMemoryImportStore(t)
+	batches := useFakeImportBatches(nil)
+
+	job := newTestImportJob("job-vf")
+	job.ValidateFirst = true
+	store.Save(job)
+	processImport(job, csvBody("NIF", "12345678Z", fakeMissingRef))
+
+	got, _ := store.Get("job-vf")
+	if got.Status != StatusFailed {
+		t.Fatalf("status = %s, want failed", got.Status)
+	}
+	if len(*batches) != 1 || !(*batches)[0].validated || (*batches)[0].applied {
+		t.Fatal("solo debe haberse abierto el tramo de validación")
+	}
+}
+
+func TestProcessImportValidateFirstAcceptErrors(t *testing.T) {
+	store := useMemoryImportStore(t)
+	batches := useFakeImportBatches(nil)
+
+	job := newTestImportJob("job-accept")
+	job.ValidateFirst = true
+	job.AcceptErrors = true
+	store.Save(job)
+	processImport(job, csvBody("NIF", "12345678Z", fakeMissingRef, "87654321X"))
+
+	got, _ := store.Get("job-accept")
+	if got.Status != StatusCompleted {
+		t.Fatalf("status = %s, want completed", got.Status)
+	}
+	if got.TotalRows != 3 || got.SuccessfulRows != 2 || got.FailedRows != 1 {
+		t.Fatalf("contadores inesperados: %+v", got)
+	}
+	if len(*batches) != 2 {
+		t.Fatalf("tramos = %d, want validación + escritura", len(*batches))
+	}
+	if w := (*batches)[1]; !w.applied || len(w.rows) != 2 || w.rows[0] != 1 || w.rows[1] != 3 {
+		t.Fatalf("el tramo de escritura debe excluir la fila rechazada: %+v", w.rows)
+	}
+}
@@ -0,0 +1,244 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// importRowReader fuente de filas de un import (csv.Reader o un libro Excel)
+type importRowReader interface {
+	Read() ([]string, error)
+}
+
+// maxHeaderScanRows filas del principio de la hoja en las que se busca el encabezado
+const maxHeaderScanRows = 20
+
+// isWorkbookFile indica si el archivo es un libro Excel por su extensión
+func isWorkbookFile(filename string) bool {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".xlsx", ".xlsm":
+		return true
+	}
+	return false
+}
+
+// rowReader abre el archivo como CSV o como libro Excel según el formato
+func (f importFormat) rowReader(r io.Reader, importType ImportType) (importRowReader, error) {
+	if f.Workbook {
+		return newXLSXRowReader(r, f.Sheet, f.knownHeaders(importType))
+	}
+	return f.csvReader(r), nil
+}
+
+// knownHeaders encabezados que se esperan para el tipo (o para todos si no se indica),
+// en minúsculas, para detectar la fila de encabezado en hojas con títulos o filas vacías
+func (f importFormat) knownHeaders(importType ImportType) map[string]bool {
+	known := make(map[string]bool)
+	for t, spec := range importSpecs {
+		if importType != "" && t != importType {
+			continue
+		}
+		for _, field := range spec.Fields {
+			known[field.Column] = true
+			for _, h := range field.Headers {
+				known[strings.ToLower(h)] = true
+			}
+		}
+	}
+	for source := range f.ColumnMap {
+		known[strings.ToLower(strings.TrimSpace(source))] = true
+	}
+	return known
+}
+
+// xlsxRowReader lee una hoja de un libro Excel fila a fila empezando por el encabezado.
+// Las celdas con formato de fecha se devuelven como YYYY-MM-DD y los números sin formato.
+type xlsxRowReader struct {
+	Sheets    []string // Hojas del libro
+	Sheet     string   // Hoja leída
+	HeaderRow int      // Fila del encabezado (1 = primera)
+	rows      [][]string
+	pos       int
+	dateCols  map[int]bool
+	date1904  bool
+}
+
+func newXLSXRowReader(r io.Reader, sheet string, known map[string]bool) (*xlsxRowReader, error) {
+	file, err := excelize.OpenReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("error abriendo libro Excel: %w", err)
+	}
+	defer file.Close()
+
+	x := &xlsxRowReader{Sheets: file.GetSheetList(), dateCols: make(map[int]bool)}
+	if x.Sheet, err = selectSheet(file, sheet); err != nil {
+		return nil, err
+	}
+	if props, err := file.GetWorkbookProps(); err == nil && props.Date1904 != nil {
+		x.date1904 = *props.Date1904
+	}
+
+	x.rows, err = file.GetRows(x.Sheet, excelize.Options{RawCellValue: true})
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo hoja %s: %w", x.Sheet, err)
+	}
+
+	x.pos = detectHeaderRow(x.rows, known)
+	x.HeaderRow = x.pos + 1
+	if x.pos < len(x.rows) {
+		x.detectDateColumns(file, len(x.rows[x.pos]))
+	}
+	return x, nil
+}
+
+// selectSheet elige la hoja por nombre o número (1 = primera); por defecto la activa
+func selectSheet(file *excelize.File, sheet string) (string, error) {
+	sheets := file.GetSheetList()
+	if len(sheets) == 0 {
+		return "", fmt.Errorf("el libro no tiene hojas")
+	}
+	if sheet == "" {
+		if name := file.GetSheetName(file.GetActiveSheetIndex()); name != "" {
+			return name, nil
+		}
+		return sheets[0], nil
+	}
+	for _, name := range sheets {
+		if strings.EqualFold(name, sheet) {
+			return name, nil
+		}
+	}
+	if n, err := strconv.Atoi(sheet); err == nil && n >= 1 && n <= len(sheets) {
+		return sheets[n-1], nil
+	}
+	return "", fmt.Errorf("hoja no encontrada: %s (hojas: %s)", sheet, strings.Join(sheets, ", "))
+}
+
+// detectHeaderRow devuelve el índice de la fila que más encabezados conocidos contiene
+// entre las primeras filas; si ninguna coincide, la primera fila no vacía
+func detectHeaderRow(rows [][]string, known map[string]bool) int {
+	best, bestMatches, firstNonEmpty := -1, 0, -1
+	for i := 0; i < len(rows) && i < maxHeaderScanRows; i++ {
+		matches, filled := 0, 0
+		for _, cell := range rows[i] {
+			cell = strings.ToLower(strings.TrimSpace(cell))
+			if cell == "" {
+				continue
+			}
+			filled++
+			if known[cell] {
+				matches++
+			}
+		}
+		if filled > 0 && firstNonEmpty < 0 {
+			firstNonEmpty = i
+		}
+		if matches > bestMatches {
+			best, bestMatches = i, matches
+		}
+	}
+	if best >= 0 {
+		return best
+	}
+	if firstNonEmpty >= 0 {
+		return firstNonEmpty
+	}
+	return 0
+}
+
+// detectDateColumns marca como fecha las columnas cuya primera celda con datos
+// (bajo el encabezado) tiene formato de fecha
+func (x *xlsxRowReader) detectDateColumns(file *excelize.File, cols int) {
+	styleIsDate := make(map[int]bool)
+	for col := 0; col < cols; col++ {
+		for row := x.pos + 1; row < len(x.rows) && row <= x.pos+maxHeaderScanRows; row++ {
+			if col >= len(x.rows[row]) || x.rows[row][col] == "" {
+				continue
+			}
+			cell, _ := excelize.CoordinatesToCellName(col+1, row+1)
+			styleID, err := file.GetCellStyle(x.Sheet, cell)
+			if err != nil {
+				break
+			}
+			isDate, ok := styleIsDate[styleID]
+			if !ok {
+				style, err := file.GetStyle(styleID)
+				isDate = err == nil && isDateStyle(style)
+				styleIsDate[styleID] = isDate
+			}
+			x.dateCols[col] = isDate
+			break
+		}
+	}
+}
+
+// isDateStyle indica si el formato de número del estilo es una fecha
+func isDateStyle(style *excelize.Style) bool {
+	if style.CustomNumFmt != nil {
+		return isDateFormatCode(*style.CustomNumFmt)
+	}
+	switch {
+	case style.NumFmt >= 14 && style.NumFmt <= 17, style.NumFmt == 22,
+		style.NumFmt >= 27 && style.NumFmt <= 36, style.NumFmt >= 50 && style.NumFmt <= 58:
+		return true
+	}
+	return false
+}
+
+// isDateFormatCode formato personalizado con día o año (ignora literales y colores)
+func isDateFormatCode(code string) bool {
+	var b strings.Builder
+	inQuote, inBracket := false, false
+	for _, r := range strings.ToLower(code) {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+		case inQuote:
+		case r == '[':
+			inBracket = true
+		case r == ']':
+			inBracket = false
+		case !inBracket:
+			b.WriteRune(r)
+		}
+	}
+	clean := b.String()
+	return strings.ContainsAny(clean, "dy")
+}
+
+// Read devuelve la siguiente fila (la primera es el encabezado)
+func (x *xlsxRowReader) Read() ([]string, error) {
+	if x.pos >= len(x.rows) {
+		return nil, io.EOF
+	}
+	row := x.rows[x.pos]
+	x.pos++
+
+	for col, isDate := range x.dateCols {
+		if !isDate || col >= len(row) || row[col] == "" {
+			continue
+		}
+		serial, err := strconv.ParseFloat(row[col], 64)
+		if err != nil {
+			continue
+		}
+		if t, err := excelize.ExcelDateToTime(serial, x.date1904); err == nil {
+			row[col] = t.Format("2006-01-02")
+		}
+	}
+	return row, nil
+}
+
+// withWorkbook lee el archivo como libro Excel si es .xlsx, de la hoja indicada
+func (f importFormat) withWorkbook(filename, sheet string) importFormat {
+	if isWorkbookFile(filename) {
+		f.Workbook = true
+		f.Sheet = strings.TrimSpace(sheet)
+	}
+	return f
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// testWorkbook libro con una hoja de portada y los recibos en la segunda hoja,
+// con título y fila vacía antes del encabezado y la fecha como celda de fecha
+func testWorkbook(t *testing.T) []byte {
+	t.Helper()
+	f := excelize.NewFile()
+	defer f.Close()
+
+	f.SetCellValue("Sheet1", "A1", "Exportación GCO")
+	if _, err := f.NewSheet("Recibos"); err != nil {
+		t.Fatal(err)
+	}
+	sheet := "Recibos"
+	f.SetCellValue(sheet, "A1", "Listado de recibos")
+	for i, h := range []string{"Nº recibo", "Nº póliza", "Prima total", "Fecha emisión", "Cliente"} {
+		cell, _ := excelize.CoordinatesToCellName(i+1, 3)
+		f.SetCellValue(sheet, cell, h)
+	}
+	f.SetCellValue(sheet, "A4", "R1")
+	f.SetCellValue(sheet, "B4", "P1")
+	f.SetCellValue(sheet, "C4", 1234.5)
+	f.SetCellValue(sheet, "D4", time.Date(2025, 12, 31, 0, 0, 0, 0, time.UTC))
+	f.SetCellValue(sheet, "E4", "Muñoz")
+
+	dateFmt := "dd/mm/yyyy"
+	style, err := f.NewStyle(&excelize.Style{CustomNumFmt: &dateFmt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.SetCellStyle(sheet, "D4", "D4", style)
+
+	var buf bytes.Buffer
+	if err := f.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestXLSXRowReader(t *testing.T) {
+	data := testWorkbook(t)
+	known := occidentFormat.knownHeaders(ImportRecibos)
+
+	// Por defecto la hoja activa (la portada): sin encabezado conocido
+	x, err := newXLSXRowReader(bytes.NewReader(data), "", known)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x.Sheet != "Sheet1" || len(x.Sheets) != 2 {
+		t.Fatalf("hoja = %s, hojas = %v", x.Sheet, x.Sheets)
+	}
+
+	if _, err := newXLSXRowReader(bytes.NewReader(data), "Ventas", known); err == nil {
+		t.Fatal("hoja inexistente aceptada")
+	}
+
+	// Por número: encabezado en la fila 3 y la fecha convertida
+	x, err = newXLSXRowReader(bytes.NewReader(data), "2", known)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x.Sheet != "Recibos" || x.HeaderRow != 3 {
+		t.Fatalf("hoja = %s, encabezado = %d", x.Sheet, x.HeaderRow)
+	}
+	headers, _ := x.Read()
+	if headers[0] != "Nº recibo" {
+		t.Fatalf("encabezados = %v", headers)
+	}
+	row, _ := x.Read()
+	if row[2] != "1234.5" || row[3] != "2025-12-31" {
+		t.Fatalf("fila = %v", row)
+	}
+	if _, err := x.Read(); err != io.EOF {
+		t.Fatalf("err = %v, want EOF", err)
+	}
+}
+
+func TestIsDateFormatCode(t *testing.T) {
+	for code, want := range map[string]bool{
+		"dd/mm/yyyy":          true,
+		"yyyy-mm-dd hh:mm":    true,
+		"#,##0.00 €":          false,
+		`[Red]#,##0;"días" 0`: false,
+		"0%":                  false,
+	} {
+		if got := isDateFormatCode(code); got != want {
+			t.Errorf("isDateFormatCode(%q) = %v, want %v", code, got, want)
+		}
+	}
+}
+
+func TestProcessImportXLSX(t *testing.T) {
+	store := useMemoryImportStore(t)
+
+	var added [][]interface{}
+	newImportBatch = func(job *ImportJob, spec importSpec) (importBatch, error) {
+		return &recordingBatch{added: &added}, nil
+	}
+
+	job := newTestImportJob("job-xlsx")
+	job.Type = ImportRecibos
+	job.format = occidentFormat.withWorkbook("Recibos.XLSX", "Recibos")
+	store.Save(job)
+	processImport(job, io.NopCloser(bytes.NewReader(testWorkbook(t))))
+
+	got, _ := store.Get("job-xlsx")
+	if got.Status != StatusCompleted || got.SuccessfulRows != 1 || got.Sheet != "Recibos" {
+		t.Fatalf("job = %+v", got)
+	}
+
+	values := map[string]interface{}{}
+	for i, f := range importSpecs[ImportRecibos].Fields {
+		values[f.Column] = added[0][i]
+	}
+	if values["numero_recibo"] != "R1" || values["prima_total"] != 1234.5 ||
+		values["fecha_emision"] != "2025-12-31" || values["nombre_cliente"] != "Muñoz" {
+		t.Fatalf("valores = %v", values)
+	}
+}
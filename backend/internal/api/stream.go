@@ -0,0 +1,162 @@
+package api
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+
+	"soriano-mediadores/internal/auth"
+	"soriano-mediadores/internal/bots"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// Eventos propios del endpoint; el resto son los bots.Evento del bot
+const (
+	eventoInicio = "inicio" // session_id y bot, antes de procesar
+	eventoFin    = "fin"    // Respuesta completa con la traza (la que queda en la conversación)
+	eventoError  = "error"
+)
+
+// websocketGUID constante del handshake (RFC 6455)
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// ChatStream chat por Server-Sent Events, con el bot de la ruta o el que
+// elige el enrutador: eventos "inicio" (bot y enrutado), "estado" y
+// "herramienta" mientras se ejecutan las consultas, "token" con cada
+// fragmento del texto ("reinicio" si el modelo se cortó a medias y los tokens
+// recibidos se sustituyen) y "fin" con la respuesta completa
+// POST /api/chat/stream y /api/chat/:bot/stream {"session_id", "mensaje", "bot"}
+// GET  con ?session_id=&mensaje= (EventSource)
+func ChatStream(c *fiber.Ctx) error {
+	var req peticionChat
+	if c.Method() == fiber.MethodGet {
+		req.Bot, req.SessionID, req.Mensaje = c.Query("bot"), c.Query("session_id"), c.Query("mensaje")
+	} else if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"success": false, "error": "JSON inválido"})
+	}
+	bot, enrutado, err := elegirBot(c, &req)
+	if bot == nil {
+		return err
+	}
+
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Connection", "keep-alive")
+	c.Set("X-Accel-Buffering", "no") // Sin buffer en nginx
+
+	// El writer se ejecuta después de devolver el handler: no usar c dentro
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		conectado := true
+		enviar := func(evento string, datos interface{}) {
+			if !conectado {
+				return
+			}
+			data, _ := json.Marshal(datos)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", evento, data)
+			if err := w.Flush(); err != nil {
+				// La respuesta se termina igualmente para guardarla en la conversación
+				log.Printf("⚠️  Chat %s: cliente desconectado del stream %s", bot.Info().ID, req.SessionID)
+				conectado = false
+			}
+		}
+
+		enviar(eventoInicio, inicioChat(req.SessionID, bot, enrutado))
+		respuesta, err := bot.ProcesarConsulta(req.SessionID, req.Mensaje, func(e bots.Evento) {
+			enviar(e.Tipo, e)
+		})
+		if err != nil {
+			enviar(eventoError, fiber.Map{"error": err.Error()})
+			return
+		}
+		enviar(eventoFin, respuestaChat(req.SessionID, bot, enrutado, respuesta))
+	})
+	return nil
+}
+
+func inicioChat(sessionID string, bot bots.Bot, enrutado *bots.Enrutado) fiber.Map {
+	m := fiber.Map{"session_id": sessionID, "bot": bot.Info().ID, "bot_nombre": bot.Info().Nombre}
+	if enrutado != nil {
+		m["enrutado"] = infoEnrutado(enrutado)
+	}
+	return m
+}
+
+// ChatWebSocket chat por WebSocket: cada mensaje de texto del cliente es
+// {"bot", "session_id", "mensaje"} (sin bot decide el enrutador) y el
+// servidor responde con los mismos eventos que ChatStream como objetos JSON
+// con su "tipo"
+// GET /api/chat/ws
+func ChatWebSocket(c *fiber.Ctx) error {
+	key := c.Get("Sec-WebSocket-Key")
+	if !strings.EqualFold(c.Get("Upgrade"), "websocket") || key == "" {
+		return c.Status(fiber.StatusUpgradeRequired).JSON(fiber.Map{"success": false, "error": "Se requiere una conexión WebSocket"})
+	}
+	// La sesión se lee antes del upgrade: c deja de ser válido después
+	session, _ := c.Locals("session").(*auth.Session)
+
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	c.Set("Upgrade", "websocket")
+	c.Set("Connection", "Upgrade")
+	c.Set("Sec-WebSocket-Accept", base64.StdEncoding.EncodeToString(sum[:]))
+	c.Status(fiber.StatusSwitchingProtocols)
+
+	c.Context().Hijack(func(conn net.Conn) {
+		conectado := true
+		enviar := func(datos interface{}) {
+			if !conectado {
+				return
+			}
+			data, _ := json.Marshal(datos)
+			if err := wsutil.WriteServerText(conn, data); err != nil {
+				conectado = false
+			}
+		}
+
+		for conectado {
+			data, op, err := wsutil.ReadClientData(conn)
+			if err != nil {
+				return
+			}
+			if op != ws.OpText {
+				continue
+			}
+			var req peticionChat
+			if err := json.Unmarshal(data, &req); err != nil || strings.TrimSpace(req.Mensaje) == "" {
+				enviar(fiber.Map{"tipo": eventoError, "error": "Se esperaba {\"bot\", \"session_id\", \"mensaje\"}"})
+				continue
+			}
+			if req.SessionID == "" {
+				req.SessionID = uuid.New().String()
+			}
+			bot, enrutado, _, err := seleccionarBot(session, &req)
+			if err != nil {
+				enviar(fiber.Map{"tipo": eventoError, "session_id": req.SessionID, "error": err.Error()})
+				continue
+			}
+
+			inicio := inicioChat(req.SessionID, bot, enrutado)
+			inicio["tipo"] = eventoInicio
+			enviar(inicio)
+			respuesta, err := bot.ProcesarConsulta(req.SessionID, req.Mensaje, func(e bots.Evento) {
+				enviar(e)
+			})
+			if err != nil {
+				enviar(fiber.Map{"tipo": eventoError, "session_id": req.SessionID, "error": err.Error()})
+				continue
+			}
+			fin := respuestaChat(req.SessionID, bot, enrutado, respuesta)
+			fin["tipo"] = eventoFin
+			enviar(fin)
+		}
+	})
+	return nil
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"soriano-mediadores/internal/ai"
+	"soriano-mediadores/internal/bots"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/gofiber/fiber/v2"
+)
+
+const respuestaPIAS = "El PIAS de Seguros Bilbao permite ahorrar hasta 8.000€ al año con ventajas fiscales."
+
+// useStreamBots bots con el agente sobre un stub; las conversaciones ya
+// tienen un turno para que no se use la caché de Redis
+func useStreamBots(t *testing.T, sesiones ...string) {
+	t.Helper()
+	InitBots()
+	ai.SetProvider("bot_agente", &ai.StubProvider{Reply: respuestaPIAS})
+	for _, s := range sesiones {
+		bots.Registrar(s, "bot_agente", bots.RolUsuario, "Hola")
+	}
+}
+
+func TestChatStreamSSE(t *testing.T) {
+	useStreamBots(t, "sse-1")
+	app := fiber.New()
+	app.Post("/api/chat/:bot/stream", ChatStream)
+
+	req := httptest.NewRequest("POST", "/api/chat/agente/stream", strings.NewReader(`{"session_id": "sse-1", "mensaje": "¿Qué es un PIAS?"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", resp.Header.Get("Content-Type"))
+	}
+	body, _ := io.ReadAll(resp.Body)
+
+	var tipos []string
+	var texto strings.Builder
+	var fin map[string]interface{}
+	for _, bloque := range strings.Split(strings.TrimSpace(string(body)), "\n\n") {
+		evento, data, _ := strings.Cut(bloque, "\n")
+		evento = strings.TrimPrefix(evento, "event: ")
+		data = strings.TrimPrefix(data, "data: ")
+		tipos = append(tipos, evento)
+		switch evento {
+		case bots.EventoToken:
+			var e bots.Evento
+			json.Unmarshal([]byte(data), &e)
+			texto.WriteString(e.Texto)
+		case eventoFin:
+			json.Unmarshal([]byte(data), &fin)
+		}
+	}
+	if tipos[0] != eventoInicio || tipos[len(tipos)-1] != eventoFin || len(tipos) < 4 {
+		t.Fatalf("eventos = %v", tipos)
+	}
+	if texto.String() != respuestaPIAS || fin["respuesta"] != respuestaPIAS || fin["session_id"] != "sse-1" {
+		t.Fatalf("texto = %q, fin = %v", texto.String(), fin)
+	}
+
+	// La respuesta completa queda en la conversación
+	_, turnos, err := bots.ObtenerConversacion("sse-1")
+	if err != nil || turnos[len(turnos)-1].Contenido != respuestaPIAS {
+		t.Fatalf("conversación: %+v, %v", turnos, err)
+	}
+
+	req = httptest.NewRequest("GET", "/api/chat/agente/stream?mensaje=", nil)
+	app.Get("/api/chat/:bot/stream", ChatStream)
+	if resp, _ := app.Test(req); resp.StatusCode != 400 {
+		t.Fatalf("sin mensaje: %d", resp.StatusCode)
+	}
+}
+
+func TestChatWebSocket(t *testing.T) {
+	useStreamBots(t, "ws-1")
+	app := fiber.New()
+	app.Get("/api/chat/ws", ChatWebSocket)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(ln)
+	defer app.Shutdown()
+
+	conn, _, _, err := ws.Dial(context.Background(), "ws://"+ln.Addr().String()+"/api/chat/ws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	leer := func() map[string]interface{} {
+		data, err := wsutil.ReadServerText(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var evento map[string]interface{}
+		json.Unmarshal(data, &evento)
+		return evento
+	}
+
+	wsutil.WriteClientText(conn, []byte(`{"bot": "desconocido", "mensaje": "hola"}`))
+	if e := leer(); e["tipo"] != eventoError {
+		t.Fatalf("bot desconocido: %v", e)
+	}
+
+	wsutil.WriteClientText(conn, []byte(`{"bot": "agente", "session_id": "ws-1", "mensaje": "¿Qué es un PIAS?"}`))
+	var texto strings.Builder
+	for {
+		e := leer()
+		if e["tipo"] == bots.EventoToken {
+			texto.WriteString(e["texto"].(string))
+		}
+		if e["tipo"] == eventoFin {
+			if e["respuesta"] != respuestaPIAS || texto.String() != respuestaPIAS {
+				t.Fatalf("fin = %v, texto = %q", e, texto.String())
+			}
+			break
+		}
+	}
+}
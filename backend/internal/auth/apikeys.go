@@ -0,0 +1,453 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"soriano-mediadores/internal/db"
+	"soriano-mediadores/internal/secretbox"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lib/pq"
+)
+
+// Claves de API para N8N y otros clientes sin navegador. La clave completa
+// (smk_<prefijo>_<secreto>) solo se muestra al crearla; se guarda su SHA-256.
+// Con require_signature además hay que firmar cada petición:
+//
+//	X-Timestamp: <unix segundos>
+//	X-Signature: sha256=<hex de HMAC-SHA256(secreto de firma, timestamp\nMÉTODO\nruta?query\ncuerpo)>
+
+const (
+	apiKeyPrefix = "smk_"
+	// signatureTolerance diferencia máxima entre X-Timestamp y el reloj del servidor
+	signatureTolerance = 5 * time.Minute
+	// touchAPIKeyInterval frecuencia máxima con la que se guarda last_used_at
+	touchAPIKeyInterval = time.Minute
+)
+
+// APIKeyScopes permisos que se pueden dar a una clave de API: grupos de
+// rutas pensados para integraciones (nunca administración)
+var APIKeyScopes = []Permission{
+	PermN8N, PermAnalyticsRead, PermStatsRead, PermClientesRead, PermScraperRead, PermScraperRun,
+}
+
+// APIKey clave de API de una integración
+type APIKey struct {
+	ID               int          `json:"id"`
+	Name             string       `json:"name"`
+	Prefix           string       `json:"prefix"`
+	Hash             string       `json:"-"`
+	Scopes           []Permission `json:"scopes"`
+	RequireSignature bool         `json:"require_signature"`
+	// SigningSecret secreto de firma cifrado con API_KEY_ENCRYPTION_KEY
+	SigningSecret string     `json:"-"`
+	CreatedBy     string     `json:"created_by"`
+	CreatedAt     time.Time  `json:"created_at"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt    *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP    string     `json:"last_used_ip,omitempty"`
+	RevokedAt     *time.Time `json:"revoked_at,omitempty"`
+}
+
+// active indica si la clave se puede usar
+func (k *APIKey) active(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+// APIKeyStore almacén de claves de API
+type APIKeyStore interface {
+	Create(k *APIKey) error
+	GetByPrefix(prefix string) (*APIKey, error)
+	List() ([]*APIKey, error)
+	Revoke(id int, at time.Time) error
+	Touch(id int, ip string, at time.Time) error
+}
+
+var (
+	// apiKeyStore claves en la tabla api_keys (reemplazable en tests)
+	apiKeyStore APIKeyStore = &postgresAPIKeyStore{}
+
+	// ErrAPIKeyNotFound no hay clave con ese ID o prefijo
+	ErrAPIKeyNotFound = errors.New("clave de API no encontrada")
+	// errInvalidAPIKey clave desconocida, revocada o caducada (sin más detalle)
+	errInvalidAPIKey = errors.New("clave de API inválida")
+
+	// seenSignatures firmas ya usadas dentro de la tolerancia (contra reenvíos)
+	seenSignatures   = make(map[string]time.Time)
+	seenSignaturesMu sync.Mutex
+)
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// apiKeyFromRequest clave de X-API-Key o Authorization: Bearer smk_...
+func apiKeyFromRequest(c *fiber.Ctx) string {
+	if key := c.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if bearer := strings.TrimPrefix(c.Get("Authorization"), "Bearer "); strings.HasPrefix(bearer, apiKeyPrefix) {
+		return bearer
+	}
+	return ""
+}
+
+// authenticateAPIKey valida la clave (y la firma si la exige) y devuelve una
+// sesión sin usuario con los permisos de la clave
+func authenticateAPIKey(c *fiber.Ctx, key string) (*Session, error) {
+	parts := strings.SplitN(strings.TrimPrefix(key, apiKeyPrefix), "_", 2)
+	if !strings.HasPrefix(key, apiKeyPrefix) || len(parts) != 2 {
+		return nil, errInvalidAPIKey
+	}
+	k, err := apiKeyStore.GetByPrefix(parts[0])
+	if err == ErrAPIKeyNotFound {
+		return nil, errInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if subtle.ConstantTimeCompare([]byte(hashAPIKey(key)), []byte(k.Hash)) != 1 || !k.active(now) {
+		return nil, errInvalidAPIKey
+	}
+
+	if k.RequireSignature {
+		if err := verifySignature(c, k, now); err != nil {
+			return nil, err
+		}
+	}
+
+	if k.LastUsedAt == nil || now.Sub(*k.LastUsedAt) > touchAPIKeyInterval {
+		if err := apiKeyStore.Touch(k.ID, c.IP(), now); err != nil {
+			log.Printf("⚠️  Error guardando el uso de la clave %s: %v", k.Prefix, err)
+		}
+	}
+
+	id := "apikey:" + strconv.Itoa(k.ID)
+	return &Session{
+		ID:         id,
+		UserInfo:   UserInfo{ID: id, DisplayName: k.Name},
+		CreatedAt:  k.CreatedAt,
+		LastSeenAt: now,
+		IP:         c.IP(),
+		APIKeyID:   k.ID,
+		Scopes:     k.Scopes,
+	}, nil
+}
+
+// verifySignature comprueba X-Timestamp y X-Signature y que la firma no se
+// haya usado ya
+func verifySignature(c *fiber.Ctx, k *APIKey, now time.Time) error {
+	ts, err := strconv.ParseInt(c.Get("X-Timestamp"), 10, 64)
+	if err != nil {
+		return errors.New("falta X-Timestamp")
+	}
+	if diff := now.Sub(time.Unix(ts, 0)); diff > signatureTolerance || diff < -signatureTolerance {
+		return errors.New("X-Timestamp fuera de plazo")
+	}
+
+	box, err := secretbox.FromEnv("API_KEY_ENCRYPTION_KEY")
+	if err != nil {
+		return fmt.Errorf("no se puede verificar la firma: %w", err)
+	}
+	secret, err := box.OpenString(k.SigningSecret)
+	if err != nil {
+		return fmt.Errorf("no se puede verificar la firma: %w", err)
+	}
+
+	signature := strings.TrimPrefix(c.Get("X-Signature"), "sha256=")
+	expected := SignRequest(secret, c.Get("X-Timestamp"), c.Method(), c.OriginalURL(), c.Body())
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return errors.New("firma inválida")
+	}
+	if !rememberSignature(signature, now) {
+		return errors.New("petición repetida")
+	}
+	return nil
+}
+
+// SignRequest firma de una petición (hex de HMAC-SHA256) para X-Signature
+func SignRequest(secret, timestamp, method, path string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "\n" + method + "\n" + path + "\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// rememberSignature registra la firma; false si ya se vio (en Redis si está
+// disponible, para que valga entre instancias)
+func rememberSignature(signature string, now time.Time) bool {
+	ttl := 2 * signatureTolerance
+	if _, ok := sessionStore.(*redisSessionStore); ok {
+		added, err := db.RedisClient.SetNX(context.Background(), "auth:signature:"+signature, 1, ttl).Result()
+		if err == nil {
+			return added
+		}
+		log.Printf("⚠️  Redis no disponible para firmas: %v", err)
+	}
+
+	seenSignaturesMu.Lock()
+	defer seenSignaturesMu.Unlock()
+	for sig, expiry := range seenSignatures {
+		if now.After(expiry) {
+			delete(seenSignatures, sig)
+		}
+	}
+	if _, seen := seenSignatures[signature]; seen {
+		return false
+	}
+	seenSignatures[signature] = now.Add(ttl)
+	return true
+}
+
+// postgresAPIKeyStore claves en la tabla api_keys
+type postgresAPIKeyStore struct{}
+
+var errNoDB = errors.New("PostgreSQL no inicializado")
+
+const apiKeyColumns = `
+	id, name, prefix, key_hash, scopes, require_signature, COALESCE(signing_secret, ''),
+	COALESCE(created_by, ''), created_at, expires_at, last_used_at, COALESCE(last_used_ip, ''), revoked_at
+`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAPIKey(row rowScanner) (*APIKey, error) {
+	var k APIKey
+	var scopesJSON []byte
+	var expiresAt, lastUsedAt, revokedAt sql.NullTime
+	err := row.Scan(&k.ID, &k.Name, &k.Prefix, &k.Hash, &scopesJSON, &k.RequireSignature, &k.SigningSecret,
+		&k.CreatedBy, &k.CreatedAt, &expiresAt, &lastUsedAt, &k.LastUsedIP, &revokedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(scopesJSON, &k.Scopes); err != nil {
+		return nil, fmt.Errorf("scopes corrupto en clave %d: %w", k.ID, err)
+	}
+	for _, t := range []struct {
+		src sql.NullTime
+		dst **time.Time
+	}{{expiresAt, &k.ExpiresAt}, {lastUsedAt, &k.LastUsedAt}, {revokedAt, &k.RevokedAt}} {
+		if t.src.Valid {
+			value := t.src.Time
+			*t.dst = &value
+		}
+	}
+	return &k, nil
+}
+
+func (s *postgresAPIKeyStore) Create(k *APIKey) error {
+	if db.PostgresDB == nil {
+		return errNoDB
+	}
+	scopesJSON, _ := json.Marshal(k.Scopes)
+	return db.PostgresDB.QueryRow(`
+		INSERT INTO api_keys (name, prefix, key_hash, scopes, require_signature, signing_secret, created_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7, $8)
+		RETURNING id, created_at
+	`, k.Name, k.Prefix, k.Hash, scopesJSON, k.RequireSignature, k.SigningSecret, k.CreatedBy, k.ExpiresAt).Scan(&k.ID, &k.CreatedAt)
+}
+
+func (s *postgresAPIKeyStore) GetByPrefix(prefix string) (*APIKey, error) {
+	if db.PostgresDB == nil {
+		return nil, errNoDB
+	}
+	k, err := scanAPIKey(db.PostgresDB.QueryRow("SELECT "+apiKeyColumns+" FROM api_keys WHERE prefix = $1", prefix))
+	if err == sql.ErrNoRows {
+		return nil, ErrAPIKeyNotFound
+	}
+	return k, err
+}
+
+func (s *postgresAPIKeyStore) List() ([]*APIKey, error) {
+	if db.PostgresDB == nil {
+		return nil, errNoDB
+	}
+	rows, err := db.PostgresDB.Query("SELECT " + apiKeyColumns + " FROM api_keys ORDER BY created_at DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+func (s *postgresAPIKeyStore) Revoke(id int, at time.Time) error {
+	if db.PostgresDB == nil {
+		return errNoDB
+	}
+	res, err := db.PostgresDB.Exec("UPDATE api_keys SET revoked_at = COALESCE(revoked_at, $2) WHERE id = $1", id, at)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+func (s *postgresAPIKeyStore) Touch(id int, ip string, at time.Time) error {
+	if db.PostgresDB == nil {
+		return errNoDB
+	}
+	_, err := db.PostgresDB.Exec("UPDATE api_keys SET last_used_at = $2, last_used_ip = $3 WHERE id = $1", id, at, ip)
+	return err
+}
+
+// CreateAPIKeyRequest datos para crear una clave
+type CreateAPIKeyRequest struct {
+	Name             string       `json:"name"`
+	Scopes           []Permission `json:"scopes"`
+	RequireSignature bool         `json:"require_signature"`
+	ExpiresInDays    int          `json:"expires_in_days"` // 0 = no caduca
+}
+
+// CreateAPIKeyHandler crea una clave; la clave y el secreto de firma solo se
+// devuelven en esta respuesta
+// POST /api/api-keys
+func CreateAPIKeyHandler(c *fiber.Ctx) error {
+	var req CreateAPIKeyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"success": false, "error": "JSON inválido"})
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		return c.Status(400).JSON(fiber.Map{"success": false, "error": "name es obligatorio (máximo 100 caracteres)"})
+	}
+	if len(req.Scopes) == 0 {
+		return c.Status(400).JSON(fiber.Map{"success": false, "error": "scopes es obligatorio", "allowed_scopes": APIKeyScopes})
+	}
+	for _, scope := range req.Scopes {
+		if !allowedScope(scope) {
+			return c.Status(400).JSON(fiber.Map{"success": false, "error": "scope no permitido: " + string(scope), "allowed_scopes": APIKeyScopes})
+		}
+	}
+	if req.ExpiresInDays < 0 {
+		return c.Status(400).JSON(fiber.Map{"success": false, "error": "expires_in_days no puede ser negativo"})
+	}
+
+	prefix, err := randomHex(4)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"success": false, "error": err.Error()})
+	}
+	secret, err := randomHex(24)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"success": false, "error": err.Error()})
+	}
+	key := apiKeyPrefix + prefix + "_" + secret
+
+	k := &APIKey{
+		Name:             req.Name,
+		Prefix:           prefix,
+		Hash:             hashAPIKey(key),
+		Scopes:           req.Scopes,
+		RequireSignature: req.RequireSignature,
+	}
+	if session, ok := c.Locals("session").(*Session); ok {
+		k.CreatedBy = session.UserInfo.Mail
+	}
+	if req.ExpiresInDays > 0 {
+		expires := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		k.ExpiresAt = &expires
+	}
+
+	var signingSecret string
+	if req.RequireSignature {
+		box, err := secretbox.FromEnv("API_KEY_ENCRYPTION_KEY")
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"success": false, "error": "Firma no disponible: " + err.Error()})
+		}
+		if signingSecret, err = randomHex(32); err != nil {
+			return c.Status(500).JSON(fiber.Map{"success": false, "error": err.Error()})
+		}
+		if k.SigningSecret, err = box.SealString(signingSecret); err != nil {
+			return c.Status(500).JSON(fiber.Map{"success": false, "error": err.Error()})
+		}
+	}
+
+	if err := apiKeyStore.Create(k); err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return c.Status(409).JSON(fiber.Map{"success": false, "error": "Prefijo de clave repetido, inténtalo de nuevo"})
+		}
+		return c.Status(500).JSON(fiber.Map{"success": false, "error": "Error creando clave: " + err.Error()})
+	}
+
+	log.Printf("🔑 Clave de API '%s' (%s) creada por %s - scopes: %v", k.Name, k.Prefix, k.CreatedBy, k.Scopes)
+
+	response := fiber.Map{"success": true, "api_key": k, "key": key}
+	if signingSecret != "" {
+		response["signing_secret"] = signingSecret
+	}
+	return c.Status(201).JSON(response)
+}
+
+func allowedScope(scope Permission) bool {
+	for _, s := range APIKeyScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ListAPIKeysHandler claves de API (sin secretos)
+// GET /api/api-keys
+func ListAPIKeysHandler(c *fiber.Ctx) error {
+	keys, err := apiKeyStore.List()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"success": false, "error": "Error obteniendo claves: " + err.Error()})
+	}
+	return c.JSON(fiber.Map{"success": true, "api_keys": keys, "total": len(keys), "allowed_scopes": APIKeyScopes})
+}
+
+// RevokeAPIKeyHandler revoca una clave (deja de aceptarse al momento)
+// DELETE /api/api-keys/:id
+func RevokeAPIKeyHandler(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"success": false, "error": "ID inválido"})
+	}
+	if err := apiKeyStore.Revoke(id, time.Now()); err != nil {
+		status := 500
+		if errors.Is(err, ErrAPIKeyNotFound) {
+			status = 404
+		}
+		return c.Status(status).JSON(fiber.Map{"success": false, "error": err.Error()})
+	}
+	log.Printf("🔑 Clave de API %d revocada", id)
+	return c.JSON(fiber.Map{"success": true})
+}
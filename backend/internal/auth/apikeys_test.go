@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// memoryAPIKeyStore claves en memoria para los tests
+type memoryAPIKeyStore struct {
+	mu   sync.Mutex
+	keys []*APIKey
+}
+
+func (m *memoryAPIKeyStore) Create(k *APIKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k.ID = len(m.keys) + 1
+	k.CreatedAt = time.Now()
+	copied := *k
+	m.keys = append(m.keys, &copied)
+	return nil
+}
+
+func (m *memoryAPIKeyStore) GetByPrefix(prefix string) (*APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, k := range m.keys {
+		if k.Prefix == prefix {
+			copied := *k
+			return &copied, nil
+		}
+	}
+	return nil, ErrAPIKeyNotFound
+}
+
+func (m *memoryAPIKeyStore) List() ([]*APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*APIKey(nil), m.keys...), nil
+}
+
+func (m *memoryAPIKeyStore) Revoke(id int, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if id < 1 || id > len(m.keys) {
+		return ErrAPIKeyNotFound
+	}
+	m.keys[id-1].RevokedAt = &at
+	return nil
+}
+
+func (m *memoryAPIKeyStore) Touch(id int, ip string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys[id-1].LastUsedAt, m.keys[id-1].LastUsedIP = &at, ip
+	return nil
+}
+
+func TestAPIKeys(t *testing.T) {
+	store := &memoryAPIKeyStore{}
+	prev := apiKeyStore
+	apiKeyStore = store
+	t.Cleanup(func() { apiKeyStore = prev })
+	t.Setenv("API_KEY_ENCRYPTION_KEY", "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+
+	app := fiber.New()
+	// Administrador con sesión para gestionar las claves
+	app.Use(func(c *fiber.Ctx) error {
+		if c.Get("X-Test-Admin") != "" {
+			c.Locals("session", &Session{UserInfo: UserInfo{Mail: "it@sorianomediadores.es"}, Roles: []Role{RoleAdmin}})
+			return c.Next()
+		}
+		return AuthMiddleware(c)
+	})
+	ok := func(c *fiber.Ctx) error { return c.SendString("ok") }
+	app.Post("/api/api-keys", RequirePermission(PermAPIKeysAdmin), CreateAPIKeyHandler)
+	app.Delete("/api/api-keys/:id", RequirePermission(PermAPIKeysAdmin), RevokeAPIKeyHandler)
+	app.Post("/api/n8n/webhook", RequirePermission(PermN8N), ok)
+	app.Get("/api/analytics/financial-kpis", RequirePermission(PermAnalyticsRead), ok)
+
+	do := func(method, url, body string, headers map[string]string) (int, map[string]interface{}) {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+	admin := map[string]string{"X-Test-Admin": "1"}
+
+	if code, _ := do("POST", "/api/api-keys", `{"name": "n8n", "scopes": ["apikeys:admin"]}`, admin); code != 400 {
+		t.Fatalf("scope de administración aceptado: %d", code)
+	}
+	code, body := do("POST", "/api/api-keys", `{"name": "n8n", "scopes": ["n8n:call"]}`, admin)
+	if code != 201 {
+		t.Fatalf("crear: %d %v", code, body)
+	}
+	key := body["key"].(string)
+	if store.keys[0].Hash == key || strings.Contains(store.keys[0].Hash, strings.Split(key, "_")[2]) {
+		t.Fatal("la clave se guardó en claro")
+	}
+
+	// La clave solo abre las rutas de sus scopes
+	if code, _ := do("POST", "/api/n8n/webhook", `{}`, map[string]string{"X-API-Key": key}); code != 200 {
+		t.Fatalf("n8n con clave: %d", code)
+	}
+	if code, _ := do("POST", "/api/n8n/webhook", `{}`, map[string]string{"Authorization": "Bearer " + key}); code != 200 {
+		t.Fatalf("n8n con Bearer: %d", code)
+	}
+	if code, _ := do("GET", "/api/analytics/financial-kpis", "", map[string]string{"X-API-Key": key}); code != 403 {
+		t.Fatalf("analytics fuera de scope: %d", code)
+	}
+	if code, _ := do("POST", "/api/n8n/webhook", `{}`, map[string]string{"X-API-Key": key + "x"}); code != 401 {
+		t.Fatalf("clave alterada: %d", code)
+	}
+	if store.keys[0].LastUsedAt == nil || store.keys[0].LastUsedIP == "" {
+		t.Fatal("no se registró el último uso")
+	}
+
+	if code, _ := do("DELETE", "/api/api-keys/1", "", admin); code != 200 {
+		t.Fatalf("revocar: %d", code)
+	}
+	if code, _ := do("POST", "/api/n8n/webhook", `{}`, map[string]string{"X-API-Key": key}); code != 401 {
+		t.Fatalf("clave revocada: %d", code)
+	}
+
+	// Clave con firma HMAC
+	code, body = do("POST", "/api/api-keys", `{"name": "n8n firmado", "scopes": ["n8n:call"], "require_signature": true, "expires_in_days": 30}`, admin)
+	if code != 201 || body["signing_secret"] == nil {
+		t.Fatalf("crear con firma: %d %v", code, body)
+	}
+	key, secret := body["key"].(string), body["signing_secret"].(string)
+	signed := func(ts time.Time, payload string) map[string]string {
+		stamp := strconv.FormatInt(ts.Unix(), 10)
+		return map[string]string{
+			"X-API-Key":   key,
+			"X-Timestamp": stamp,
+			"X-Signature": "sha256=" + SignRequest(secret, stamp, "POST", "/api/n8n/webhook", []byte(payload)),
+		}
+	}
+
+	headers := signed(time.Now(), `{"action": "ping"}`)
+	if code, body := do("POST", "/api/n8n/webhook", `{"action": "ping"}`, headers); code != 200 {
+		t.Fatalf("firma válida: %d %v", code, body)
+	}
+	if code, _ := do("POST", "/api/n8n/webhook", `{"action": "ping"}`, headers); code != 401 {
+		t.Fatalf("reenvío aceptado: %d", code)
+	}
+	if code, _ := do("POST", "/api/n8n/webhook", `{"action": "borrar"}`, signed(time.Now(), `{"action": "ping"}`)); code != 401 {
+		t.Fatalf("cuerpo manipulado aceptado: %d", code)
+	}
+	if code, _ := do("POST", "/api/n8n/webhook", `{}`, signed(time.Now().Add(-10*time.Minute), `{}`)); code != 401 {
+		t.Fatalf("timestamp antiguo aceptado: %d", code)
+	}
+	if code, _ := do("POST", "/api/n8n/webhook", `{}`, map[string]string{"X-API-Key": key}); code != 401 {
+		t.Fatalf("sin firma aceptado: %d", code)
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -40,21 +39,33 @@ type UserInfo struct {
 	JobTitle          string `json:"jobTitle,omitempty"`
 }
 
-// Session representa una sesión de usuario autenticado
+// Session representa una sesión de usuario autenticado (se guarda en
+// sessionStore con la clave ID, el hash de la cookie session_id)
 type Session struct {
+	ID          string
 	UserInfo    UserInfo
 	AccessToken string
-	ExpiresAt   time.Time
-	CreatedAt   time.Time
+	// RefreshToken cifrado con AUTH_SESSION_KEY ("" si no hay clave)
+	RefreshToken string
+	ExpiresAt    time.Time // Caducidad del access token
+	CreatedAt    time.Time
+	LastSeenAt   time.Time // Última petición (expiración deslizante)
+	UserAgent    string
+	IP           string
+	// Groups grupos de Entra ID al hacer login; Roles los roles que dan
+	Groups []Group
+	Roles  []Role
+	// APIKeyID clave de API de la petición (0 = usuario); sus permisos son Scopes
+	APIKeyID int          `json:",omitempty"`
+	Scopes   []Permission `json:",omitempty"`
 }
 
-var (
-	// Almacén de sesiones en memoria
-	sessions     = make(map[string]*Session)
-	sessionMutex sync.RWMutex
+// oauthScopes scopes pedidos a Microsoft (offline_access para el refresh token)
+const oauthScopes = "openid profile email offline_access User.Read"
 
-	// Almacén de estados OAuth (para prevenir CSRF)
-	oauthStates     = make(map[string]time.Time)
+var (
+	// Almacén de estados OAuth (CSRF, PKCE y nonce) si no hay Redis
+	oauthStates     = make(map[string]*oauthFlow)
 	oauthStateMutex sync.RWMutex
 
 	// Configuración
@@ -95,49 +106,27 @@ func InitAuth() {
 
 	log.Printf("🔐 Auth inicializado - Redirect URI: %s", redirectURI)
 
+	// Roles según los grupos de Entra ID
+	initRBAC()
+	// Almacén de sesiones (Redis) y cifrado del refresh token
+	initSessions()
+	// Validación del ID token y lista de acceso
+	initOIDC()
+
 	// Limpiar estados OAuth expirados periódicamente
 	go cleanupOAuthStates()
 	// Limpiar sesiones expiradas periódicamente
 	go cleanupExpiredSessions()
 }
 
-// generateState genera un estado aleatorio para OAuth
-func generateState() (string, error) {
-	b := make([]byte, 32)
-	if _, err := rand.Read(b); err != nil {
-		return "", err
-	}
-	state := base64.URLEncoding.EncodeToString(b)
-
-	oauthStateMutex.Lock()
-	oauthStates[state] = time.Now().Add(10 * time.Minute)
-	oauthStateMutex.Unlock()
-
-	return state, nil
-}
-
-// validateState valida el estado OAuth
-func validateState(state string) bool {
-	oauthStateMutex.Lock()
-	defer oauthStateMutex.Unlock()
-
-	expiry, exists := oauthStates[state]
-	if !exists {
-		return false
-	}
-
-	delete(oauthStates, state)
-	return time.Now().Before(expiry)
-}
-
 // cleanupOAuthStates limpia estados OAuth expirados
 func cleanupOAuthStates() {
 	ticker := time.NewTicker(5 * time.Minute)
 	for range ticker.C {
 		oauthStateMutex.Lock()
 		now := time.Now()
-		for state, expiry := range oauthStates {
-			if now.After(expiry) {
+		for state, flow := range oauthStates {
+			if now.After(flow.ExpiresAt) {
 				delete(oauthStates, state)
 			}
 		}
@@ -145,19 +134,13 @@ func cleanupOAuthStates() {
 	}
 }
 
-// cleanupExpiredSessions limpia sesiones expiradas
+// cleanupExpiredSessions limpia sesiones expiradas (en Redis caducan por TTL)
 func cleanupExpiredSessions() {
 	ticker := time.NewTicker(15 * time.Minute)
 	for range ticker.C {
-		sessionMutex.Lock()
-		now := time.Now()
-		for sessionID, session := range sessions {
-			if now.After(session.ExpiresAt) {
-				delete(sessions, sessionID)
-				log.Printf("🔐 Sesión expirada eliminada: %s", sessionID[:8])
-			}
+		if store, ok := sessionStore.(*memorySessionStore); ok {
+			store.cleanup()
 		}
-		sessionMutex.Unlock()
 	}
 }
 
@@ -170,24 +153,29 @@ func generateSessionID() (string, error) {
 	return base64.URLEncoding.EncodeToString(b), nil
 }
 
-// GetAuthURL devuelve la URL de autenticación de Microsoft
+// GetAuthURL devuelve la URL de autenticación de Microsoft (con PKCE S256
+// y nonce ligados al state)
 func GetAuthURL() (string, error) {
-	state, err := generateState()
+	state, flow, err := newOAuthFlow()
 	if err != nil {
 		return "", err
 	}
 
 	params := url.Values{
-		"client_id":     {clientID},
-		"response_type": {"code"},
-		"redirect_uri":  {redirectURI},
-		"response_mode": {"query"},
-		"scope":         {"openid profile email User.Read"},
-		"state":         {state},
+		"client_id":             {clientID},
+		"response_type":         {"code"},
+		"redirect_uri":          {redirectURI},
+		"response_mode":         {"query"},
+		"scope":                 {oauthScopes},
+		"state":                 {state},
+		"nonce":                 {flow.Nonce},
+		"code_challenge":        {flow.codeChallenge()},
+		"code_challenge_method": {"S256"},
 	}
 
 	authURL := fmt.Sprintf(
-		"https://login.microsoftonline.com/%s/oauth2/v2.0/authorize?%s",
+		"%s/%s/oauth2/v2.0/authorize?%s",
+		loginBaseURL,
 		tenantID,
 		params.Encode(),
 	)
@@ -196,16 +184,18 @@ func GetAuthURL() (string, error) {
 }
 
 // ExchangeCodeForToken intercambia el código de autorización por tokens
-func ExchangeCodeForToken(code string) (*TokenResponse, error) {
-	tokenURL := fmt.Sprintf("https://login.microsoftonline.com/%s/oauth2/v2.0/token", tenantID)
+// (codeVerifier es el code_verifier PKCE del login)
+func ExchangeCodeForToken(code, codeVerifier string) (*TokenResponse, error) {
+	tokenURL := fmt.Sprintf("%s/%s/oauth2/v2.0/token", loginBaseURL, tenantID)
 
 	data := url.Values{
 		"client_id":     {clientID},
 		"client_secret": {clientSecret},
 		"code":          {code},
+		"code_verifier": {codeVerifier},
 		"redirect_uri":  {redirectURI},
 		"grant_type":    {"authorization_code"},
-		"scope":         {"openid profile email User.Read"},
+		"scope":         {oauthScopes},
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -245,7 +235,7 @@ func GetUserInfo(accessToken string) (*UserInfo, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	req, err := http.NewRequestWithContext(ctx, "GET", "https://graph.microsoft.com/v1.0/me", nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", graphBaseURL+"/me", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -274,41 +264,73 @@ func GetUserInfo(accessToken string) (*UserInfo, error) {
 	return &userInfo, nil
 }
 
-// CreateSession crea una nueva sesión para el usuario
-func CreateSession(userInfo *UserInfo, accessToken string, expiresIn int) (string, error) {
+// CreateSession crea una nueva sesión para el usuario con los roles de sus
+// grupos; devuelve el valor de la cookie session_id
+func CreateSession(userInfo *UserInfo, groups []Group, tokens *TokenResponse, userAgent, ip string) (string, error) {
 	sessionID, err := generateSessionID()
 	if err != nil {
 		return "", err
 	}
 
+	now := time.Now()
 	session := &Session{
-		UserInfo:    *userInfo,
-		AccessToken: accessToken,
-		ExpiresAt:   time.Now().Add(time.Duration(expiresIn) * time.Second),
-		CreatedAt:   time.Now(),
+		ID:         sessionKey(sessionID),
+		UserInfo:   *userInfo,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		UserAgent:  userAgent,
+		IP:         ip,
+		Groups:     groups,
+		Roles:      RolesForGroups(groups),
+	}
+	if err := session.setTokens(tokens, now); err != nil {
+		return "", err
 	}
 
-	sessionMutex.Lock()
-	sessions[sessionID] = session
-	sessionMutex.Unlock()
+	if err := sessionStore.Save(session, session.ttl(now)); err != nil {
+		return "", fmt.Errorf("error guardando sesión: %w", err)
+	}
 
-	log.Printf("🔐 Nueva sesión creada para: %s (%s)", userInfo.DisplayName, userInfo.Mail)
+	log.Printf("🔐 Nueva sesión creada para: %s (%s) - roles: %v", userInfo.DisplayName, userInfo.Mail, session.Roles)
 
 	return sessionID, nil
 }
 
-// GetSession obtiene una sesión por su ID
+// GetSession obtiene la sesión de una cookie. Cada petición desliza la
+// expiración por inactividad, y el access token se renueva con el refresh
+// token antes de que caduque.
 func GetSession(sessionID string) (*Session, error) {
-	sessionMutex.RLock()
-	defer sessionMutex.RUnlock()
+	session, err := sessionStore.Get(sessionKey(sessionID))
+	if err != nil {
+		return nil, err
+	}
 
-	session, exists := sessions[sessionID]
-	if !exists {
-		return nil, errors.New("sesión no encontrada")
+	now := time.Now()
+	if session.expired(now) {
+		sessionStore.Delete(session.ID)
+		return nil, ErrSessionExpired
 	}
 
-	if time.Now().After(session.ExpiresAt) {
-		return nil, errors.New("sesión expirada")
+	changed := false
+	if now.Add(refreshMargin).After(session.ExpiresAt) {
+		if err := session.refresh(now); err != nil {
+			if now.After(session.ExpiresAt) {
+				// Sin token válido no hay forma de seguir: nuevo login
+				sessionStore.Delete(session.ID)
+				return nil, ErrSessionExpired
+			}
+			log.Printf("⚠️  No se pudo renovar el token de %s: %v", session.UserInfo.Mail, err)
+		} else {
+			log.Printf("🔐 Token renovado para %s", session.UserInfo.Mail)
+			changed = true
+		}
+	}
+
+	if changed || now.Sub(session.LastSeenAt) > touchInterval {
+		session.LastSeenAt = now
+		if err := sessionStore.Save(session, session.ttl(now)); err != nil {
+			log.Printf("⚠️  Error actualizando sesión de %s: %v", session.UserInfo.Mail, err)
+		}
 	}
 
 	return session, nil
@@ -316,14 +338,9 @@ func GetSession(sessionID string) (*Session, error) {
 
 // DeleteSession elimina una sesión
 func DeleteSession(sessionID string) {
-	sessionMutex.Lock()
-	defer sessionMutex.Unlock()
-	delete(sessions, sessionID)
-}
-
-// ValidateStateHandler valida el estado OAuth en el callback
-func ValidateStateHandler(state string) bool {
-	return validateState(state)
+	if err := sessionStore.Delete(sessionKey(sessionID)); err != nil {
+		log.Printf("⚠️  Error eliminando sesión: %v", err)
+	}
 }
 
 // ========== HANDLERS HTTP ==========
@@ -351,8 +368,8 @@ func CallbackHandler(c *fiber.Ctx) error {
 	}
 
 	// Validar estado (prevenir CSRF)
-	state := c.Query("state")
-	if !ValidateStateHandler(state) {
+	flow, ok := consumeOAuthFlow(c.Query("state"))
+	if !ok {
 		log.Printf("❌ Estado OAuth inválido")
 		return c.Redirect("/login?error=invalid_state", 302)
 	}
@@ -364,21 +381,45 @@ func CallbackHandler(c *fiber.Ctx) error {
 	}
 
 	// Intercambiar código por token
-	tokenResp, err := ExchangeCodeForToken(code)
+	tokenResp, err := ExchangeCodeForToken(code, flow.Verifier)
 	if err != nil {
 		log.Printf("❌ Error intercambiando código: %v", err)
 		return c.Redirect("/login?error=token_exchange_failed", 302)
 	}
 
+	// Verificar el ID token (firma, emisor, audiencia, tenant y nonce)
+	claims, err := ValidateIDToken(tokenResp.IDToken, flow.Nonce)
+	if err != nil {
+		log.Printf("🚫 %v", err)
+		return c.Redirect("/login?error=invalid_id_token", 302)
+	}
+
 	// Obtener información del usuario
 	userInfo, err := GetUserInfo(tokenResp.AccessToken)
 	if err != nil {
 		log.Printf("❌ Error obteniendo usuario: %v", err)
 		return c.Redirect("/login?error=user_info_failed", 302)
 	}
+	// /me debe ser el mismo usuario que firmó Microsoft en el ID token
+	if userInfo.ID != claims.ObjectID {
+		log.Printf("🚫 El usuario de Graph (%s) no coincide con el ID token (%s)", userInfo.ID, claims.ObjectID)
+		return c.Redirect("/login?error=invalid_id_token", 302)
+	}
+
+	// Lista de acceso de usuarios y dominios
+	if !userAllowed(claims.PreferredUsername, claims.Email, userInfo.Mail, userInfo.UserPrincipalName) {
+		log.Printf("🚫 Usuario fuera de la lista de acceso: %s", claims.PreferredUsername)
+		return c.Redirect("/login?error=not_allowed", 302)
+	}
+
+	// Grupos de Entra ID para los roles (sin ellos, solo el rol por defecto)
+	groups, err := GetUserGroups(tokenResp.AccessToken)
+	if err != nil {
+		log.Printf("⚠️  Error obteniendo grupos de %s: %v", userInfo.Mail, err)
+	}
 
 	// Crear sesión
-	sessionID, err := CreateSession(userInfo, tokenResp.AccessToken, tokenResp.ExpiresIn)
+	sessionID, err := CreateSession(userInfo, groups, tokenResp, c.Get("User-Agent"), c.IP())
 	if err != nil {
 		log.Printf("❌ Error creando sesión: %v", err)
 		return c.Redirect("/login?error=session_failed", 302)
@@ -388,7 +429,7 @@ func CallbackHandler(c *fiber.Ctx) error {
 	c.Cookie(&fiber.Cookie{
 		Name:     "session_id",
 		Value:    sessionID,
-		Expires:  time.Now().Add(time.Duration(tokenResp.ExpiresIn) * time.Second),
+		Expires:  time.Now().Add(sessionMaxAge),
 		HTTPOnly: true,
 		Secure:   strings.HasPrefix(baseURL, "https"),
 		SameSite: "Lax",
@@ -406,7 +447,7 @@ func LogoutHandler(c *fiber.Ctx) error {
 	sessionID := c.Cookies("session_id")
 	if sessionID != "" {
 		DeleteSession(sessionID)
-		log.Printf("🔓 Sesión cerrada: %s", sessionID)
+		log.Printf("🔓 Sesión cerrada: %s", sessionKey(sessionID)[:8])
 	}
 
 	// Eliminar cookie
@@ -456,10 +497,16 @@ func MeHandler(c *fiber.Ctx) error {
 		})
 	}
 
+	roles := session.Roles
+	if roles == nil {
+		roles = []Role{}
+	}
 	return c.JSON(fiber.Map{
 		"authenticated": true,
 		"user":          session.UserInfo,
 		"expiresAt":     session.ExpiresAt,
+		"roles":         roles,
+		"permissions":   session.Permissions(),
 	})
 }
 
@@ -487,6 +534,21 @@ func AuthMiddleware(c *fiber.Ctx) error {
 		return c.Next()
 	}
 
+	// Clientes sin navegador (N8N): clave de API en lugar de cookie
+	if key := apiKeyFromRequest(c); key != "" {
+		session, err := authenticateAPIKey(c, key)
+		if err != nil {
+			log.Printf("🚫 Clave de API rechazada para %s %s: %v", c.Method(), path, err)
+			return c.Status(401).JSON(fiber.Map{
+				"error":         err.Error(),
+				"authenticated": false,
+			})
+		}
+		c.Locals("user", session.UserInfo)
+		c.Locals("session", session)
+		return c.Next()
+	}
+
 	// Verificar sesión
 	sessionID := c.Cookies("session_id")
 	if sessionID == "" {
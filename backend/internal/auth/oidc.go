@@ -0,0 +1,332 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// issuerBaseURL emisor de los ID tokens de Entra ID (v2.0)
+	issuerBaseURL = "https://login.microsoftonline.com"
+	// oauthFlowTTL tiempo máximo entre el login y el callback
+	oauthFlowTTL = 10 * time.Minute
+	// jwksTTL las claves de firma se vuelven a descargar tras este tiempo
+	jwksTTL = 24 * time.Hour
+	// jwksMinRefresh evita descargar el JWKS en bucle con kids desconocidos
+	jwksMinRefresh = 5 * time.Minute
+	// clockSkew margen para exp/iat/nbf
+	clockSkew = 2 * time.Minute
+)
+
+// oauthFlow datos de un login en curso, ligados al parámetro state: el
+// code_verifier de PKCE y el nonce que debe traer el ID token
+type oauthFlow struct {
+	Verifier  string    `json:"verifier"`
+	Nonce     string    `json:"nonce"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// codeChallenge code_challenge S256 de PKCE
+func (f *oauthFlow) codeChallenge() string {
+	sum := sha256.Sum256([]byte(f.Verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+var (
+	// allowedDomains y allowedUsers lista de acceso (AUTH_ALLOWED_DOMAINS,
+	// AUTH_ALLOWED_USERS); vacías = cualquier usuario del tenant
+	allowedDomains []string
+	allowedUsers   []string
+
+	jwks = &jwksCache{}
+)
+
+// initOIDC lee la lista de acceso y el JWKS local (AUTH_JWKS_FILE)
+func initOIDC() {
+	allowedDomains = splitLower(os.Getenv("AUTH_ALLOWED_DOMAINS"))
+	allowedUsers = splitLower(os.Getenv("AUTH_ALLOWED_USERS"))
+	jwks = &jwksCache{file: os.Getenv("AUTH_JWKS_FILE")}
+	if jwks.file != "" {
+		log.Printf("⚠️  ID tokens verificados con las claves locales de %s", jwks.file)
+	}
+	if tenantID == "" || tenantID == "common" || tenantID == "organizations" {
+		log.Printf("⚠️  MS_TENANT_ID debe ser el ID del tenant para validar el emisor (es %q)", tenantID)
+	}
+}
+
+func splitLower(value string) []string {
+	var out []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(strings.ToLower(item)); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// newOAuthFlow crea state, code_verifier y nonce para un login y los guarda
+// (en Redis si las sesiones están en Redis, para que el callback pueda
+// llegar a otra instancia)
+func newOAuthFlow() (string, *oauthFlow, error) {
+	state, err := generateSessionID()
+	if err != nil {
+		return "", nil, err
+	}
+	verifier, err := generateSessionID()
+	if err != nil {
+		return "", nil, err
+	}
+	nonce, err := generateSessionID()
+	if err != nil {
+		return "", nil, err
+	}
+	flow := &oauthFlow{
+		Verifier:  strings.TrimRight(verifier, "="),
+		Nonce:     nonce,
+		ExpiresAt: time.Now().Add(oauthFlowTTL),
+	}
+
+	if store, ok := sessionStore.(*redisSessionStore); ok {
+		data, _ := json.Marshal(flow)
+		if err := store.client.Set(context.Background(), "auth:oauth_state:"+state, data, oauthFlowTTL).Err(); err != nil {
+			return "", nil, err
+		}
+		return state, flow, nil
+	}
+
+	oauthStateMutex.Lock()
+	oauthStates[state] = flow
+	oauthStateMutex.Unlock()
+	return state, flow, nil
+}
+
+// consumeOAuthFlow recupera (una sola vez) el login en curso de un state
+func consumeOAuthFlow(state string) (*oauthFlow, bool) {
+	if state == "" {
+		return nil, false
+	}
+	if store, ok := sessionStore.(*redisSessionStore); ok {
+		data, err := store.client.GetDel(context.Background(), "auth:oauth_state:"+state).Bytes()
+		if err != nil {
+			if err != redis.Nil {
+				log.Printf("⚠️  Error leyendo estado OAuth: %v", err)
+			}
+			return nil, false
+		}
+		var flow oauthFlow
+		if json.Unmarshal(data, &flow) != nil {
+			return nil, false
+		}
+		return &flow, time.Now().Before(flow.ExpiresAt)
+	}
+
+	oauthStateMutex.Lock()
+	defer oauthStateMutex.Unlock()
+	flow, exists := oauthStates[state]
+	if !exists {
+		return nil, false
+	}
+	delete(oauthStates, state)
+	return flow, time.Now().Before(flow.ExpiresAt)
+}
+
+// IDTokenClaims claims del ID token de Entra ID que usa la aplicación
+type IDTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce             string `json:"nonce"`
+	TenantID          string `json:"tid"`
+	ObjectID          string `json:"oid"`
+	PreferredUsername string `json:"preferred_username"`
+	Email             string `json:"email"`
+	Name              string `json:"name"`
+}
+
+// ValidateIDToken verifica la firma del ID token con el JWKS del tenant y
+// comprueba emisor, audiencia, tenant, caducidad y nonce
+func ValidateIDToken(raw, nonce string) (*IDTokenClaims, error) {
+	if raw == "" {
+		return nil, errors.New("la respuesta no trae id_token")
+	}
+	claims := &IDTokenClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return jwks.key(kid)
+	},
+		jwt.WithValidMethods([]string{"RS256"}),
+		jwt.WithIssuer(fmt.Sprintf("%s/%s/v2.0", issuerBaseURL, tenantID)),
+		jwt.WithAudience(clientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(clockSkew),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("ID token inválido: %w", err)
+	}
+	if claims.TenantID != tenantID {
+		return nil, fmt.Errorf("ID token de otro tenant (%s)", claims.TenantID)
+	}
+	if claims.Nonce == "" || claims.Nonce != nonce {
+		return nil, errors.New("el nonce del ID token no coincide")
+	}
+	if claims.ObjectID == "" {
+		return nil, errors.New("el ID token no identifica al usuario (oid)")
+	}
+	return claims, nil
+}
+
+// userAllowed aplica la lista de acceso a las direcciones del usuario
+func userAllowed(addresses ...string) bool {
+	if len(allowedDomains) == 0 && len(allowedUsers) == 0 {
+		return true
+	}
+	for _, addr := range addresses {
+		addr = strings.ToLower(strings.TrimSpace(addr))
+		if addr == "" {
+			continue
+		}
+		for _, user := range allowedUsers {
+			if addr == user {
+				return true
+			}
+		}
+		if at := strings.LastIndex(addr, "@"); at >= 0 {
+			for _, domain := range allowedDomains {
+				if addr[at+1:] == domain {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// jwksCache claves públicas de firma del tenant por kid
+type jwksCache struct {
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+	file      string // JWKS local (tests, entornos sin salida a internet)
+}
+
+// key clave del kid; descarga el JWKS si caducó o si el kid es nuevo
+// (Microsoft rota las claves)
+func (j *jwksCache) key(kid string) (*rsa.PublicKey, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	key, found := j.keys[kid]
+	age := time.Since(j.fetchedAt)
+	if found && age < jwksTTL {
+		return key, nil
+	}
+	if !found && j.keys != nil && age < jwksMinRefresh {
+		return nil, fmt.Errorf("clave de firma desconocida (kid %q)", kid)
+	}
+
+	keys, err := j.load()
+	if err != nil {
+		if found {
+			log.Printf("⚠️  Error renovando JWKS, se usa el anterior: %v", err)
+			return key, nil
+		}
+		return nil, err
+	}
+	j.keys, j.fetchedAt = keys, time.Now()
+
+	if key, found = keys[kid]; !found {
+		return nil, fmt.Errorf("clave de firma desconocida (kid %q)", kid)
+	}
+	return key, nil
+}
+
+// load lee el JWKS del archivo local o del endpoint del tenant
+func (j *jwksCache) load() (map[string]*rsa.PublicKey, error) {
+	var data []byte
+	var err error
+	if j.file != "" {
+		data, err = os.ReadFile(j.file)
+	} else {
+		data, err = fetchJWKS(fmt.Sprintf("%s/%s/discovery/v2.0/keys", loginBaseURL, tenantID))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo JWKS: %w", err)
+	}
+	return parseJWKS(data)
+}
+
+func fetchJWKS(url string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d: %s", resp.StatusCode, string(body))
+	}
+	return body, nil
+}
+
+// parseJWKS claves RSA de un JWKS ({"keys": [{"kid", "kty", "n", "e"}]})
+func parseJWKS(data []byte) (map[string]*rsa.PublicKey, error) {
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("JWKS inválido: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("clave %s: módulo inválido", k.Kid)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("clave %s: exponente inválido", k.Kid)
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("el JWKS no tiene claves RSA de firma")
+	}
+	return keys, nil
+}
@@ -0,0 +1,217 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testTenant = "9b1c3f4e-0000-4000-8000-5f0e7a2d1c11"
+
+// useTestOIDC configura cliente, tenant y un JWKS local con una clave RSA
+// generada para el test
+func useTestOIDC(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := map[string]interface{}{"keys": []map[string]string{{
+		"kid": "test-kid",
+		"kty": "RSA",
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}}
+	data, _ := json.Marshal(set)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	prevClient, prevTenant, prevJWKS := clientID, tenantID, jwks
+	prevDomains, prevUsers := allowedDomains, allowedUsers
+	clientID, tenantID = "app-client-id", testTenant
+	jwks = &jwksCache{file: path}
+	allowedDomains, allowedUsers = nil, nil
+	t.Cleanup(func() {
+		clientID, tenantID, jwks = prevClient, prevTenant, prevJWKS
+		allowedDomains, allowedUsers = prevDomains, prevUsers
+	})
+	return key
+}
+
+func testIDToken(t *testing.T, key *rsa.PrivateKey, edit func(*IDTokenClaims, *jwt.Token)) string {
+	t.Helper()
+	claims := &IDTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    fmt.Sprintf("https://login.microsoftonline.com/%s/v2.0", testTenant),
+			Audience:  jwt.ClaimStrings{"app-client-id"},
+			Subject:   "sub-ana",
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+		Nonce:             "nonce-1",
+		TenantID:          testTenant,
+		ObjectID:          "u-ana",
+		PreferredUsername: "ana@sorianomediadores.es",
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "test-kid"
+	if edit != nil {
+		edit(claims, token)
+	}
+	if token.Method == jwt.SigningMethodHS256 {
+		signed, _ := token.SignedString([]byte("secreto"))
+		return signed
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestValidateIDToken(t *testing.T) {
+	key := useTestOIDC(t)
+
+	claims, err := ValidateIDToken(testIDToken(t, key, nil), "nonce-1")
+	if err != nil || claims.ObjectID != "u-ana" {
+		t.Fatalf("token válido: %+v, %v", claims, err)
+	}
+
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+	cases := map[string]string{
+		"otra audiencia":  testIDToken(t, key, func(c *IDTokenClaims, _ *jwt.Token) { c.Audience = jwt.ClaimStrings{"otra-app"} }),
+		"otro emisor":     testIDToken(t, key, func(c *IDTokenClaims, _ *jwt.Token) { c.Issuer = "https://login.microsoftonline.com/common/v2.0" }),
+		"otro tenant":     testIDToken(t, key, func(c *IDTokenClaims, _ *jwt.Token) { c.TenantID = "f00dcafe-0000-4000-8000-000000000000" }),
+		"otro nonce":      testIDToken(t, key, func(c *IDTokenClaims, _ *jwt.Token) { c.Nonce = "nonce-2" }),
+		"caducado":        testIDToken(t, key, func(c *IDTokenClaims, _ *jwt.Token) { c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Hour)) }),
+		"sin oid":         testIDToken(t, key, func(c *IDTokenClaims, _ *jwt.Token) { c.ObjectID = "" }),
+		"kid desconocido": testIDToken(t, key, func(_ *IDTokenClaims, tok *jwt.Token) { tok.Header["kid"] = "rotada" }),
+		"HS256": testIDToken(t, key, func(_ *IDTokenClaims, tok *jwt.Token) {
+			tok.Method = jwt.SigningMethodHS256
+			tok.Header["alg"] = "HS256"
+		}),
+		"otra clave": testIDToken(t, other, nil),
+	}
+	for name, raw := range cases {
+		if _, err := ValidateIDToken(raw, "nonce-1"); err == nil {
+			t.Errorf("%s: token aceptado", name)
+		}
+	}
+}
+
+func TestCallbackPKCEAndIDToken(t *testing.T) {
+	key := useTestOIDC(t)
+	useMemorySessions(t)
+	initRBAC()
+
+	var challenge, nonce string
+	idToken := func(*IDTokenClaims, *jwt.Token) {}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/oauth2/v2.0/token"):
+			r.ParseForm()
+			// El code_verifier debe corresponder al code_challenge del login
+			sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+			if base64.RawURLEncoding.EncodeToString(sum[:]) != challenge {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"error": "invalid_grant"}`)
+				return
+			}
+			token := testIDToken(t, key, func(c *IDTokenClaims, tok *jwt.Token) {
+				c.Nonce = nonce
+				idToken(c, tok)
+			})
+			fmt.Fprintf(w, `{"access_token": "at", "expires_in": 3600, "id_token": %q}`, token)
+		case r.URL.Path == "/me":
+			fmt.Fprint(w, `{"id": "u-ana", "displayName": "Ana", "mail": "ana@sorianomediadores.es"}`)
+		default:
+			fmt.Fprint(w, `{"value": []}`)
+		}
+	}))
+	defer server.Close()
+	prevLogin, prevGraph := loginBaseURL, graphBaseURL
+	loginBaseURL, graphBaseURL = server.URL, server.URL
+	t.Cleanup(func() { loginBaseURL, graphBaseURL = prevLogin, prevGraph })
+
+	app := fiber.New()
+	app.Get("/auth/login", LoginHandler)
+	app.Get("/auth/callback", CallbackHandler)
+	login := func() string {
+		resp, err := app.Test(httptest.NewRequest("GET", "/auth/login", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		authURL, _ := url.Parse(resp.Header.Get("Location"))
+		q := authURL.Query()
+		if q.Get("code_challenge_method") != "S256" || q.Get("nonce") == "" {
+			t.Fatalf("URL de login sin PKCE/nonce: %s", authURL)
+		}
+		challenge, nonce = q.Get("code_challenge"), q.Get("nonce")
+		return q.Get("state")
+	}
+	callback := func(state string) *http.Response {
+		resp, err := app.Test(httptest.NewRequest("GET", "/auth/callback?code=abc&state="+url.QueryEscape(state), nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	state := login()
+	resp := callback(state)
+	if resp.Header.Get("Location") != "/" || len(resp.Cookies()) == 0 {
+		t.Fatalf("login correcto: %d %s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	if session, err := GetSession(resp.Cookies()[0].Value); err != nil || session.UserInfo.ID != "u-ana" {
+		t.Fatalf("sesión: %+v, %v", session, err)
+	}
+	// El state solo vale una vez
+	if loc := callback(state).Header.Get("Location"); loc != "/login?error=invalid_state" {
+		t.Fatalf("state reutilizado: %s", loc)
+	}
+
+	// Un ID token con el nonce de otro login se rechaza
+	state = login()
+	idToken = func(c *IDTokenClaims, _ *jwt.Token) { c.Nonce = "robado" }
+	if loc := callback(state).Header.Get("Location"); loc != "/login?error=invalid_id_token" {
+		t.Fatalf("nonce ajeno: %s", loc)
+	}
+
+	// El usuario de Graph tiene que ser el del ID token
+	state = login()
+	idToken = func(c *IDTokenClaims, _ *jwt.Token) { c.ObjectID = "u-otro" }
+	if loc := callback(state).Header.Get("Location"); loc != "/login?error=invalid_id_token" {
+		t.Fatalf("oid distinto: %s", loc)
+	}
+
+	// Lista de acceso
+	idToken = func(*IDTokenClaims, *jwt.Token) {}
+	allowedDomains, allowedUsers = []string{"sorianomediadores.com"}, []string{"jefe@sorianomediadores.es"}
+	state = login()
+	if loc := callback(state).Header.Get("Location"); loc != "/login?error=not_allowed" {
+		t.Fatalf("usuario fuera de la lista: %s", loc)
+	}
+	allowedUsers = append(allowedUsers, "ana@sorianomediadores.es")
+	state = login()
+	if loc := callback(state).Header.Get("Location"); loc != "/" {
+		t.Fatalf("usuario en la lista: %s", loc)
+	}
+}
@@ -0,0 +1,259 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Role rol de la aplicación, asignado según los grupos de Entra ID del usuario
+type Role string
+
+const (
+	RoleAdmin      Role = "admin"
+	RoleCobros     Role = "cobros"
+	RoleSiniestros Role = "siniestros"
+	RoleComercial  Role = "comercial"
+	RoleLectura    Role = "lectura"
+)
+
+// AllRoles roles en orden de privilegio
+var AllRoles = []Role{RoleAdmin, RoleCobros, RoleSiniestros, RoleComercial, RoleLectura}
+
+// Permission permiso que exige una ruta (recurso:acción)
+type Permission string
+
+const (
+	PermClientesRead  Permission = "clientes:read"
+	PermClientesWrite Permission = "clientes:write"
+	PermStatsRead     Permission = "stats:read"
+	PermAnalyticsRead Permission = "analytics:read"
+	PermChat          Permission = "chat:use"
+	PermChatAdmin     Permission = "chat:admin" // Ver y borrar conversaciones de otros usuarios
+	PermRecobrosRead  Permission = "recobros:read"
+	PermRecobrosSend  Permission = "recobros:send"
+	PermImport        Permission = "import:manage"
+	PermN8N           Permission = "n8n:call"
+	PermScraperRead   Permission = "scraper:read"
+	PermScraperRun    Permission = "scraper:run"
+	PermScraperAdmin  Permission = "scraper:admin"
+	PermSessionsAdmin Permission = "sessions:admin" // Ver y revocar sesiones de otros usuarios
+	PermAPIKeysAdmin  Permission = "apikeys:admin"
+)
+
+// rolePermissions permisos de cada rol (admin tiene todos)
+var rolePermissions = map[Role][]Permission{
+	RoleCobros: {
+		PermClientesRead, PermClientesWrite, PermStatsRead, PermChat,
+		PermRecobrosRead, PermRecobrosSend, PermScraperRead,
+	},
+	RoleSiniestros: {
+		PermClientesRead, PermClientesWrite, PermStatsRead, PermChat,
+	},
+	RoleComercial: {
+		PermClientesRead, PermClientesWrite, PermStatsRead, PermAnalyticsRead, PermChat,
+	},
+	RoleLectura: {
+		PermClientesRead, PermStatsRead, PermAnalyticsRead, PermChat, PermRecobrosRead, PermScraperRead,
+	},
+}
+
+// allPermissions permisos del rol admin
+var allPermissions = []Permission{
+	PermClientesRead, PermClientesWrite, PermStatsRead, PermAnalyticsRead, PermChat, PermChatAdmin,
+	PermRecobrosRead, PermRecobrosSend, PermImport, PermN8N,
+	PermScraperRead, PermScraperRun, PermScraperAdmin, PermSessionsAdmin, PermAPIKeysAdmin,
+}
+
+// Group grupo de Entra ID del usuario
+type Group struct {
+	ID          string `json:"id"`
+	DisplayName string `json:"displayName"`
+}
+
+var (
+	// graphBaseURL API de Microsoft Graph (reemplazable en tests)
+	graphBaseURL = "https://graph.microsoft.com/v1.0"
+
+	// roleGroups grupos (ID o nombre) de AUTH_GROUPS_<ROL> que dan cada rol
+	roleGroups map[Role][]string
+	// defaultRole rol de quien no está en ningún grupo mapeado ("" = ninguno)
+	defaultRole Role
+)
+
+// initRBAC lee el mapeo grupo → rol de AUTH_GROUPS_ADMIN, AUTH_GROUPS_COBROS,
+// AUTH_GROUPS_SINIESTROS, AUTH_GROUPS_COMERCIAL y AUTH_GROUPS_LECTURA (IDs de
+// objeto o nombres de grupo separados por comas) y AUTH_DEFAULT_ROLE
+func initRBAC() {
+	roleGroups = make(map[Role][]string)
+	for _, role := range AllRoles {
+		for _, group := range strings.Split(os.Getenv("AUTH_GROUPS_"+strings.ToUpper(string(role))), ",") {
+			if group = strings.TrimSpace(group); group != "" {
+				roleGroups[role] = append(roleGroups[role], strings.ToLower(group))
+			}
+		}
+	}
+
+	defaultRole = RoleLectura
+	if value, ok := os.LookupEnv("AUTH_DEFAULT_ROLE"); ok {
+		defaultRole = Role(strings.TrimSpace(strings.ToLower(value)))
+		if defaultRole != "" && !isRole(defaultRole) {
+			log.Printf("⚠️  AUTH_DEFAULT_ROLE desconocido (%s), sin rol por defecto", value)
+			defaultRole = ""
+		}
+	}
+
+	if len(roleGroups[RoleAdmin]) == 0 {
+		log.Println("⚠️  AUTH_GROUPS_ADMIN vacío: ningún usuario tendrá rol admin")
+	}
+}
+
+func isRole(role Role) bool {
+	for _, r := range AllRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// RolesForGroups roles que corresponden a los grupos del usuario; sin
+// ninguno mapeado, el rol por defecto
+func RolesForGroups(groups []Group) []Role {
+	var roles []Role
+	for _, role := range AllRoles {
+		if groupsMatch(groups, roleGroups[role]) {
+			roles = append(roles, role)
+		}
+	}
+	if len(roles) == 0 && defaultRole != "" {
+		roles = []Role{defaultRole}
+	}
+	return roles
+}
+
+func groupsMatch(groups []Group, configured []string) bool {
+	for _, want := range configured {
+		for _, g := range groups {
+			if strings.ToLower(g.ID) == want || strings.ToLower(g.DisplayName) == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// PermissionsFor permisos de un conjunto de roles, ordenados
+func PermissionsFor(roles []Role) []Permission {
+	set := make(map[Permission]bool)
+	for _, role := range roles {
+		perms := rolePermissions[role]
+		if role == RoleAdmin {
+			perms = allPermissions
+		}
+		for _, p := range perms {
+			set[p] = true
+		}
+	}
+	permissions := make([]Permission, 0, len(set))
+	for p := range set {
+		permissions = append(permissions, p)
+	}
+	sort.Slice(permissions, func(i, j int) bool { return permissions[i] < permissions[j] })
+	return permissions
+}
+
+// Permissions permisos de la sesión según sus roles (los scopes si es una
+// clave de API)
+func (s *Session) Permissions() []Permission {
+	if s.APIKeyID != 0 {
+		permissions := append([]Permission(nil), s.Scopes...)
+		sort.Slice(permissions, func(i, j int) bool { return permissions[i] < permissions[j] })
+		return permissions
+	}
+	return PermissionsFor(s.Roles)
+}
+
+// Can indica si la sesión tiene el permiso
+func (s *Session) Can(perm Permission) bool {
+	for _, p := range s.Permissions() {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// GetUserGroups grupos de Entra ID del usuario (incluidos los anidados)
+func GetUserGroups(accessToken string) ([]Group, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var groups []Group
+	next := graphBaseURL + "/me/transitiveMemberOf/microsoft.graph.group?$select=id,displayName&$top=999"
+	for next != "" {
+		req, err := http.NewRequestWithContext(ctx, "GET", next, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("error obteniendo grupos: %s", string(body))
+		}
+
+		var page struct {
+			Value    []Group `json:"value"`
+			NextLink string  `json:"@odata.nextLink"`
+		}
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, err
+		}
+		groups = append(groups, page.Value...)
+		next = page.NextLink
+	}
+	return groups, nil
+}
+
+// RequirePermission middleware que exige los permisos indicados a la sesión
+// (va después de AuthMiddleware)
+func RequirePermission(perms ...Permission) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		session, ok := c.Locals("session").(*Session)
+		if !ok || session == nil {
+			return c.Status(401).JSON(fiber.Map{
+				"error":         "No autenticado",
+				"authenticated": false,
+			})
+		}
+		for _, perm := range perms {
+			if !session.Can(perm) {
+				log.Printf("🚫 %s sin permiso %s para %s %s", session.UserInfo.Mail, perm, c.Method(), c.Path())
+				return c.Status(403).JSON(fiber.Map{
+					"success":    false,
+					"error":      "No tienes permiso para esta acción",
+					"permission": perm,
+				})
+			}
+		}
+		return c.Next()
+	}
+}
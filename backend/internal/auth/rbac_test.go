@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestRolesFromEntraGroups(t *testing.T) {
+	t.Setenv("AUTH_GROUPS_ADMIN", "3f1c0c8e-0000-4000-8000-000000000001")
+	t.Setenv("AUTH_GROUPS_COBROS", "Cobros, Recobros")
+	t.Setenv("AUTH_DEFAULT_ROLE", "lectura")
+	initRBAC()
+
+	// Graph pagina los grupos con @odata.nextLink
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Query().Get("page") == "2" {
+			fmt.Fprint(w, `{"value": [{"id": "b", "displayName": "recobros"}]}`)
+			return
+		}
+		fmt.Fprintf(w, `{"value": [{"id": "a", "displayName": "Oficina Madrid"}], "@odata.nextLink": "%s/next?page=2"}`, server.URL)
+	}))
+	defer server.Close()
+	prev := graphBaseURL
+	graphBaseURL = server.URL
+	t.Cleanup(func() { graphBaseURL = prev })
+
+	groups, err := GetUserGroups("token")
+	if err != nil || len(groups) != 2 {
+		t.Fatalf("grupos = %v, %v", groups, err)
+	}
+	if roles := RolesForGroups(groups); len(roles) != 1 || roles[0] != RoleCobros {
+		t.Fatalf("roles = %v", roles)
+	}
+	if roles := RolesForGroups([]Group{{ID: "otro"}}); len(roles) != 1 || roles[0] != RoleLectura {
+		t.Fatalf("rol por defecto = %v", roles)
+	}
+	if roles := RolesForGroups([]Group{{ID: "3F1C0C8E-0000-4000-8000-000000000001"}, {DisplayName: "cobros"}}); len(roles) != 2 {
+		t.Fatalf("admin + cobros = %v", roles)
+	}
+
+	t.Setenv("AUTH_DEFAULT_ROLE", "")
+	initRBAC()
+	if roles := RolesForGroups(nil); len(roles) != 0 {
+		t.Fatalf("sin rol por defecto = %v", roles)
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	sessionFor := func(roles ...Role) *Session {
+		return &Session{UserInfo: UserInfo{Mail: "ana@sorianomediadores.es"}, Roles: roles}
+	}
+
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		switch c.Get("X-Test-Role") {
+		case "":
+		case "ninguno":
+			c.Locals("session", sessionFor())
+		default:
+			c.Locals("session", sessionFor(Role(c.Get("X-Test-Role"))))
+		}
+		return c.Next()
+	})
+	ok := func(c *fiber.Ctx) error { return c.SendString("ok") }
+	app.Post("/api/recobros/send-bulk", RequirePermission(PermRecobrosSend), ok)
+	app.Post("/api/admin/import/start", RequirePermission(PermImport), ok)
+	app.Post("/api/scraper/stop", RequirePermission(PermScraperRun), ok)
+	app.Get("/api/clientes", RequirePermission(PermClientesRead), ok)
+
+	for _, tc := range []struct {
+		role, method, path string
+		status             int
+	}{
+		{"", "GET", "/api/clientes", 401},
+		{"ninguno", "GET", "/api/clientes", 403},
+		{"lectura", "GET", "/api/clientes", 200},
+		{"lectura", "POST", "/api/recobros/send-bulk", 403},
+		{"cobros", "POST", "/api/recobros/send-bulk", 200},
+		{"cobros", "POST", "/api/admin/import/start", 403},
+		{"comercial", "POST", "/api/scraper/stop", 403},
+		{"admin", "POST", "/api/admin/import/start", 200},
+		{"admin", "POST", "/api/scraper/stop", 200},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		req.Header.Set("X-Test-Role", tc.role)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tc.status {
+			t.Errorf("%s %s como %q: %d, esperado %d", tc.method, tc.path, tc.role, resp.StatusCode, tc.status)
+		}
+	}
+}
+
+func TestMeListsPermissions(t *testing.T) {
+	t.Setenv("AUTH_GROUPS_COBROS", "cobros")
+	t.Setenv("AUTH_GROUPS_LECTURA", "lectura")
+	initRBAC()
+	sessionID, err := CreateSession(&UserInfo{DisplayName: "Ana", Mail: "ana@sorianomediadores.es"},
+		[]Group{{DisplayName: "Cobros"}, {DisplayName: "Lectura"}}, &TokenResponse{AccessToken: "token", ExpiresIn: 3600}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	app.Get("/auth/me", MeHandler)
+	req := httptest.NewRequest("GET", "/auth/me", nil)
+	req.AddCookie(&http.Cookie{Name: "session_id", Value: sessionID})
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var body struct {
+		Roles       []Role       `json:"roles"`
+		Permissions []Permission `json:"permissions"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	want := PermissionsFor([]Role{RoleCobros, RoleLectura})
+	if len(body.Roles) != 2 || len(body.Permissions) != len(want) {
+		t.Fatalf("/auth/me = %+v", body)
+	}
+	for i, p := range want {
+		if body.Permissions[i] != p {
+			t.Fatalf("permisos = %v, esperado %v", body.Permissions, want)
+		}
+	}
+}
@@ -0,0 +1,450 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"soriano-mediadores/internal/db"
+	"soriano-mediadores/internal/secretbox"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gofiber/fiber/v2"
+)
+
+var (
+	// ErrSessionNotFound la sesión no existe (o ya caducó en Redis)
+	ErrSessionNotFound = errors.New("sesión no encontrada")
+	// ErrSessionExpired la sesión superó la inactividad o la duración máxima
+	ErrSessionExpired = errors.New("sesión expirada")
+)
+
+const (
+	// refreshMargin renovar el access token cuando le queda menos de esto
+	refreshMargin = 5 * time.Minute
+	// touchInterval frecuencia máxima con la que se guarda LastSeenAt
+	touchInterval = time.Minute
+)
+
+var (
+	// sessionStore almacén de sesiones: Redis si hay conexión, si no memoria
+	sessionStore SessionStore = newMemorySessionStore()
+
+	// sessionIdleTTL expiración deslizante: se renueva con cada petición
+	sessionIdleTTL = 8 * time.Hour
+	// sessionMaxAge duración máxima desde el login, con o sin actividad
+	sessionMaxAge = 7 * 24 * time.Hour
+
+	// tokenBox cifra el refresh token (AUTH_SESSION_KEY); nil = no se guarda
+	tokenBox *secretbox.Box
+
+	// loginBaseURL endpoint de Microsoft identity (reemplazable en tests)
+	loginBaseURL = "https://login.microsoftonline.com"
+)
+
+// SessionStore almacén de sesiones compartido entre instancias
+type SessionStore interface {
+	Save(s *Session, ttl time.Duration) error
+	Get(id string) (*Session, error)
+	Delete(id string) error
+	// ListByUser sesiones activas de un usuario (UserInfo.ID)
+	ListByUser(userID string) ([]*Session, error)
+}
+
+// initSessions configura duración, cifrado del refresh token y almacén
+func initSessions() {
+	if hours, err := strconv.Atoi(os.Getenv("AUTH_SESSION_IDLE_HOURS")); err == nil && hours > 0 {
+		sessionIdleTTL = time.Duration(hours) * time.Hour
+	}
+	if days, err := strconv.Atoi(os.Getenv("AUTH_SESSION_MAX_DAYS")); err == nil && days > 0 {
+		sessionMaxAge = time.Duration(days) * 24 * time.Hour
+	}
+
+	box, err := secretbox.FromEnv("AUTH_SESSION_KEY")
+	if err != nil {
+		log.Printf("⚠️  Refresh token no se guarda (%v): la sesión termina al caducar el access token", err)
+	}
+	tokenBox = box
+
+	if db.RedisClient != nil && db.RedisClient.Ping(context.Background()).Err() == nil {
+		sessionStore = &redisSessionStore{client: db.RedisClient}
+		log.Println("🔐 Sesiones en Redis")
+	} else {
+		log.Println("⚠️  Redis no disponible: sesiones en memoria (se pierden al reiniciar)")
+	}
+}
+
+// sessionKey ID de la sesión: hash del valor de la cookie, que nunca se guarda
+func sessionKey(cookie string) string {
+	sum := sha256.Sum256([]byte(cookie))
+	return hex.EncodeToString(sum[:])
+}
+
+// expired indica si la sesión superó la inactividad o la duración máxima
+func (s *Session) expired(now time.Time) bool {
+	return now.Sub(s.LastSeenAt) > sessionIdleTTL || now.Sub(s.CreatedAt) > sessionMaxAge
+}
+
+// ttl tiempo que debe vivir en el almacén desde ahora
+func (s *Session) ttl(now time.Time) time.Duration {
+	ttl := sessionIdleTTL
+	if remaining := s.CreatedAt.Add(sessionMaxAge).Sub(now); remaining < ttl {
+		ttl = remaining
+	}
+	return ttl
+}
+
+// setTokens guarda los tokens de una respuesta de Microsoft; el refresh token
+// (rotado en cada renovación) solo se conserva cifrado
+func (s *Session) setTokens(tokens *TokenResponse, now time.Time) error {
+	s.AccessToken = tokens.AccessToken
+	s.ExpiresAt = now.Add(time.Duration(tokens.ExpiresIn) * time.Second)
+	if tokens.RefreshToken == "" || tokenBox == nil {
+		return nil
+	}
+	sealed, err := tokenBox.SealString(tokens.RefreshToken)
+	if err != nil {
+		return fmt.Errorf("error cifrando refresh token: %w", err)
+	}
+	s.RefreshToken = sealed
+	return nil
+}
+
+// refresh renueva el access token con el refresh token de la sesión
+func (s *Session) refresh(now time.Time) error {
+	if s.RefreshToken == "" || tokenBox == nil {
+		return errors.New("la sesión no tiene refresh token")
+	}
+	refreshToken, err := tokenBox.OpenString(s.RefreshToken)
+	if err != nil {
+		return err
+	}
+	tokens, err := RefreshAccessToken(refreshToken)
+	if err != nil {
+		return err
+	}
+	return s.setTokens(tokens, now)
+}
+
+// RefreshAccessToken obtiene un access token nuevo (y un refresh token rotado)
+func RefreshAccessToken(refreshToken string) (*TokenResponse, error) {
+	tokenURL := fmt.Sprintf("%s/%s/oauth2/v2.0/token", loginBaseURL, tenantID)
+
+	data := url.Values{
+		"client_id":     {clientID},
+		"client_secret": {clientSecret},
+		"refresh_token": {refreshToken},
+		"grant_type":    {"refresh_token"},
+		"scope":         {oauthScopes},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", tokenURL, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error renovando token: %s", string(body))
+	}
+
+	var tokenResp TokenResponse
+	if err := json.Unmarshal(body, &tokenResp); err != nil {
+		return nil, err
+	}
+	return &tokenResp, nil
+}
+
+// memorySessionStore sesiones en memoria (sin Redis, una sola instancia)
+type memorySessionStore struct {
+	mu       sync.RWMutex
+	sessions map[string]*Session
+	expires  map[string]time.Time
+}
+
+func newMemorySessionStore() *memorySessionStore {
+	return &memorySessionStore{
+		sessions: make(map[string]*Session),
+		expires:  make(map[string]time.Time),
+	}
+}
+
+func (m *memorySessionStore) Save(s *Session, ttl time.Duration) error {
+	copied := *s
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions[s.ID] = &copied
+	m.expires[s.ID] = time.Now().Add(ttl)
+	return nil
+}
+
+func (m *memorySessionStore) Get(id string) (*Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	s, ok := m.sessions[id]
+	if !ok || time.Now().After(m.expires[id]) {
+		return nil, ErrSessionNotFound
+	}
+	copied := *s
+	return &copied, nil
+}
+
+func (m *memorySessionStore) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, id)
+	delete(m.expires, id)
+	return nil
+}
+
+func (m *memorySessionStore) ListByUser(userID string) ([]*Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	now := time.Now()
+	var sessions []*Session
+	for id, s := range m.sessions {
+		if s.UserInfo.ID == userID && now.Before(m.expires[id]) {
+			copied := *s
+			sessions = append(sessions, &copied)
+		}
+	}
+	return sessions, nil
+}
+
+// cleanup elimina las sesiones caducadas
+func (m *memorySessionStore) cleanup() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for id, expiry := range m.expires {
+		if now.After(expiry) {
+			delete(m.sessions, id)
+			delete(m.expires, id)
+			log.Printf("🔐 Sesión expirada eliminada: %s", id[:8])
+		}
+	}
+}
+
+// redisSessionStore sesiones en Redis: auth:session:<id> con TTL deslizante
+// y el conjunto auth:user_sessions:<usuario> para listarlas y revocarlas
+type redisSessionStore struct {
+	client *redis.Client
+}
+
+func redisSessionKey(id string) string            { return "auth:session:" + id }
+func redisUserKey(userID string) string           { return "auth:user_sessions:" + userID }
+func (r *redisSessionStore) ctx() context.Context { return context.Background() }
+
+func (r *redisSessionStore) Save(s *Session, ttl time.Duration) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	pipe := r.client.TxPipeline()
+	pipe.Set(r.ctx(), redisSessionKey(s.ID), data, ttl)
+	pipe.SAdd(r.ctx(), redisUserKey(s.UserInfo.ID), s.ID)
+	pipe.Expire(r.ctx(), redisUserKey(s.UserInfo.ID), sessionMaxAge)
+	_, err = pipe.Exec(r.ctx())
+	return err
+}
+
+func (r *redisSessionStore) Get(id string) (*Session, error) {
+	data, err := r.client.Get(r.ctx(), redisSessionKey(id)).Bytes()
+	if err == redis.Nil {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s Session
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("sesión corrupta: %w", err)
+	}
+	return &s, nil
+}
+
+func (r *redisSessionStore) Delete(id string) error {
+	s, err := r.Get(id)
+	if err == ErrSessionNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	pipe := r.client.TxPipeline()
+	pipe.Del(r.ctx(), redisSessionKey(id))
+	pipe.SRem(r.ctx(), redisUserKey(s.UserInfo.ID), id)
+	_, err = pipe.Exec(r.ctx())
+	return err
+}
+
+func (r *redisSessionStore) ListByUser(userID string) ([]*Session, error) {
+	ids, err := r.client.SMembers(r.ctx(), redisUserKey(userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	var sessions []*Session
+	for _, id := range ids {
+		s, err := r.Get(id)
+		if err == ErrSessionNotFound {
+			// Caducó por TTL: quitarla del índice del usuario
+			r.client.SRem(r.ctx(), redisUserKey(userID), id)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, nil
+}
+
+// SessionInfo sesión activa tal como se muestra al usuario (sin tokens)
+type SessionInfo struct {
+	ID         string    `json:"id"`
+	UserID     string    `json:"user_id"`
+	Mail       string    `json:"mail"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	Current    bool      `json:"current"`
+}
+
+// currentSession sesión de la cookie (las rutas /auth/* no pasan por AuthMiddleware)
+func currentSession(c *fiber.Ctx) (*Session, error) {
+	if session, ok := c.Locals("session").(*Session); ok && session != nil {
+		return session, nil
+	}
+	cookie := c.Cookies("session_id")
+	if cookie == "" {
+		return nil, errors.New("No autenticado")
+	}
+	return GetSession(cookie)
+}
+
+// sessionsTarget usuario cuyas sesiones se gestionan: el propio o, con
+// permiso sessions:admin, el de ?user_id=
+func sessionsTarget(c *fiber.Ctx) (*Session, string, error) {
+	session, err := currentSession(c)
+	if err != nil {
+		return nil, "", c.Status(401).JSON(fiber.Map{"error": err.Error(), "authenticated": false})
+	}
+	userID := c.Query("user_id")
+	if userID == "" || userID == session.UserInfo.ID {
+		return session, session.UserInfo.ID, nil
+	}
+	if !session.Can(PermSessionsAdmin) {
+		return nil, "", c.Status(403).JSON(fiber.Map{
+			"success":    false,
+			"error":      "No tienes permiso para esta acción",
+			"permission": PermSessionsAdmin,
+		})
+	}
+	return session, userID, nil
+}
+
+// ListSessionsHandler sesiones activas del usuario
+// GET /auth/sessions[?user_id=]
+func ListSessionsHandler(c *fiber.Ctx) error {
+	session, userID, err := sessionsTarget(c)
+	if session == nil {
+		return err
+	}
+	sessions, err := sessionStore.ListByUser(userID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"success": false, "error": "Error obteniendo sesiones: " + err.Error()})
+	}
+
+	infos := make([]SessionInfo, 0, len(sessions))
+	for _, s := range sessions {
+		infos = append(infos, SessionInfo{
+			ID:         s.ID,
+			UserID:     s.UserInfo.ID,
+			Mail:       s.UserInfo.Mail,
+			UserAgent:  s.UserAgent,
+			IP:         s.IP,
+			CreatedAt:  s.CreatedAt,
+			LastSeenAt: s.LastSeenAt,
+			Current:    s.ID == session.ID,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].LastSeenAt.After(infos[j].LastSeenAt) })
+
+	return c.JSON(fiber.Map{"success": true, "sessions": infos, "total": len(infos)})
+}
+
+// RevokeSessionHandler cierra una sesión del usuario (p. ej. un equipo perdido)
+// DELETE /auth/sessions/:id[?user_id=]
+func RevokeSessionHandler(c *fiber.Ctx) error {
+	session, userID, err := sessionsTarget(c)
+	if session == nil {
+		return err
+	}
+	sessions, err := sessionStore.ListByUser(userID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"success": false, "error": "Error obteniendo sesiones: " + err.Error()})
+	}
+	for _, s := range sessions {
+		if s.ID == c.Params("id") {
+			if err := sessionStore.Delete(s.ID); err != nil {
+				return c.Status(500).JSON(fiber.Map{"success": false, "error": err.Error()})
+			}
+			log.Printf("🔓 Sesión %s de %s revocada por %s", s.ID[:8], s.UserInfo.Mail, session.UserInfo.Mail)
+			return c.JSON(fiber.Map{"success": true})
+		}
+	}
+	return c.Status(404).JSON(fiber.Map{"success": false, "error": ErrSessionNotFound.Error()})
+}
+
+// RevokeOtherSessionsHandler cierra todas las sesiones del usuario salvo la
+// actual (todas si es otro usuario)
+// DELETE /auth/sessions[?user_id=]
+func RevokeOtherSessionsHandler(c *fiber.Ctx) error {
+	session, userID, err := sessionsTarget(c)
+	if session == nil {
+		return err
+	}
+	sessions, err := sessionStore.ListByUser(userID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"success": false, "error": "Error obteniendo sesiones: " + err.Error()})
+	}
+	revoked := 0
+	for _, s := range sessions {
+		if s.ID == session.ID {
+			continue
+		}
+		if err := sessionStore.Delete(s.ID); err != nil {
+			return c.Status(500).JSON(fiber.Map{"success": false, "error": err.Error()})
+		}
+		revoked++
+	}
+	log.Printf("🔓 %d sesiones de %s revocadas por %s", revoked, userID, session.UserInfo.Mail)
+	return c.JSON(fiber.Map{"success": true, "revoked": revoked})
+}
@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"soriano-mediadores/internal/secretbox"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func useMemorySessions(t *testing.T) *memorySessionStore {
+	t.Helper()
+	store := newMemorySessionStore()
+	prevStore, prevBox := sessionStore, tokenBox
+	sessionStore = store
+	box, err := secretbox.New([]byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenBox = box
+	t.Cleanup(func() { sessionStore, tokenBox = prevStore, prevBox })
+	return store
+}
+
+func TestSessionSlidingExpiration(t *testing.T) {
+	store := useMemorySessions(t)
+	ana := &UserInfo{ID: "u-ana", Mail: "ana@sorianomediadores.es"}
+
+	cookie, err := CreateSession(ana, nil, &TokenResponse{AccessToken: "at", ExpiresIn: 3600}, "Firefox", "10.0.0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// La actividad mueve LastSeenAt; la inactividad más allá de sessionIdleTTL la cierra
+	stored, _ := store.Get(sessionKey(cookie))
+	stored.LastSeenAt = time.Now().Add(-2 * time.Hour)
+	store.Save(stored, time.Hour)
+	if _, err := GetSession(cookie); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ = store.Get(sessionKey(cookie)); time.Since(stored.LastSeenAt) > time.Minute {
+		t.Fatalf("LastSeenAt no se actualizó: %v", stored.LastSeenAt)
+	}
+
+	stored.LastSeenAt = time.Now().Add(-sessionIdleTTL - time.Minute)
+	store.Save(stored, time.Hour)
+	if _, err := GetSession(cookie); err != ErrSessionExpired {
+		t.Fatalf("sesión inactiva: %v", err)
+	}
+	if _, err := store.Get(sessionKey(cookie)); err != ErrSessionNotFound {
+		t.Fatal("la sesión expirada sigue guardada")
+	}
+}
+
+func TestSessionRefreshTokenRotation(t *testing.T) {
+	store := useMemorySessions(t)
+
+	var refreshed []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("grant_type") != "refresh_token" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		rt := r.Form.Get("refresh_token")
+		refreshed = append(refreshed, rt)
+		n := len(refreshed) + 1
+		fmt.Fprintf(w, `{"access_token": "at-%d", "refresh_token": "rt-%d", "expires_in": 60}`, n, n)
+	}))
+	defer server.Close()
+	prev := loginBaseURL
+	loginBaseURL = server.URL
+	t.Cleanup(func() { loginBaseURL = prev })
+
+	// Token que caduca en un minuto: dentro del margen de renovación
+	cookie, err := CreateSession(&UserInfo{ID: "u-ana"}, nil, &TokenResponse{AccessToken: "at-1", RefreshToken: "rt-1", ExpiresIn: 60}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := store.Get(sessionKey(cookie))
+	if stored.RefreshToken == "" || stored.RefreshToken == "rt-1" {
+		t.Fatalf("refresh token sin cifrar: %q", stored.RefreshToken)
+	}
+
+	session, err := GetSession(cookie)
+	if err != nil || session.AccessToken != "at-2" {
+		t.Fatalf("renovación: %+v, %v", session, err)
+	}
+	if _, err := GetSession(cookie); err != nil {
+		t.Fatal(err)
+	}
+	// Cada renovación usa el refresh token rotado de la anterior
+	if len(refreshed) != 2 || refreshed[0] != "rt-1" || refreshed[1] != "rt-2" {
+		t.Fatalf("refresh tokens usados = %v", refreshed)
+	}
+
+	// Si Microsoft rechaza el refresh token y el access token ya caducó, hay que volver a entrar
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	})
+	stored, _ = store.Get(sessionKey(cookie))
+	stored.ExpiresAt = time.Now().Add(-time.Second)
+	store.Save(stored, time.Hour)
+	if _, err := GetSession(cookie); err != ErrSessionExpired {
+		t.Fatalf("token caducado sin renovación: %v", err)
+	}
+}
+
+func TestListAndRevokeSessions(t *testing.T) {
+	useMemorySessions(t)
+	t.Setenv("AUTH_GROUPS_ADMIN", "it")
+	initRBAC()
+
+	ana := &UserInfo{ID: "u-ana", Mail: "ana@sorianomediadores.es"}
+	tokens := &TokenResponse{AccessToken: "at", ExpiresIn: 3600}
+	laptop, _ := CreateSession(ana, nil, tokens, "Firefox", "10.0.0.5")
+	phone, _ := CreateSession(ana, nil, tokens, "Safari iOS", "10.0.0.9")
+	tablet, _ := CreateSession(ana, nil, tokens, "Chrome", "10.0.0.7")
+	admin, _ := CreateSession(&UserInfo{ID: "u-it", Mail: "it@sorianomediadores.es"}, []Group{{DisplayName: "IT"}}, tokens, "", "")
+
+	app := fiber.New()
+	app.Get("/auth/sessions", ListSessionsHandler)
+	app.Delete("/auth/sessions", RevokeOtherSessionsHandler)
+	app.Delete("/auth/sessions/:id", RevokeSessionHandler)
+	call := func(cookie, method, url string) (int, map[string]interface{}) {
+		req := httptest.NewRequest(method, url, nil)
+		req.AddCookie(&http.Cookie{Name: "session_id", Value: cookie})
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, body
+	}
+
+	code, body := call(laptop, "GET", "/auth/sessions")
+	if code != 200 || body["total"] != float64(3) {
+		t.Fatalf("listar: %d %v", code, body)
+	}
+	for _, s := range body["sessions"].([]interface{}) {
+		s := s.(map[string]interface{})
+		if s["current"] != (s["id"] == sessionKey(laptop)) || s["refresh_token"] != nil || s["access_token"] != nil {
+			t.Fatalf("sesión listada = %v", s)
+		}
+	}
+
+	if code, _ := call(laptop, "DELETE", "/auth/sessions/"+sessionKey(phone)); code != 200 {
+		t.Fatalf("revocar: %d", code)
+	}
+	if _, err := GetSession(phone); err != ErrSessionNotFound {
+		t.Fatalf("sesión revocada sigue activa: %v", err)
+	}
+
+	// Otro usuario solo con sessions:admin
+	if code, _ := call(laptop, "GET", "/auth/sessions?user_id=u-it"); code != 403 {
+		t.Fatalf("sin permiso: %d", code)
+	}
+	if code, body := call(admin, "DELETE", "/auth/sessions?user_id=u-ana"); code != 200 || body["revoked"] != float64(2) {
+		t.Fatalf("admin revoca todas: %d %v", code, body)
+	}
+	for _, cookie := range []string{laptop, tablet} {
+		if _, err := GetSession(cookie); err == nil {
+			t.Fatal("quedan sesiones de ana")
+		}
+	}
+}
@@ -18,9 +18,23 @@ func NewBotAgente() *BotAgente {
 	}
 }
 
+func init() { RegistrarBot(NewBotAgente()) }
+
+// Info presentación del bot en /api/bots y para el enrutador
+func (b *BotAgente) Info() InfoBot {
+	return InfoBot{
+		ID:            "agente",
+		BotID:         b.ID,
+		Nombre:        "Agente Comercial",
+		Descripcion:   "Información sobre productos Occident, precios orientativos, comparativas. Pregunta sobre seguros de auto, hogar, vida, salud, PIAS, etc.",
+		Ejemplos:      []string{"¿Qué seguros de vida tenéis?", "Información sobre PIAS de Seguros Bilbao", "Diferencia entre todo riesgo con y sin franquicia"},
+		PalabrasClave: []string{"seguro de", "precio", "presupuesto", "contratar", "pias", "producto", "tarifa", "franquicia", "todo riesgo"},
+	}
+}
+
 // ProcesarConsulta procesa consultas comerciales
-func (b *BotAgente) ProcesarConsulta(sessionID string, mensaje string) (string, error) {
-	return ProcesarConFallback(b.ID, sessionID, mensaje, func(msg string) (string, error) {
+func (b *BotAgente) ProcesarConsulta(sessionID string, mensaje string, emitir Emisor) (*Respuesta, error) {
+	return ProcesarConFallback(b.ID, sessionID, mensaje, emitir, func(msg string, historial []ai.Message) (*Respuesta, error) {
 		systemPrompt := `Eres un AGENTE COMERCIAL EXPERTO de SORIANO MEDIADORES, correduría de seguros española
 colaboradora exclusiva de GRUPO OCCIDENT, con más de 30 años de experiencia en el mercado español.
 
@@ -115,11 +129,11 @@ INSTRUCCIONES:
 - Menciona la solidez del Grupo Occident (fundado en 1864)
 - Destaca el servicio personalizado de correduría vs. comparadores online`
 
-		respuesta, err := ai.ConsultarAI(msg, systemPrompt)
+		respuesta, err := ai.ConsultarAIConHistorialStream(b.ID, systemPrompt, historial, msg, emitir.tokens())
 		if err != nil {
-			return "Lo siento, no puedo procesar tu consulta comercial en este momento. Un agente te contactará pronto.", err
+			return &Respuesta{Texto: "Lo siento, no puedo procesar tu consulta comercial en este momento. Un agente te contactará pronto."}, err
 		}
 
-		return respuesta, nil
+		return &Respuesta{Texto: respuesta, transmitida: emitir != nil}, nil
 	})
 }
@@ -2,6 +2,7 @@ package bots
 
 import (
 	"fmt"
+	"soriano-mediadores/internal/ai"
 	"soriano-mediadores/internal/db"
 	"strings"
 )
@@ -20,21 +21,63 @@ func NewBotAnalista() *BotAnalista {
 	}
 }
 
-// ProcesarConsulta procesa consultas de análisis
-func (b *BotAnalista) ProcesarConsulta(sessionID string, mensaje string) (string, error) {
-	return ProcesarConFallback(b.ID, sessionID, mensaje, func(msg string) (string, error) {
-		mensajeLower := strings.ToLower(msg)
-
-		if strings.Contains(mensajeLower, "top") || strings.Contains(mensajeLower, "mejores") {
-			return b.TopClientes()
-		} else if strings.Contains(mensajeLower, "ramo") || strings.Contains(mensajeLower, "producto") {
-			return b.AnalisisPorRamo()
-		} else if strings.Contains(mensajeLower, "comision") {
-			return b.AnalisisComisiones()
-		}
+func init() { RegistrarBot(NewBotAnalista()) }
+
+// Info presentación del bot en /api/bots y para el enrutador
+func (b *BotAnalista) Info() InfoBot {
+	return InfoBot{
+		ID:            "analista",
+		BotID:         b.ID,
+		Nombre:        "Analista de Datos",
+		Descripcion:   "Estadísticas de cartera, top clientes por primas, distribución por ramos, análisis de tendencias.",
+		Ejemplos:      []string{"Top 20 clientes", "Distribución por ramos", "Estadísticas generales"},
+		PalabrasClave: []string{"top", "mejores", "ramo", "comision", "cartera", "tendencia", "estadistica", "reporte", "informe"},
+	}
+}
+
+// ProcesarConsulta procesa consultas de análisis con los informes de la
+// cartera como herramientas
+func (b *BotAnalista) ProcesarConsulta(sessionID string, mensaje string, emitir Emisor) (*Respuesta, error) {
+	systemPrompt := `Eres el analista de negocio de SORIANO MEDIADORES, correduría de seguros española
+colaboradora exclusiva de GRUPO OCCIDENT.
+
+Usa las herramientas para obtener las cifras reales de la cartera y basa tu análisis en ellas.
+No inventes cifras. Señala oportunidades y riesgos de forma concisa, en español de España.`
+
+	return ProcesarConFallback(b.ID, sessionID, mensaje, emitir, conHerramientas(b.ID, systemPrompt, b.Herramientas(), emitir, b.porPalabrasClave))
+}
+
+// Herramientas informes de la cartera disponibles para el modelo
+func (b *BotAnalista) Herramientas() []Herramienta {
+	return []Herramienta{
+		SinArgumentos("top_clientes",
+			"Los 20 clientes con más primas en cartera, con sus comisiones y número de pólizas",
+			b.TopClientes),
+		SinArgumentos("analisis_por_ramo",
+			"Pólizas y primas agrupadas por ramo",
+			b.AnalisisPorRamo),
+		SinArgumentos("analisis_comisiones",
+			"Totales y medias de primas y comisiones de la cartera",
+			b.AnalisisComisiones),
+		SinArgumentos("reporte_general",
+			"Reporte general: clientes, pólizas, recibos y siniestros",
+			b.ReporteGeneral),
+	}
+}
+
+// porPalabrasClave enrutado sin function calling
+func (b *BotAnalista) porPalabrasClave(msg string, _ []ai.Message) (string, error) {
+	mensajeLower := strings.ToLower(msg)
+
+	if strings.Contains(mensajeLower, "top") || strings.Contains(mensajeLower, "mejores") {
+		return b.TopClientes()
+	} else if strings.Contains(mensajeLower, "ramo") || strings.Contains(mensajeLower, "producto") {
+		return b.AnalisisPorRamo()
+	} else if strings.Contains(mensajeLower, "comision") {
+		return b.AnalisisComisiones()
+	}
 
-		return b.ReporteGeneral()
-	})
+	return b.ReporteGeneral()
 }
 
 // TopClientes lista los mejores clientes
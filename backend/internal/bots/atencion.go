@@ -22,31 +22,107 @@ func NewBotAtencion() *BotAtencion {
 	}
 }
 
-// ProcesarConsulta procesa una consulta del cliente
-func (b *BotAtencion) ProcesarConsulta(sessionID string, mensaje string) (string, error) {
-	// Guardar mensaje en MongoDB
-	db.GuardarSesionBot(sessionID, b.ID, map[string]interface{}{
-		"tipo":    "consulta",
-		"mensaje": mensaje,
-	})
-
-	// PASO 1: Intentar respuesta desde fallback (más rápido)
-	if respuesta, found := FindBestMatch(b.ID, mensaje); found {
-		// Cachear en Redis
-		db.CacheSet("bot_response:"+b.ID+":"+mensaje, respuesta, 24*time.Hour)
-		return respuesta, nil
+func init() { RegistrarBot(NewBotAtencion()) }
+
+// Info presentación del bot en /api/bots y para el enrutador
+func (b *BotAtencion) Info() InfoBot {
+	return InfoBot{
+		ID:            "atencion",
+		BotID:         b.ID,
+		Nombre:        "Atención al Cliente",
+		Descripcion:   "Busca clientes por nombre/NIF, consulta pólizas, recibos y siniestros de un cliente específico. Pregunta: 'Busca a Juan García' o 'Pólizas del cliente 12345678A'",
+		Ejemplos:      []string{"Busca a Héctor Pérez", "Pólizas del cliente 20777103/000", "¿Qué productos ofrece Occident?"},
+		PalabrasClave: []string{"busca", "cliente", "poliza", "nif", "dni", "contacto", "horario", "oficina"},
 	}
+}
 
-	// PASO 2: Verificar cache de Redis
-	var cachedResponse string
-	cacheKey := "bot_response:" + b.ID + ":" + mensaje
-	if db.CacheExists(cacheKey) {
-		if err := db.CacheGet(cacheKey, &cachedResponse); err == nil {
-			return cachedResponse, nil
-		}
+// ProcesarConsulta procesa una consulta del cliente con el historial de la
+// sesión («¿y sus recibos?» se refiere al último cliente consultado). El
+// modelo busca al cliente y consulta sus datos con las herramientas; sin
+// function calling se clasifica la consulta como antes
+func (b *BotAtencion) ProcesarConsulta(sessionID string, mensaje string, emitir Emisor) (*Respuesta, error) {
+	systemPrompt := `Eres el asistente de atención al cliente de SORIANO MEDIADORES, correduría de seguros española
+colaboradora exclusiva de GRUPO OCCIDENT (Catalana Occidente, Plus Ultra Seguros, Seguros Bilbao, NorteHispana).
+
+Para preguntas sobre un cliente usa las herramientas: busca primero al cliente (nombre, NIF/NIE/CIF o IdAccount)
+para obtener su IdAccount y después consulta sus pólizas, recibos o siniestros. Si la consulta se refiere a un
+cliente de la conversación previa («¿y sus recibos?»), usa su IdAccount sin volver a buscarlo.
+Si hay varios clientes posibles, enuméralos y pide que se concrete.
+No inventes datos: responde solo con lo que devuelvan las herramientas.
+Para preguntas generales (coberturas, contacto, horarios) responde directamente: sede en Calle Constitución 5,
+Villajoyosa (Alicante), teléfono +34 96 681 02 90, info@sorianomediadores.es, de 09:00 a 17:00.
+Responde en español de España con terminología de seguros española (póliza, prima, siniestro).`
+
+	return ProcesarConFallback(b.ID, sessionID, mensaje, emitir, conHerramientas(b.ID, systemPrompt, b.Herramientas(), emitir, b.clasificarConsulta))
+}
+
+// Herramientas consultas de clientes disponibles para el modelo
+func (b *BotAtencion) Herramientas() []Herramienta {
+	idAccount := texto("IdAccount del cliente (formato XXXXXXXX/XXX), obtenido con buscar_clientes")
+	return []Herramienta{
+		NuevaHerramienta("buscar_clientes",
+			"Busca clientes por nombre, NIF/NIE/CIF o IdAccount y devuelve sus datos de contacto y totales",
+			objeto(map[string]Schema{
+				"termino": texto("Nombre, NIF/NIE/CIF o IdAccount"),
+				"limite":  entero("Número máximo de clientes (por defecto 10)", 1, 20),
+			}, "termino"),
+			func(args struct {
+				Termino string `json:"termino"`
+				Limite  int    `json:"limite"`
+			}) (interface{}, error) {
+				if args.Limite == 0 {
+					args.Limite = 10
+				}
+				clientes, err := db.BuscarClientes(args.Termino, args.Limite)
+				if err != nil {
+					return nil, fmt.Errorf("error buscando clientes: %w", err)
+				}
+				db.GuardarBusquedaCache(args.Termino, clientes)
+				return clientes, nil
+			}),
+		NuevaHerramienta("obtener_cliente",
+			"Ficha de un cliente por su IdAccount",
+			objeto(map[string]Schema{"id_account": idAccount}, "id_account"),
+			func(args argsCliente) (interface{}, error) {
+				return db.ObtenerClientePorID(args.IDAccount)
+			}),
+		NuevaHerramienta("obtener_polizas_cliente",
+			"Pólizas de un cliente con ramo, compañía, situación, prima anual y fechas",
+			objeto(map[string]Schema{"id_account": idAccount}, "id_account"),
+			func(args argsCliente) (interface{}, error) {
+				return db.ObtenerPolizasCliente(args.IDAccount)
+			}),
+		NuevaHerramienta("obtener_recibos_cliente",
+			"Últimos recibos de un cliente con su situación de cobro e importe",
+			objeto(map[string]Schema{
+				"id_account": idAccount,
+				"limite":     entero("Número máximo de recibos (por defecto 20)", 1, 100),
+			}, "id_account"),
+			func(args struct {
+				argsCliente
+				Limite int `json:"limite"`
+			}) (interface{}, error) {
+				if args.Limite == 0 {
+					args.Limite = 20
+				}
+				return db.ObtenerRecibosCliente(args.IDAccount, args.Limite)
+			}),
+		NuevaHerramienta("obtener_siniestros_cliente",
+			"Siniestros de un cliente con situación, fechas y tramitador",
+			objeto(map[string]Schema{"id_account": idAccount}, "id_account"),
+			func(args argsCliente) (interface{}, error) {
+				return db.ObtenerSiniestrosCliente(args.IDAccount)
+			}),
 	}
+}
+
+type argsCliente struct {
+	IDAccount string `json:"id_account"`
+}
 
-	// PASO 3: Detectar tipo de consulta usando AI (solo si no hay match)
+// clasificarConsulta respuesta sin function calling: clasifica la consulta
+// con el modelo y extrae el cliente del texto
+func (b *BotAtencion) clasificarConsulta(mensaje string, historial []ai.Message) (string, error) {
 	systemPrompt := `Eres el asistente de atención al cliente de SORIANO MEDIADORES, correduría de seguros española
 colaboradora exclusiva de GRUPO OCCIDENT (Catalana Occidente, Plus Ultra Seguros, Seguros Bilbao, NorteHispana).
 
@@ -57,9 +133,11 @@ Analiza la consulta del cliente y clasifícala en una de estas categorías:
 - CONSULTAR_SINIESTROS: ver siniestros, partes o tramitaciones de un cliente
 - INFORMACION_GENERAL: preguntas sobre coberturas, productos Occident, horarios, contacto, documentación
 
+Ten en cuenta la conversación previa: "¿y sus recibos?" tras consultar un cliente es CONSULTAR_RECIBOS.
+
 Responde SOLO con la categoría exacta, sin explicaciones adicionales.`
 
-	categoria, err := ai.ConsultarAI(mensaje, systemPrompt)
+	categoria, err := ai.ConsultarAIConHistorial(b.ID, systemPrompt, historial, mensaje)
 	if err != nil {
 		// Si AI falla, devolver respuesta por defecto
 		return GetDefaultResponse(b.ID), nil
@@ -67,18 +145,21 @@ Responde SOLO con la categoría exacta, sin explicaciones adicionales.`
 
 	categoria = strings.TrimSpace(strings.ToUpper(categoria))
 
+	// Los extractores de cliente ven la conversación previa en el texto
+	consulta := conContexto(historial, mensaje)
+
 	// Procesar según categoría
 	switch categoria {
 	case "BUSCAR_CLIENTE":
-		return b.BuscarCliente(mensaje)
+		return b.BuscarCliente(consulta)
 	case "CONSULTAR_POLIZAS":
-		return b.ConsultarPolizas(mensaje)
+		return b.ConsultarPolizas(consulta)
 	case "CONSULTAR_RECIBOS":
-		return b.ConsultarRecibos(mensaje)
+		return b.ConsultarRecibos(consulta)
 	case "CONSULTAR_SINIESTROS":
-		return b.ConsultarSiniestros(mensaje)
+		return b.ConsultarSiniestros(consulta)
 	default:
-		return b.InformacionGeneral(mensaje)
+		return b.InformacionGeneral(mensaje, historial)
 	}
 }
 
@@ -200,7 +281,7 @@ func (b *BotAtencion) ConsultarSiniestros(consulta string) (string, error) {
 }
 
 // InformacionGeneral responde preguntas generales
-func (b *BotAtencion) InformacionGeneral(consulta string) (string, error) {
+func (b *BotAtencion) InformacionGeneral(consulta string, historial []ai.Message) (string, error) {
 	systemPrompt := `Eres el asistente virtual de SORIANO MEDIADORES, correduría de seguros española con más de 30 años
 de experiencia, colaboradora exclusiva de GRUPO OCCIDENT.
 
@@ -275,7 +356,7 @@ INSTRUCCIONES:
 
 Si no conoces la respuesta exacta, sugiere contactar con la oficina al +34 96 681 02 90 o por email a info@sorianomediadores.es.`
 
-	respuesta, err := ai.ConsultarAI(consulta, systemPrompt)
+	respuesta, err := ai.ConsultarAIConHistorial(b.ID, systemPrompt, historial, consulta)
 	if err != nil {
 		return "Lo siento, no puedo procesar tu consulta en este momento. Por favor, contacta con nuestra oficina en horario de atención (L-V 9:00-14:00 y 16:00-19:00).", err
 	}
@@ -382,11 +463,12 @@ func extraerTerminoBusqueda(consulta string) string {
 	// Usar AI para extraer el término de búsqueda
 	systemPrompt := `Eres un extractor de datos para SORIANO MEDIADORES (correduría de seguros española con Occident).
 Extrae ÚNICAMENTE el término de búsqueda (nombre de persona/empresa, NIF/DNI/NIE/CIF, o IdAccount formato XXXXXXXX/XXX).
+Si hay conversación previa, extrae el término de la consulta actual.
 Responde SOLO con el término extraído, sin explicaciones ni texto adicional.`
-	termino, err := ai.ConsultarAI(consulta, systemPrompt)
+	termino, err := ai.ConsultarAIBot("bot_atencion", consulta, systemPrompt)
 	if err != nil {
 		// Fallback: usar la consulta completa
-		return consulta
+		return consultaActual(consulta)
 	}
 	return strings.TrimSpace(termino)
 }
@@ -400,8 +482,11 @@ Extrae ÚNICAMENTE el identificador del cliente de la consulta:
 - NIE: X/Y/Z + 7 dígitos + letra (ej: X1234567L)
 - CIF empresa: letra + 8 dígitos (ej: B12345678)
 
+Si la consulta actual no lo incluye (p. ej. "¿y sus recibos?"), usa el del cliente del que se habla
+en la conversación previa.
+
 Responde SOLO con el identificador encontrado, sin explicaciones. Si no encuentras ninguno, responde vacío.`
-	id, err := ai.ConsultarAI(consulta, systemPrompt)
+	id, err := ai.ConsultarAIBot("bot_atencion", consulta, systemPrompt)
 	if err != nil {
 		return ""
 	}
@@ -3,6 +3,7 @@ package bots
 import (
 	"database/sql"
 	"fmt"
+	"soriano-mediadores/internal/ai"
 	"soriano-mediadores/internal/db"
 	"strings"
 )
@@ -21,23 +22,64 @@ func NewBotAuditor() *BotAuditor {
 	}
 }
 
-// ProcesarConsulta procesa consultas de auditoría
-func (b *BotAuditor) ProcesarConsulta(sessionID string, mensaje string) (string, error) {
+func init() { RegistrarBot(NewBotAuditor()) }
+
+// Info presentación del bot en /api/bots y para el enrutador
+func (b *BotAuditor) Info() InfoBot {
+	return InfoBot{
+		ID:            "auditor",
+		BotID:         b.ID,
+		Nombre:        "Auditor de Calidad",
+		Descripcion:   "Detecta clientes duplicados, datos incompletos, problemas de integridad referencial.",
+		Ejemplos:      []string{"Busca duplicados", "Análisis de calidad de datos", "Datos huérfanos"},
+		PalabrasClave: []string{"duplicado", "calidad", "huerfano", "integridad", "incompleto", "auditoria"},
+	}
+}
+
+// ProcesarConsulta procesa consultas de auditoría con las comprobaciones de
+// calidad como herramientas
+func (b *BotAuditor) ProcesarConsulta(sessionID string, mensaje string, emitir Emisor) (*Respuesta, error) {
+	systemPrompt := `Eres el auditor de calidad de datos de SORIANO MEDIADORES, correduría de seguros española
+colaboradora exclusiva de GRUPO OCCIDENT.
+
+Usa las herramientas para ejecutar las comprobaciones sobre la base de datos y resume los hallazgos
+con su impacto y las acciones recomendadas. No inventes resultados. Responde en español de España.`
+
 	// Usar sistema de fallback primero
-	return ProcesarConFallback(b.ID, sessionID, mensaje, func(msg string) (string, error) {
-		// Si fallback no encuentra match, procesar con lógica original
-		mensajeLower := strings.ToLower(msg)
-
-		if strings.Contains(mensajeLower, "duplicado") {
-			return b.DetectarDuplicados()
-		} else if strings.Contains(mensajeLower, "calidad") || strings.Contains(mensajeLower, "integridad") {
-			return b.AnalisisCalidadDatos()
-		} else if strings.Contains(mensajeLower, "huerfano") || strings.Contains(mensajeLower, "fk") {
-			return b.DetectarDatosHuerfanos()
-		}
+	return ProcesarConFallback(b.ID, sessionID, mensaje, emitir, conHerramientas(b.ID, systemPrompt, b.Herramientas(), emitir, b.porPalabrasClave))
+}
+
+// Herramientas comprobaciones de calidad disponibles para el modelo
+func (b *BotAuditor) Herramientas() []Herramienta {
+	return []Herramienta{
+		SinArgumentos("detectar_duplicados",
+			"Busca posibles clientes duplicados por nombre",
+			b.DetectarDuplicados),
+		SinArgumentos("analisis_calidad_datos",
+			"Clientes sin email, teléfono o dirección y registros sin cliente o póliza",
+			b.AnalisisCalidadDatos),
+		SinArgumentos("detectar_datos_huerfanos",
+			"Pólizas, recibos y siniestros cuyo cliente o póliza no existe",
+			b.DetectarDatosHuerfanos),
+		SinArgumentos("auditoria_general",
+			"Auditoría completa: duplicados, calidad y datos huérfanos",
+			b.AuditoriaGeneral),
+	}
+}
+
+// porPalabrasClave enrutado sin function calling
+func (b *BotAuditor) porPalabrasClave(msg string, _ []ai.Message) (string, error) {
+	mensajeLower := strings.ToLower(msg)
+
+	if strings.Contains(mensajeLower, "duplicado") {
+		return b.DetectarDuplicados()
+	} else if strings.Contains(mensajeLower, "calidad") || strings.Contains(mensajeLower, "integridad") {
+		return b.AnalisisCalidadDatos()
+	} else if strings.Contains(mensajeLower, "huerfano") || strings.Contains(mensajeLower, "fk") {
+		return b.DetectarDatosHuerfanos()
+	}
 
-		return b.AuditoriaGeneral()
-	})
+	return b.AuditoriaGeneral()
 }
 
 // DetectarDuplicados busca posibles duplicados
@@ -22,26 +22,85 @@ func NewBotCobranza() *BotCobranza {
 	}
 }
 
-// ProcesarConsulta procesa consultas relacionadas con cobranza
-func (b *BotCobranza) ProcesarConsulta(sessionID string, mensaje string) (string, error) {
-	return ProcesarConFallback(b.ID, sessionID, mensaje, func(msg string) (string, error) {
-		mensajeLower := strings.ToLower(msg)
-
-		// Detectar tipo de consulta
-		if strings.Contains(mensajeLower, "pendiente") || strings.Contains(mensajeLower, "impagado") {
-			return b.ListarRecibosPendientes(100)
-		} else if strings.Contains(mensajeLower, "vencido") || strings.Contains(mensajeLower, "atrasado") {
-			return b.ListarRecibosVencidos()
-		} else if strings.Contains(mensajeLower, "contacto") || strings.Contains(mensajeLower, "llamar") {
-			return b.GenerarListaContacto()
-		} else if strings.Contains(mensajeLower, "estadistica") || strings.Contains(mensajeLower, "resumen") {
-			return b.ResumenCobranza()
-		} else if strings.Contains(mensajeLower, "mensaje") || strings.Contains(mensajeLower, "email") || strings.Contains(mensajeLower, "carta") {
-			return b.GenerarMensajeRecobro(msg)
-		}
+func init() { RegistrarBot(NewBotCobranza()) }
+
+// Info presentación del bot en /api/bots y para el enrutador
+func (b *BotCobranza) Info() InfoBot {
+	return InfoBot{
+		ID:            "cobranza",
+		BotID:         b.ID,
+		Nombre:        "Gestor de Cobranza",
+		Descripcion:   "Lista clientes morosos, recibos pendientes/vencidos, genera mensajes de recobro. Pregunta: 'Clientes que deben' o 'Recibos vencidos'",
+		Ejemplos:      []string{"Recibos pendientes", "Clientes morosos", "Genera carta de recobro nivel 2", "Lista de contacto para llamar"},
+		PalabrasClave: []string{"pendiente", "impagado", "vencido", "moroso", "recobro", "cobro", "cobranza", "deuda", "deben", "carta", "llamar"},
+	}
+}
+
+// ProcesarConsulta procesa consultas relacionadas con cobranza: el modelo
+// elige las herramientas y redacta la respuesta con sus resultados
+func (b *BotCobranza) ProcesarConsulta(sessionID string, mensaje string, emitir Emisor) (*Respuesta, error) {
+	systemPrompt := `Eres el gestor de cobranza de SORIANO MEDIADORES, correduría de seguros española
+colaboradora exclusiva de GRUPO OCCIDENT.
+
+Usa las herramientas para obtener los datos reales de recibos y clientes antes de responder.
+No inventes importes, recibos ni teléfonos: si una herramienta no devuelve datos, dilo.
+Responde en español de España, de forma clara y ordenada.`
+
+	return ProcesarConFallback(b.ID, sessionID, mensaje, emitir, conHerramientas(b.ID, systemPrompt, b.Herramientas(), emitir, b.porPalabrasClave))
+}
+
+// Herramientas consultas de cobranza disponibles para el modelo
+func (b *BotCobranza) Herramientas() []Herramienta {
+	return []Herramienta{
+		NuevaHerramienta("listar_recibos_pendientes",
+			"Lista los recibos pendientes de cobro, los más antiguos primero, con cliente, importe y fecha",
+			objeto(map[string]Schema{"limite": entero("Número máximo de recibos (por defecto 100)", 1, 200)}),
+			func(args struct {
+				Limite int `json:"limite"`
+			}) (interface{}, error) {
+				if args.Limite == 0 {
+					args.Limite = 100
+				}
+				return b.ListarRecibosPendientes(args.Limite)
+			}),
+		SinArgumentos("listar_recibos_vencidos",
+			"Lista los recibos pendientes con más de 30 días desde su emisión, con teléfono y email del cliente",
+			b.ListarRecibosVencidos),
+		SinArgumentos("lista_contacto_cobro",
+			"Clientes con recibos pendientes ordenados por deuda total, con sus datos de contacto",
+			b.GenerarListaContacto),
+		SinArgumentos("resumen_cobranza",
+			"Estadísticas de recibos cobrados, pendientes y anulados con sus importes",
+			b.ResumenCobranza),
+		NuevaHerramienta("generar_mensaje_recobro",
+			"Redacta una carta o email de recobro según los días de retraso (niveles 1 a 4)",
+			objeto(map[string]Schema{"contexto": texto("Cliente, recibo, importe, fecha de vencimiento y días de retraso")}, "contexto"),
+			func(args struct {
+				Contexto string `json:"contexto"`
+			}) (interface{}, error) {
+				return b.GenerarMensajeRecobro(args.Contexto)
+			}),
+	}
+}
 
+// porPalabrasClave enrutado sin function calling
+func (b *BotCobranza) porPalabrasClave(msg string, _ []ai.Message) (string, error) {
+	mensajeLower := strings.ToLower(msg)
+
+	// Detectar tipo de consulta
+	if strings.Contains(mensajeLower, "pendiente") || strings.Contains(mensajeLower, "impagado") {
+		return b.ListarRecibosPendientes(100)
+	} else if strings.Contains(mensajeLower, "vencido") || strings.Contains(mensajeLower, "atrasado") {
+		return b.ListarRecibosVencidos()
+	} else if strings.Contains(mensajeLower, "contacto") || strings.Contains(mensajeLower, "llamar") {
+		return b.GenerarListaContacto()
+	} else if strings.Contains(mensajeLower, "estadistica") || strings.Contains(mensajeLower, "resumen") {
 		return b.ResumenCobranza()
-	})
+	} else if strings.Contains(mensajeLower, "mensaje") || strings.Contains(mensajeLower, "email") || strings.Contains(mensajeLower, "carta") {
+		return b.GenerarMensajeRecobro(msg)
+	}
+
+	return b.ResumenCobranza()
 }
 
 // GenerarMensajeRecobro genera un mensaje de recobro personalizado usando AI
@@ -111,7 +170,7 @@ FORMATO DEL MENSAJE:
 
 Genera el mensaje apropiado según el contexto proporcionado.`
 
-	respuesta, err := ai.ConsultarAI(contexto, systemPrompt)
+	respuesta, err := ai.ConsultarAIBot(b.ID, contexto, systemPrompt)
 	if err != nil {
 		return "Error generando mensaje de recobro. Por favor, contacte con el departamento de cobros.", err
 	}
@@ -1,6 +1,7 @@
 package bots
 
 import (
+	"soriano-mediadores/internal/ai"
 	"soriano-mediadores/internal/db"
 	"time"
 )
@@ -8,41 +9,63 @@ import (
 // ProcesarConFallback es una función helper que intenta responder usando:
 // 1. Respuestas pre-programadas (fallback)
 // 2. Cache de Redis
-// 3. AI (solo si los anteriores fallan)
-func ProcesarConFallback(botID string, sessionID string, mensaje string, aiFunc func(string) (string, error)) (string, error) {
-	// Guardar en MongoDB
-	db.GuardarSesionBot(sessionID, botID, map[string]interface{}{
-		"tipo":    "consulta",
-		"mensaje": mensaje,
-	})
+// 3. AI (solo si los anteriores fallan), con el historial de la sesión
+// La consulta y la respuesta quedan en la conversación (memoria multi-turno).
+// Con emitir, las respuestas que no llegaron por fragmentos (fallback, caché,
+// rutas sin streaming) se entregan completas en un único token, precedidas de
+// un EventoReinicio si el stream del modelo se cortó a medias
+func ProcesarConFallback(botID string, sessionID string, mensaje string, emitir Emisor, aiFunc func(string, []ai.Message) (*Respuesta, error)) (*Respuesta, error) {
+	historial := Historial(sessionID, botID)
+	Registrar(sessionID, botID, RolUsuario, mensaje)
 
+	if aiFunc != nil && emitir != nil {
+		consultar := aiFunc
+		aiFunc = func(msg string, historial []ai.Message) (*Respuesta, error) {
+			respuesta, err := consultar(msg, historial)
+			emitir.reiniciar(err)
+			return respuesta, err
+		}
+	}
+	respuesta := procesarConFallback(botID, mensaje, historial, aiFunc)
+	if emitir != nil && !respuesta.transmitida {
+		emitir(Evento{Tipo: EventoToken, Texto: respuesta.Texto})
+	}
+	Registrar(sessionID, botID, RolAsistente, respuesta.Texto)
+	return respuesta, nil
+}
+
+func procesarConFallback(botID string, mensaje string, historial []ai.Message, aiFunc func(string, []ai.Message) (*Respuesta, error)) *Respuesta {
 	// PASO 1: Intentar respuesta desde fallback (instantáneo)
 	if respuesta, found := FindBestMatch(botID, mensaje); found {
 		// Cachear la respuesta para siguiente vez
 		cacheKey := "bot_response:" + botID + ":" + mensaje
 		db.CacheSet(cacheKey, respuesta, 24*time.Hour)
-		return respuesta, nil
+		return &Respuesta{Texto: respuesta}
 	}
 
-	// PASO 2: Verificar cache de Redis (muy rápido)
+	// PASO 2: Verificar cache de Redis (muy rápido). Solo sin historial: una
+	// pregunta de seguimiento depende de la conversación
 	var cachedResponse string
 	cacheKey := "bot_response:" + botID + ":" + mensaje
-	if db.CacheExists(cacheKey) {
+	if len(historial) == 0 && db.CacheExists(cacheKey) {
 		if err := db.CacheGet(cacheKey, &cachedResponse); err == nil {
-			return cachedResponse, nil
+			return &Respuesta{Texto: cachedResponse}
 		}
 	}
 
 	// PASO 3: Intentar con AI (más lento, solo si no hay alternativa)
 	if aiFunc != nil {
-		respuesta, err := aiFunc(mensaje)
+		respuesta, err := aiFunc(mensaje, historial)
 		if err == nil {
-			// Cachear la respuesta de AI para futuras consultas similares
-			db.CacheSet(cacheKey, respuesta, 24*time.Hour)
-			return respuesta, nil
+			// Cachear la respuesta de AI para futuras consultas similares; las
+			// que usan herramientas dependen de datos vivos y no se cachean
+			if len(historial) == 0 && len(respuesta.Traza) == 0 {
+				db.CacheSet(cacheKey, respuesta.Texto, 24*time.Hour)
+			}
+			return respuesta
 		}
 	}
 
 	// PASO 4: Si todo falla, respuesta genérica por defecto
-	return GetDefaultResponse(botID), nil
+	return &Respuesta{Texto: GetDefaultResponse(botID)}
 }
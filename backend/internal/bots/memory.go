@@ -0,0 +1,561 @@
+package bots
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"soriano-mediadores/internal/ai"
+	"soriano-mediadores/internal/db"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Roles de los turnos (los de la API de chat)
+const (
+	RolUsuario   = "user"
+	RolAsistente = "assistant"
+)
+
+var (
+	ErrConversacionNoEncontrada = errors.New("Conversación no encontrada")
+	ErrConversacionAjena        = errors.New("La conversación pertenece a otro usuario")
+)
+
+// Turno un mensaje de la conversación
+type Turno struct {
+	Rol       string    `json:"rol"`
+	Contenido string    `json:"contenido"`
+	BotID     string    `json:"bot_id"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Conversacion datos de una sesión de chat (session_id); los turnos se
+// guardan aparte, en la colección conversaciones
+type Conversacion struct {
+	SessionID string `json:"session_id" bson:"_id"`
+	UserID    string `json:"user_id" bson:"user_id"`
+	UserMail  string `json:"user_mail" bson:"user_mail"`
+	BotID     string `json:"bot_id" bson:"bot_id"` // Último bot que respondió
+	Titulo    string `json:"titulo" bson:"titulo"` // Primer mensaje del usuario
+	Mensajes  int    `json:"mensajes" bson:"mensajes"`
+	// Resumen de los turnos anteriores a ResumidoHasta (memoria deslizante)
+	Resumen       string    `json:"resumen,omitempty" bson:"resumen"`
+	ResumidoHasta time.Time `json:"resumido_hasta,omitempty" bson:"resumido_hasta"`
+	CreatedAt     time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+// ConversationStore almacén de conversaciones
+type ConversationStore interface {
+	// Abrir crea la conversación o comprueba que es del usuario
+	Abrir(sessionID, userID, userMail, botID string) (*Conversacion, error)
+	Get(sessionID string) (*Conversacion, error)
+	// Turnos turnos posteriores a desde, en orden
+	Turnos(sessionID string, desde time.Time) ([]Turno, error)
+	Agregar(sessionID string, turno Turno) error
+	GuardarResumen(sessionID, resumen string, hasta time.Time) error
+	// List conversaciones del usuario ("" = todas), las más recientes primero
+	List(userID string, limit int) ([]*Conversacion, error)
+	Delete(sessionID string) error
+}
+
+var (
+	conversationStore ConversationStore = newMemoryConversationStore()
+
+	// memoriaTokens presupuesto de tokens del historial (CHAT_MEMORY_TOKENS)
+	memoriaTokens = 1500
+)
+
+// InitMemoria usa MongoSessionsDB para las conversaciones (en memoria si
+// MongoDB no está disponible)
+func InitMemoria() {
+	if n, err := strconv.Atoi(os.Getenv("CHAT_MEMORY_TOKENS")); err == nil && n > 0 {
+		memoriaTokens = n
+	}
+	if db.MongoSessionsDB == nil {
+		log.Println("⚠️  MongoDB no disponible: las conversaciones se guardan en memoria")
+		return
+	}
+	conversationStore = &mongoConversationStore{db: db.MongoSessionsDB}
+}
+
+// AbrirConversacion asocia la sesión de chat al usuario
+func AbrirConversacion(sessionID, userID, userMail, botID string) error {
+	_, err := conversationStore.Abrir(sessionID, userID, userMail, botID)
+	return err
+}
+
+// ObtenerConversacion conversación con todos sus turnos
+func ObtenerConversacion(sessionID string) (*Conversacion, []Turno, error) {
+	conv, err := conversationStore.Get(sessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	turnos, err := conversationStore.Turnos(sessionID, time.Time{})
+	if err != nil {
+		return nil, nil, err
+	}
+	return conv, turnos, nil
+}
+
+// ListarConversaciones conversaciones del usuario ("" = todas)
+func ListarConversaciones(userID string, limit int) ([]*Conversacion, error) {
+	return conversationStore.List(userID, limit)
+}
+
+// BorrarConversacion borra la conversación y sus turnos
+func BorrarConversacion(sessionID string) error {
+	return conversationStore.Delete(sessionID)
+}
+
+// Registrar añade un turno a la conversación (no es crítico si falla)
+func Registrar(sessionID, botID, rol, contenido string) {
+	if sessionID == "" {
+		return
+	}
+	turno := Turno{Rol: rol, Contenido: contenido, BotID: botID, Timestamp: time.Now()}
+	if err := conversationStore.Agregar(sessionID, turno); err != nil {
+		log.Printf("⚠️  Error guardando turno de %s: %v", sessionID, err)
+	}
+}
+
+// Historial mensajes previos de la sesión para el LLM: el resumen de lo
+// antiguo y los turnos recientes que caben en memoriaTokens. Cuando se pasa
+// del presupuesto, los turnos más antiguos se incorporan al resumen
+func Historial(sessionID, botID string) []ai.Message {
+	if sessionID == "" {
+		return nil
+	}
+	conv, err := conversationStore.Get(sessionID)
+	if err != nil {
+		if err != ErrConversacionNoEncontrada {
+			log.Printf("⚠️  Error cargando conversación %s: %v", sessionID, err)
+		}
+		return nil
+	}
+	turnos, err := conversationStore.Turnos(sessionID, conv.ResumidoHasta)
+	if err != nil {
+		log.Printf("⚠️  Error cargando turnos de %s: %v", sessionID, err)
+		return nil
+	}
+
+	// Una cuarta parte del presupuesto para el resumen y el resto para los
+	// turnos recientes; ningún turno ocupa más de una cuarta parte
+	maxParte := memoriaTokens / 4
+	total := estimarTokens(conv.Resumen)
+	for i := range turnos {
+		turnos[i].Contenido = recortarTokens(turnos[i].Contenido, maxParte)
+		total += estimarTokens(turnos[i].Contenido)
+	}
+
+	if total > memoriaTokens && len(turnos) > 1 {
+		// Se quedan los turnos recientes que caben; el resto pasa al resumen
+		keep, usados := 0, 0
+		for i := len(turnos) - 1; i >= 0; i-- {
+			t := estimarTokens(turnos[i].Contenido)
+			if usados+t > memoriaTokens-maxParte {
+				break
+			}
+			keep++
+			usados += t
+		}
+		viejos := turnos[:len(turnos)-keep]
+		turnos = turnos[len(turnos)-keep:]
+
+		if len(viejos) > 0 {
+			resumen, err := resumirTurnos(botID, conv.Resumen, viejos)
+			if err != nil {
+				log.Printf("⚠️  Error resumiendo conversación %s: %v", sessionID, err)
+			} else {
+				conv.Resumen = resumen
+				if err := conversationStore.GuardarResumen(sessionID, resumen, viejos[len(viejos)-1].Timestamp); err != nil {
+					log.Printf("⚠️  Error guardando resumen de %s: %v", sessionID, err)
+				}
+			}
+		}
+	}
+
+	historial := make([]ai.Message, 0, len(turnos)+1)
+	if conv.Resumen != "" {
+		historial = append(historial, ai.Message{
+			Role:    "system",
+			Content: "Resumen de la conversación anterior:\n" + recortarTokens(conv.Resumen, maxParte),
+		})
+	}
+	for _, t := range turnos {
+		historial = append(historial, ai.Message{Role: t.Rol, Content: t.Contenido})
+	}
+	return historial
+}
+
+// resumirTurnos actualiza el resumen con los turnos que salen del historial
+func resumirTurnos(botID, resumen string, turnos []Turno) (string, error) {
+	systemPrompt := `Resumes conversaciones entre empleados de SORIANO MEDIADORES y su asistente interno.
+Escribe un resumen breve (máximo 120 palabras) en español de España que permita continuar la conversación.
+Conserva SIEMPRE: nombres de clientes, IdAccount, NIF/NIE/CIF, números de póliza, recibo o siniestro,
+y lo que el empleado estaba consultando. Responde SOLO con el resumen.`
+
+	var sb strings.Builder
+	if resumen != "" {
+		sb.WriteString("Resumen anterior:\n" + resumen + "\n\n")
+	}
+	sb.WriteString("Nuevos mensajes:\n")
+	for _, t := range turnos {
+		sb.WriteString(etiquetaRol(t.Rol) + ": " + t.Contenido + "\n")
+	}
+	out, err := ai.ConsultarAIBot(botID, sb.String(), systemPrompt)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// conContexto consulta con la conversación previa en texto, para los
+// extractores que solo reciben un mensaje («¿y sus recibos?»)
+func conContexto(historial []ai.Message, consulta string) string {
+	if len(historial) == 0 {
+		return consulta
+	}
+	var sb strings.Builder
+	sb.WriteString("Conversación previa:\n")
+	for _, m := range historial {
+		sb.WriteString(etiquetaRol(m.Role) + ": " + recortarTokens(m.Content, 150) + "\n")
+	}
+	sb.WriteString("\nConsulta actual: " + consulta)
+	return sb.String()
+}
+
+// consultaActual el mensaje original de un texto de conContexto
+func consultaActual(consulta string) string {
+	if i := strings.LastIndex(consulta, "\nConsulta actual: "); i >= 0 {
+		return consulta[i+len("\nConsulta actual: "):]
+	}
+	return consulta
+}
+
+func etiquetaRol(rol string) string {
+	switch rol {
+	case RolUsuario:
+		return "Empleado"
+	case RolAsistente:
+		return "Asistente"
+	}
+	return "Resumen"
+}
+
+// estimarTokens aproximación de ~4 caracteres por token
+func estimarTokens(s string) int {
+	if s == "" {
+		return 0
+	}
+	return utf8.RuneCountInString(s)/4 + 1
+}
+
+// recortarTokens corta el texto a unos max tokens
+func recortarTokens(s string, max int) string {
+	if estimarTokens(s) <= max {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:max*4]) + "…"
+}
+
+// ========== ALMACÉN EN MEMORIA ==========
+
+type memoryConversationStore struct {
+	mu     sync.Mutex
+	convs  map[string]*Conversacion
+	turnos map[string][]Turno
+}
+
+func newMemoryConversationStore() *memoryConversationStore {
+	return &memoryConversationStore{convs: make(map[string]*Conversacion), turnos: make(map[string][]Turno)}
+}
+
+func (m *memoryConversationStore) Abrir(sessionID, userID, userMail, botID string) (*Conversacion, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	conv, ok := m.convs[sessionID]
+	if !ok {
+		now := time.Now()
+		conv = &Conversacion{SessionID: sessionID, UserID: userID, UserMail: userMail, BotID: botID, CreatedAt: now, UpdatedAt: now}
+		m.convs[sessionID] = conv
+	}
+	if conv.UserID == "" {
+		conv.UserID, conv.UserMail = userID, userMail
+	}
+	if conv.UserID != userID {
+		return nil, ErrConversacionAjena
+	}
+	copied := *conv
+	return &copied, nil
+}
+
+func (m *memoryConversationStore) Get(sessionID string) (*Conversacion, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	conv, ok := m.convs[sessionID]
+	if !ok {
+		return nil, ErrConversacionNoEncontrada
+	}
+	copied := *conv
+	return &copied, nil
+}
+
+func (m *memoryConversationStore) Turnos(sessionID string, desde time.Time) ([]Turno, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []Turno
+	for _, t := range m.turnos[sessionID] {
+		if t.Timestamp.After(desde) {
+			out = append(out, t)
+		}
+	}
+	return out, nil
+}
+
+func (m *memoryConversationStore) Agregar(sessionID string, turno Turno) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	conv, ok := m.convs[sessionID]
+	if !ok {
+		conv = &Conversacion{SessionID: sessionID, CreatedAt: turno.Timestamp}
+		m.convs[sessionID] = conv
+	}
+	if conv.Titulo == "" && turno.Rol == RolUsuario {
+		conv.Titulo = recortarTokens(turno.Contenido, 20)
+	}
+	conv.BotID, conv.UpdatedAt = turno.BotID, turno.Timestamp
+	conv.Mensajes++
+	m.turnos[sessionID] = append(m.turnos[sessionID], turno)
+	return nil
+}
+
+func (m *memoryConversationStore) GuardarResumen(sessionID, resumen string, hasta time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	conv, ok := m.convs[sessionID]
+	if !ok {
+		return ErrConversacionNoEncontrada
+	}
+	conv.Resumen, conv.ResumidoHasta = resumen, hasta
+	return nil
+}
+
+func (m *memoryConversationStore) List(userID string, limit int) ([]*Conversacion, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []*Conversacion
+	for _, conv := range m.convs {
+		if userID == "" || conv.UserID == userID {
+			copied := *conv
+			out = append(out, &copied)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].UpdatedAt.After(out[j].UpdatedAt) })
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+func (m *memoryConversationStore) Delete(sessionID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.convs[sessionID]; !ok {
+		return ErrConversacionNoEncontrada
+	}
+	delete(m.convs, sessionID)
+	delete(m.turnos, sessionID)
+	return nil
+}
+
+// ========== ALMACÉN EN MONGODB ==========
+
+// mongoConversationStore turnos en la colección conversaciones (los
+// documentos de GuardarSesionBot, tipo consulta/respuesta) y datos de cada
+// sesión en conversaciones_sesiones
+type mongoConversationStore struct {
+	db *mongo.Database
+}
+
+// turnoDoc documento de GuardarSesionBot
+type turnoDoc struct {
+	BotID   string `bson:"bot_id"`
+	Mensaje struct {
+		Tipo    string `bson:"tipo"`
+		Mensaje string `bson:"mensaje"`
+	} `bson:"mensaje"`
+	Timestamp time.Time `bson:"timestamp"`
+}
+
+func (s *mongoConversationStore) ctx() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), 5*time.Second)
+}
+
+func (s *mongoConversationStore) sesiones() *mongo.Collection {
+	return s.db.Collection("conversaciones_sesiones")
+}
+
+func (s *mongoConversationStore) Abrir(sessionID, userID, userMail, botID string) (*Conversacion, error) {
+	ctx, cancel := s.ctx()
+	defer cancel()
+	now := time.Now()
+	// Se reclama la conversación si no existe o si aún no tiene usuario
+	_, err := s.sesiones().UpdateOne(ctx,
+		bson.M{"_id": sessionID, "user_id": bson.M{"$in": bson.A{"", nil}}},
+		bson.M{
+			"$set":         bson.M{"user_id": userID, "user_mail": userMail},
+			"$setOnInsert": bson.M{"bot_id": botID, "titulo": "", "mensajes": 0, "created_at": now, "updated_at": now},
+		},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return nil, err
+	}
+	conv, err := s.Get(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if conv.UserID != userID {
+		return nil, ErrConversacionAjena
+	}
+	return conv, nil
+}
+
+func (s *mongoConversationStore) Get(sessionID string) (*Conversacion, error) {
+	ctx, cancel := s.ctx()
+	defer cancel()
+	var conv Conversacion
+	if err := s.sesiones().FindOne(ctx, bson.M{"_id": sessionID}).Decode(&conv); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrConversacionNoEncontrada
+		}
+		return nil, err
+	}
+	return &conv, nil
+}
+
+func (s *mongoConversationStore) Turnos(sessionID string, desde time.Time) ([]Turno, error) {
+	ctx, cancel := s.ctx()
+	defer cancel()
+	cursor, err := s.db.Collection("conversaciones").Find(ctx,
+		bson.M{"session_id": sessionID, "timestamp": bson.M{"$gt": desde}},
+		options.Find().SetSort(bson.D{{Key: "timestamp", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var docs []turnoDoc
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	turnos := make([]Turno, 0, len(docs))
+	for _, d := range docs {
+		rol := RolUsuario
+		if d.Mensaje.Tipo == "respuesta" {
+			rol = RolAsistente
+		}
+		turnos = append(turnos, Turno{Rol: rol, Contenido: d.Mensaje.Mensaje, BotID: d.BotID, Timestamp: d.Timestamp})
+	}
+	return turnos, nil
+}
+
+func (s *mongoConversationStore) Agregar(sessionID string, turno Turno) error {
+	tipo := "consulta"
+	if turno.Rol == RolAsistente {
+		tipo = "respuesta"
+	}
+	if err := db.GuardarSesionBot(sessionID, turno.BotID, map[string]interface{}{
+		"tipo":    tipo,
+		"mensaje": turno.Contenido,
+	}); err != nil {
+		return err
+	}
+
+	ctx, cancel := s.ctx()
+	defer cancel()
+	_, err := s.sesiones().UpdateOne(ctx,
+		bson.M{"_id": sessionID},
+		bson.M{
+			"$set":         bson.M{"bot_id": turno.BotID, "updated_at": turno.Timestamp},
+			"$inc":         bson.M{"mensajes": 1},
+			"$setOnInsert": bson.M{"user_id": "", "titulo": "", "created_at": turno.Timestamp},
+		},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil || turno.Rol != RolUsuario {
+		return err
+	}
+	_, err = s.sesiones().UpdateOne(ctx,
+		bson.M{"_id": sessionID, "titulo": ""},
+		bson.M{"$set": bson.M{"titulo": recortarTokens(turno.Contenido, 20)}},
+	)
+	return err
+}
+
+func (s *mongoConversationStore) GuardarResumen(sessionID, resumen string, hasta time.Time) error {
+	ctx, cancel := s.ctx()
+	defer cancel()
+	res, err := s.sesiones().UpdateOne(ctx,
+		bson.M{"_id": sessionID},
+		bson.M{"$set": bson.M{"resumen": resumen, "resumido_hasta": hasta}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrConversacionNoEncontrada
+	}
+	return nil
+}
+
+func (s *mongoConversationStore) List(userID string, limit int) ([]*Conversacion, error) {
+	ctx, cancel := s.ctx()
+	defer cancel()
+	filter := bson.M{}
+	if userID != "" {
+		filter["user_id"] = userID
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "updated_at", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	cursor, err := s.sesiones().Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var out []*Conversacion
+	if err := cursor.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (s *mongoConversationStore) Delete(sessionID string) error {
+	ctx, cancel := s.ctx()
+	defer cancel()
+	res, err := s.sesiones().DeleteOne(ctx, bson.M{"_id": sessionID})
+	if err != nil {
+		return err
+	}
+	if _, err := s.db.Collection("conversaciones").DeleteMany(ctx, bson.M{"session_id": sessionID}); err != nil {
+		return fmt.Errorf("error borrando turnos: %w", err)
+	}
+	if res.DeletedCount == 0 {
+		return ErrConversacionNoEncontrada
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package bots
+
+import (
+	"strings"
+	"testing"
+
+	"soriano-mediadores/internal/ai"
+)
+
+func useMemoryConversations(t *testing.T) *memoryConversationStore {
+	t.Helper()
+	store := newMemoryConversationStore()
+	prev, prevTokens := conversationStore, memoriaTokens
+	conversationStore = store
+	t.Cleanup(func() { conversationStore, memoriaTokens = prev, prevTokens })
+	return store
+}
+
+func TestHistorialResumenDeslizante(t *testing.T) {
+	store := useMemoryConversations(t)
+	memoriaTokens = 100
+	stub := &ai.StubProvider{Reply: "Se consultó a Ana García (20777103/000) y sus pólizas de auto y hogar."}
+	ai.SetProvider("bot_memoria", stub)
+
+	if err := AbrirConversacion("s1", "u-ana", "ana@sorianomediadores.es", "bot_memoria"); err != nil {
+		t.Fatal(err)
+	}
+	if err := AbrirConversacion("s1", "u-luis", "luis@sorianomediadores.es", "bot_memoria"); err != ErrConversacionAjena {
+		t.Fatalf("conversación de otro usuario: %v", err)
+	}
+
+	// Por debajo del presupuesto: el historial es la conversación tal cual
+	Registrar("s1", "bot_memoria", RolUsuario, "Busca a Ana García")
+	Registrar("s1", "bot_memoria", RolAsistente, "Ana García, IdAccount 20777103/000")
+	if h := Historial("s1", "bot_memoria"); len(h) != 2 || h[0].Role != RolUsuario || stub.Calls() != 0 {
+		t.Fatalf("historial corto = %+v", h)
+	}
+
+	for i := 0; i < 4; i++ {
+		Registrar("s1", "bot_memoria", RolUsuario, "¿y sus pólizas? "+strings.Repeat("detalle ", 15))
+		Registrar("s1", "bot_memoria", RolAsistente, "Pólizas: "+strings.Repeat("auto hogar ", 15))
+	}
+
+	// Se pasa del presupuesto: lo antiguo se resume y quedan los turnos recientes
+	h := Historial("s1", "bot_memoria")
+	if stub.Calls() != 1 || h[0].Role != "system" || !strings.Contains(h[0].Content, "20777103/000") {
+		t.Fatalf("historial resumido = %+v", h)
+	}
+	if !strings.Contains(stub.Requests[0].Messages[1].Content, "Busca a Ana García") {
+		t.Fatal("el resumen no incluye los turnos antiguos")
+	}
+	total := 0
+	for _, m := range h {
+		total += estimarTokens(m.Content)
+	}
+	if total > memoriaTokens || len(h) < 3 {
+		t.Fatalf("historial de %d tokens y %d mensajes", total, len(h))
+	}
+	conv, _ := store.Get("s1")
+	if conv.Resumen == "" || conv.ResumidoHasta.IsZero() || conv.Mensajes != 10 || conv.Titulo != "Busca a Ana García" {
+		t.Fatalf("conversación = %+v", conv)
+	}
+
+	// El resumen guardado se reutiliza: no se vuelve a resumir
+	if h2 := Historial("s1", "bot_memoria"); len(h2) != len(h) || stub.Calls() != 1 {
+		t.Fatalf("segundo historial = %d mensajes, %d resúmenes", len(h2), stub.Calls())
+	}
+
+	// La transcripción completa sigue disponible
+	_, turnos, err := ObtenerConversacion("s1")
+	if err != nil || len(turnos) != 10 {
+		t.Fatalf("transcripción: %d turnos, %v", len(turnos), err)
+	}
+	if err := BorrarConversacion("s1"); err != nil || Historial("s1", "bot_memoria") != nil {
+		t.Fatalf("borrar: %v", err)
+	}
+}
+
+func TestConContexto(t *testing.T) {
+	historial := []ai.Message{
+		{Role: RolUsuario, Content: "Busca a Ana García"},
+		{Role: RolAsistente, Content: "Ana García, IdAccount 20777103/000"},
+	}
+	consulta := conContexto(historial, "¿y sus recibos?")
+	if !strings.Contains(consulta, "Asistente: Ana García, IdAccount 20777103/000") {
+		t.Fatalf("contexto = %q", consulta)
+	}
+	if consultaActual(consulta) != "¿y sus recibos?" || conContexto(nil, "hola") != "hola" {
+		t.Fatalf("consulta actual = %q", consultaActual(consulta))
+	}
+}
@@ -0,0 +1,65 @@
+package bots
+
+import (
+	"log"
+	"sync"
+)
+
+// Bot interfaz común de los bots de chat. Cada bot se registra en el init
+// de su fichero con RegistrarBot y aparece en /api/bots y en el enrutador
+type Bot interface {
+	Info() InfoBot
+	// ProcesarConsulta responde al mensaje con la memoria de la sesión;
+	// con emitir la respuesta llega en streaming
+	ProcesarConsulta(sessionID, mensaje string, emitir Emisor) (*Respuesta, error)
+}
+
+// InfoBot presentación de un bot
+type InfoBot struct {
+	ID          string   `json:"id"` // Nombre en las rutas: /api/chat/<id>
+	Nombre      string   `json:"nombre"`
+	Descripcion string   `json:"descripcion"` // También la lee el enrutador
+	Ejemplos    []string `json:"ejemplos"`
+	// BotID identificador interno (bot_<id>): memoria, proveedor LLM, fallback
+	BotID string `json:"-"`
+	// PalabrasClave enrutado cuando el modelo no está disponible
+	PalabrasClave []string `json:"-"`
+}
+
+var (
+	registroMu sync.RWMutex
+	registro   []Bot
+)
+
+// RegistrarBot añade un bot al registro (un ID repetido sustituye al anterior)
+func RegistrarBot(b Bot) {
+	registroMu.Lock()
+	defer registroMu.Unlock()
+	for i, r := range registro {
+		if r.Info().ID == b.Info().ID {
+			log.Printf("⚠️  Bot %s registrado dos veces", b.Info().ID)
+			registro[i] = b
+			return
+		}
+	}
+	registro = append(registro, b)
+}
+
+// ListaBots bots registrados, en orden de registro
+func ListaBots() []Bot {
+	registroMu.RLock()
+	defer registroMu.RUnlock()
+	return append([]Bot(nil), registro...)
+}
+
+// ObtenerBot bot por su ID de ruta ("cobranza") o interno ("bot_cobranza")
+func ObtenerBot(id string) (Bot, bool) {
+	registroMu.RLock()
+	defer registroMu.RUnlock()
+	for _, b := range registro {
+		if info := b.Info(); info.ID == id || info.BotID == id {
+			return b, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,142 @@
+package bots
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"soriano-mediadores/internal/ai"
+)
+
+// RouterID identificador del enrutador (LLM_PROVIDER_BOT_ROUTER)
+const RouterID = "bot_router"
+
+// botPorDefecto responde cuando no hay ninguna pista de intención
+const botPorDefecto = "atencion"
+
+// Motivos de una decisión de enrutado
+const (
+	MotivoModelo        = "modelo"         // El modelo clasificó la intención
+	MotivoPalabrasClave = "palabras_clave" // Sin modelo: palabras clave de los bots
+	MotivoConversacion  = "conversacion"   // Sin pistas: sigue el bot de la conversación
+	MotivoDefecto       = "defecto"
+)
+
+// Enrutado bot elegido para un mensaje del chat unificado
+type Enrutado struct {
+	Bot Bot
+	// Anterior ID del bot que respondió el último turno ("" si es nueva)
+	Anterior string
+	Motivo   string
+}
+
+// Traspaso la conversación pasa a otro bot (p. ej. atención → cobranza)
+func (e Enrutado) Traspaso() bool {
+	return e.Anterior != "" && e.Anterior != e.Bot.Info().ID
+}
+
+// Enrutar elige el bot que responde al mensaje: el modelo clasifica la
+// intención con las descripciones de los bots registrados, el bot actual y
+// la conversación previa; sin modelo se puntúan las palabras clave
+func Enrutar(sessionID, mensaje string) Enrutado {
+	disponibles := ListaBots()
+	var anterior Bot
+	if conv, err := conversationStore.Get(sessionID); err == nil && conv.BotID != "" {
+		anterior, _ = ObtenerBot(conv.BotID)
+	}
+	e := Enrutado{}
+	botIDHistorial := RouterID
+	if anterior != nil {
+		e.Anterior = anterior.Info().ID
+		botIDHistorial = anterior.Info().BotID
+	}
+
+	historial := Historial(sessionID, botIDHistorial)
+	respuesta, err := ai.ConsultarAIConHistorial(RouterID, promptEnrutador(disponibles, e.Anterior), historial, mensaje)
+	if err == nil {
+		if b := botEnRespuesta(disponibles, respuesta); b != nil {
+			e.Bot, e.Motivo = b, MotivoModelo
+			return e
+		}
+		log.Printf("⚠️  Enrutador: respuesta sin bot válido: %q", respuesta)
+	} else {
+		log.Printf("⚠️  Enrutador sin modelo, por palabras clave: %v", err)
+	}
+
+	if b := botPorPalabrasClave(disponibles, mensaje); b != nil {
+		e.Bot, e.Motivo = b, MotivoPalabrasClave
+	} else if anterior != nil {
+		e.Bot, e.Motivo = anterior, MotivoConversacion
+	} else if b, ok := ObtenerBot(botPorDefecto); ok {
+		e.Bot, e.Motivo = b, MotivoDefecto
+	} else if len(disponibles) > 0 {
+		e.Bot, e.Motivo = disponibles[0], MotivoDefecto
+	}
+	return e
+}
+
+func promptEnrutador(disponibles []Bot, anterior string) string {
+	var sb strings.Builder
+	sb.WriteString(`Eres el enrutador del chat interno de SORIANO MEDIADORES, correduría de seguros española
+colaboradora exclusiva de GRUPO OCCIDENT. Elige el asistente que debe responder al mensaje del usuario.
+
+ASISTENTES:
+`)
+	for _, b := range disponibles {
+		info := b.Info()
+		sb.WriteString(fmt.Sprintf("- %s: %s\n", info.ID, info.Descripcion))
+	}
+	if anterior != "" {
+		sb.WriteString(fmt.Sprintf(`
+La conversación la lleva ahora el asistente "%s". Mantenlo si el mensaje sigue con el mismo tema
+(«¿y sus recibos?» tras consultar un cliente); cambia de asistente si el usuario pide algo que
+corresponde a otro (p. ej. tras consultar un cliente, generar una carta de recobro → cobranza).
+`, anterior))
+	}
+	sb.WriteString("\nResponde SOLO con el identificador del asistente, sin explicaciones.")
+	return sb.String()
+}
+
+// botEnRespuesta bot cuyo ID aparece en la respuesta del modelo
+func botEnRespuesta(disponibles []Bot, respuesta string) Bot {
+	respuesta = strings.ToLower(strings.TrimSpace(respuesta))
+	for _, b := range disponibles {
+		if respuesta == b.Info().ID {
+			return b
+		}
+	}
+	// «Asistente: cobranza.» o similares
+	for _, b := range disponibles {
+		if strings.Contains(respuesta, b.Info().ID) {
+			return b
+		}
+	}
+	return nil
+}
+
+// botPorPalabrasClave bot con más palabras clave en el mensaje (nil si
+// ninguna); en un empate gana el especializado sobre el bot por defecto, cuyas
+// palabras («cliente», «póliza») aparecen en casi cualquier consulta
+func botPorPalabrasClave(disponibles []Bot, mensaje string) Bot {
+	texto := sinTildes(strings.ToLower(mensaje))
+	var mejor Bot
+	mejorPuntos := 0
+	for _, b := range disponibles {
+		puntos := 0
+		for _, palabra := range b.Info().PalabrasClave {
+			if strings.Contains(texto, palabra) {
+				puntos++
+			}
+		}
+		if puntos > mejorPuntos || (puntos > 0 && puntos == mejorPuntos && mejor.Info().ID == botPorDefecto) {
+			mejor, mejorPuntos = b, puntos
+		}
+	}
+	return mejor
+}
+
+var tildes = strings.NewReplacer("á", "a", "é", "e", "í", "i", "ó", "o", "ú", "u", "ü", "u", "ñ", "n")
+
+func sinTildes(s string) string {
+	return tildes.Replace(s)
+}
@@ -0,0 +1,95 @@
+package bots
+
+import (
+	"errors"
+	"testing"
+
+	"soriano-mediadores/internal/ai"
+)
+
+func TestRegistroBots(t *testing.T) {
+	lista := ListaBots()
+	if len(lista) != 6 {
+		t.Fatalf("bots registrados = %d", len(lista))
+	}
+	for _, id := range []string{"atencion", "cobranza", "siniestros", "agente", "analista", "auditor"} {
+		b, ok := ObtenerBot(id)
+		if !ok {
+			t.Fatalf("falta el bot %s", id)
+		}
+		info := b.Info()
+		if info.BotID != "bot_"+id || info.Nombre == "" || info.Descripcion == "" || len(info.PalabrasClave) == 0 {
+			t.Errorf("info de %s = %+v", id, info)
+		}
+		if porBotID, _ := ObtenerBot(info.BotID); porBotID != b {
+			t.Errorf("ObtenerBot(%s) no devuelve el mismo bot", info.BotID)
+		}
+	}
+}
+
+func TestEnrutarModelo(t *testing.T) {
+	useMemoryConversations(t)
+	stub := &ai.StubProvider{Replies: map[string]string{
+		"Genera una carta de recobro para 20777103/000": "cobranza",
+		"¿y qué es un PIAS?":                            "Asistente: agente.",
+		"hola":                                          "no lo sé",
+	}}
+	ai.SetProvider(RouterID, stub)
+
+	e := Enrutar("r1", "Genera una carta de recobro para 20777103/000")
+	if e.Bot.Info().ID != "cobranza" || e.Motivo != MotivoModelo || e.Traspaso() {
+		t.Fatalf("enrutado = %+v", e)
+	}
+	if e := Enrutar("r1", "¿y qué es un PIAS?"); e.Bot.Info().ID != "agente" {
+		t.Fatalf("respuesta con texto alrededor: %+v", e)
+	}
+
+	// Respuesta sin bot válido y sin palabras clave: bot por defecto
+	if e := Enrutar("r1", "hola"); e.Bot.Info().ID != botPorDefecto || e.Motivo != MotivoDefecto {
+		t.Fatalf("sin pistas = %+v", e)
+	}
+}
+
+func TestEnrutarTraspaso(t *testing.T) {
+	useMemoryConversations(t)
+	stub := &ai.StubProvider{Reply: "cobranza"}
+	ai.SetProvider(RouterID, stub)
+
+	Registrar("r2", "bot_atencion", RolUsuario, "Busca a Ana García")
+	Registrar("r2", "bot_atencion", RolAsistente, "Ana García, IdAccount 20777103/000, 2 recibos pendientes")
+
+	e := Enrutar("r2", "Redacta la carta de recobro")
+	if e.Anterior != "atencion" || e.Bot.Info().ID != "cobranza" || !e.Traspaso() {
+		t.Fatalf("enrutado = %+v", e)
+	}
+	// El enrutador ve la conversación y el bot que la lleva
+	req := stub.Requests[0]
+	if len(req.Messages) != 4 || req.Messages[1].Content != "Busca a Ana García" {
+		t.Fatalf("mensajes = %+v", req.Messages)
+	}
+}
+
+func TestEnrutarSinModelo(t *testing.T) {
+	useMemoryConversations(t)
+	ai.SetProvider(RouterID, &ai.StubProvider{Err: errors.New("sin proveedor")})
+
+	casos := map[string]string{
+		"Tengo recibos impagados de Ana":         "cobranza",
+		"Un cliente ha tenido un accidente":      "siniestros",
+		"Auditoría de pólizas sin teléfono":      "auditor",
+		"Dame las estadísticas de la cartera":    "analista",
+		"Busca a Ana García":                     "atencion",
+		"Recomiéndame un producto de jubilación": "agente",
+	}
+	for mensaje, id := range casos {
+		if e := Enrutar("r3", mensaje); e.Bot.Info().ID != id || e.Motivo != MotivoPalabrasClave {
+			t.Errorf("%q → %s (%s), se esperaba %s", mensaje, e.Bot.Info().ID, e.Motivo, id)
+		}
+	}
+
+	// Sin palabras clave sigue el bot de la conversación
+	Registrar("r4", "bot_siniestros", RolAsistente, "Siniestro abierto")
+	if e := Enrutar("r4", "gracias"); e.Bot.Info().ID != "siniestros" || e.Motivo != MotivoConversacion || e.Traspaso() {
+		t.Fatalf("sin pistas = %+v", e)
+	}
+}
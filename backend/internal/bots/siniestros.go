@@ -21,23 +21,72 @@ func NewBotSiniestros() *BotSiniestros {
 	}
 }
 
-// ProcesarConsulta procesa consultas de siniestros
-func (b *BotSiniestros) ProcesarConsulta(sessionID string, mensaje string) (string, error) {
-	return ProcesarConFallback(b.ID, sessionID, mensaje, func(msg string) (string, error) {
-		mensajeLower := strings.ToLower(msg)
-
-		if strings.Contains(mensajeLower, "abierto") || strings.Contains(mensajeLower, "pendiente") {
-			return b.ListarSiniestrosAbiertos()
-		} else if strings.Contains(mensajeLower, "estadistica") || strings.Contains(mensajeLower, "resumen") {
-			return b.ResumenSiniestros()
-		} else if strings.Contains(mensajeLower, "tramitador") {
-			return b.EstadisticasPorTramitador()
-		} else if strings.Contains(mensajeLower, "documento") || strings.Contains(mensajeLower, "necesito") || strings.Contains(mensajeLower, "parte") {
-			return b.AsesorarDocumentacion(msg)
-		}
+func init() { RegistrarBot(NewBotSiniestros()) }
+
+// Info presentación del bot en /api/bots y para el enrutador
+func (b *BotSiniestros) Info() InfoBot {
+	return InfoBot{
+		ID:            "siniestros",
+		BotID:         b.ID,
+		Nombre:        "Gestor de Siniestros",
+		Descripcion:   "Lista siniestros abiertos, estadísticas por tramitador, documentación necesaria para partes. Pregunta: 'Siniestros abiertos' o '¿Qué documentos necesito para un parte de auto?'",
+		Ejemplos:      []string{"Siniestros abiertos", "Estadísticas por tramitador", "¿Qué documentos necesito para un siniestro de hogar?"},
+		PalabrasClave: []string{"siniestro", "parte", "tramitador", "documento", "accidente", "robo", "danos"},
+	}
+}
+
+// ProcesarConsulta procesa consultas de siniestros con las herramientas
+// del departamento
+func (b *BotSiniestros) ProcesarConsulta(sessionID string, mensaje string, emitir Emisor) (*Respuesta, error) {
+	systemPrompt := `Eres el departamento de siniestros de SORIANO MEDIADORES, correduría de seguros española
+colaboradora exclusiva de GRUPO OCCIDENT.
+
+Usa las herramientas para consultar los siniestros reales y la carga de los tramitadores antes de responder.
+Para dudas de documentación o pasos a seguir usa asesorar_documentacion.
+No inventes números de siniestro ni datos: si una herramienta no devuelve datos, dilo.
+Responde en español de España.`
+
+	return ProcesarConFallback(b.ID, sessionID, mensaje, emitir, conHerramientas(b.ID, systemPrompt, b.Herramientas(), emitir, b.porPalabrasClave))
+}
 
+// Herramientas consultas de siniestros disponibles para el modelo
+func (b *BotSiniestros) Herramientas() []Herramienta {
+	return []Herramienta{
+		SinArgumentos("listar_siniestros_abiertos",
+			"Lista los siniestros abiertos o en trámite con póliza, cliente, fecha y tramitador",
+			b.ListarSiniestrosAbiertos),
+		SinArgumentos("resumen_siniestros",
+			"Estadísticas de siniestros por situación",
+			b.ResumenSiniestros),
+		SinArgumentos("estadisticas_por_tramitador",
+			"Carga de trabajo de cada tramitador: siniestros totales y abiertos",
+			b.EstadisticasPorTramitador),
+		NuevaHerramienta("asesorar_documentacion",
+			"Explica la documentación y los pasos para declarar o tramitar un siniestro de un ramo",
+			objeto(map[string]Schema{"consulta": texto("Tipo de siniestro y ramo, p. ej. 'accidente de tráfico con culpa'")}, "consulta"),
+			func(args struct {
+				Consulta string `json:"consulta"`
+			}) (interface{}, error) {
+				return b.AsesorarDocumentacion(args.Consulta)
+			}),
+	}
+}
+
+// porPalabrasClave enrutado sin function calling
+func (b *BotSiniestros) porPalabrasClave(msg string, _ []ai.Message) (string, error) {
+	mensajeLower := strings.ToLower(msg)
+
+	if strings.Contains(mensajeLower, "abierto") || strings.Contains(mensajeLower, "pendiente") {
+		return b.ListarSiniestrosAbiertos()
+	} else if strings.Contains(mensajeLower, "estadistica") || strings.Contains(mensajeLower, "resumen") {
 		return b.ResumenSiniestros()
-	})
+	} else if strings.Contains(mensajeLower, "tramitador") {
+		return b.EstadisticasPorTramitador()
+	} else if strings.Contains(mensajeLower, "documento") || strings.Contains(mensajeLower, "necesito") || strings.Contains(mensajeLower, "parte") {
+		return b.AsesorarDocumentacion(msg)
+	}
+
+	return b.ResumenSiniestros()
 }
 
 // AsesorarDocumentacion asesora sobre documentación necesaria para siniestros
@@ -134,7 +183,7 @@ RESPONSABILIDAD CIVIL:
 
 Responde de forma clara, práctica y en español de España. Indica siempre los documentos específicos necesarios.`
 
-	respuesta, err := ai.ConsultarAI(consulta, systemPrompt)
+	respuesta, err := ai.ConsultarAIBot(b.ID, consulta, systemPrompt)
 	if err != nil {
 		return "Error procesando consulta de siniestros. Contacte con el departamento de siniestros en horario de oficina.", err
 	}
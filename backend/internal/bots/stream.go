@@ -0,0 +1,53 @@
+package bots
+
+import "soriano-mediadores/internal/ai"
+
+// Tipos de evento de una respuesta en streaming
+const (
+	EventoEstado      = "estado"      // Qué está haciendo el bot (p. ej. ejecutando una herramienta)
+	EventoToken       = "token"       // Fragmento del texto de la respuesta
+	EventoHerramienta = "herramienta" // Herramienta terminada, con su paso de la traza
+	// EventoReinicio el stream se cortó a medias: descartar los tokens
+	// recibidos, a continuación llega la respuesta alternativa completa
+	EventoReinicio = "reinicio"
+)
+
+// Evento progreso de una respuesta en streaming
+type Evento struct {
+	Tipo        string     `json:"tipo"`
+	Texto       string     `json:"texto,omitempty"`
+	Herramienta string     `json:"herramienta,omitempty"`
+	Paso        *PasoTraza `json:"paso,omitempty"`
+}
+
+// Emisor recibe los eventos de una respuesta; nil = respuesta sin streaming
+type Emisor func(Evento)
+
+func (e Emisor) estado(texto, herramienta string) {
+	if e != nil {
+		e(Evento{Tipo: EventoEstado, Texto: texto, Herramienta: herramienta})
+	}
+}
+
+func (e Emisor) paso(p PasoTraza) {
+	if e != nil {
+		e(Evento{Tipo: EventoHerramienta, Herramienta: p.Herramienta, Paso: &p})
+	}
+}
+
+// reiniciar tras un ai.IsPartialStream, antes del texto de sustitución
+func (e Emisor) reiniciar(err error) {
+	if e != nil && ai.IsPartialStream(err) {
+		e(Evento{Tipo: EventoReinicio, Texto: "Respuesta interrumpida, se sustituye"})
+	}
+}
+
+// tokens callback de fragmentos para el proveedor (nil sin streaming)
+func (e Emisor) tokens() func(string) {
+	if e == nil {
+		return nil
+	}
+	return func(texto string) {
+		e(Evento{Tipo: EventoToken, Texto: texto})
+	}
+}
@@ -0,0 +1,266 @@
+package bots
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"soriano-mediadores/internal/ai"
+)
+
+// Respuesta respuesta de un bot con la traza de las herramientas que usó
+type Respuesta struct {
+	Texto string      `json:"respuesta"`
+	Traza []PasoTraza `json:"traza,omitempty"`
+	// transmitida el texto ya se entregó al Emisor por fragmentos
+	transmitida bool
+}
+
+// PasoTraza una llamada a herramienta durante la respuesta
+type PasoTraza struct {
+	Herramienta string          `json:"herramienta"`
+	Argumentos  json.RawMessage `json:"argumentos"`
+	Resultado   string          `json:"resultado,omitempty"` // Inicio del resultado
+	Error       string          `json:"error,omitempty"`
+	DuracionMs  int64           `json:"duracion_ms"`
+}
+
+// Schema JSON Schema de los parámetros de una herramienta
+type Schema = map[string]interface{}
+
+// Herramienta capacidad de un bot que el modelo puede invocar con argumentos
+type Herramienta struct {
+	Nombre      string
+	Descripcion string
+	Parametros  Schema
+	ejecutar    func(args json.RawMessage) (interface{}, error)
+}
+
+const (
+	// maxPasosHerramientas rondas de llamadas antes de exigir la respuesta
+	maxPasosHerramientas = 4
+	// maxResultadoHerramienta caracteres del resultado que ve el modelo
+	maxResultadoHerramienta = 6000
+	// maxResultadoTraza caracteres del resultado en la traza de la UI
+	maxResultadoTraza = 500
+)
+
+// NuevaHerramienta herramienta tipada: los argumentos del modelo se validan
+// contra el esquema y se decodifican en A
+func NuevaHerramienta[A any](nombre, descripcion string, parametros Schema, fn func(A) (interface{}, error)) Herramienta {
+	return Herramienta{
+		Nombre:      nombre,
+		Descripcion: descripcion,
+		Parametros:  parametros,
+		ejecutar: func(raw json.RawMessage) (interface{}, error) {
+			if err := validarArgumentos(parametros, raw); err != nil {
+				return nil, err
+			}
+			var args A
+			if len(bytes.TrimSpace(raw)) > 0 {
+				if err := json.Unmarshal(raw, &args); err != nil {
+					return nil, fmt.Errorf("argumentos inválidos: %w", err)
+				}
+			}
+			return fn(args)
+		},
+	}
+}
+
+// SinArgumentos herramienta sin parámetros sobre un método existente
+func SinArgumentos(nombre, descripcion string, fn func() (string, error)) Herramienta {
+	return NuevaHerramienta(nombre, descripcion, objeto(nil), func(struct{}) (interface{}, error) {
+		return fn()
+	})
+}
+
+// objeto esquema de un objeto con sus propiedades y las obligatorias
+func objeto(propiedades map[string]Schema, obligatorias ...string) Schema {
+	if propiedades == nil {
+		propiedades = map[string]Schema{}
+	}
+	s := Schema{"type": "object", "properties": propiedades}
+	if len(obligatorias) > 0 {
+		s["required"] = obligatorias
+	}
+	return s
+}
+
+func texto(descripcion string) Schema {
+	return Schema{"type": "string", "description": descripcion}
+}
+
+func entero(descripcion string, min, max int) Schema {
+	return Schema{"type": "integer", "description": descripcion, "minimum": min, "maximum": max}
+}
+
+// validarArgumentos comprueba obligatorios, tipos y rangos del esquema
+func validarArgumentos(schema Schema, raw json.RawMessage) error {
+	args := map[string]interface{}{}
+	if len(bytes.TrimSpace(raw)) > 0 {
+		if err := json.Unmarshal(raw, &args); err != nil {
+			return fmt.Errorf("los argumentos deben ser un objeto JSON: %w", err)
+		}
+	}
+	if obligatorias, ok := schema["required"].([]string); ok {
+		for _, nombre := range obligatorias {
+			if v, ok := args[nombre]; !ok || v == nil || v == "" {
+				return fmt.Errorf("falta el argumento %q", nombre)
+			}
+		}
+	}
+	propiedades, _ := schema["properties"].(map[string]Schema)
+	for nombre, valor := range args {
+		prop, ok := propiedades[nombre]
+		if !ok || valor == nil {
+			continue
+		}
+		switch prop["type"] {
+		case "string":
+			if _, ok := valor.(string); !ok {
+				return fmt.Errorf("%s debe ser texto", nombre)
+			}
+		case "integer":
+			n, ok := valor.(float64)
+			if !ok || n != math.Trunc(n) {
+				return fmt.Errorf("%s debe ser un número entero", nombre)
+			}
+			if min, ok := prop["minimum"].(int); ok && n < float64(min) {
+				return fmt.Errorf("%s debe ser al menos %d", nombre, min)
+			}
+			if max, ok := prop["maximum"].(int); ok && n > float64(max) {
+				return fmt.Errorf("%s debe ser como mucho %d", nombre, max)
+			}
+		}
+	}
+	return nil
+}
+
+// EjecutarConHerramientas deja que el modelo elija y parametrice las
+// herramientas, las ejecuta y le devuelve los resultados hasta que compone
+// la respuesta final. Solo devuelve error si el proveedor falla. Con emitir
+// se informa de cada herramienta y el texto llega por fragmentos
+func EjecutarConHerramientas(botID, systemPrompt string, historial []ai.Message, mensaje string, herramientas []Herramienta, emitir Emisor) (*Respuesta, error) {
+	specs := make([]ai.ToolSpec, len(herramientas))
+	porNombre := make(map[string]Herramienta, len(herramientas))
+	for i, h := range herramientas {
+		specs[i] = ai.ToolSpec{Name: h.Nombre, Description: h.Descripcion, Parameters: h.Parametros}
+		porNombre[h.Nombre] = h
+	}
+
+	messages := make([]ai.Message, 0, len(historial)+2)
+	messages = append(messages, ai.Message{Role: "system", Content: systemPrompt})
+	messages = append(messages, historial...)
+	messages = append(messages, ai.Message{Role: "user", Content: mensaje})
+
+	provider := ai.ProviderFor(botID)
+	respuesta := &Respuesta{}
+	for ronda := 0; ; ronda++ {
+		req := ai.ChatRequest{Messages: messages, Temperature: 0.2, MaxTokens: 1024}
+		// Agotadas las rondas, se pide la respuesta con lo obtenido
+		if ronda < maxPasosHerramientas {
+			req.Tools = specs
+		}
+		var onDelta func(string)
+		transmitida := false
+		if tokens := emitir.tokens(); tokens != nil {
+			onDelta = func(texto string) {
+				transmitida = true
+				tokens(texto)
+			}
+		}
+		resp, err := ai.ChatStream(context.Background(), provider, req, onDelta)
+		if err != nil {
+			return nil, err
+		}
+		if len(resp.ToolCalls) == 0 || req.Tools == nil {
+			if resp.Content == "" {
+				return nil, fmt.Errorf("%s no devolvió respuesta", provider.Name())
+			}
+			respuesta.Texto = resp.Content
+			respuesta.transmitida = transmitida
+			return respuesta, nil
+		}
+
+		messages = append(messages, ai.Message{Role: "assistant", Content: resp.Content, ToolCalls: resp.ToolCalls})
+		for _, call := range resp.ToolCalls {
+			emitir.estado("Ejecutando "+call.Function.Name, call.Function.Name)
+			paso, resultado := ejecutarHerramienta(porNombre, call)
+			emitir.paso(paso)
+			respuesta.Traza = append(respuesta.Traza, paso)
+			messages = append(messages, ai.Message{Role: "tool", ToolCallID: call.ID, Content: resultado})
+		}
+	}
+}
+
+// ejecutarHerramienta ejecuta una llamada del modelo; los errores se le
+// devuelven como resultado para que pueda corregir los argumentos
+func ejecutarHerramienta(herramientas map[string]Herramienta, call ai.ToolCall) (PasoTraza, string) {
+	args := json.RawMessage(call.Function.Arguments)
+	if !json.Valid(args) {
+		args = json.RawMessage("{}")
+	}
+	paso := PasoTraza{Herramienta: call.Function.Name, Argumentos: args}
+
+	h, ok := herramientas[call.Function.Name]
+	if !ok {
+		paso.Error = "herramienta desconocida"
+		return paso, "Error: herramienta desconocida " + call.Function.Name
+	}
+
+	start := time.Now()
+	out, err := h.ejecutar(json.RawMessage(call.Function.Arguments))
+	paso.DuracionMs = time.Since(start).Milliseconds()
+	if err != nil {
+		log.Printf("⚠️  Herramienta %s: %v", h.Nombre, err)
+		paso.Error = err.Error()
+		return paso, "Error: " + err.Error()
+	}
+
+	resultado, ok := out.(string)
+	if !ok {
+		data, err := json.Marshal(out)
+		if err != nil {
+			paso.Error = err.Error()
+			return paso, "Error: " + err.Error()
+		}
+		resultado = string(data)
+	}
+	paso.Resultado = recortarTexto(resultado, maxResultadoTraza)
+	return paso, recortarTexto(resultado, maxResultadoHerramienta)
+}
+
+func recortarTexto(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return string(runes[:max]) + "…"
+}
+
+// soloTexto respuesta sin herramientas a partir de los métodos de texto
+func soloTexto(texto string, err error) (*Respuesta, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &Respuesta{Texto: texto}, nil
+}
+
+// conHerramientas aiFunc de ProcesarConFallback para los bots con
+// herramientas: el modelo elige las consultas y, si el proveedor no está
+// disponible o no admite function calling, se usa el enrutado anterior
+func conHerramientas(botID, systemPrompt string, herramientas []Herramienta, emitir Emisor, sinHerramientas func(string, []ai.Message) (string, error)) func(string, []ai.Message) (*Respuesta, error) {
+	return func(msg string, historial []ai.Message) (*Respuesta, error) {
+		respuesta, err := EjecutarConHerramientas(botID, systemPrompt, historial, msg, herramientas, emitir)
+		if err == nil {
+			return respuesta, nil
+		}
+		log.Printf("⚠️  %s sin function calling: %v", botID, err)
+		emitir.reiniciar(err)
+		return soloTexto(sinHerramientas(msg, historial))
+	}
+}
@@ -0,0 +1,258 @@
+package bots
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"soriano-mediadores/internal/ai"
+)
+
+func llamada(id, nombre, args string) ai.ToolCall {
+	return ai.ToolCall{ID: id, Type: "function", Function: ai.ToolCallFunction{Name: nombre, Arguments: args}}
+}
+
+func herramientasPrueba(llamadas *[]string) []Herramienta {
+	return []Herramienta{
+		NuevaHerramienta("obtener_recibos_cliente", "Recibos de un cliente",
+			objeto(map[string]Schema{
+				"id_account": texto("IdAccount"),
+				"limite":     entero("Máximo", 1, 100),
+			}, "id_account"),
+			func(args struct {
+				argsCliente
+				Limite int `json:"limite"`
+			}) (interface{}, error) {
+				*llamadas = append(*llamadas, args.IDAccount)
+				return []map[string]interface{}{{"numero_recibo": "R-1", "prima_total": 120.5, "situacion_recibo": "Pendiente"}}, nil
+			}),
+		SinArgumentos("resumen_cobranza", "Resumen", func() (string, error) {
+			return "Pendientes: 3", nil
+		}),
+	}
+}
+
+func TestEjecutarConHerramientas(t *testing.T) {
+	var llamadas []string
+	stub := &ai.StubProvider{Script: []ai.ChatResponse{
+		{ToolCalls: []ai.ToolCall{
+			llamada("c1", "obtener_recibos_cliente", `{"id_account": "20777103/000", "limite": 5}`),
+			llamada("c2", "obtener_recibos_cliente", `{"limite": 500}`),
+			llamada("c3", "borrar_cliente", `{}`),
+		}},
+		{Content: "Tiene un recibo pendiente (R-1) de 120,50 €."},
+	}}
+	ai.SetProvider("bot_herramientas", stub)
+
+	resp, err := EjecutarConHerramientas("bot_herramientas", "sistema", nil, "¿Qué debe 20777103/000?", herramientasPrueba(&llamadas), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Texto != "Tiene un recibo pendiente (R-1) de 120,50 €." {
+		t.Fatalf("texto = %q", resp.Texto)
+	}
+	if len(llamadas) != 1 || llamadas[0] != "20777103/000" {
+		t.Fatalf("ejecuciones = %v", llamadas)
+	}
+
+	// Traza: la llamada válida con su resultado y los errores de validación
+	if len(resp.Traza) != 3 {
+		t.Fatalf("traza = %+v", resp.Traza)
+	}
+	if resp.Traza[0].Error != "" || !strings.Contains(resp.Traza[0].Resultado, "R-1") {
+		t.Errorf("paso 1 = %+v", resp.Traza[0])
+	}
+	if !strings.Contains(resp.Traza[1].Error, `"id_account"`) {
+		t.Errorf("paso 2 = %+v", resp.Traza[1])
+	}
+	if resp.Traza[2].Error != "herramienta desconocida" {
+		t.Errorf("paso 3 = %+v", resp.Traza[2])
+	}
+
+	// El modelo recibe las herramientas y, después, sus resultados
+	if stub.Calls() != 2 || len(stub.Requests[0].Tools) != 2 {
+		t.Fatalf("peticiones = %d, herramientas = %d", stub.Calls(), len(stub.Requests[0].Tools))
+	}
+	mensajes := stub.Requests[1].Messages
+	if asistente := mensajes[2]; asistente.Role != "assistant" || len(asistente.ToolCalls) != 3 {
+		t.Fatalf("mensaje del asistente = %+v", asistente)
+	}
+	for i, id := range []string{"c1", "c2", "c3"} {
+		m := mensajes[3+i]
+		if m.Role != "tool" || m.ToolCallID != id {
+			t.Fatalf("resultado %d = %+v", i, m)
+		}
+	}
+	if !strings.HasPrefix(mensajes[4].Content, "Error: ") {
+		t.Errorf("el error de validación no llega al modelo: %q", mensajes[4].Content)
+	}
+}
+
+func TestEjecutarConHerramientasLimitePasos(t *testing.T) {
+	var script []ai.ChatResponse
+	for i := 0; i < maxPasosHerramientas; i++ {
+		script = append(script, ai.ChatResponse{ToolCalls: []ai.ToolCall{llamada("c", "resumen_cobranza", "")}})
+	}
+	stub := &ai.StubProvider{Script: script, Reply: "Hay 3 recibos pendientes."}
+	ai.SetProvider("bot_herramientas_bucle", stub)
+
+	var llamadas []string
+	resp, err := EjecutarConHerramientas("bot_herramientas_bucle", "sistema", nil, "resumen", herramientasPrueba(&llamadas), nil)
+	if err != nil || resp.Texto != "Hay 3 recibos pendientes." {
+		t.Fatalf("respuesta: %+v, %v", resp, err)
+	}
+	if len(resp.Traza) != maxPasosHerramientas {
+		t.Fatalf("traza = %d pasos", len(resp.Traza))
+	}
+	// La última petición ya no ofrece herramientas
+	if last := stub.Requests[len(stub.Requests)-1]; last.Tools != nil {
+		t.Fatal("la petición final no debe llevar herramientas")
+	}
+	if string(resp.Traza[0].Argumentos) != "{}" {
+		t.Errorf("argumentos vacíos = %s", resp.Traza[0].Argumentos)
+	}
+}
+
+func TestValidarArgumentos(t *testing.T) {
+	schema := objeto(map[string]Schema{
+		"termino": texto("Nombre"),
+		"limite":  entero("Máximo", 1, 20),
+	}, "termino")
+	casos := map[string]bool{
+		`{"termino": "Ana"}`:                true,
+		`{"termino": "Ana", "limite": 20}`:  true,
+		`{"termino": ""}`:                   false,
+		`{"termino": 7}`:                    false,
+		`{"termino": "Ana", "limite": 2.5}`: false,
+		`{"termino": "Ana", "limite": 21}`:  false,
+		`["Ana"]`:                           false,
+	}
+	for args, ok := range casos {
+		if err := validarArgumentos(schema, json.RawMessage(args)); (err == nil) != ok {
+			t.Errorf("%s: %v", args, err)
+		}
+	}
+}
+
+func TestEjecutarConHerramientasEventos(t *testing.T) {
+	stub := &ai.StubProvider{
+		Script: []ai.ChatResponse{{ToolCalls: []ai.ToolCall{llamada("c1", "resumen_cobranza", "{}")}}},
+		Reply:  "Hay 3 recibos pendientes.",
+	}
+	ai.SetProvider("bot_herramientas_eventos", stub)
+
+	var eventos []Evento
+	var llamadas []string
+	resp, err := EjecutarConHerramientas("bot_herramientas_eventos", "sistema", nil, "resumen", herramientasPrueba(&llamadas), func(e Evento) {
+		eventos = append(eventos, e)
+	})
+	if err != nil || !resp.transmitida {
+		t.Fatalf("respuesta: %+v, %v", resp, err)
+	}
+
+	var tipos, texto []string
+	for _, e := range eventos {
+		tipos = append(tipos, e.Tipo)
+		if e.Tipo == EventoToken {
+			texto = append(texto, e.Texto)
+		}
+	}
+	if tipos[0] != EventoEstado || eventos[0].Herramienta != "resumen_cobranza" {
+		t.Fatalf("primer evento = %+v", eventos[0])
+	}
+	if tipos[1] != EventoHerramienta || eventos[1].Paso == nil || eventos[1].Paso.Resultado != "Pendientes: 3" {
+		t.Fatalf("segundo evento = %+v", eventos[1])
+	}
+	if strings.Join(texto, "") != resp.Texto || len(texto) < 2 {
+		t.Fatalf("tokens = %q", texto)
+	}
+}
+
+// eventosDe tipos de evento y texto final que ve el cliente (los tokens
+// anteriores a un reinicio se descartan)
+func eventosDe(eventos []Evento) ([]string, string) {
+	var tipos []string
+	var texto strings.Builder
+	for _, e := range eventos {
+		tipos = append(tipos, e.Tipo)
+		switch e.Tipo {
+		case EventoReinicio:
+			texto.Reset()
+		case EventoToken:
+			texto.WriteString(e.Texto)
+		}
+	}
+	return tipos, texto.String()
+}
+
+func TestStreamCortadoConHerramientas(t *testing.T) {
+	useMemoryConversations(t)
+	ai.SetProvider("bot_herramientas_corte", &ai.StubProvider{
+		Reply:     "Hay 3 recibos pendientes de cobro.",
+		StreamErr: errors.New("conexión cerrada"),
+	})
+	Registrar("corte-1", "bot_herramientas_corte", RolUsuario, "Hola")
+
+	var eventos []Evento
+	emitir := Emisor(func(e Evento) { eventos = append(eventos, e) })
+	var llamadas []string
+	resp, err := ProcesarConFallback("bot_herramientas_corte", "corte-1", "¿Cuántos recibos hay pendientes?", emitir,
+		conHerramientas("bot_herramientas_corte", "sistema", herramientasPrueba(&llamadas), emitir, func(string, []ai.Message) (string, error) {
+			return "Pendientes: 3", nil
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tipos, texto := eventosDe(eventos)
+	want := []string{EventoToken, EventoReinicio, EventoToken}
+	if strings.Join(tipos, ",") != strings.Join(want, ",") {
+		t.Fatalf("eventos = %v", tipos)
+	}
+	if texto != "Pendientes: 3" || resp.Texto != texto {
+		t.Fatalf("texto = %q, respuesta = %q", texto, resp.Texto)
+	}
+}
+
+func TestStreamCortadoSinHerramientas(t *testing.T) {
+	useMemoryConversations(t)
+	ai.SetProvider("bot_stream_corte", &ai.StubProvider{
+		Reply:     "El PIAS permite ahorrar con ventajas fiscales.",
+		StreamErr: errors.New("conexión cerrada"),
+	})
+	Registrar("corte-2", "bot_stream_corte", RolUsuario, "Hola")
+
+	var eventos []Evento
+	emitir := Emisor(func(e Evento) { eventos = append(eventos, e) })
+	resp, err := ProcesarConFallback("bot_stream_corte", "corte-2", "¿Qué es un PIAS?", emitir, func(msg string, historial []ai.Message) (*Respuesta, error) {
+		texto, err := ai.ConsultarAIConHistorialStream("bot_stream_corte", "sistema", historial, msg, emitir.tokens())
+		if err != nil {
+			return nil, err
+		}
+		return &Respuesta{Texto: texto, transmitida: true}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tipos, texto := eventosDe(eventos)
+	if len(tipos) != 3 || tipos[1] != EventoReinicio {
+		t.Fatalf("eventos = %v", tipos)
+	}
+	// El cliente se queda con la respuesta por defecto, la misma que se guarda
+	if texto != GetDefaultResponse("bot_stream_corte") || resp.Texto != texto {
+		t.Fatalf("texto = %q, respuesta = %q", texto, resp.Texto)
+	}
+
+	// Sin corte no hay reinicio
+	ai.SetProvider("bot_stream_corte", &ai.StubProvider{Reply: "Sin cortes."})
+	eventos = nil
+	ProcesarConFallback("bot_stream_corte", "corte-2", "¿Y un PPA?", emitir, func(msg string, historial []ai.Message) (*Respuesta, error) {
+		texto, err := ai.ConsultarAIConHistorialStream("bot_stream_corte", "sistema", historial, msg, emitir.tokens())
+		return &Respuesta{Texto: texto, transmitida: true}, err
+	})
+	if tipos, texto := eventosDe(eventos); strings.Contains(strings.Join(tipos, ","), EventoReinicio) || texto != "Sin cortes." {
+		t.Fatalf("eventos = %v, texto = %q", tipos, texto)
+	}
+}
@@ -2,6 +2,7 @@ package email
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -41,6 +42,22 @@ type MessageContent struct {
 	ToRecipients []EmailRecipient   `json:"toRecipients"`
 	CcRecipients []EmailRecipient   `json:"ccRecipients,omitempty"`
 	From         *EmailRecipient    `json:"from,omitempty"`
+	Attachments  []FileAttachment   `json:"attachments,omitempty"`
+}
+
+// FileAttachment - Adjunto en el formato fileAttachment de Graph
+type FileAttachment struct {
+	ODataType    string `json:"@odata.type"`
+	Name         string `json:"name"`
+	ContentType  string `json:"contentType"`
+	ContentBytes string `json:"contentBytes"` // Base64
+}
+
+// Attachment - Archivo a adjuntar en SendEmailWithAttachments
+type Attachment struct {
+	Name        string
+	ContentType string
+	Content     []byte
 }
 
 // MessageBody - Cuerpo del mensaje
@@ -281,3 +298,75 @@ func (gc *GraphClient) SendBulkEmails(from string, emails []struct {
 
 	return nil, nil
 }
+
+// SendEmailWithAttachments - Envía un email a varios destinatarios con adjuntos.
+// Graph limita el mensaje a unos 3 MB con adjuntos incluidos.
+func (gc *GraphClient) SendEmailWithAttachments(from string, to []string, subject, htmlBody string, attachments []Attachment) error {
+	if len(to) == 0 {
+		return fmt.Errorf("no hay destinatarios")
+	}
+
+	// Asegurar que tenemos un token válido
+	if err := gc.GetAccessToken(); err != nil {
+		return fmt.Errorf("error obteniendo access token: %w", err)
+	}
+
+	recipients := make([]EmailRecipient, 0, len(to))
+	for _, addr := range to {
+		recipients = append(recipients, EmailRecipient{EmailAddress: EmailAddress{Address: addr}})
+	}
+
+	files := make([]FileAttachment, 0, len(attachments))
+	for _, a := range attachments {
+		contentType := a.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		files = append(files, FileAttachment{
+			ODataType:    "#microsoft.graph.fileAttachment",
+			Name:         a.Name,
+			ContentType:  contentType,
+			ContentBytes: base64.StdEncoding.EncodeToString(a.Content),
+		})
+	}
+
+	message := EmailMessage{
+		Message: MessageContent{
+			Subject: subject,
+			Body: MessageBody{
+				ContentType: "HTML",
+				Content:     htmlBody,
+			},
+			ToRecipients: recipients,
+			Attachments:  files,
+		},
+		SaveToSentItems: true,
+	}
+
+	jsonData, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("error serializando mensaje: %w", err)
+	}
+
+	sendURL := fmt.Sprintf("https://graph.microsoft.com/v1.0/users/%s/sendMail", from)
+	req, err := http.NewRequest("POST", sendURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return fmt.Errorf("error creando request de envío: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+gc.AccessToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := gc.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error enviando email: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("error en envío de email (status %d): %s", resp.StatusCode, string(body))
+	}
+
+	return nil
+}
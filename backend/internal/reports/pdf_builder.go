@@ -1,6 +1,7 @@
 package reports
 
 import (
+	"bytes"
 	"fmt"
 	"time"
 
@@ -265,14 +266,12 @@ func (b *PDFBuilder) addKPIBox(label, value string) {
 }
 
 func (b *PDFBuilder) getOutput() ([]byte, error) {
-	var buf []byte
-	buf = b.pdf.Output(buf)
-
-	if b.pdf.Error() != nil {
-		return nil, b.pdf.Error()
+	var buf bytes.Buffer
+	if err := b.pdf.Output(&buf); err != nil {
+		return nil, err
 	}
 
-	return buf, nil
+	return buf.Bytes(), nil
 }
 
 // Helper para extraer float de map
@@ -5,8 +5,11 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sync"
 	"time"
 
+	"soriano-mediadores/internal/secretbox"
+
 	"github.com/chromedp/chromedp"
 )
 
@@ -17,136 +20,90 @@ type AuthManager struct {
 	BaseURL    string
 	IsLoggedIn bool
 	LastLogin  time.Time
+	// Screenshots capturas de debug (compartidas con el Downloader)
+	Screenshots *ScreenshotLog
+	// Profile perfil de portal de la ejecución en curso (nil = el activo)
+	Profile *PortalProfile
+	// TOTPSecret secreto base32 del segundo factor (GCO_TOTP_SECRET)
+	TOTPSecret string
+	// SessionFile cookies cifradas entre ejecuciones (requiere GCO_SESSION_KEY)
+	SessionFile string
+	// SessionReused la última EnsureSession reutilizó la sesión guardada
+	SessionReused bool
+
+	box             *secretbox.Box
+	boxOnce         sync.Once
+	lastTOTPCounter uint64
+}
+
+func (am *AuthManager) screenshots() *ScreenshotLog {
+	if am.Screenshots == nil {
+		am.Screenshots = NewScreenshotLog()
+	}
+	return am.Screenshots
 }
 
 // NewAuthManager crea un nuevo AuthManager con las credenciales de .env
 func NewAuthManager() *AuthManager {
 	return &AuthManager{
-		Username: os.Getenv("GCO_USERNAME"),
-		Password: os.Getenv("GCO_PASSWORD"),
-		BaseURL:  os.Getenv("GCO_BASE_URL"),
+		Username:   os.Getenv("GCO_USERNAME"),
+		Password:   os.Getenv("GCO_PASSWORD"),
+		BaseURL:    os.Getenv("GCO_BASE_URL"),
+		TOTPSecret: os.Getenv("GCO_TOTP_SECRET"),
+	}
+}
+
+// profile perfil de portal a usar (el activo si no se fijó uno para la ejecución)
+func (am *AuthManager) profile() *PortalProfile {
+	if am.Profile == nil {
+		return portalProfiles.Current().PortalProfile
 	}
+	return am.Profile
 }
 
 // Login realiza el login en el portal GCO
 func (am *AuthManager) Login(ctx context.Context) error {
 	log.Printf("[Auth] Iniciando login en %s con usuario %s", am.BaseURL, am.Username)
 
-	// Selectores comunes para formularios de login
-	// NOTA: Estos se ajustarán según el HTML real del portal GCO
-	usernameSelectors := []string{
-		`input[name="username"]`,
-		`input[name="user"]`,
-		`input[name="login"]`,
-		`input[name="Usuario"]`,
-		`input[id="username"]`,
-		`input[id="user"]`,
-		`input[id="txtUsuario"]`,
-		`input[type="text"]:first-of-type`,
-		`#username`,
-		`#user`,
-		`#login`,
-	}
-
-	passwordSelectors := []string{
-		`input[name="password"]`,
-		`input[name="pass"]`,
-		`input[name="Password"]`,
-		`input[name="Clave"]`,
-		`input[id="password"]`,
-		`input[id="pass"]`,
-		`input[id="txtPassword"]`,
-		`input[type="password"]`,
-		`#password`,
-		`#pass`,
-	}
-
-	submitSelectors := []string{
-		`button[type="submit"]`,
-		`input[type="submit"]`,
-		`button[name="login"]`,
-		`button[id="btnLogin"]`,
-		`button[id="btnEntrar"]`,
-		`.btn-login`,
-		`.login-button`,
-		`button:contains("Entrar")`,
-		`button:contains("Acceder")`,
-		`input[value="Entrar"]`,
-		`input[value="Acceder"]`,
-	}
+	login := am.profile().Login
+	loginURL := am.BaseURL + login.Path
 
 	// Navegar al portal
 	err := chromedp.Run(ctx,
-		chromedp.Navigate(am.BaseURL),
+		chromedp.Navigate(loginURL),
 		chromedp.WaitReady("body"),
 		chromedp.Sleep(2*time.Second),
 	)
 	if err != nil {
-		return fmt.Errorf("error navegando a %s: %w", am.BaseURL, err)
+		return fmt.Errorf("error navegando a %s: %w", loginURL, err)
 	}
 
 	log.Println("[Auth] Página cargada, buscando formulario de login...")
 
-	// Intentar encontrar y rellenar el campo de usuario
-	var usernameSelector string
-	for _, sel := range usernameSelectors {
-		var exists bool
-		err := chromedp.Run(ctx,
-			chromedp.Evaluate(fmt.Sprintf(`document.querySelector('%s') !== null`, sel), &exists),
-		)
-		if err == nil && exists {
-			usernameSelector = sel
-			log.Printf("[Auth] Campo usuario encontrado: %s", sel)
-			break
-		}
-	}
-
-	if usernameSelector == "" {
+	// Intentar encontrar el campo de usuario
+	loc, usernameSelector, ok := locate(ctx, login.Username, "username")
+	if !ok {
 		// Tomar screenshot para debug
-		var screenshot []byte
-		chromedp.Run(ctx, chromedp.CaptureScreenshot(&screenshot))
-		if len(screenshot) > 0 {
-			os.WriteFile("/opt/soriano/logs/scraper/login_page.png", screenshot, 0644)
-			log.Println("[Auth] Screenshot guardado en /opt/soriano/logs/scraper/login_page.png")
+		if path := am.screenshots().Take(ctx, "login_page.png"); path != "" {
+			log.Printf("[Auth] Screenshot guardado en %s", path)
 		}
 		return fmt.Errorf("no se encontró campo de usuario en la página")
 	}
+	log.Printf("[Auth] Campo usuario encontrado: %s", loc)
 
 	// Encontrar campo de password
-	var passwordSelector string
-	for _, sel := range passwordSelectors {
-		var exists bool
-		err := chromedp.Run(ctx,
-			chromedp.Evaluate(fmt.Sprintf(`document.querySelector('%s') !== null`, sel), &exists),
-		)
-		if err == nil && exists {
-			passwordSelector = sel
-			log.Printf("[Auth] Campo password encontrado: %s", sel)
-			break
-		}
-	}
-
-	if passwordSelector == "" {
+	loc, passwordSelector, ok := locate(ctx, login.Password, "password")
+	if !ok {
 		return fmt.Errorf("no se encontró campo de password en la página")
 	}
+	log.Printf("[Auth] Campo password encontrado: %s", loc)
 
 	// Encontrar botón de submit
-	var submitSelector string
-	for _, sel := range submitSelectors {
-		var exists bool
-		err := chromedp.Run(ctx,
-			chromedp.Evaluate(fmt.Sprintf(`document.querySelector('%s') !== null`, sel), &exists),
-		)
-		if err == nil && exists {
-			submitSelector = sel
-			log.Printf("[Auth] Botón submit encontrado: %s", sel)
-			break
-		}
-	}
-
-	if submitSelector == "" {
+	loc, submitSelector, ok := locate(ctx, login.Submit, "submit")
+	if !ok {
 		return fmt.Errorf("no se encontró botón de submit en la página")
 	}
+	log.Printf("[Auth] Botón submit encontrado: %s", loc)
 
 	// Rellenar formulario y hacer login
 	log.Println("[Auth] Rellenando formulario de login...")
@@ -174,52 +131,25 @@ func (am *AuthManager) Login(ctx context.Context) error {
 		return fmt.Errorf("error esperando respuesta de login: %w", err)
 	}
 
-	// Verificar si el login fue exitoso
-	// Buscar indicadores de login exitoso (menú, dashboard, nombre de usuario, etc.)
-	dashboardSelectors := []string{
-		`.dashboard`,
-		`.main-content`,
-		`.menu-principal`,
-		`.sidebar`,
-		`.nav-menu`,
-		`#menu`,
-		`.header-user`,
-		`.user-info`,
-		`nav`,
-		`.layout-main`,
+	// Segundo factor: el portal pide el código TOTP tras la contraseña
+	if loc, codeSelector, ok := locate(ctx, login.TOTP, "totp"); ok {
+		log.Printf("[Auth] El portal pide segundo factor: %s", loc)
+		if err := am.submitTOTP(ctx, codeSelector, login); err != nil {
+			am.screenshots().Take(ctx, "totp.png")
+			return err
+		}
 	}
 
+	// Verificar si el login fue exitoso (menú, dashboard, nombre de usuario, etc.)
 	var loginSuccess bool
-	for _, sel := range dashboardSelectors {
-		var exists bool
-		err := chromedp.Run(ctx,
-			chromedp.Evaluate(fmt.Sprintf(`document.querySelector('%s') !== null`, sel), &exists),
-		)
-		if err == nil && exists {
-			loginSuccess = true
-			log.Printf("[Auth] Dashboard detectado: %s", sel)
-			break
-		}
+	if loc, _, ok := locate(ctx, login.Success, "success"); ok {
+		loginSuccess = true
+		log.Printf("[Auth] Dashboard detectado: %s", loc)
 	}
 
 	// Verificar si hay mensajes de error
-	errorSelectors := []string{
-		`.error`,
-		`.alert-danger`,
-		`.error-message`,
-		`.login-error`,
-		`#error`,
-		`.validation-error`,
-	}
-
-	for _, sel := range errorSelectors {
-		var errorText string
-		err := chromedp.Run(ctx,
-			chromedp.Text(sel, &errorText, chromedp.ByQuery, chromedp.AtLeast(0)),
-		)
-		if err == nil && errorText != "" {
-			return fmt.Errorf("error de login: %s", errorText)
-		}
+	if errorText := targetText(ctx, login.Error, "login-error"); errorText != "" {
+		return fmt.Errorf("error de login: %s", errorText)
 	}
 
 	// Si no detectamos dashboard pero tampoco error, verificar URL
@@ -228,16 +158,13 @@ func (am *AuthManager) Login(ctx context.Context) error {
 	log.Printf("[Auth] URL actual: %s", currentURL)
 
 	// Si la URL cambió del login, probablemente el login fue exitoso
-	if currentURL != am.BaseURL && currentURL != am.BaseURL+"/" {
+	if currentURL != loginURL && currentURL != loginURL+"/" {
 		loginSuccess = true
 	}
 
 	if !loginSuccess {
 		// Tomar screenshot para debug
-		var screenshot []byte
-		chromedp.Run(ctx, chromedp.CaptureScreenshot(&screenshot))
-		if len(screenshot) > 0 {
-			os.WriteFile("/opt/soriano/logs/scraper/after_login.png", screenshot, 0644)
+		if am.screenshots().Take(ctx, "after_login.png") != "" {
 			log.Println("[Auth] Screenshot post-login guardado")
 		}
 
@@ -256,17 +183,79 @@ func (am *AuthManager) Login(ctx context.Context) error {
 	am.LastLogin = time.Now()
 	log.Println("[Auth] Login exitoso!")
 
+	if err := am.saveSession(ctx); err != nil {
+		log.Printf("[Auth] ⚠️ %v", err)
+	}
+
 	return nil
 }
 
-// IsSessionValid verifica si la sesión sigue siendo válida
-func (am *AuthManager) IsSessionValid() bool {
-	if !am.IsLoggedIn {
+// submitTOTP rellena el código del segundo factor y lo envía
+func (am *AuthManager) submitTOTP(ctx context.Context, codeSelector string, login LoginProfile) error {
+	if am.TOTPSecret == "" {
+		return fmt.Errorf("el portal pide segundo factor y GCO_TOTP_SECRET no está configurado")
+	}
+	key, err := decodeTOTPSecret(am.TOTPSecret)
+	if err != nil {
+		return err
+	}
+
+	// Un código ya usado se rechaza: esperar a la siguiente ventana
+	counter := totpCounter(time.Now())
+	if counter == am.lastTOTPCounter {
+		wait := time.Until(time.Unix(int64(counter+1)*int64(totpPeriod/time.Second), 0))
+		log.Printf("[Auth] Código TOTP ya usado, esperando %s", wait.Round(time.Second))
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		counter = totpCounter(time.Now())
+	}
+	am.lastTOTPCounter = counter
+
+	submit := login.TOTPSubmit
+	if len(submit) == 0 {
+		submit = login.Submit
+	}
+	err = chromedp.Run(ctx,
+		chromedp.WaitVisible(codeSelector, chromedp.ByQuery),
+		chromedp.Clear(codeSelector),
+		chromedp.SendKeys(codeSelector, totpCode(key, counter)),
+		chromedp.Sleep(500*time.Millisecond),
+	)
+	if err != nil {
+		return fmt.Errorf("error rellenando código TOTP: %w", err)
+	}
+	if _, ok := clickTarget(ctx, submit, "totp-submit"); !ok {
+		return fmt.Errorf("no se encontró el botón para enviar el código TOTP")
+	}
+
+	log.Println("[Auth] Código TOTP enviado, esperando respuesta...")
+	return chromedp.Run(ctx, chromedp.Sleep(3*time.Second))
+}
+
+// IsSessionValid comprueba contra el portal si la sesión del navegador sigue
+// activa: abre una página protegida y mira que no redirija al login
+func (am *AuthManager) IsSessionValid(ctx context.Context) bool {
+	profile := am.profile()
+	probeURL := am.BaseURL + profile.Session.ProbePath
+
+	err := chromedp.Run(ctx,
+		chromedp.Navigate(probeURL),
+		chromedp.WaitReady("body"),
+		chromedp.Sleep(time.Second),
+	)
+	if err != nil {
+		log.Printf("[Auth] Error comprobando sesión en %s: %v", probeURL, err)
 		return false
 	}
 
-	// Sesión válida por 30 minutos
-	return time.Since(am.LastLogin) < 30*time.Minute
+	if _, _, onLogin := locate(ctx, profile.Login.Username, "probe-login"); onLogin {
+		return false
+	}
+	_, _, ok := locate(ctx, profile.Login.Success, "probe-success")
+	return ok
 }
 
 // Logout cierra la sesión
@@ -274,25 +263,12 @@ func (am *AuthManager) Logout(ctx context.Context) error {
 	log.Println("[Auth] Cerrando sesión...")
 
 	// Intentar encontrar y hacer click en logout
-	logoutSelectors := []string{
-		`a[href*="logout"]`,
-		`a[href*="salir"]`,
-		`button:contains("Salir")`,
-		`button:contains("Cerrar sesión")`,
-		`.logout`,
-		`#logout`,
+	if loc, ok := clickTarget(ctx, am.profile().Logout, "logout"); ok {
+		log.Printf("[Auth] Logout clickeado: %s", loc)
 	}
 
-	for _, sel := range logoutSelectors {
-		var exists bool
-		err := chromedp.Run(ctx,
-			chromedp.Evaluate(fmt.Sprintf(`document.querySelector('%s') !== null`, sel), &exists),
-		)
-		if err == nil && exists {
-			chromedp.Run(ctx, chromedp.Click(sel, chromedp.ByQuery))
-			break
-		}
-	}
+	// La sesión cerrada ya no sirve para la próxima ejecución
+	am.ClearSession()
 
 	am.IsLoggedIn = false
 	return nil
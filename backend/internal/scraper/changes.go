@@ -0,0 +1,384 @@
+package scraper
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"soriano-mediadores/internal/api"
+	"soriano-mediadores/internal/db"
+
+	"github.com/lib/pq"
+)
+
+// Tipos de cambio detectados entre dos snapshots consecutivos
+const (
+	ChangeNuevoCliente   = "nuevo_cliente"
+	ChangePolizaAnulada  = "poliza_anulada"
+	ChangeReciboDevuelto = "recibo_devuelto"
+	ChangeNuevoSiniestro = "nuevo_siniestro"
+)
+
+// RunChange cambio de un registro respecto a la ejecución anterior
+type RunChange struct {
+	ID         int64             `json:"id,omitempty"`
+	RunID      string            `json:"run_id"`
+	Type       CSVType           `json:"type"`
+	Change     string            `json:"change"`
+	Key        string            `json:"key"`
+	Previous   string            `json:"previous,omitempty"` // Situación anterior
+	Current    string            `json:"current,omitempty"`  // Situación actual
+	Data       map[string]string `json:"data,omitempty"`
+	DetectedAt time.Time         `json:"detected_at"`
+}
+
+// changeRule cómo se detecta el cambio de cada tipo. Sin match, el cambio es
+// el alta (clave que no estaba); con match, pasar a cumplirlo (o aparecer cumpliéndolo).
+type changeRule struct {
+	Change string
+	Keys   []string // Columnas clave (la primera no vacía)
+	State  []string // Columnas que forman la situación mostrada
+	Match  func(row map[string]string) bool
+	Fields []string // Columnas que se guardan con el cambio
+}
+
+var changeRules = map[CSVType]changeRule{
+	CSVClientes: {
+		Change: ChangeNuevoCliente,
+		Keys:   []string{"id_account", "nif"},
+		Fields: []string{"nif", "id_account", "nombre_completo", "email_contacto", "telefono_contacto", "provincia"},
+	},
+	CSVPolizas: {
+		Change: ChangePolizaAnulada,
+		Keys:   []string{"numero_poliza"},
+		State:  []string{"situacion_poliza"},
+		Match:  isPolizaAnulada,
+		Fields: []string{"numero_poliza", "id_account", "nombre_cliente", "ramo", "prima_anual", "fecha_vencimiento"},
+	},
+	CSVRecibos: {
+		Change: ChangeReciboDevuelto,
+		Keys:   []string{"numero_recibo"},
+		State:  []string{"situacion_recibo", "detalle_recibo"},
+		Match:  isReciboDevuelto,
+		Fields: []string{"numero_recibo", "numero_poliza", "id_account", "nombre_cliente", "prima_total", "fecha_emision", "forma_pago"},
+	},
+	CSVSiniestros: {
+		Change: ChangeNuevoSiniestro,
+		Keys:   []string{"numero_siniestro"},
+		State:  []string{"situacion_siniestro"},
+		Fields: []string{"numero_siniestro", "numero_poliza", "id_account", "cliente", "fecha_ocurrencia", "fecha_apertura", "tramitador"},
+	},
+}
+
+// isPolizaAnulada situación de póliza anulada o dada de baja
+func isPolizaAnulada(row map[string]string) bool {
+	s := strings.ToLower(row["situacion_poliza"])
+	return strings.Contains(s, "anulad") || strings.Contains(s, "baja") || strings.Contains(s, "cancelad")
+}
+
+// isReciboDevuelto mismo criterio que los KPIs de recobro:
+// situación Retornado o detalle Devuelto
+func isReciboDevuelto(row map[string]string) bool {
+	return strings.EqualFold(row["situacion_recibo"], "Retornado") ||
+		strings.Contains(strings.ToLower(row["situacion_recibo"]), "devuelto") ||
+		strings.Contains(strings.ToLower(row["detalle_recibo"]), "devuelto")
+}
+
+func (r changeRule) key(row map[string]string) string {
+	for _, col := range r.Keys {
+		if v := row[col]; v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func (r changeRule) state(row map[string]string) string {
+	var parts []string
+	for _, col := range r.State {
+		if v := row[col]; v != "" {
+			parts = append(parts, v)
+		}
+	}
+	return strings.Join(parts, " / ")
+}
+
+// snapshotEntry estado de un registro en el snapshot anterior
+type snapshotEntry struct {
+	state   string
+	matched bool
+}
+
+// scanFile lee un archivo descargado (sustituible en tests)
+var scanFile = api.ScanImportFile
+
+// diffSnapshots compara el archivo actual con el snapshot anterior del mismo tipo
+func diffSnapshots(csvType CSVType, previousPath, currentPath string) ([]RunChange, error) {
+	rule, ok := changeRules[csvType]
+	if !ok {
+		return nil, fmt.Errorf("tipo sin reglas de cambios: %s", csvType)
+	}
+
+	previous := make(map[string]snapshotEntry)
+	err := scanFile(previousPath, api.ImportType(csvType), func(row map[string]string) error {
+		if key := rule.key(row); key != "" {
+			previous[key] = snapshotEntry{state: rule.state(row), matched: rule.Match != nil && rule.Match(row)}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo snapshot anterior: %w", err)
+	}
+
+	var changes []RunChange
+	seen := make(map[string]bool)
+	err = scanFile(currentPath, api.ImportType(csvType), func(row map[string]string) error {
+		key := rule.key(row)
+		if key == "" || seen[key] {
+			return nil
+		}
+		seen[key] = true
+
+		prev, existed := previous[key]
+		if rule.Match == nil {
+			if existed {
+				return nil
+			}
+		} else if !rule.Match(row) || (existed && prev.matched) {
+			return nil
+		}
+
+		data := make(map[string]string, len(rule.Fields))
+		for _, col := range rule.Fields {
+			if v := row[col]; v != "" {
+				data[col] = v
+			}
+		}
+		changes = append(changes, RunChange{
+			Type:     csvType,
+			Change:   rule.Change,
+			Key:      key,
+			Previous: prev.state,
+			Current:  rule.state(row),
+			Data:     data,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo archivo descargado: %w", err)
+	}
+	return changes, nil
+}
+
+// snapshotDir directorio con el último archivo procesado de cada tipo
+func (s *GCOScraper) snapshotDir() string {
+	return filepath.Join(s.DownloadDir, "snapshots")
+}
+
+// previousSnapshot ruta del snapshot anterior del tipo ("" si no hay)
+func (s *GCOScraper) previousSnapshot(csvType CSVType) string {
+	matches, _ := filepath.Glob(filepath.Join(s.snapshotDir(), string(csvType)+".*"))
+	if len(matches) == 0 {
+		return ""
+	}
+	return matches[0]
+}
+
+// saveSnapshot copia el archivo descargado como snapshot del tipo
+func (s *GCOScraper) saveSnapshot(csvType CSVType, path string) error {
+	dir := s.snapshotDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if old := s.previousSnapshot(csvType); old != "" {
+		os.Remove(old)
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(filepath.Join(dir, string(csvType)+strings.ToLower(filepath.Ext(path))))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// computeChanges calcula y guarda los cambios de cada archivo respecto al
+// snapshot anterior. La primera ejecución de un tipo solo fija la base.
+// El snapshot solo avanza si los cambios se guardaron, para no perderlos.
+func (s *GCOScraper) computeChanges(result *ScraperResult) {
+	for _, file := range result.Files {
+		previous := s.previousSnapshot(file.Type)
+		if previous == "" {
+			log.Printf("[Scraper] Sin snapshot anterior de %s: se toma como base", file.Type)
+			if err := s.saveSnapshot(file.Type, file.Path); err != nil {
+				log.Printf("[Scraper] ⚠️ Error guardando snapshot de %s: %v", file.Type, err)
+			}
+			continue
+		}
+
+		changes, err := diffSnapshots(file.Type, previous, file.Path)
+		if err != nil {
+			log.Printf("[Scraper] ⚠️ Error calculando cambios de %s: %v", file.Type, err)
+			continue
+		}
+		for i := range changes {
+			changes[i].RunID = result.RunID
+		}
+		if err := saveRunChanges(result.RunID, changes); err != nil {
+			log.Printf("[Scraper] ⚠️ Error guardando cambios de %s: %v", file.Type, err)
+			continue
+		}
+
+		if result.Changes == nil {
+			result.Changes = make(map[string]int)
+		}
+		if len(changes) > 0 {
+			result.Changes[changes[0].Change] += len(changes)
+		}
+		log.Printf("[Scraper] %s: %d cambios respecto a la ejecución anterior", file.Type, len(changes))
+
+		if err := s.saveSnapshot(file.Type, file.Path); err != nil {
+			log.Printf("[Scraper] ⚠️ Error guardando snapshot de %s: %v", file.Type, err)
+		}
+	}
+}
+
+// saveRunChanges guarda los cambios de una ejecución (sustituible en tests)
+var saveRunChanges = saveRunChangesPostgres
+
+func saveRunChangesPostgres(runID string, changes []RunChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	if db.PostgresDB == nil {
+		return fmt.Errorf("PostgreSQL no inicializado")
+	}
+
+	tx, err := db.PostgresDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(pq.CopyIn("scraper_run_changes",
+		"run_id", "csv_type", "change_type", "entity_key", "previous_value", "current_value", "data"))
+	if err != nil {
+		return err
+	}
+	for _, ch := range changes {
+		data, err := json.Marshal(ch.Data)
+		if err != nil {
+			return err
+		}
+		if _, err := stmt.Exec(runID, string(ch.Type), ch.Change, ch.Key,
+			nullString(ch.Previous), nullString(ch.Current), string(data)); err != nil {
+			return err
+		}
+	}
+	if _, err := stmt.Exec(); err != nil {
+		return err
+	}
+	if err := stmt.Close(); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// RunChangesFilter filtros de la consulta de cambios
+type RunChangesFilter struct {
+	Type   CSVType
+	Change string
+	Limit  int
+	Offset int
+}
+
+// listRunChanges cambios de una ejecución, el total filtrado y el resumen por tipo de cambio
+func listRunChanges(runID string, filter RunChangesFilter) ([]RunChange, int, map[string]int, error) {
+	whereClauses := []string{"run_id = $1"}
+	args := []interface{}{runID}
+	argPos := 2
+
+	if filter.Type != "" {
+		whereClauses = append(whereClauses, fmt.Sprintf("csv_type = $%d", argPos))
+		args = append(args, string(filter.Type))
+		argPos++
+	}
+	if filter.Change != "" {
+		whereClauses = append(whereClauses, fmt.Sprintf("change_type = $%d", argPos))
+		args = append(args, filter.Change)
+		argPos++
+	}
+	where := strings.Join(whereClauses, " AND ")
+
+	summary := make(map[string]int)
+	rows, err := db.PostgresDB.Query(
+		"SELECT change_type, COUNT(*) FROM scraper_run_changes WHERE run_id = $1 GROUP BY change_type", runID)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	for rows.Next() {
+		var change string
+		var count int
+		if err := rows.Scan(&change, &count); err != nil {
+			rows.Close()
+			return nil, 0, nil, err
+		}
+		summary[change] = count
+	}
+	rows.Close()
+
+	var total int
+	if err := db.PostgresDB.QueryRow("SELECT COUNT(*) FROM scraper_run_changes WHERE "+where, args...).Scan(&total); err != nil {
+		return nil, 0, nil, err
+	}
+
+	query := fmt.Sprintf(`
+		SELECT id, run_id, csv_type, change_type, entity_key,
+			COALESCE(previous_value, ''), COALESCE(current_value, ''), data, detected_at
+		FROM scraper_run_changes
+		WHERE %s
+		ORDER BY change_type, id
+		LIMIT $%d OFFSET $%d
+	`, where, argPos, argPos+1)
+	args = append(args, filter.Limit, filter.Offset)
+
+	rows, err = db.PostgresDB.Query(query, args...)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	defer rows.Close()
+
+	changes := []RunChange{}
+	for rows.Next() {
+		var ch RunChange
+		var data []byte
+		if err := rows.Scan(&ch.ID, &ch.RunID, &ch.Type, &ch.Change, &ch.Key,
+			&ch.Previous, &ch.Current, &data, &ch.DetectedAt); err != nil {
+			return nil, 0, nil, err
+		}
+		if len(data) > 0 {
+			json.Unmarshal(data, &ch.Data)
+		}
+		changes = append(changes, ch)
+	}
+	return changes, total, summary, rows.Err()
+}
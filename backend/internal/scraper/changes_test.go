@@ -0,0 +1,110 @@
+package scraper
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeCSV(t *testing.T, dir, name string, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDiffSnapshotsRecibos(t *testing.T) {
+	dir := t.TempDir()
+	header := "Nº recibo;Nº póliza;Situación del recibo;Detalle del recibo;Prima total"
+	prev := writeCSV(t, dir, "prev.csv", header,
+		"R1;P1;Pendiente;;100,00",
+		"R2;P1;Retornado;;50,00",
+		"R3;P2;Cobrado;;20,00",
+	)
+	cur := writeCSV(t, dir, "cur.csv", header,
+		"R1;P1;Retornado;;100,00",              // Pendiente → Retornado
+		"R2;P1;Retornado;;50,00",               // Ya estaba devuelto
+		"R3;P2;Pendiente;Devuelto banco;20,00", // Detalle Devuelto
+		"R4;P3;Retornado;;10,00",               // Nuevo y ya devuelto
+		"R5;P3;Pendiente;;10,00",
+	)
+
+	changes, err := diffSnapshots(CSVRecibos, prev, cur)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, ch := range changes {
+		keys = append(keys, ch.Key)
+		if ch.Change != ChangeReciboDevuelto {
+			t.Fatalf("cambio = %s", ch.Change)
+		}
+	}
+	if got := strings.Join(keys, ","); got != "R1,R3,R4" {
+		t.Fatalf("recibos devueltos = %s", got)
+	}
+	if changes[0].Previous != "Pendiente" || changes[0].Current != "Retornado" || changes[0].Data["prima_total"] != "100,00" {
+		t.Fatalf("cambio R1 = %+v", changes[0])
+	}
+	if changes[2].Previous != "" {
+		t.Fatalf("R4 es nuevo, previous = %q", changes[2].Previous)
+	}
+}
+
+func TestDiffSnapshotsAltasYAnulaciones(t *testing.T) {
+	dir := t.TempDir()
+
+	prev := writeCSV(t, dir, "c1.csv", "NIF;IdAccount;Nombre completo", "11111111H;A1;Ana")
+	cur := writeCSV(t, dir, "c2.csv", "NIF;IdAccount;Nombre completo", "11111111H;A1;Ana", "22222222J;A2;Luis")
+	changes, err := diffSnapshots(CSVClientes, prev, cur)
+	if err != nil || len(changes) != 1 || changes[0].Key != "A2" || changes[0].Change != ChangeNuevoCliente {
+		t.Fatalf("clientes: %+v, %v", changes, err)
+	}
+
+	header := "Número de la póliza;IdAccount;Situación de la póliza"
+	prev = writeCSV(t, dir, "p1.csv", header, "P1;A1;Vigor", "P2;A1;Anulada")
+	cur = writeCSV(t, dir, "p2.csv", header, "P1;A1;Anulada", "P2;A1;Anulada", "P3;A2;Vigor")
+	changes, err = diffSnapshots(CSVPolizas, prev, cur)
+	if err != nil || len(changes) != 1 || changes[0].Key != "P1" || changes[0].Change != ChangePolizaAnulada {
+		t.Fatalf("pólizas: %+v, %v", changes, err)
+	}
+}
+
+func TestComputeChangesBaseline(t *testing.T) {
+	var saved []RunChange
+	prev := saveRunChanges
+	saveRunChanges = func(runID string, changes []RunChange) error {
+		saved = append(saved, changes...)
+		return nil
+	}
+	t.Cleanup(func() { saveRunChanges = prev })
+
+	dir := t.TempDir()
+	s := &GCOScraper{DownloadDir: dir}
+	header := "Número de siniestro;Número de póliza;Situación del siniestro"
+
+	// Primera ejecución: solo fija la base
+	path := writeCSV(t, dir, "SINIESTROS.csv", header, "S1;P1;Abierto")
+	first := &ScraperResult{RunID: "run-1", Files: []DownloadedFile{{Type: CSVSiniestros, Path: path}}}
+	s.computeChanges(first)
+	if len(saved) != 0 || s.previousSnapshot(CSVSiniestros) == "" {
+		t.Fatalf("base: guardados = %d, snapshot = %q", len(saved), s.previousSnapshot(CSVSiniestros))
+	}
+
+	// Segunda: el siniestro nuevo
+	writeCSV(t, dir, "SINIESTROS.csv", header, "S1;P1;Cerrado", "S2;P2;Abierto")
+	second := &ScraperResult{RunID: "run-2", Files: []DownloadedFile{{Type: CSVSiniestros, Path: path}}}
+	s.computeChanges(second)
+	if len(saved) != 1 || saved[0].Key != "S2" || saved[0].RunID != "run-2" || second.Changes[ChangeNuevoSiniestro] != 1 {
+		t.Fatalf("cambios = %+v, resumen = %v", saved, second.Changes)
+	}
+
+	// Tercera sin cambios: el snapshot avanzó
+	s.computeChanges(&ScraperResult{RunID: "run-3", Files: []DownloadedFile{{Type: CSVSiniestros, Path: path}}})
+	if len(saved) != 1 {
+		t.Fatalf("cambios repetidos: %+v", saved)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/chromedp/chromedp"
@@ -15,35 +16,48 @@ import (
 // Downloader maneja la navegación y descarga de CSVs
 type Downloader struct {
 	DownloadDir string
-	Configs     map[CSVType]DownloadConfig
+	BaseURL     string // Portada del portal (para las pestañas en paralelo)
+	Screenshots *ScreenshotLog
+	// Profile perfil de portal de la ejecución en curso (nil = el activo)
+	Profile *PortalProfile
+	// downloadMu con varias pestañas, las exportaciones van de una en una: la
+	// descarga se detecta por el archivo nuevo en DownloadDir
+	downloadMu sync.Mutex
 }
 
 // NewDownloader crea un nuevo Downloader
 func NewDownloader(downloadDir string) *Downloader {
 	return &Downloader{
 		DownloadDir: downloadDir,
-		Configs:     DefaultDownloadConfigs,
+		Screenshots: NewScreenshotLog(),
 	}
 }
 
-// DownloadCSV descarga un CSV específico del portal
-func (d *Downloader) DownloadCSV(ctx context.Context, csvType CSVType) (*DownloadedFile, error) {
-	config, ok := d.Configs[csvType]
+// DownloadCSV descarga un CSV específico del portal, filtrado por rng si no es nil
+func (d *Downloader) DownloadCSV(ctx context.Context, csvType CSVType, rng *DateRange) (*DownloadedFile, error) {
+	profile := d.Profile
+	if profile == nil {
+		profile = portalProfiles.Current().PortalProfile
+	}
+	config, ok := profile.Downloads[csvType]
 	if !ok {
 		return nil, fmt.Errorf("configuración no encontrada para: %s", csvType)
 	}
+	if rng != nil && config.DateFilter == nil {
+		return nil, fmt.Errorf("el perfil de portal no tiene filtro de fechas para %s", csvType)
+	}
 
 	log.Printf("[Downloader] Iniciando descarga de %s...", csvType)
 
 	// Navegar por los menús hasta llegar a la sección deseada
-	for i, menuItem := range config.MenuPath {
-		log.Printf("[Downloader] Navegando a menú: %s (%d/%d)", menuItem, i+1, len(config.MenuPath))
+	for i, menuItem := range config.Menu {
+		log.Printf("[Downloader] Navegando a menú: %s (%d/%d)", menuItem[0], i+1, len(config.Menu))
 
 		err := d.clickMenu(ctx, menuItem)
 		if err != nil {
 			// Tomar screenshot para debug
 			d.takeScreenshot(ctx, fmt.Sprintf("menu_error_%s_%d.png", csvType, i))
-			return nil, fmt.Errorf("error navegando a menú '%s': %w", menuItem, err)
+			return nil, fmt.Errorf("error navegando a menú '%s': %w", menuItem[0], err)
 		}
 
 		// Esperar a que cargue la página
@@ -52,12 +66,19 @@ func (d *Downloader) DownloadCSV(ctx context.Context, csvType CSVType) (*Downloa
 
 	// Esperar a que la tabla/grid de datos esté visible
 	log.Println("[Downloader] Esperando a que carguen los datos...")
-	err := d.waitForData(ctx, config.WaitSelector)
+	err := d.waitForData(ctx, config.Wait)
 	if err != nil {
 		log.Printf("[Downloader] Advertencia: no se detectó tabla de datos: %v", err)
 		// Continuar de todos modos, puede que la estructura sea diferente
 	}
 
+	if rng != nil {
+		if err := d.applyDateFilter(ctx, config.DateFilter, rng); err != nil {
+			d.takeScreenshot(ctx, fmt.Sprintf("filter_error_%s.png", csvType))
+			return nil, fmt.Errorf("error filtrando %s por fechas: %w", csvType, err)
+		}
+	}
+
 	// Buscar y hacer click en el botón de exportar
 	log.Println("[Downloader] Buscando botón de exportar...")
 	downloadPath, err := d.clickExportAndDownload(ctx, config)
@@ -84,174 +105,94 @@ func (d *Downloader) DownloadCSV(ctx context.Context, csvType CSVType) (*Downloa
 	return result, nil
 }
 
-// clickMenu hace click en un elemento del menú
-func (d *Downloader) clickMenu(ctx context.Context, menuItem string) error {
-	// Selectores para encontrar elementos del menú
-	menuSelectors := []string{
-		fmt.Sprintf(`a:contains("%s")`, menuItem),
-		fmt.Sprintf(`span:contains("%s")`, menuItem),
-		fmt.Sprintf(`li:contains("%s") > a`, menuItem),
-		fmt.Sprintf(`[data-menu="%s"]`, menuItem),
-		fmt.Sprintf(`[title="%s"]`, menuItem),
-		fmt.Sprintf(`nav a[href*="%s" i]`, strings.ToLower(menuItem)),
-		fmt.Sprintf(`.menu-item:contains("%s")`, menuItem),
-		fmt.Sprintf(`.nav-link:contains("%s")`, menuItem),
+// applyDateFilter rellena desde/hasta en el listado y aplica el filtro
+func (d *Downloader) applyDateFilter(ctx context.Context, filter *DateFilterProfile, rng *DateRange) error {
+	from, to := rng.From.Format(filter.layout()), rng.To.Format(filter.layout())
+	log.Printf("[Downloader] Filtrando por fechas: %s - %s", from, to)
+
+	if _, err := fillTarget(ctx, filter.From, "date_from", from); err != nil {
+		return fmt.Errorf("fecha desde: %w", err)
+	}
+	if _, err := fillTarget(ctx, filter.To, "date_to", to); err != nil {
+		return fmt.Errorf("fecha hasta: %w", err)
 	}
 
-	// Primero intentar con JavaScript para manejar :contains()
-	for _, sel := range menuSelectors {
-		var clicked bool
-
-		// Usar evaluación JavaScript para selectores con :contains()
-		if strings.Contains(sel, ":contains") {
-			// Convertir :contains a XPath o búsqueda JS
-			searchText := strings.ToLower(menuItem)
-			jsCode := fmt.Sprintf(`
-				(function() {
-					const elements = document.querySelectorAll('a, span, li, button, div');
-					for (let el of elements) {
-						if (el.textContent.toLowerCase().includes('%s')) {
-							el.click();
-							return true;
-						}
-					}
-					return false;
-				})()
-			`, searchText)
-
-			err := chromedp.Run(ctx, chromedp.Evaluate(jsCode, &clicked))
-			if err == nil && clicked {
-				log.Printf("[Downloader] Menú '%s' clickeado via JS", menuItem)
-				return nil
-			}
-		} else {
-			// Selector CSS normal
-			var exists bool
-			err := chromedp.Run(ctx,
-				chromedp.Evaluate(fmt.Sprintf(`document.querySelector('%s') !== null`, sel), &exists),
-			)
-			if err == nil && exists {
-				err = chromedp.Run(ctx,
-					chromedp.Click(sel, chromedp.ByQuery),
-				)
-				if err == nil {
-					log.Printf("[Downloader] Menú '%s' clickeado via selector: %s", menuItem, sel)
-					return nil
-				}
-			}
+	if len(filter.Apply) > 0 {
+		loc, ok := clickTarget(ctx, filter.Apply, "date_apply")
+		if !ok {
+			return fmt.Errorf("no se encontró el botón de aplicar el filtro")
 		}
+		log.Printf("[Downloader] Filtro aplicado: %s", loc)
 	}
 
-	return fmt.Errorf("no se encontró elemento de menú: %s", menuItem)
+	// Esperar a que el listado se recargue con el filtro
+	time.Sleep(2 * time.Second)
+	return nil
 }
 
-// waitForData espera a que los datos estén visibles
-func (d *Downloader) waitForData(ctx context.Context, waitSelector string) error {
-	selectors := strings.Split(waitSelector, ", ")
-
-	for _, sel := range selectors {
-		sel = strings.TrimSpace(sel)
-		var exists bool
-		err := chromedp.Run(ctx,
-			chromedp.Evaluate(fmt.Sprintf(`document.querySelector('%s') !== null`, sel), &exists),
-		)
-		if err == nil && exists {
-			// Esperar a que sea visible
-			ctx2, cancel := context.WithTimeout(ctx, 30*time.Second)
-			defer cancel()
-
-			err = chromedp.Run(ctx2, chromedp.WaitVisible(sel, chromedp.ByQuery))
-			if err == nil {
-				log.Printf("[Downloader] Datos visibles (selector: %s)", sel)
-				return nil
-			}
-		}
+// openHome abre la portada del portal en una pestaña nueva (comparte las
+// cookies de la sesión con la pestaña del login)
+func (d *Downloader) openHome(ctx context.Context) error {
+	if d.BaseURL == "" {
+		return fmt.Errorf("GCO_BASE_URL no configurada")
 	}
+	return chromedp.Run(ctx,
+		chromedp.Navigate(d.BaseURL),
+		chromedp.WaitReady("body", chromedp.ByQuery),
+	)
+}
 
-	return fmt.Errorf("no se encontró indicador de datos cargados")
+// clickMenu hace click en un elemento del menú
+func (d *Downloader) clickMenu(ctx context.Context, menuItem Target) error {
+	loc, ok := clickTarget(ctx, menuItem, "menu")
+	if !ok {
+		return fmt.Errorf("no se encontró elemento de menú")
+	}
+	log.Printf("[Downloader] Menú clickeado: %s", loc)
+	return nil
 }
 
-// clickExportAndDownload hace click en exportar y espera la descarga
-func (d *Downloader) clickExportAndDownload(ctx context.Context, config DownloadConfig) (string, error) {
-	exportSelectors := strings.Split(config.ExportButton, ", ")
+// waitForData espera a que los datos estén visibles
+func (d *Downloader) waitForData(ctx context.Context, wait Target) error {
+	if len(wait) == 0 {
+		return nil
+	}
 
-	// También buscar por texto
-	exportTexts := []string{"Exportar", "Export", "Excel", "CSV", "Descargar", "Download"}
+	loc, sel, ok := locate(ctx, wait, "data")
+	if !ok {
+		return fmt.Errorf("no se encontró indicador de datos cargados")
+	}
 
-	// Intentar selectores CSS primero
-	for _, sel := range exportSelectors {
-		sel = strings.TrimSpace(sel)
-		if strings.Contains(sel, ":contains") {
-			continue // Lo manejaremos con JS
-		}
+	// Esperar a que sea visible
+	ctx2, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
 
-		var exists bool
-		err := chromedp.Run(ctx,
-			chromedp.Evaluate(fmt.Sprintf(`document.querySelector('%s') !== null`, sel), &exists),
-		)
-		if err == nil && exists {
-			log.Printf("[Downloader] Botón export encontrado: %s", sel)
-
-			// Hacer click
-			err = chromedp.Run(ctx, chromedp.Click(sel, chromedp.ByQuery))
-			if err == nil {
-				return d.waitForDownload(config.FileName)
-			}
-		}
+	if err := chromedp.Run(ctx2, chromedp.WaitVisible(sel, chromedp.ByQuery)); err != nil {
+		return fmt.Errorf("indicador de datos no visible (%s): %w", loc, err)
 	}
+	log.Printf("[Downloader] Datos visibles (selector: %s)", loc)
+	return nil
+}
 
-	// Buscar por texto con JavaScript
-	for _, text := range exportTexts {
-		var clicked bool
-		jsCode := fmt.Sprintf(`
-			(function() {
-				const elements = document.querySelectorAll('button, a, input[type="button"], input[type="submit"]');
-				for (let el of elements) {
-					if (el.textContent.toLowerCase().includes('%s') ||
-						(el.title && el.title.toLowerCase().includes('%s')) ||
-						(el.value && el.value.toLowerCase().includes('%s'))) {
-						el.click();
-						return true;
-					}
-				}
-				return false;
-			})()
-		`, strings.ToLower(text), strings.ToLower(text), strings.ToLower(text))
-
-		err := chromedp.Run(ctx, chromedp.Evaluate(jsCode, &clicked))
-		if err == nil && clicked {
-			log.Printf("[Downloader] Botón '%s' clickeado", text)
-			return d.waitForDownload(config.FileName)
-		}
-	}
+// clickExportAndDownload hace click en exportar y espera la descarga
+func (d *Downloader) clickExportAndDownload(ctx context.Context, config DownloadConfig) (string, error) {
+	d.downloadMu.Lock()
+	defer d.downloadMu.Unlock()
 
-	// Último intento: buscar cualquier botón/link con icono de descarga
-	var clicked bool
-	jsCode := `
-		(function() {
-			// Buscar iconos comunes de descarga/export
-			const icons = document.querySelectorAll('[class*="download"], [class*="export"], [class*="excel"], [class*="csv"]');
-			for (let icon of icons) {
-				let clickable = icon.closest('button, a');
-				if (clickable) {
-					clickable.click();
-					return true;
-				}
-			}
-			return false;
-		})()
-	`
-	err := chromedp.Run(ctx, chromedp.Evaluate(jsCode, &clicked))
-	if err == nil && clicked {
-		log.Println("[Downloader] Botón con icono de descarga clickeado")
-		return d.waitForDownload(config.FileName)
-	}
+	// Solo cuentan los archivos escritos después del click (margen de 1s por la
+	// resolución del mtime): las descargas anteriores siguen en el directorio
+	since := time.Now().Add(-time.Second)
 
-	return "", fmt.Errorf("no se encontró botón de exportar")
+	loc, ok := clickTarget(ctx, config.Export, "export")
+	if !ok {
+		return "", fmt.Errorf("no se encontró botón de exportar")
+	}
+	log.Printf("[Downloader] Botón export clickeado: %s", loc)
+	return d.waitForDownload(config.FileName, since)
 }
 
 // waitForDownload espera a que se complete la descarga
-func (d *Downloader) waitForDownload(expectedFileName string) (string, error) {
+func (d *Downloader) waitForDownload(expectedFileName string, since time.Time) (string, error) {
 	log.Printf("[Downloader] Esperando descarga de %s...", expectedFileName)
 
 	// Ruta esperada del archivo
@@ -267,7 +208,7 @@ func (d *Downloader) waitForDownload(expectedFileName string) (string, error) {
 
 	for time.Now().Before(deadline) {
 		// Buscar el archivo esperado
-		if info, err := os.Stat(expectedPath); err == nil {
+		if info, err := os.Stat(expectedPath); err == nil && !info.ModTime().Before(since) {
 			currentSize := info.Size()
 
 			// Verificar que el archivo no está siendo escrito (tamaño estable)
@@ -291,8 +232,8 @@ func (d *Downloader) waitForDownload(expectedFileName string) (string, error) {
 				continue
 			}
 
-			// Si el archivo fue modificado en los últimos 2 minutos
-			if time.Since(info.ModTime()) < 2*time.Minute {
+			// Si el archivo se escribió después del click
+			if !info.ModTime().Before(since) {
 				currentSize := info.Size()
 
 				// Verificar estabilidad
@@ -314,7 +255,7 @@ func (d *Downloader) waitForDownload(expectedFileName string) (string, error) {
 			}
 		}
 
-		// También buscar archivos .xlsx (Excel) que podrían necesitar conversión
+		// GCO a veces exporta Excel: el import lo lee directamente (sin conversión)
 		xlsxFiles, _ := filepath.Glob(filepath.Join(d.DownloadDir, "*.xlsx"))
 		for _, file := range xlsxFiles {
 			info, err := os.Stat(file)
@@ -322,9 +263,14 @@ func (d *Downloader) waitForDownload(expectedFileName string) (string, error) {
 				continue
 			}
 
-			if time.Since(info.ModTime()) < 2*time.Minute && info.Size() > 0 {
+			if !info.ModTime().Before(since) && info.Size() > 0 {
+				// Renombrar al nombre esperado conservando la extensión .xlsx
+				xlsxPath := strings.TrimSuffix(expectedPath, filepath.Ext(expectedPath)) + ".xlsx"
+				if file != xlsxPath {
+					os.Rename(file, xlsxPath)
+					file = xlsxPath
+				}
 				log.Printf("[Downloader] Archivo Excel descargado: %s", file)
-				// Por ahora retornamos el xlsx, después se puede añadir conversión
 				return file, nil
 			}
 		}
@@ -337,21 +283,75 @@ func (d *Downloader) waitForDownload(expectedFileName string) (string, error) {
 
 // takeScreenshot toma una captura de pantalla para debug
 func (d *Downloader) takeScreenshot(ctx context.Context, filename string) {
+	if d.Screenshots == nil {
+		d.Screenshots = NewScreenshotLog()
+	}
+	d.Screenshots.Take(ctx, filename)
+}
+
+// ScreenshotLog guarda las capturas de debug en GCO_LOG_DIR con el prefijo de
+// la ejecución y recuerda las rutas para el historial de ejecuciones
+type ScreenshotLog struct {
+	Dir    string
+	prefix string
+	paths  []string
+	mu     sync.Mutex
+}
+
+// NewScreenshotLog crea el registro de capturas en GCO_LOG_DIR
+func NewScreenshotLog() *ScreenshotLog {
+	dir := os.Getenv("GCO_LOG_DIR")
+	if dir == "" {
+		dir = "/opt/soriano/logs/scraper"
+	}
+	return &ScreenshotLog{Dir: dir}
+}
+
+// Reset empieza una ejecución nueva: las capturas llevan el prefijo indicado
+func (l *ScreenshotLog) Reset(prefix string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.prefix = prefix
+	l.paths = nil
+}
+
+// Paths capturas tomadas desde el último Reset
+func (l *ScreenshotLog) Paths() []string {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.paths...)
+}
+
+// Take captura la pantalla y la guarda; devuelve la ruta ("" si falló)
+func (l *ScreenshotLog) Take(ctx context.Context, filename string) string {
 	var screenshot []byte
 	err := chromedp.Run(ctx, chromedp.CaptureScreenshot(&screenshot))
-	if err != nil {
+	if err != nil || len(screenshot) == 0 {
 		log.Printf("[Downloader] Error tomando screenshot: %v", err)
-		return
+		return ""
 	}
 
-	screenshotPath := filepath.Join(os.Getenv("GCO_LOG_DIR"), filename)
-	err = os.WriteFile(screenshotPath, screenshot, 0644)
-	if err != nil {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.prefix != "" {
+		filename = l.prefix + "_" + filename
+	}
+	screenshotPath := filepath.Join(l.Dir, filename)
+	if err := os.WriteFile(screenshotPath, screenshot, 0644); err != nil {
 		log.Printf("[Downloader] Error guardando screenshot: %v", err)
-		return
+		return ""
 	}
+	l.paths = append(l.paths, screenshotPath)
 
 	log.Printf("[Downloader] Screenshot guardado: %s", screenshotPath)
+	return screenshotPath
 }
 
 // DownloadAll descarga todos los tipos de CSV
@@ -359,10 +359,8 @@ func (d *Downloader) DownloadAll(ctx context.Context) ([]*DownloadedFile, []erro
 	var files []*DownloadedFile
 	var errors []error
 
-	csvTypes := []CSVType{CSVClientes, CSVPolizas, CSVRecibos, CSVSiniestros}
-
-	for _, csvType := range csvTypes {
-		file, err := d.DownloadCSV(ctx, csvType)
+	for _, csvType := range AllCSVTypes {
+		file, err := d.DownloadCSV(ctx, csvType, nil)
 		if err != nil {
 			errors = append(errors, fmt.Errorf("%s: %w", csvType, err))
 			continue
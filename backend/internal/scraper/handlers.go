@@ -2,19 +2,50 @@ package scraper
 
 import (
 	"context"
+	"errors"
 	"log"
+	"strconv"
+	"strings"
 	"time"
 
+	"soriano-mediadores/internal/api"
+	"soriano-mediadores/internal/auth"
+
 	"github.com/gofiber/fiber/v2"
 )
 
 // RunScraperHandler ejecuta el scraper manualmente
-// POST /api/scraper/run
+// POST /api/scraper/run?import_mode=add|update|replace|none
+//
+//	&types=recibos,polizas     solo esos tipos (por defecto todos)
+//	&from=2026-10-01&to=2026-10-16 o &last_days=7   filtro de fechas del listado
+//	&parallel=2                pestañas simultáneas (máximo 4)
 func RunScraperHandler(c *fiber.Ctx) error {
 	if GlobalScraper == nil {
 		InitScraper()
 	}
 
+	opts := DefaultRunOptions()
+	switch mode := c.Query("import_mode"); mode {
+	case "":
+	case "none":
+		opts.ImportMode = ""
+	default:
+		if !api.IsValidImportMode(api.ImportMode(mode)) {
+			return c.Status(400).JSON(fiber.Map{
+				"success": false,
+				"error":   "import_mode inválido (add, update, replace o none)",
+			})
+		}
+		opts.ImportMode = api.ImportMode(mode)
+	}
+	if err := parseRunSelection(func(key string) string { return c.Query(key) }, &opts); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
 	if GlobalScraper.IsRunning {
 		return c.Status(409).JSON(fiber.Map{
 			"success": false,
@@ -29,19 +60,23 @@ func RunScraperHandler(c *fiber.Ctx) error {
 		defer cancel()
 
 		log.Println("[Handler] Iniciando scraper manualmente...")
-		result, err := GlobalScraper.RunWithRetry(ctx, DefaultRetryConfig)
+		result, err := GlobalScraper.RunWithRetry(ctx, DefaultRetryConfig, opts)
 
 		if err != nil {
 			log.Printf("[Handler] Error en scraper: %v", err)
 		} else {
-			log.Printf("[Handler] Scraper completado. Archivos: %d", len(result.Files))
+			log.Printf("[Handler] Scraper completado. Archivos: %d, Imports: %d", len(result.Files), len(result.ImportJobIDs))
 		}
 	}()
 
 	return c.JSON(fiber.Map{
-		"success": true,
-		"message": "Scraper iniciado en background",
-		"status":  StatusRunning,
+		"success":     true,
+		"message":     "Scraper iniciado en background",
+		"status":      StatusRunning,
+		"import_mode": opts.ImportMode,
+		"types":       opts.selectedTypes(),
+		"date_range":  opts.dateRangeAt(time.Now()),
+		"parallel":    opts.parallelTabs(),
 	})
 }
 
@@ -96,12 +131,16 @@ func StopScraperHandler(c *fiber.Ctx) error {
 	})
 }
 
-// ConfigureScheduleHandler configura el schedule del scraper
+// ConfigureScheduleHandler configura el schedule "default" (se mantiene por
+// compatibilidad; los schedules se gestionan en /api/scraper/schedules)
 // POST /api/scraper/schedule
 func ConfigureScheduleHandler(c *fiber.Ctx) error {
 	var req struct {
-		Cron    string `json:"cron"`
-		Enabled *bool  `json:"enabled"`
+		Cron     string   `json:"cron"`
+		Enabled  *bool    `json:"enabled"`
+		Types    []string `json:"types"`     // Tipos de cada ejecución ([] = todos)
+		LastDays *int     `json:"last_days"` // Últimos N días (0 = exportación completa)
+		Parallel *int     `json:"parallel"`
 	}
 
 	if err := c.BodyParser(&req); err != nil {
@@ -111,37 +150,45 @@ func ConfigureScheduleHandler(c *fiber.Ctx) error {
 		})
 	}
 
-	if GlobalScheduler == nil {
-		if GlobalScraper == nil {
-			InitScraper()
-		}
-		GlobalScheduler = NewScraperScheduler(GlobalScraper)
+	ss, err := schedulerOrInit()
+	if err != nil {
+		return scheduleError(c, err)
 	}
 
-	// Actualizar cron si se proporciona
+	sc := ss.scheduleByName("default")
+	if sc == nil {
+		sc = ss.envSchedule()
+		sc.ID = 0
+	}
 	if req.Cron != "" {
-		err := GlobalScheduler.UpdateCron(req.Cron)
+		sc.Cron = req.Cron
+	}
+	if req.Enabled != nil {
+		sc.Enabled = *req.Enabled
+	}
+	if req.Types != nil {
+		types, err := ParseCSVTypes(strings.Join(req.Types, ","))
 		if err != nil {
-			return c.Status(400).JSON(fiber.Map{
-				"success": false,
-				"error":   "Expresión cron inválida: " + err.Error(),
-			})
+			return c.Status(400).JSON(fiber.Map{"success": false, "error": err.Error()})
 		}
+		sc.Types = types
+	}
+	if req.LastDays != nil {
+		sc.LastDays = *req.LastDays
+	}
+	if req.Parallel != nil {
+		sc.Parallel = *req.Parallel
 	}
 
-	// Habilitar/deshabilitar si se proporciona
-	if req.Enabled != nil {
-		if *req.Enabled {
-			GlobalScheduler.Enable()
-		} else {
-			GlobalScheduler.Disable()
-		}
+	saved, err := ss.Save(sc)
+	if err != nil {
+		return scheduleError(c, err)
 	}
 
 	return c.JSON(fiber.Map{
 		"success":  true,
 		"message":  "Schedule actualizado",
-		"schedule": GlobalScheduler.GetStatus(),
+		"schedule": saved,
 	})
 }
 
@@ -181,7 +228,8 @@ func TestLoginHandler(c *fiber.Ctx) error {
 	ctx, cancelTimeout := context.WithTimeout(ctx, 2*time.Minute)
 	defer cancelTimeout()
 
-	// Intentar login
+	// Intentar login con el perfil de portal activo
+	GlobalScraper.Auth.Profile = portalProfiles.Current().PortalProfile
 	err := GlobalScraper.Auth.Login(ctx)
 	if err != nil {
 		log.Printf("[Handler] Error en test de login: %v", err)
@@ -215,10 +263,17 @@ func GetConfigHandler(c *fiber.Ctx) error {
 		username = username[:4] + "****"
 	}
 
+	profile := portalProfiles.Current()
+
 	return c.JSON(fiber.Map{
-		"base_url":     GlobalScraper.BaseURL,
-		"username":     username,
-		"download_dir": GlobalScraper.DownloadDir,
+		"base_url":        GlobalScraper.BaseURL,
+		"username":        username,
+		"download_dir":    GlobalScraper.DownloadDir,
+		"session_reuse":   GlobalScraper.Auth.ReusesSessions(),
+		"totp_configured": GlobalScraper.Auth.TOTPSecret != "",
+		"profile_version": profile.Version,
+		"profile_source":  profile.Source,
+		"parallel_tabs":   RunOptions{}.parallelTabs(),
 		"csv_types": []string{
 			string(CSVClientes),
 			string(CSVPolizas),
@@ -228,18 +283,273 @@ func GetConfigHandler(c *fiber.Ctx) error {
 	})
 }
 
+// ClearSessionHandler olvida la sesión del portal guardada: la próxima
+// ejecución hará login completo
+// DELETE /api/scraper/session
+func ClearSessionHandler(c *fiber.Ctx) error {
+	if GlobalScraper == nil {
+		InitScraper()
+	}
+	if GlobalScraper.IsRunning {
+		return c.Status(409).JSON(fiber.Map{"error": "El scraper está en ejecución"})
+	}
+
+	GlobalScraper.Auth.ClearSession()
+	return c.JSON(fiber.Map{"message": "Sesión del portal eliminada"})
+}
+
+// GetProfileHandler perfil de portal activo (selectores de login, menús y exportación)
+// GET /api/scraper/profile
+func GetProfileHandler(c *fiber.Ctx) error {
+	return c.JSON(portalProfiles.Current())
+}
+
+// ReloadProfileHandler vuelve a leer GCO_PORTAL_PROFILE sin reiniciar el servidor.
+// Si el perfil nuevo no es válido se mantiene el anterior.
+// POST /api/scraper/profile/reload
+func ReloadProfileHandler(c *fiber.Ctx) error {
+	previous := portalProfiles.Current()
+
+	loaded, err := portalProfiles.Reload()
+	if err != nil {
+		log.Printf("[Handler] ⚠️ Perfil de portal rechazado: %v", err)
+		resp := fiber.Map{
+			"error":          err.Error(),
+			"active_version": previous.Version,
+		}
+		var profileErr *ProfileError
+		if errors.As(err, &profileErr) {
+			resp["problems"] = profileErr.Problems
+		}
+		return c.Status(400).JSON(resp)
+	}
+
+	log.Printf("[Handler] Perfil de portal recargado: %s → %s", previous.Version, loaded.Version)
+	return c.JSON(fiber.Map{
+		"message":          "Perfil recargado",
+		"version":          loaded.Version,
+		"previous_version": previous.Version,
+		"source":           loaded.Source,
+		"checksum":         loaded.Checksum,
+		"changed":          loaded.Checksum != previous.Checksum,
+	})
+}
+
+// GetRunChangesHandler cambios detectados en una ejecución respecto a la anterior
+// GET /api/scraper/runs/:id/changes?type=recibos&change=recibo_devuelto&limit=100&offset=0
+func GetRunChangesHandler(c *fiber.Ctx) error {
+	filter := RunChangesFilter{
+		Type:   CSVType(c.Query("type")),
+		Change: c.Query("change"),
+		Limit:  c.QueryInt("limit", 100),
+		Offset: c.QueryInt("offset", 0),
+	}
+	if filter.Type != "" {
+		if _, ok := changeRules[filter.Type]; !ok {
+			return c.Status(400).JSON(fiber.Map{
+				"success": false,
+				"error":   "Tipo inválido (clientes, polizas, recibos o siniestros)",
+			})
+		}
+	}
+	if filter.Limit <= 0 || filter.Limit > 1000 {
+		filter.Limit = 100
+	}
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+
+	if _, err := runStore.Get(c.Params("id")); err == ErrRunNotFound {
+		return c.Status(404).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	changes, total, summary, err := listRunChanges(c.Params("id"), filter)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"success": false,
+			"error":   "Error obteniendo cambios: " + err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"run_id":  c.Params("id"),
+		"summary": summary,
+		"changes": changes,
+		"total":   total,
+		"limit":   filter.Limit,
+		"offset":  filter.Offset,
+	})
+}
+
+// GetRunsHandler historial de ejecuciones (más recientes primero)
+// GET /api/scraper/runs?status=failed&limit=20&offset=0
+func GetRunsHandler(c *fiber.Ctx) error {
+	filter := RunFilter{
+		Status:     c.Query("status"),
+		ScheduleID: c.QueryInt("schedule_id", 0),
+		Limit:      c.QueryInt("limit", 20),
+		Offset:     c.QueryInt("offset", 0),
+	}
+	if filter.Limit <= 0 || filter.Limit > 100 {
+		filter.Limit = 20
+	}
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+
+	runs, total, err := runStore.List(filter)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"success": false,
+			"error":   "Error obteniendo historial: " + err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"runs":    runs,
+		"total":   total,
+		"limit":   filter.Limit,
+		"offset":  filter.Offset,
+	})
+}
+
+// GetRunHandler detalle de una ejecución con sus archivos
+// GET /api/scraper/runs/:id
+func GetRunHandler(c *fiber.Ctx) error {
+	run, err := runStore.Get(c.Params("id"))
+	if err == ErrRunNotFound {
+		return c.Status(404).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"success": false,
+			"error":   "Error obteniendo ejecución: " + err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"run":     run,
+	})
+}
+
+// ReimportRunFileHandler vuelve a importar un archivo archivado de una ejecución
+// POST /api/scraper/runs/:id/files/:fileId/reimport?mode=update
+func ReimportRunFileHandler(c *fiber.Ctx) error {
+	run, err := runStore.Get(c.Params("id"))
+	if err == ErrRunNotFound {
+		return c.Status(404).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"success": false,
+			"error":   "Error obteniendo ejecución: " + err.Error(),
+		})
+	}
+
+	fileID, _ := strconv.ParseInt(c.Params("fileId"), 10, 64)
+	var file *DownloadedFile
+	for i := range run.Files {
+		if run.Files[i].ID == fileID {
+			file = &run.Files[i]
+		}
+	}
+	if file == nil {
+		return c.Status(404).JSON(fiber.Map{
+			"success": false,
+			"error":   "Archivo no encontrado en la ejecución",
+		})
+	}
+	if file.ArchivePath == "" {
+		return c.Status(410).JSON(fiber.Map{
+			"success": false,
+			"error":   "El archivo ya no está en el archivo (retención)",
+		})
+	}
+
+	// El archivo tiene que ser exactamente el que se descargó
+	checksum, err := fileChecksum(file.ArchivePath)
+	if err != nil {
+		return c.Status(410).JSON(fiber.Map{
+			"success": false,
+			"error":   "No se puede leer el archivo archivado: " + err.Error(),
+		})
+	}
+	if file.Checksum != "" && checksum != file.Checksum {
+		return c.Status(409).JSON(fiber.Map{
+			"success": false,
+			"error":   "El checksum del archivo archivado no coincide con el de la descarga",
+		})
+	}
+
+	mode := api.ImportMode(c.Query("mode", string(api.ModeUpdate)))
+	job, err := startImportFile(file.ArchivePath, api.ImportFileOptions{
+		Type:          api.ImportType(file.Type),
+		Mode:          mode,
+		Username:      c.Query("username", "scraper"),
+		ValidateFirst: true,
+		AcceptErrors:  mode != api.ModeReplace,
+	})
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	log.Printf("[Handler] Reimportando %s de la ejecución %s (job %s)", file.Type, run.RunID, job.ID)
+	return c.JSON(fiber.Map{
+		"success":   true,
+		"import_id": job.ID,
+		"type":      file.Type,
+		"mode":      mode,
+		"message":   "Importación iniciada",
+	})
+}
+
 // RegisterScraperRoutes registra todas las rutas del scraper
 func RegisterScraperRoutes(app *fiber.App) {
 	scraper := app.Group("/api/scraper")
 
-	scraper.Post("/run", RunScraperHandler)
-	scraper.Get("/status", GetScraperStatusHandler)
-	scraper.Get("/metrics", GetScraperMetricsHandler)
-	scraper.Post("/stop", StopScraperHandler)
-	scraper.Get("/schedule", GetScheduleStatusHandler)
-	scraper.Post("/schedule", ConfigureScheduleHandler)
-	scraper.Post("/test-login", TestLoginHandler)
-	scraper.Get("/config", GetConfigHandler)
+	// Consultar: scraper:read; lanzar/detener: scraper:run; configurar: scraper:admin
+	read := auth.RequirePermission(auth.PermScraperRead)
+	run := auth.RequirePermission(auth.PermScraperRun)
+	admin := auth.RequirePermission(auth.PermScraperAdmin)
+
+	scraper.Post("/run", run, RunScraperHandler)
+	scraper.Get("/status", read, GetScraperStatusHandler)
+	scraper.Get("/metrics", read, GetScraperMetricsHandler)
+	scraper.Post("/stop", run, StopScraperHandler)
+	scraper.Get("/schedule", read, GetScheduleStatusHandler)
+	scraper.Post("/schedule", admin, ConfigureScheduleHandler)
+	scraper.Get("/schedules", read, GetSchedulesHandler)
+	scraper.Post("/schedules", admin, CreateScheduleHandler)
+	scraper.Get("/schedules/:id", read, GetScheduleHandler)
+	scraper.Put("/schedules/:id", admin, UpdateScheduleHandler)
+	scraper.Delete("/schedules/:id", admin, DeleteScheduleHandler)
+	scraper.Post("/schedules/:id/run", run, RunScheduleHandler)
+	scraper.Get("/notifications", read, GetNotificationsHandler)
+	scraper.Post("/notifications/:id/read", read, MarkNotificationReadHandler)
+	scraper.Post("/test-login", admin, TestLoginHandler)
+	scraper.Delete("/session", admin, ClearSessionHandler)
+	scraper.Get("/config", admin, GetConfigHandler)
+	scraper.Get("/profile", read, GetProfileHandler)
+	scraper.Post("/profile/reload", admin, ReloadProfileHandler)
+	scraper.Get("/runs", read, GetRunsHandler)
+	scraper.Get("/runs/:id", read, GetRunHandler)
+	scraper.Get("/runs/:id/changes", read, GetRunChangesHandler)
+	scraper.Post("/runs/:id/files/:fileId/reimport", run, ReimportRunFileHandler)
 
 	log.Println("[Scraper] Rutas del scraper registradas en /api/scraper/*")
 }
@@ -0,0 +1,512 @@
+package scraper
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"soriano-mediadores/internal/db"
+
+	"github.com/lib/pq"
+)
+
+// ErrRunNotFound la ejecución no existe en el historial
+var ErrRunNotFound = errors.New("ejecución no encontrada")
+
+// RunFilter filtros del historial de ejecuciones
+type RunFilter struct {
+	Status     string
+	ScheduleID int // 0 = todas
+	Limit      int
+	Offset     int
+}
+
+// RunStore persistencia del historial de ejecuciones y sus archivos
+type RunStore interface {
+	// Save inserta o actualiza la ejecución y sus archivos (asigna los ID de archivo)
+	Save(result *ScraperResult) error
+	Get(id string) (*ScraperResult, error)
+	List(filter RunFilter) ([]*ScraperResult, int, error)
+	// ExpiredFiles archivos archivados descargados antes de la fecha
+	ExpiredFiles(before time.Time) ([]DownloadedFile, error)
+	// MarkPurged marca los archivos como eliminados del archivo
+	MarkPurged(ids []int64) error
+	// MarkInterrupted pasa a interrumpidas las ejecuciones que quedaron en curso
+	MarkInterrupted() (int, error)
+	// LoadMetrics recalcula las métricas agregadas desde el historial
+	LoadMetrics(m *ScraperMetrics) error
+}
+
+// runStore almacén del historial (sustituible en tests)
+var runStore RunStore = &postgresRunStore{}
+
+// StatusInterrupted el servidor se detuvo durante la ejecución
+const StatusInterrupted ScraperStatus = "interrupted"
+
+// saveRun persiste la ejecución sin interrumpir el scraper si falla
+func saveRun(result *ScraperResult) {
+	if err := runStore.Save(result); err != nil {
+		log.Printf("[Scraper] ⚠️ Error guardando ejecución %s: %v", result.RunID, err)
+	}
+}
+
+// archiveDir directorio del archivo de descargas (GCO_ARCHIVE_DIR)
+func (s *GCOScraper) archiveDir() string {
+	if dir := os.Getenv("GCO_ARCHIVE_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(s.DownloadDir, "archive")
+}
+
+// archiveRetentionFromEnv días que se conservan los archivos (GCO_ARCHIVE_RETENTION_DAYS,
+// 90 por defecto, 0 = sin límite)
+func archiveRetentionFromEnv() time.Duration {
+	days := 90
+	if v := os.Getenv("GCO_ARCHIVE_RETENTION_DAYS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			days = n
+		}
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// archiveFiles copia cada archivo descargado a <archivo>/AAAA/MM/DD/<run>/ y
+// calcula su SHA-256
+func (s *GCOScraper) archiveFiles(result *ScraperResult) {
+	dir := filepath.Join(s.archiveDir(), result.StartTime.Format("2006/01/02"), result.RunID)
+	for i := range result.Files {
+		file := &result.Files[i]
+		dest := filepath.Join(dir, filepath.Base(file.Path))
+		checksum, err := copyWithChecksum(file.Path, dest)
+		if err != nil {
+			log.Printf("[Scraper] ⚠️ Error archivando %s: %v", file.Path, err)
+			continue
+		}
+		file.Checksum = checksum
+		file.ArchivePath = dest
+	}
+}
+
+// copyWithChecksum copia src a dst y devuelve el SHA-256 del contenido
+func copyWithChecksum(src, dst string) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return "", err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(out, hash), in); err != nil {
+		out.Close()
+		return "", err
+	}
+	if err := out.Close(); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// fileChecksum SHA-256 de un archivo
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// purgeArchive elimina los archivos archivados con más antigüedad que la retención
+func (s *GCOScraper) purgeArchive(retention time.Duration) {
+	if retention <= 0 {
+		return
+	}
+	files, err := runStore.ExpiredFiles(time.Now().Add(-retention))
+	if err != nil {
+		log.Printf("[Scraper] ⚠️ Error buscando archivos caducados: %v", err)
+		return
+	}
+
+	var purged []int64
+	for _, file := range files {
+		if err := os.Remove(file.ArchivePath); err != nil && !os.IsNotExist(err) {
+			log.Printf("[Scraper] ⚠️ Error eliminando %s: %v", file.ArchivePath, err)
+			continue
+		}
+		// Quitar el directorio de la ejecución si quedó vacío
+		os.Remove(filepath.Dir(file.ArchivePath))
+		purged = append(purged, file.ID)
+	}
+	if len(purged) == 0 {
+		return
+	}
+	if err := runStore.MarkPurged(purged); err != nil {
+		log.Printf("[Scraper] ⚠️ Error marcando archivos purgados: %v", err)
+		return
+	}
+	log.Printf("[Scraper] 🧹 %d archivos eliminados del archivo (retención %s)", len(purged), retention)
+}
+
+// postgresRunStore historial en las tablas scraper_runs y scraper_run_files
+type postgresRunStore struct{}
+
+// errNoDB el historial necesita PostgreSQL
+var errNoDB = errors.New("PostgreSQL no inicializado")
+
+func (s *postgresRunStore) Save(result *ScraperResult) error {
+	if db.PostgresDB == nil {
+		return errNoDB
+	}
+	errorsJSON, _ := json.Marshal(result.Errors)
+	importIDsJSON, _ := json.Marshal(result.ImportJobIDs)
+	changesJSON, _ := json.Marshal(result.Changes)
+	screenshotsJSON, _ := json.Marshal(result.Screenshots)
+	typesJSON, _ := json.Marshal(result.Types)
+
+	var dateFrom, dateTo sql.NullTime
+	if result.DateRange != nil {
+		dateFrom = sql.NullTime{Time: result.DateRange.From, Valid: true}
+		dateTo = sql.NullTime{Time: result.DateRange.To, Valid: true}
+	}
+
+	var scheduleID sql.NullInt64
+	if result.ScheduleID > 0 {
+		scheduleID = sql.NullInt64{Int64: int64(result.ScheduleID), Valid: true}
+	}
+
+	var endedAt sql.NullTime
+	var durationMs sql.NullInt64
+	if !result.EndTime.IsZero() {
+		endedAt = sql.NullTime{Time: result.EndTime, Valid: true}
+		durationMs = sql.NullInt64{Int64: result.EndTime.Sub(result.StartTime).Milliseconds(), Valid: true}
+	}
+
+	tx, err := db.PostgresDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO scraper_runs (
+			id, status, success, started_at, ended_at, duration_ms,
+			errors, import_mode, import_job_ids, changes, screenshots, profile_version,
+			session_reused, types, date_from, date_to, schedule_id
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)
+		ON CONFLICT (id) DO UPDATE SET
+			status = EXCLUDED.status,
+			success = EXCLUDED.success,
+			ended_at = EXCLUDED.ended_at,
+			duration_ms = EXCLUDED.duration_ms,
+			errors = EXCLUDED.errors,
+			import_job_ids = EXCLUDED.import_job_ids,
+			changes = EXCLUDED.changes,
+			screenshots = EXCLUDED.screenshots,
+			session_reused = EXCLUDED.session_reused,
+			types = EXCLUDED.types
+	`, result.RunID, result.Status, result.Success, result.StartTime, endedAt, durationMs,
+		string(errorsJSON), nullString(result.ImportMode), string(importIDsJSON),
+		string(changesJSON), string(screenshotsJSON), nullString(result.ProfileVersion),
+		result.SessionReused, string(typesJSON), dateFrom, dateTo, scheduleID)
+	if err != nil {
+		return err
+	}
+
+	for i := range result.Files {
+		file := &result.Files[i]
+		if file.ID > 0 {
+			_, err = tx.Exec(`
+				UPDATE scraper_run_files SET import_job_id = $2, checksum = $3, archive_path = $4
+				WHERE id = $1
+			`, file.ID, nullString(file.ImportJobID), nullString(file.Checksum), nullString(file.ArchivePath))
+		} else {
+			err = tx.QueryRow(`
+				INSERT INTO scraper_run_files (
+					run_id, csv_type, path, size, checksum, archive_path, import_job_id, downloaded_at
+				) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+				RETURNING id
+			`, result.RunID, string(file.Type), file.Path, file.Size, nullString(file.Checksum),
+				nullString(file.ArchivePath), nullString(file.ImportJobID), file.DownloadedAt).Scan(&file.ID)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+const runColumns = `
+	id, status, success, started_at, ended_at, COALESCE(duration_ms, 0),
+	errors, COALESCE(import_mode, ''), import_job_ids, changes, screenshots,
+	COALESCE(profile_version, ''), session_reused, types, date_from, date_to, COALESCE(schedule_id, 0)
+`
+
+func scanRun(row rowScanner) (*ScraperResult, error) {
+	var r ScraperResult
+	var endedAt sql.NullTime
+	var durationMs int64
+	var errorsJSON, importIDsJSON, changesJSON, screenshotsJSON, typesJSON []byte
+	var dateFrom, dateTo sql.NullTime
+
+	err := row.Scan(&r.RunID, &r.Status, &r.Success, &r.StartTime, &endedAt, &durationMs,
+		&errorsJSON, &r.ImportMode, &importIDsJSON, &changesJSON, &screenshotsJSON, &r.ProfileVersion,
+		&r.SessionReused, &typesJSON, &dateFrom, &dateTo, &r.ScheduleID)
+	if err != nil {
+		return nil, err
+	}
+	if dateFrom.Valid && dateTo.Valid {
+		r.DateRange = &DateRange{From: dateFrom.Time, To: dateTo.Time}
+	}
+	if endedAt.Valid {
+		r.EndTime = endedAt.Time
+		r.Duration = (time.Duration(durationMs) * time.Millisecond).String()
+	}
+	json.Unmarshal(errorsJSON, &r.Errors)
+	json.Unmarshal(importIDsJSON, &r.ImportJobIDs)
+	json.Unmarshal(changesJSON, &r.Changes)
+	json.Unmarshal(screenshotsJSON, &r.Screenshots)
+	json.Unmarshal(typesJSON, &r.Types)
+	if r.Errors == nil {
+		r.Errors = []string{}
+	}
+	r.Files = []DownloadedFile{}
+	return &r, nil
+}
+
+// rowScanner abstrae *sql.Row y *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+const runFileColumns = `
+	id, csv_type, path, size, COALESCE(checksum, ''), COALESCE(archive_path, ''),
+	COALESCE(import_job_id, ''), downloaded_at
+`
+
+func scanRunFile(row rowScanner) (DownloadedFile, error) {
+	var f DownloadedFile
+	err := row.Scan(&f.ID, &f.Type, &f.Path, &f.Size, &f.Checksum, &f.ArchivePath, &f.ImportJobID, &f.DownloadedAt)
+	return f, err
+}
+
+func (s *postgresRunStore) Get(id string) (*ScraperResult, error) {
+	if db.PostgresDB == nil {
+		return nil, errNoDB
+	}
+	run, err := scanRun(db.PostgresDB.QueryRow("SELECT "+runColumns+" FROM scraper_runs WHERE id = $1", id))
+	if err == sql.ErrNoRows {
+		return nil, ErrRunNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.PostgresDB.Query("SELECT "+runFileColumns+" FROM scraper_run_files WHERE run_id = $1 ORDER BY id", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		f, err := scanRunFile(rows)
+		if err != nil {
+			return nil, err
+		}
+		run.Files = append(run.Files, f)
+	}
+	return run, rows.Err()
+}
+
+func (s *postgresRunStore) List(filter RunFilter) ([]*ScraperResult, int, error) {
+	if db.PostgresDB == nil {
+		return nil, 0, errNoDB
+	}
+	whereClauses := []string{"1=1"}
+	args := []interface{}{}
+	argPos := 1
+
+	if filter.Status != "" {
+		whereClauses = append(whereClauses, fmt.Sprintf("status = $%d", argPos))
+		args = append(args, filter.Status)
+		argPos++
+	}
+	if filter.ScheduleID > 0 {
+		whereClauses = append(whereClauses, fmt.Sprintf("schedule_id = $%d", argPos))
+		args = append(args, filter.ScheduleID)
+		argPos++
+	}
+	where := strings.Join(whereClauses, " AND ")
+
+	var total int
+	if err := db.PostgresDB.QueryRow("SELECT COUNT(*) FROM scraper_runs WHERE "+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM scraper_runs WHERE %s ORDER BY started_at DESC LIMIT $%d OFFSET $%d",
+		runColumns, where, argPos, argPos+1)
+	args = append(args, filter.Limit, filter.Offset)
+
+	rows, err := db.PostgresDB.Query(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	runs := []*ScraperResult{}
+	byID := make(map[string]*ScraperResult)
+	var ids []string
+	for rows.Next() {
+		run, err := scanRun(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		runs = append(runs, run)
+		byID[run.RunID] = run
+		ids = append(ids, run.RunID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	rows.Close()
+
+	// Archivos de las ejecuciones de la página
+	if len(ids) > 0 {
+		fileRows, err := db.PostgresDB.Query(
+			"SELECT run_id, "+runFileColumns+" FROM scraper_run_files WHERE run_id = ANY($1) ORDER BY id",
+			pq.Array(ids))
+		if err != nil {
+			return nil, 0, err
+		}
+		defer fileRows.Close()
+		for fileRows.Next() {
+			var runID string
+			var f DownloadedFile
+			if err := fileRows.Scan(&runID, &f.ID, &f.Type, &f.Path, &f.Size, &f.Checksum,
+				&f.ArchivePath, &f.ImportJobID, &f.DownloadedAt); err != nil {
+				return nil, 0, err
+			}
+			if run := byID[runID]; run != nil {
+				run.Files = append(run.Files, f)
+			}
+		}
+	}
+
+	return runs, total, nil
+}
+
+func (s *postgresRunStore) ExpiredFiles(before time.Time) ([]DownloadedFile, error) {
+	if db.PostgresDB == nil {
+		return nil, errNoDB
+	}
+	rows, err := db.PostgresDB.Query(
+		"SELECT "+runFileColumns+" FROM scraper_run_files WHERE archive_path IS NOT NULL AND downloaded_at < $1",
+		before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []DownloadedFile
+	for rows.Next() {
+		f, err := scanRunFile(rows)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
+
+func (s *postgresRunStore) MarkPurged(ids []int64) error {
+	if db.PostgresDB == nil {
+		return errNoDB
+	}
+	_, err := db.PostgresDB.Exec(
+		"UPDATE scraper_run_files SET archive_path = NULL, purged_at = NOW() WHERE id = ANY($1)",
+		pq.Array(ids))
+	return err
+}
+
+func (s *postgresRunStore) MarkInterrupted() (int, error) {
+	if db.PostgresDB == nil {
+		return 0, errNoDB
+	}
+	res, err := db.PostgresDB.Exec(`
+		UPDATE scraper_runs SET status = $1, ended_at = NOW(),
+			duration_ms = (EXTRACT(EPOCH FROM (NOW() - started_at)) * 1000)::BIGINT
+		WHERE status IN ($2, $3)
+	`, StatusInterrupted, StatusRunning, StatusImporting)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
+
+func (s *postgresRunStore) LoadMetrics(m *ScraperMetrics) error {
+	if db.PostgresDB == nil {
+		return errNoDB
+	}
+	var lastStart sql.NullTime
+	var lastStatus sql.NullString
+	var lastDurationMs, avgDurationMs sql.NullFloat64
+
+	err := db.PostgresDB.QueryRow(`
+		SELECT
+			COUNT(*),
+			COUNT(*) FILTER (WHERE success),
+			COUNT(*) FILTER (WHERE NOT success AND status <> $1),
+			COALESCE(AVG(duration_ms), 0)
+		FROM scraper_runs
+		WHERE status NOT IN ($1, $2)
+	`, StatusRunning, StatusImporting).Scan(&m.TotalRuns, &m.SuccessfulRuns, &m.FailedRuns, &avgDurationMs)
+	if err != nil {
+		return err
+	}
+
+	err = db.PostgresDB.QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM(size), 0) FROM scraper_run_files
+	`).Scan(&m.TotalFilesDownloaded, &m.TotalBytesDownloaded)
+	if err != nil {
+		return err
+	}
+
+	err = db.PostgresDB.QueryRow(`
+		SELECT started_at, status, duration_ms FROM scraper_runs
+		WHERE status NOT IN ($1, $2)
+		ORDER BY started_at DESC LIMIT 1
+	`, StatusRunning, StatusImporting).Scan(&lastStart, &lastStatus, &lastDurationMs)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	m.AverageRunDuration = time.Duration(avgDurationMs.Float64) * time.Millisecond
+	if lastStart.Valid {
+		m.LastRunTime = lastStart.Time
+		m.LastRunStatus = ScraperStatus(lastStatus.String)
+		m.LastRunDuration = time.Duration(lastDurationMs.Float64) * time.Millisecond
+	}
+	return nil
+}
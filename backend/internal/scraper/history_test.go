@@ -0,0 +1,203 @@
+package scraper
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"soriano-mediadores/internal/api"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// memoryRunStore historial en memoria para tests
+type memoryRunStore struct {
+	mu     sync.Mutex
+	runs   map[string]*ScraperResult
+	nextID int64
+	purged []int64
+}
+
+func useMemoryRunStore(t *testing.T) *memoryRunStore {
+	t.Helper()
+	store := &memoryRunStore{runs: make(map[string]*ScraperResult)}
+	prev := runStore
+	runStore = store
+	t.Cleanup(func() { runStore = prev })
+	return store
+}
+
+func (s *memoryRunStore) Save(result *ScraperResult) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range result.Files {
+		if result.Files[i].ID == 0 {
+			s.nextID++
+			result.Files[i].ID = s.nextID
+		}
+	}
+	copied := *result
+	copied.Files = append([]DownloadedFile(nil), result.Files...)
+	s.runs[result.RunID] = &copied
+	return nil
+}
+
+func (s *memoryRunStore) Get(id string) (*ScraperResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	run, ok := s.runs[id]
+	if !ok {
+		return nil, ErrRunNotFound
+	}
+	copied := *run
+	copied.Files = append([]DownloadedFile(nil), run.Files...)
+	return &copied, nil
+}
+
+func (s *memoryRunStore) List(filter RunFilter) ([]*ScraperResult, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var runs []*ScraperResult
+	for _, run := range s.runs {
+		if (filter.Status == "" || string(run.Status) == filter.Status) &&
+			(filter.ScheduleID == 0 || run.ScheduleID == filter.ScheduleID) {
+			runs = append(runs, run)
+		}
+	}
+	return runs, len(runs), nil
+}
+
+func (s *memoryRunStore) ExpiredFiles(before time.Time) ([]DownloadedFile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var files []DownloadedFile
+	for _, run := range s.runs {
+		for _, f := range run.Files {
+			if f.ArchivePath != "" && f.DownloadedAt.Before(before) {
+				files = append(files, f)
+			}
+		}
+	}
+	return files, nil
+}
+
+func (s *memoryRunStore) MarkPurged(ids []int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.purged = append(s.purged, ids...)
+	for _, run := range s.runs {
+		for i := range run.Files {
+			for _, id := range ids {
+				if run.Files[i].ID == id {
+					run.Files[i].ArchivePath = ""
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (s *memoryRunStore) MarkInterrupted() (int, error)       { return 0, nil }
+func (s *memoryRunStore) LoadMetrics(m *ScraperMetrics) error { return nil }
+
+func TestArchiveAndRetention(t *testing.T) {
+	store := useMemoryRunStore(t)
+	dir := t.TempDir()
+	s := &GCOScraper{DownloadDir: dir}
+
+	path := writeCSV(t, dir, "RECIBOS.csv", "Nº recibo", "R1")
+	old := &ScraperResult{
+		RunID:     "run-old",
+		StartTime: time.Now().Add(-100 * 24 * time.Hour),
+		Files:     []DownloadedFile{{Type: CSVRecibos, Path: path, DownloadedAt: time.Now().Add(-100 * 24 * time.Hour)}},
+	}
+	recent := &ScraperResult{
+		RunID:     "run-new",
+		StartTime: time.Now(),
+		Files:     []DownloadedFile{{Type: CSVRecibos, Path: path, DownloadedAt: time.Now()}},
+	}
+	for _, run := range []*ScraperResult{old, recent} {
+		s.archiveFiles(run)
+		if err := store.Save(run); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	archived := old.Files[0].ArchivePath
+	if archived == "" || filepath.Dir(archived) != filepath.Join(dir, "archive", old.StartTime.Format("2006/01/02"), "run-old") {
+		t.Fatalf("archivado en %q", archived)
+	}
+	if want, _ := fileChecksum(path); old.Files[0].Checksum != want || len(want) != 64 {
+		t.Fatalf("checksum = %q, want %q", old.Files[0].Checksum, want)
+	}
+
+	s.purgeArchive(90 * 24 * time.Hour)
+
+	if _, err := os.Stat(archived); !os.IsNotExist(err) {
+		t.Fatal("el archivo caducado sigue en el archivo")
+	}
+	if _, err := os.Stat(recent.Files[0].ArchivePath); err != nil {
+		t.Fatalf("el archivo reciente se borró: %v", err)
+	}
+	if len(store.purged) != 1 || store.purged[0] != old.Files[0].ID {
+		t.Fatalf("purgados = %v", store.purged)
+	}
+}
+
+func TestReimportRunFileHandler(t *testing.T) {
+	store := useMemoryRunStore(t)
+	dir := t.TempDir()
+	s := &GCOScraper{DownloadDir: dir}
+
+	var started []api.ImportFileOptions
+	prev := startImportFile
+	startImportFile = func(path string, opts api.ImportFileOptions) (*api.ImportJob, error) {
+		started = append(started, opts)
+		return &api.ImportJob{ID: "job-reimport"}, nil
+	}
+	t.Cleanup(func() { startImportFile = prev })
+
+	run := &ScraperResult{RunID: "run-1", StartTime: time.Now(), Files: []DownloadedFile{
+		{Type: CSVPolizas, Path: writeCSV(t, dir, "POLIZAS.csv", "Número de la póliza", "P1"), DownloadedAt: time.Now()},
+	}}
+	s.archiveFiles(run)
+	store.Save(run)
+
+	app := fiber.New()
+	app.Post("/runs/:id/files/:fileId/reimport", ReimportRunFileHandler)
+	post := func(url string) (int, map[string]interface{}) {
+		resp, err := app.Test(httptest.NewRequest("POST", url, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, body
+	}
+
+	fileURL := fmt.Sprintf("/runs/run-1/files/%d/reimport", run.Files[0].ID)
+	if code, body := post(fileURL + "?mode=add"); code != 200 || body["import_id"] != "job-reimport" {
+		t.Fatalf("reimport: %d %v", code, body)
+	}
+	if len(started) != 1 || started[0].Type != api.ImportPolizas || started[0].Mode != api.ModeAdd {
+		t.Fatalf("importaciones = %+v", started)
+	}
+
+	if code, _ := post("/runs/run-x/files/1/reimport"); code != 404 {
+		t.Fatalf("ejecución inexistente: %d", code)
+	}
+
+	// Archivo archivado modificado: no se reimporta
+	os.WriteFile(run.Files[0].ArchivePath, []byte("manipulado"), 0644)
+	if code, _ := post(fileURL); code != 409 {
+		t.Fatalf("checksum distinto: %d", code)
+	}
+	if len(started) != 1 {
+		t.Fatal("se reimportó un archivo con checksum distinto")
+	}
+}
@@ -0,0 +1,119 @@
+package scraper
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"soriano-mediadores/internal/api"
+)
+
+// RunOptions opciones de una ejecución del scraper
+type RunOptions struct {
+	ImportMode api.ImportMode `json:"import_mode,omitempty"` // add, update, replace; vacío = no importar
+	Types      []CSVType      `json:"types,omitempty"`       // Tipos a descargar; vacío = todos
+	DateRange  *DateRange     `json:"date_range,omitempty"`  // Filtro de fechas del listado
+	LastDays   int            `json:"last_days,omitempty"`   // Últimos N días (se resuelve al ejecutar)
+	Parallel   int            `json:"parallel,omitempty"`    // Pestañas simultáneas; 0 = GCO_PARALLEL_TABS
+	ScheduleID int            `json:"-"`                     // Schedule que lanza la ejecución (0 = manual)
+}
+
+// DefaultRunOptions opciones por defecto: importar los archivos descargados
+// (GCO_AUTO_IMPORT=false lo desactiva) en modo GCO_IMPORT_MODE (update por defecto)
+func DefaultRunOptions() RunOptions {
+	if os.Getenv("GCO_AUTO_IMPORT") == "false" {
+		return RunOptions{}
+	}
+	mode := api.ImportMode(os.Getenv("GCO_IMPORT_MODE"))
+	if mode == "" {
+		mode = api.ModeUpdate
+	}
+	if !api.IsValidImportMode(mode) {
+		log.Printf("[Scraper] GCO_IMPORT_MODE inválido (%s), usando update", mode)
+		mode = api.ModeUpdate
+	}
+	return RunOptions{ImportMode: mode}
+}
+
+// importOrder orden de importación: cada tipo referencia a los anteriores
+var importOrder = []CSVType{CSVClientes, CSVPolizas, CSVRecibos, CSVSiniestros}
+
+// importDependencies tipo del que dependen las referencias de cada importación
+var importDependencies = map[CSVType]CSVType{
+	CSVPolizas:    CSVClientes,
+	CSVRecibos:    CSVPolizas,
+	CSVSiniestros: CSVPolizas,
+}
+
+// importFile / startImportFile lanzan la importación de un archivo (sustituibles en tests)
+var (
+	importFile      = api.ImportFile
+	startImportFile = api.StartImportFile
+)
+
+// importFiles importa los archivos descargados en orden de dependencias y
+// enlaza los import jobs con el resultado. Si la importación de un tipo falla,
+// se omiten los que dependen de él para no cargar referencias huérfanas.
+func (s *GCOScraper) importFiles(ctx context.Context, result *ScraperResult, opts RunOptions) {
+	failed := make(map[CSVType]bool)
+
+	for _, csvType := range importOrder {
+		idx := -1
+		for i := range result.Files {
+			if result.Files[i].Type == csvType {
+				idx = i
+				break
+			}
+		}
+		if idx < 0 {
+			continue
+		}
+		file := &result.Files[idx]
+
+		if parent, ok := importDependencies[csvType]; ok && failed[parent] {
+			failed[csvType] = true
+			errMsg := fmt.Sprintf("Importación de %s omitida: falló la importación de %s", csvType, parent)
+			log.Println("[Scraper] " + errMsg)
+			result.Errors = append(result.Errors, errMsg)
+			continue
+		}
+		if ctx.Err() != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("Importación de %s cancelada", csvType))
+			failed[csvType] = true
+			continue
+		}
+
+		log.Printf("[Scraper] Importando %s (modo %s): %s", csvType, opts.ImportMode, file.Path)
+		// Fuera de replace las filas inválidas se descartan y se importa el resto
+		job, err := importFile(ctx, file.Path, api.ImportFileOptions{
+			Type:          api.ImportType(csvType),
+			Mode:          opts.ImportMode,
+			Username:      "scraper",
+			ValidateFirst: true,
+			AcceptErrors:  opts.ImportMode != api.ModeReplace,
+		})
+		if err != nil {
+			failed[csvType] = true
+			errMsg := fmt.Sprintf("Error importando %s: %v", csvType, err)
+			log.Println("[Scraper] " + errMsg)
+			result.Errors = append(result.Errors, errMsg)
+			continue
+		}
+
+		summary := job.Snapshot()
+		file.ImportJobID = summary.ID
+		file.Import = &summary
+		result.ImportJobIDs = append(result.ImportJobIDs, summary.ID)
+
+		if summary.Status != api.StatusCompleted {
+			failed[csvType] = true
+			errMsg := fmt.Sprintf("Importación de %s terminó en estado %s (job %s)", csvType, summary.Status, summary.ID)
+			log.Println("[Scraper] " + errMsg)
+			result.Errors = append(result.Errors, errMsg)
+			continue
+		}
+		log.Printf("[Scraper] %s importado: %d filas correctas, %d con error (job %s)",
+			csvType, summary.SuccessfulRows, summary.FailedRows, summary.ID)
+	}
+}
@@ -0,0 +1,80 @@
+package scraper
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"soriano-mediadores/internal/api"
+)
+
+// stubImports sustituye la importación y devuelve el estado indicado por tipo
+func stubImports(t *testing.T, statuses map[api.ImportType]api.ImportStatus) *[]api.ImportFileOptions {
+	t.Helper()
+	var calls []api.ImportFileOptions
+	prev := importFile
+	importFile = func(ctx context.Context, path string, opts api.ImportFileOptions) (*api.ImportJob, error) {
+		calls = append(calls, opts)
+		status, ok := statuses[opts.Type]
+		if !ok {
+			status = api.StatusCompleted
+		}
+		return &api.ImportJob{ID: "job-" + string(opts.Type), Type: opts.Type, Mode: opts.Mode, Status: status}, nil
+	}
+	t.Cleanup(func() { importFile = prev })
+	return &calls
+}
+
+func TestImportFilesDependencyOrder(t *testing.T) {
+	calls := stubImports(t, nil)
+
+	// Descargados en otro orden y sin clientes
+	result := &ScraperResult{Files: []DownloadedFile{
+		{Type: CSVSiniestros, Path: "/tmp/SINIESTROS.csv"},
+		{Type: CSVRecibos, Path: "/tmp/RECIBOS.xlsx"},
+		{Type: CSVPolizas, Path: "/tmp/POLIZAS.csv"},
+	}}
+	(&GCOScraper{}).importFiles(context.Background(), result, RunOptions{ImportMode: api.ModeUpdate})
+
+	var order []string
+	for _, c := range *calls {
+		order = append(order, string(c.Type))
+		if c.Mode != api.ModeUpdate || !c.AcceptErrors {
+			t.Fatalf("opciones = %+v", c)
+		}
+	}
+	if got := strings.Join(order, ","); got != "polizas,recibos,siniestros" {
+		t.Fatalf("orden = %s", got)
+	}
+	if len(result.Errors) != 0 || len(result.ImportJobIDs) != 3 {
+		t.Fatalf("errores = %v, jobs = %v", result.Errors, result.ImportJobIDs)
+	}
+	for _, f := range result.Files {
+		if f.ImportJobID != "job-"+string(f.Type) || f.Import == nil {
+			t.Fatalf("archivo sin import job: %+v", f)
+		}
+	}
+}
+
+func TestImportFilesSkipsDependents(t *testing.T) {
+	calls := stubImports(t, map[api.ImportType]api.ImportStatus{api.ImportPolizas: api.StatusFailed})
+
+	result := &ScraperResult{Files: []DownloadedFile{
+		{Type: CSVClientes}, {Type: CSVPolizas}, {Type: CSVRecibos}, {Type: CSVSiniestros},
+	}}
+	(&GCOScraper{}).importFiles(context.Background(), result, RunOptions{ImportMode: api.ModeReplace})
+
+	if len(*calls) != 2 {
+		t.Fatalf("imports = %d, want clientes y polizas", len(*calls))
+	}
+	if (*calls)[0].AcceptErrors {
+		t.Fatal("replace no debe aceptar errores")
+	}
+	// Error de pólizas + recibos y siniestros omitidos
+	if len(result.Errors) != 3 || !strings.Contains(result.Errors[1], "omitida") {
+		t.Fatalf("errores = %v", result.Errors)
+	}
+	if result.Files[2].ImportJobID != "" {
+		t.Fatal("recibos no debería tener import job")
+	}
+}
@@ -0,0 +1,136 @@
+package scraper
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/chromedp/chromedp"
+)
+
+// locatorJS busca el primer elemento que casa con el selector CSS y, si hay
+// texto, el más interno cuyo texto visible (o title/value/aria-label) lo
+// contiene; prefiere los elementos visibles (submenús desplegados). Lo marca
+// con data-gco-target para poder usarlo después con chromedp.
+const locatorJS = `(function(css, text, mark, click) {
+	const norm = s => (s || '').replace(/\s+/g, ' ').trim().toLowerCase();
+	let nodes;
+	try {
+		nodes = Array.from(document.querySelectorAll(css));
+	} catch (e) {
+		return {found: false, error: String(e)};
+	}
+	if (text) {
+		const t = norm(text);
+		const matches = nodes.filter(el =>
+			norm(el.textContent).includes(t) || norm(el.title).includes(t) ||
+			norm(el.value).includes(t) || norm(el.getAttribute('aria-label')).includes(t));
+		nodes = matches.filter(el => !matches.some(o => o !== el && el.contains(o)));
+	}
+	const visible = nodes.filter(el => el.getClientRects().length > 0);
+	const el = visible[0] || nodes[0];
+	if (!el) {
+		return {found: false};
+	}
+	document.querySelectorAll('[data-gco-target="' + mark + '"]').forEach(o => o.removeAttribute('data-gco-target'));
+	el.setAttribute('data-gco-target', mark);
+	if (click) {
+		el.scrollIntoView({block: 'center'});
+		el.click();
+	}
+	return {found: true, text: (el.innerText || el.textContent || '').trim()};
+})(%s, %s, %s, %t)`
+
+// locateResult resultado de evaluar locatorJS
+type locateResult struct {
+	Found bool   `json:"found"`
+	Text  string `json:"text"`
+	Error string `json:"error"`
+}
+
+// markSelector selector CSS del elemento marcado por locate
+func markSelector(mark string) string {
+	return fmt.Sprintf(`[data-gco-target="%s"]`, mark)
+}
+
+// evalLocator evalúa un locator en la página; las cadenas van como literales JSON
+func evalLocator(ctx context.Context, loc Locator, mark string, click bool) (locateResult, error) {
+	css, _ := json.Marshal(loc.query())
+	text, _ := json.Marshal(loc.Text)
+	markJSON, _ := json.Marshal(mark)
+
+	var res locateResult
+	err := chromedp.Run(ctx, chromedp.Evaluate(fmt.Sprintf(locatorJS, css, text, markJSON, click), &res))
+	if err == nil && res.Error != "" {
+		err = fmt.Errorf("selector %s: %s", loc, res.Error)
+	}
+	return res, err
+}
+
+// locate prueba las alternativas del target en orden, marca el primer elemento
+// encontrado y devuelve el locator que casó y el selector del elemento marcado
+func locate(ctx context.Context, target Target, mark string) (Locator, string, bool) {
+	for _, loc := range target {
+		res, err := evalLocator(ctx, loc, mark, false)
+		if err == nil && res.Found {
+			return loc, markSelector(mark), true
+		}
+	}
+	return Locator{}, "", false
+}
+
+// clickTarget hace click (vía JS) en la primera alternativa encontrada
+func clickTarget(ctx context.Context, target Target, mark string) (Locator, bool) {
+	for _, loc := range target {
+		res, err := evalLocator(ctx, loc, mark, true)
+		if err == nil && res.Found {
+			return loc, true
+		}
+	}
+	return Locator{}, false
+}
+
+// targetText texto del primer elemento encontrado ("" si no hay ninguno)
+func targetText(ctx context.Context, target Target, mark string) string {
+	for _, loc := range target {
+		res, err := evalLocator(ctx, loc, mark, false)
+		if err == nil && res.Found && res.Text != "" {
+			return res.Text
+		}
+	}
+	return ""
+}
+
+// fillJS escribe el valor en el elemento y lanza input/change (los
+// datepickers del portal solo se enteran por esos eventos)
+const fillJS = `(function(sel, value) {
+	const el = document.querySelector(sel);
+	if (!el) {
+		return false;
+	}
+	el.focus();
+	el.value = value;
+	el.dispatchEvent(new Event('input', {bubbles: true}));
+	el.dispatchEvent(new Event('change', {bubbles: true}));
+	el.blur();
+	return true;
+})(%s, %s)`
+
+// fillTarget escribe value en la primera alternativa encontrada
+func fillTarget(ctx context.Context, target Target, mark, value string) (Locator, error) {
+	loc, sel, ok := locate(ctx, target, mark)
+	if !ok {
+		return Locator{}, fmt.Errorf("no se encontró el campo")
+	}
+	selJSON, _ := json.Marshal(sel)
+	valueJSON, _ := json.Marshal(value)
+
+	var filled bool
+	if err := chromedp.Run(ctx, chromedp.Evaluate(fmt.Sprintf(fillJS, selJSON, valueJSON), &filled)); err != nil {
+		return loc, err
+	}
+	if !filled {
+		return loc, fmt.Errorf("el campo %s desapareció", loc)
+	}
+	return loc, nil
+}
@@ -3,6 +3,8 @@ package scraper
 import (
 	"sync"
 	"time"
+
+	"soriano-mediadores/internal/api"
 )
 
 // CSVType representa los tipos de CSV que se pueden descargar
@@ -24,28 +26,54 @@ const (
 	StatusSuccess   ScraperStatus = "success"
 	StatusFailed    ScraperStatus = "failed"
 	StatusCancelled ScraperStatus = "cancelled"
+	StatusImporting ScraperStatus = "importing" // Importando los archivos descargados
 )
 
-// DownloadConfig configuración para descarga de cada tipo de CSV
+// DownloadConfig navegación para descargar un tipo de CSV (parte del perfil de portal)
 type DownloadConfig struct {
-	Type         CSVType
-	MenuPath     []string // Ruta de navegación por menús
-	ExportButton string   // Selector del botón de export
-	FileName     string   // Nombre del archivo descargado
-	WaitSelector string   // Selector para esperar antes de exportar
+	Type     CSVType  `json:"-" yaml:"-"`
+	FileName string   `json:"file_name" yaml:"file_name"`           // Nombre del archivo descargado
+	Menu     []Target `json:"menu" yaml:"menu"`                     // Ruta de navegación por menús
+	Wait     Target   `json:"wait,omitempty" yaml:"wait,omitempty"` // Indicador de datos cargados
+	Export   Target   `json:"export" yaml:"export"`                 // Botón de exportar
+	// DateFilter filtro de fechas del listado (necesario para rangos de fechas)
+	DateFilter *DateFilterProfile `json:"date_filter,omitempty" yaml:"date_filter,omitempty"`
+}
+
+// DateFilterProfile campos de fecha del listado y botón que aplica el filtro
+type DateFilterProfile struct {
+	From   Target `json:"from" yaml:"from"`
+	To     Target `json:"to" yaml:"to"`
+	Apply  Target `json:"apply,omitempty" yaml:"apply,omitempty"`   // Vacío = se filtra al cambiar las fechas
+	Format string `json:"format,omitempty" yaml:"format,omitempty"` // Layout Go de las fechas (02/01/2006)
+}
+
+// layout formato con el que se escriben las fechas en el portal
+func (f *DateFilterProfile) layout() string {
+	if f.Format == "" {
+		return "02/01/2006"
+	}
+	return f.Format
 }
 
 // DownloadedFile representa un archivo descargado
 type DownloadedFile struct {
+	ID           int64     `json:"id,omitempty"` // En scraper_run_files
 	Type         CSVType   `json:"type"`
 	Path         string    `json:"path"`
 	Size         int64     `json:"size"`
 	DownloadedAt time.Time `json:"downloaded_at"`
 	ImportJobID  string    `json:"import_job_id,omitempty"`
+	Checksum     string    `json:"checksum,omitempty"`     // SHA-256 del archivo
+	ArchivePath  string    `json:"archive_path,omitempty"` // Copia archivada ("" si ya se purgó)
+	// Import resumen del import job al terminar la importación
+	Import *api.ImportJobSummary `json:"import,omitempty"`
 }
 
 // ScraperResult resultado de una ejecución del scraper
 type ScraperResult struct {
+	RunID        string           `json:"run_id"`
+	Status       ScraperStatus    `json:"status"`
 	Success      bool             `json:"success"`
 	StartTime    time.Time        `json:"start_time"`
 	EndTime      time.Time        `json:"end_time"`
@@ -53,6 +81,19 @@ type ScraperResult struct {
 	Files        []DownloadedFile `json:"files"`
 	Errors       []string         `json:"errors"`
 	ImportJobIDs []string         `json:"import_job_ids,omitempty"`
+	Changes      map[string]int   `json:"changes,omitempty"` // Cambios respecto a la ejecución anterior
+	ImportMode   string           `json:"import_mode,omitempty"`
+	Screenshots  []string         `json:"screenshots,omitempty"` // Capturas de debug de la ejecución
+	// ProfileVersion versión del perfil de portal con la que se navegó
+	ProfileVersion string `json:"profile_version,omitempty"`
+	// SessionReused se reutilizó la sesión guardada (sin login)
+	SessionReused bool `json:"session_reused"`
+	// Types estado de cada tipo seleccionado (pendiente, descargando, ok, error)
+	Types []TypeResult `json:"types"`
+	// DateRange filtro de fechas aplicado (nil = exportación completa)
+	DateRange *DateRange `json:"date_range,omitempty"`
+	// ScheduleID schedule que lanzó la ejecución (0 = manual)
+	ScheduleID int `json:"schedule_id,omitempty"`
 }
 
 // ScraperMetrics métricas de ejecución del scraper
@@ -137,36 +178,3 @@ var DefaultRetryConfig = RetryConfig{
 	MaxDelay:      60 * time.Second,
 	BackoffFactor: 2.0,
 }
-
-// DefaultDownloadConfigs configuraciones por defecto para cada tipo de CSV
-// NOTA: Los selectores se ajustarán según el HTML real del portal
-var DefaultDownloadConfigs = map[CSVType]DownloadConfig{
-	CSVClientes: {
-		Type:         CSVClientes,
-		MenuPath:     []string{"Cartera", "Clientes"},
-		ExportButton: `button[title*="Exportar"], .btn-export, a[href*="export"], button:contains("Excel"), button:contains("CSV")`,
-		FileName:     "CLIENTES.csv",
-		WaitSelector: `table, .grid, .data-table, .ag-body`,
-	},
-	CSVPolizas: {
-		Type:         CSVPolizas,
-		MenuPath:     []string{"Cartera", "Pólizas"},
-		ExportButton: `button[title*="Exportar"], .btn-export, a[href*="export"], button:contains("Excel"), button:contains("CSV")`,
-		FileName:     "POLIZAS.csv",
-		WaitSelector: `table, .grid, .data-table, .ag-body`,
-	},
-	CSVRecibos: {
-		Type:         CSVRecibos,
-		MenuPath:     []string{"Recibos", "Listado"},
-		ExportButton: `button[title*="Exportar"], .btn-export, a[href*="export"], button:contains("Excel"), button:contains("CSV")`,
-		FileName:     "RECIBOS.csv",
-		WaitSelector: `table, .grid, .data-table, .ag-body`,
-	},
-	CSVSiniestros: {
-		Type:         CSVSiniestros,
-		MenuPath:     []string{"Siniestros", "Listado"},
-		ExportButton: `button[title*="Exportar"], .btn-export, a[href*="export"], button:contains("Excel"), button:contains("CSV")`,
-		FileName:     "SINIESTROS.csv",
-		WaitSelector: `table, .grid, .data-table, .ag-body`,
-	},
-}
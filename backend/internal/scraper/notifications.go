@@ -0,0 +1,533 @@
+package scraper
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"soriano-mediadores/internal/db"
+	"soriano-mediadores/internal/email"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// NotificationEvent resultado de una ejecución programada que se notifica
+type NotificationEvent string
+
+const (
+	NotifySuccess   NotificationEvent = "success"
+	NotifyPartial   NotificationEvent = "partial"
+	NotifyFailed    NotificationEvent = "failed"
+	NotifyRecovered NotificationEvent = "recovered" // Primera ejecución correcta tras un fallo
+)
+
+const (
+	// maxAttachmentBytes límite de capturas adjuntas por email (Graph admite ~3 MB por mensaje)
+	maxAttachmentBytes = 2 << 20
+	maxAttachments     = 5
+)
+
+// Notification aviso de una ejecución del scraper para los canales configurados
+type Notification struct {
+	ID           int64             `json:"id,omitempty"`
+	Event        NotificationEvent `json:"event"`
+	Title        string            `json:"title"`
+	Summary      string            `json:"summary"`
+	RunID        string            `json:"run_id,omitempty"`
+	ScheduleID   int               `json:"schedule_id,omitempty"`
+	ScheduleName string            `json:"schedule_name,omitempty"`
+	Duration     string            `json:"duration,omitempty"`
+	Files        []DownloadedFile  `json:"files,omitempty"`
+	Types        []TypeResult      `json:"types,omitempty"`
+	Errors       []string          `json:"errors,omitempty"`
+	Screenshots  []string          `json:"screenshots,omitempty"`
+	// Suppressed alertas iguales que se suprimieron desde la anterior enviada
+	Suppressed int        `json:"suppressed,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ReadAt     *time.Time `json:"read_at,omitempty"`
+}
+
+// newRunNotification construye el aviso de una ejecución programada (result
+// puede ser nil si falló antes de empezar)
+func newRunNotification(event NotificationEvent, sc *Schedule, result *ScraperResult, err error) *Notification {
+	n := &Notification{Event: event, CreatedAt: time.Now()}
+	if sc != nil {
+		n.ScheduleID = sc.ID
+		n.ScheduleName = sc.Name
+	}
+	if result != nil {
+		n.RunID = result.RunID
+		n.Duration = result.Duration
+		n.Files = result.Files
+		n.Types = result.Types
+		n.Errors = append(n.Errors, result.Errors...)
+		n.Screenshots = result.Screenshots
+	}
+	if err != nil {
+		n.Errors = append(n.Errors, err.Error())
+	}
+	return n
+}
+
+// title asunto del aviso según el evento
+func (n *Notification) title() string {
+	var title string
+	switch n.Event {
+	case NotifySuccess:
+		title = "✅ Scraper GCO completado"
+	case NotifyRecovered:
+		title = "✅ Scraper GCO recuperado"
+	case NotifyPartial:
+		title = "⚠️ Scraper GCO parcialmente completado"
+	default:
+		title = "❌ Error en Scraper GCO"
+	}
+	if n.ScheduleName != "" {
+		title += " (" + n.ScheduleName + ")"
+	}
+	return title
+}
+
+// summary resumen de una línea: archivos, tamaño total y errores
+func (n *Notification) summary() string {
+	var total int64
+	for _, f := range n.Files {
+		total += f.Size
+	}
+	summary := fmt.Sprintf("%d archivos descargados (%s), %d errores", len(n.Files), formatBytes(total), len(n.Errors))
+	if n.Suppressed > 0 {
+		summary += fmt.Sprintf(", %d avisos suprimidos", n.Suppressed)
+	}
+	return summary
+}
+
+// formatBytes tamaño legible (1.5 MB)
+func formatBytes(size int64) string {
+	switch {
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(size)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", size)
+	}
+}
+
+var notificationTemplate = template.Must(template.New("notification").Funcs(template.FuncMap{
+	"base":  filepath.Base,
+	"bytes": formatBytes,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="UTF-8"></head>
+<body style="font-family: Arial, sans-serif; color: #333;">
+	<h2>{{.Title}}</h2>
+	<p>{{.Summary}}</p>
+	<p style="color: #777;">Ejecución {{.RunID}}{{if .Duration}} · {{.Duration}}{{end}} · {{.CreatedAt.Format "02/01/2006 15:04"}}</p>
+	{{if .Suppressed}}<p><strong>Se suprimieron {{.Suppressed}} avisos iguales desde el anterior.</strong></p>{{end}}
+	{{if .Types}}
+	<h3>Tipos</h3>
+	<table cellpadding="6" style="border-collapse: collapse;">
+		{{range .Types}}<tr><td>{{.Type}}</td><td>{{.Status}}</td><td>{{.Error}}</td></tr>
+		{{end}}
+	</table>
+	{{end}}
+	{{if .Files}}
+	<h3>Archivos</h3>
+	<table cellpadding="6" style="border-collapse: collapse;">
+		<tr><th align="left">Tipo</th><th align="left">Archivo</th><th align="right">Tamaño</th></tr>
+		{{range .Files}}<tr><td>{{.Type}}</td><td>{{base .Path}}</td><td align="right">{{bytes .Size}}</td></tr>
+		{{end}}
+	</table>
+	{{end}}
+	{{if .Errors}}
+	<h3 style="color: #c62828;">Errores</h3>
+	<ul>{{range .Errors}}<li>{{.}}</li>{{end}}</ul>
+	{{end}}
+	{{if .Screenshots}}
+	<h3>Capturas</h3>
+	<ul>{{range .Screenshots}}<li>{{base .}}</li>{{end}}</ul>
+	{{end}}
+</body>
+</html>`))
+
+// HTML cuerpo del email con archivos, tamaños, errores y capturas
+func (n *Notification) HTML() (string, error) {
+	var buf bytes.Buffer
+	if err := notificationTemplate.Execute(&buf, n); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Notifier canal de notificación (email, webhook, in-app)
+type Notifier interface {
+	Name() string
+	Send(n *Notification) error
+}
+
+// mailSender parte de email.GraphClient que usa EmailNotifier
+type mailSender interface {
+	SendEmailWithAttachments(from string, to []string, subject, htmlBody string, attachments []email.Attachment) error
+}
+
+// EmailNotifier envía el resumen HTML por Microsoft Graph con las capturas
+// de la ejecución adjuntas
+type EmailNotifier struct {
+	Client mailSender
+	From   string
+	To     []string
+}
+
+func (e *EmailNotifier) Name() string { return "email" }
+
+func (e *EmailNotifier) Send(n *Notification) error {
+	body, err := n.HTML()
+	if err != nil {
+		return fmt.Errorf("error generando el resumen: %w", err)
+	}
+	return e.Client.SendEmailWithAttachments(e.From, e.To, n.Title, body, screenshotAttachments(n.Screenshots))
+}
+
+// screenshotAttachments lee las capturas hasta maxAttachments/maxAttachmentBytes;
+// las que no caben solo aparecen listadas en el cuerpo
+func screenshotAttachments(paths []string) []email.Attachment {
+	var attachments []email.Attachment
+	var total int
+	for _, path := range paths {
+		if len(attachments) == maxAttachments {
+			break
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("[Notification] ⚠️ Captura no adjuntada %s: %v", path, err)
+			continue
+		}
+		if total+len(content) > maxAttachmentBytes {
+			continue
+		}
+		total += len(content)
+		attachments = append(attachments, email.Attachment{
+			Name:        filepath.Base(path),
+			ContentType: "image/png",
+			Content:     content,
+		})
+	}
+	return attachments
+}
+
+// WebhookNotifier publica el aviso en JSON (p. ej. un webhook de N8N). Con
+// Secret se firma el cuerpo en X-Signature: sha256=<HMAC-SHA256 en hex>
+type WebhookNotifier struct {
+	URL        string
+	Secret     string
+	HTTPClient *http.Client
+}
+
+func (w *WebhookNotifier) Name() string { return "webhook" }
+
+func (w *WebhookNotifier) Send(n *Notification) error {
+	payload, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", w.URL, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("error creando request del webhook: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.Secret))
+		mac.Write(payload)
+		req.Header.Set("X-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	client := w.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 15 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error llamando al webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook respondió %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// InAppNotifier guarda el aviso para la campana de la aplicación
+// (GET /api/scraper/notifications)
+type InAppNotifier struct{}
+
+func (InAppNotifier) Name() string { return "inapp" }
+
+func (InAppNotifier) Send(n *Notification) error {
+	return notificationStore.Save(n)
+}
+
+// alertState último aviso enviado de un schedule
+type alertState struct {
+	event      NotificationEvent
+	sentAt     time.Time
+	suppressed int
+}
+
+// Notifications reparte los avisos de las ejecuciones programadas entre los
+// canales. Un fallo (o parcial) que se repite en el mismo schedule dentro de
+// SuppressFor no se vuelve a enviar: se cuenta y se informa en el siguiente
+// aviso. La primera ejecución correcta tras un fallo se avisa como
+// "recovered" aunque los éxitos no estén en Events.
+type Notifications struct {
+	Channels    []Notifier
+	Events      map[NotificationEvent]bool
+	SuppressFor time.Duration
+
+	mu     sync.Mutex
+	alerts map[int]*alertState // Por schedule
+}
+
+// NewNotificationsFromEnv canales de GCO_NOTIFY_CHANNELS (email, webhook,
+// inapp). Los canales sin configuración completa se omiten con un aviso.
+func NewNotificationsFromEnv() *Notifications {
+	n := &Notifications{
+		Events:      make(map[NotificationEvent]bool),
+		SuppressFor: 2 * time.Hour,
+	}
+
+	events := os.Getenv("GCO_NOTIFY_EVENTS")
+	if events == "" {
+		events = "failed,partial,recovered"
+	}
+	for _, event := range strings.Split(events, ",") {
+		n.Events[NotificationEvent(strings.TrimSpace(strings.ToLower(event)))] = true
+	}
+	if minutes, err := strconv.Atoi(os.Getenv("GCO_NOTIFY_SUPPRESS_MINUTES")); err == nil && minutes >= 0 {
+		n.SuppressFor = time.Duration(minutes) * time.Minute
+	}
+
+	channels := os.Getenv("GCO_NOTIFY_CHANNELS")
+	if channels == "" {
+		channels = "inapp"
+	}
+	for _, channel := range strings.Split(channels, ",") {
+		switch channel = strings.TrimSpace(strings.ToLower(channel)); channel {
+		case "":
+		case "inapp":
+			n.Channels = append(n.Channels, InAppNotifier{})
+		case "email":
+			var to []string
+			for _, addr := range strings.Split(os.Getenv("GCO_NOTIFY_EMAIL_TO"), ",") {
+				if addr = strings.TrimSpace(addr); addr != "" {
+					to = append(to, addr)
+				}
+			}
+			from := os.Getenv("GCO_NOTIFY_EMAIL_FROM")
+			client, err := email.NewGraphClient()
+			if err != nil || from == "" || len(to) == 0 {
+				log.Printf("[Notification] ⚠️ Canal email sin configurar (GCO_NOTIFY_EMAIL_FROM/TO, MS_*): %v", err)
+				continue
+			}
+			n.Channels = append(n.Channels, &EmailNotifier{Client: client, From: from, To: to})
+		case "webhook":
+			url := os.Getenv("GCO_NOTIFY_WEBHOOK_URL")
+			if url == "" {
+				log.Println("[Notification] ⚠️ Canal webhook sin GCO_NOTIFY_WEBHOOK_URL")
+				continue
+			}
+			n.Channels = append(n.Channels, &WebhookNotifier{URL: url, Secret: os.Getenv("GCO_NOTIFY_WEBHOOK_SECRET")})
+		default:
+			log.Printf("[Notification] ⚠️ Canal de notificación desconocido: %s", channel)
+		}
+	}
+	return n
+}
+
+// admit aplica la supresión de avisos repetidos y el filtro de eventos
+func (ns *Notifications) admit(n *Notification) bool {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	if ns.alerts == nil {
+		ns.alerts = make(map[int]*alertState)
+	}
+
+	last := ns.alerts[n.ScheduleID]
+	if n.Event == NotifySuccess {
+		if last == nil {
+			return ns.Events[NotifySuccess]
+		}
+		delete(ns.alerts, n.ScheduleID)
+		n.Event = NotifyRecovered
+		n.Suppressed = last.suppressed
+		return ns.Events[NotifyRecovered] || ns.Events[NotifySuccess]
+	}
+
+	if !ns.Events[n.Event] {
+		return false
+	}
+	if last != nil && last.event == n.Event && n.CreatedAt.Sub(last.sentAt) < ns.SuppressFor {
+		last.suppressed++
+		return false
+	}
+	if last != nil {
+		n.Suppressed = last.suppressed
+	}
+	ns.alerts[n.ScheduleID] = &alertState{event: n.Event, sentAt: n.CreatedAt}
+	return true
+}
+
+// Notify envía el aviso por todos los canales; devuelve los errores de los
+// canales que fallaron (el resto se envía igualmente)
+func (ns *Notifications) Notify(n *Notification) error {
+	if ns == nil {
+		return nil
+	}
+	if !ns.admit(n) {
+		log.Printf("[Notification] Aviso '%s' de '%s' no enviado (repetido o evento desactivado)", n.Event, n.ScheduleName)
+		return nil
+	}
+	n.Title = n.title()
+	n.Summary = n.summary()
+
+	var errs []error
+	for _, channel := range ns.Channels {
+		if err := channel.Send(n); err != nil {
+			log.Printf("[Notification] ⚠️ Error enviando por %s: %v", channel.Name(), err)
+			errs = append(errs, fmt.Errorf("%s: %w", channel.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// NotificationStore almacén de los avisos in-app
+type NotificationStore interface {
+	Save(n *Notification) error
+	List(limit int, unreadOnly bool) ([]*Notification, error)
+	MarkRead(id int64) error
+}
+
+// notificationStore almacén in-app (reemplazable en tests)
+var notificationStore NotificationStore = &postgresNotificationStore{}
+
+// ErrNotificationNotFound no hay aviso con ese ID
+var ErrNotificationNotFound = errors.New("notificación no encontrada")
+
+// postgresNotificationStore avisos en la tabla scraper_notifications
+type postgresNotificationStore struct{}
+
+func (s *postgresNotificationStore) Save(n *Notification) error {
+	if db.PostgresDB == nil {
+		return errNoDB
+	}
+	payload, _ := json.Marshal(n)
+	var scheduleID sql.NullInt64
+	if n.ScheduleID > 0 {
+		scheduleID = sql.NullInt64{Int64: int64(n.ScheduleID), Valid: true}
+	}
+	return db.PostgresDB.QueryRow(`
+		INSERT INTO scraper_notifications (event, title, summary, run_id, schedule_id, payload, created_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7)
+		RETURNING id
+	`, n.Event, n.Title, n.Summary, n.RunID, scheduleID, payload, n.CreatedAt).Scan(&n.ID)
+}
+
+func (s *postgresNotificationStore) List(limit int, unreadOnly bool) ([]*Notification, error) {
+	if db.PostgresDB == nil {
+		return nil, errNoDB
+	}
+	query := "SELECT id, payload, read_at FROM scraper_notifications"
+	if unreadOnly {
+		query += " WHERE read_at IS NULL"
+	}
+	rows, err := db.PostgresDB.Query(query+" ORDER BY created_at DESC LIMIT $1", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notifications := []*Notification{}
+	for rows.Next() {
+		var n Notification
+		var id int64
+		var payload []byte
+		var readAt sql.NullTime
+		if err := rows.Scan(&id, &payload, &readAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(payload, &n); err != nil {
+			return nil, fmt.Errorf("payload corrupto en notificación %d: %w", id, err)
+		}
+		n.ID = id
+		if readAt.Valid {
+			n.ReadAt = &readAt.Time
+		}
+		notifications = append(notifications, &n)
+	}
+	return notifications, rows.Err()
+}
+
+func (s *postgresNotificationStore) MarkRead(id int64) error {
+	if db.PostgresDB == nil {
+		return errNoDB
+	}
+	res, err := db.PostgresDB.Exec("UPDATE scraper_notifications SET read_at = COALESCE(read_at, NOW()) WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotificationNotFound
+	}
+	return nil
+}
+
+// GetNotificationsHandler avisos in-app (?unread=true solo los no leídos)
+func GetNotificationsHandler(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 50)
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	notifications, err := notificationStore.List(limit, c.QueryBool("unread", false))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"success": false,
+			"error":   "Error obteniendo notificaciones: " + err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{
+		"success":       true,
+		"notifications": notifications,
+		"total":         len(notifications),
+	})
+}
+
+// MarkNotificationReadHandler marca un aviso in-app como leído
+func MarkNotificationReadHandler(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"success": false, "error": "ID inválido"})
+	}
+	if err := notificationStore.MarkRead(id); err != nil {
+		status := 500
+		if errors.Is(err, ErrNotificationNotFound) {
+			status = 404
+		}
+		return c.Status(status).JSON(fiber.Map{"success": false, "error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"success": true})
+}
@@ -0,0 +1,160 @@
+package scraper
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"soriano-mediadores/internal/email"
+)
+
+// memoryNotificationStore avisos in-app en memoria para los tests
+type memoryNotificationStore struct {
+	notifications []*Notification
+}
+
+func (s *memoryNotificationStore) Save(n *Notification) error {
+	n.ID = int64(len(s.notifications) + 1)
+	copied := *n
+	s.notifications = append(s.notifications, &copied)
+	return nil
+}
+
+func (s *memoryNotificationStore) List(limit int, unreadOnly bool) ([]*Notification, error) {
+	var out []*Notification
+	for i := len(s.notifications) - 1; i >= 0 && len(out) < limit; i-- {
+		if !unreadOnly || s.notifications[i].ReadAt == nil {
+			out = append(out, s.notifications[i])
+		}
+	}
+	return out, nil
+}
+
+func (s *memoryNotificationStore) MarkRead(id int64) error {
+	if id < 1 || int(id) > len(s.notifications) {
+		return ErrNotificationNotFound
+	}
+	now := time.Now()
+	s.notifications[id-1].ReadAt = &now
+	return nil
+}
+
+// fakeMail registra los emails enviados
+type fakeMail struct {
+	subjects    []string
+	bodies      []string
+	attachments [][]email.Attachment
+}
+
+func (m *fakeMail) SendEmailWithAttachments(from string, to []string, subject, htmlBody string, attachments []email.Attachment) error {
+	m.subjects = append(m.subjects, subject)
+	m.bodies = append(m.bodies, htmlBody)
+	m.attachments = append(m.attachments, attachments)
+	return nil
+}
+
+func TestNotificationChannels(t *testing.T) {
+	store := &memoryNotificationStore{}
+	prev := notificationStore
+	notificationStore = store
+	t.Cleanup(func() { notificationStore = prev })
+
+	var hooks []map[string]interface{}
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mac := hmac.New(sha256.New, []byte("n8n"))
+		mac.Write(body)
+		if r.Header.Get("X-Signature") != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var payload map[string]interface{}
+		json.Unmarshal(body, &payload)
+		hooks = append(hooks, payload)
+	}))
+	defer webhook.Close()
+
+	screenshot := filepath.Join(t.TempDir(), "abcd1234_export_error_recibos.png")
+	os.WriteFile(screenshot, []byte("\x89PNG"), 0644)
+
+	mail := &fakeMail{}
+	ns := &Notifications{
+		Channels: []Notifier{
+			&EmailNotifier{Client: mail, From: "scraper@soriano.es", To: []string{"oficina@soriano.es"}},
+			&WebhookNotifier{URL: webhook.URL, Secret: "n8n"},
+			InAppNotifier{},
+		},
+		Events:      map[NotificationEvent]bool{NotifyFailed: true, NotifyPartial: true, NotifyRecovered: true},
+		SuppressFor: time.Hour,
+	}
+
+	sc := &Schedule{ID: 3, Name: "recibos"}
+	result := &ScraperResult{
+		RunID:       "abcd1234",
+		Files:       []DownloadedFile{{Type: CSVPolizas, Path: "/tmp/polizas_2026.csv", Size: 3 << 20}},
+		Errors:      []string{"recibos: timeout exportando"},
+		Screenshots: []string{screenshot},
+	}
+	start := time.Date(2026, 10, 16, 6, 0, 0, 0, time.UTC)
+	notify := func(event NotificationEvent, at time.Duration) {
+		n := newRunNotification(event, sc, result, nil)
+		n.CreatedAt = start.Add(at)
+		if err := ns.Notify(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	notify(NotifyPartial, 0)
+	if len(mail.subjects) != 1 || len(hooks) != 1 || len(store.notifications) != 1 {
+		t.Fatalf("primer aviso: %d emails, %d webhooks, %d in-app", len(mail.subjects), len(hooks), len(store.notifications))
+	}
+	body := mail.bodies[0]
+	for _, want := range []string{"polizas_2026.csv", "3.0 MB", "recibos: timeout exportando", "abcd1234_export_error_recibos.png"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("el resumen no incluye %q", want)
+		}
+	}
+	if len(mail.attachments[0]) != 1 || mail.attachments[0][0].Name != filepath.Base(screenshot) {
+		t.Fatalf("adjuntos = %+v", mail.attachments[0])
+	}
+	if hooks[0]["event"] != "partial" || hooks[0]["schedule_name"] != "recibos" {
+		t.Fatalf("webhook = %v", hooks[0])
+	}
+
+	// El mismo fallo dentro de la hora se suprime; pasada la hora se avisa con el recuento
+	notify(NotifyPartial, 20*time.Minute)
+	notify(NotifyPartial, 40*time.Minute)
+	if len(mail.subjects) != 1 {
+		t.Fatalf("avisos repetidos enviados: %v", mail.subjects)
+	}
+	notify(NotifyPartial, 90*time.Minute)
+	if len(mail.subjects) != 2 || store.notifications[1].Suppressed != 2 {
+		t.Fatalf("tras la ventana: %v, suprimidos = %d", mail.subjects, store.notifications[1].Suppressed)
+	}
+
+	// Los éxitos no se avisan salvo el primero tras un fallo
+	notify(NotifySuccess, 2*time.Hour)
+	notify(NotifySuccess, 3*time.Hour)
+	if len(mail.subjects) != 3 || !strings.HasPrefix(mail.subjects[2], "✅ Scraper GCO recuperado") {
+		t.Fatalf("recuperación: %v", mail.subjects)
+	}
+
+	// Un canal caído no impide los demás
+	webhook.Close()
+	err := ns.Notify(&Notification{Event: NotifyFailed, ScheduleID: 9, CreatedAt: start})
+	if err == nil || !strings.Contains(err.Error(), "webhook") {
+		t.Fatalf("error del webhook: %v", err)
+	}
+	if len(mail.subjects) != 4 || len(store.notifications) != 4 {
+		t.Fatalf("tras el fallo del webhook: %d emails, %d in-app", len(mail.subjects), len(store.notifications))
+	}
+}
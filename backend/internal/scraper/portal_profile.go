@@ -0,0 +1,351 @@
+package scraper
+
+import (
+	"bytes"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PortalProfile describe la navegación del portal GCO: formulario de login,
+// logout y, por tipo de CSV, la ruta de menús, la espera de datos y el botón de
+// exportar. Se carga en tiempo de ejecución (YAML o JSON) y se valida al cargar.
+type PortalProfile struct {
+	Version     string                     `json:"version" yaml:"version"`
+	Description string                     `json:"description,omitempty" yaml:"description,omitempty"`
+	Login       LoginProfile               `json:"login" yaml:"login"`
+	Logout      Target                     `json:"logout" yaml:"logout"`
+	Session     SessionProfile             `json:"session" yaml:"session"`
+	Downloads   map[CSVType]DownloadConfig `json:"downloads" yaml:"downloads"`
+}
+
+// LoginProfile formulario de login y cómo se reconoce el resultado
+type LoginProfile struct {
+	Path     string `json:"path,omitempty" yaml:"path,omitempty"` // Relativa a GCO_BASE_URL
+	Username Target `json:"username" yaml:"username"`
+	Password Target `json:"password" yaml:"password"`
+	Submit   Target `json:"submit" yaml:"submit"`
+	Success  Target `json:"success" yaml:"success"` // Presente tras un login correcto
+	Error    Target `json:"error,omitempty" yaml:"error,omitempty"`
+	// TOTP campo del código del segundo factor (si el portal lo pide)
+	TOTP       Target `json:"totp,omitempty" yaml:"totp,omitempty"`
+	TOTPSubmit Target `json:"totp_submit,omitempty" yaml:"totp_submit,omitempty"` // Vacío = submit
+}
+
+// SessionProfile cómo se comprueba que una sesión reutilizada sigue activa
+type SessionProfile struct {
+	// ProbePath página protegida (relativa a GCO_BASE_URL): con sesión muestra
+	// login.success, sin ella el formulario de login
+	ProbePath string `json:"probe_path,omitempty" yaml:"probe_path,omitempty"`
+}
+
+// Locator localiza un elemento por selector CSS estándar y, opcionalmente, por
+// el texto visible (sustituye al :contains de jQuery, que Chrome no admite)
+type Locator struct {
+	CSS  string `json:"css,omitempty" yaml:"css,omitempty"`
+	Text string `json:"text,omitempty" yaml:"text,omitempty"`
+}
+
+// Target alternativas para un mismo elemento, en orden de preferencia.
+// En el archivo admite un selector (cadena), un locator o una lista de ambos.
+type Target []Locator
+
+// defaultTextScope elementos en los que se busca un locator solo de texto
+const defaultTextScope = "a, button, span, li, div, input[type=button], input[type=submit]"
+
+// query selector CSS efectivo del locator
+func (l Locator) query() string {
+	if l.CSS == "" {
+		return defaultTextScope
+	}
+	return l.CSS
+}
+
+func (l Locator) String() string {
+	if l.Text == "" {
+		return l.CSS
+	}
+	return fmt.Sprintf("%s [texto: %s]", l.query(), l.Text)
+}
+
+func (t *Target) UnmarshalJSON(data []byte) error {
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	return t.fromRaw(raw)
+}
+
+func (t *Target) UnmarshalYAML(node *yaml.Node) error {
+	var raw interface{}
+	if err := node.Decode(&raw); err != nil {
+		return err
+	}
+	return t.fromRaw(raw)
+}
+
+func (t *Target) fromRaw(raw interface{}) error {
+	var items []interface{}
+	if list, ok := raw.([]interface{}); ok {
+		items = list
+	} else {
+		items = []interface{}{raw}
+	}
+
+	*t = nil
+	for _, item := range items {
+		switch v := item.(type) {
+		case string:
+			*t = append(*t, Locator{CSS: v})
+		case map[string]interface{}:
+			var loc Locator
+			for key, val := range v {
+				s, ok := val.(string)
+				if !ok {
+					return fmt.Errorf("el campo %q del locator debe ser texto", key)
+				}
+				switch key {
+				case "css":
+					loc.CSS = s
+				case "text":
+					loc.Text = s
+				default:
+					return fmt.Errorf("campo de locator desconocido: %q (css o text)", key)
+				}
+			}
+			*t = append(*t, loc)
+		default:
+			return fmt.Errorf("locator inválido: %v", item)
+		}
+	}
+	return nil
+}
+
+// jqueryPseudo pseudo-clases de jQuery que querySelector rechaza
+var jqueryPseudo = regexp.MustCompile(`:(contains|has-text|eq|first|last|visible|hidden|gt|lt)\(`)
+
+// validate comprueba los locators de un elemento
+func (t Target) validate(name string, required bool) []string {
+	var problems []string
+	if len(t) == 0 {
+		if required {
+			problems = append(problems, name+": falta el selector")
+		}
+		return problems
+	}
+	for i, loc := range t {
+		label := fmt.Sprintf("%s[%d]", name, i)
+		if loc.CSS == "" && loc.Text == "" {
+			problems = append(problems, label+": necesita css o text")
+		}
+		if m := jqueryPseudo.FindString(loc.CSS); m != "" {
+			problems = append(problems, fmt.Sprintf("%s: %s no es CSS estándar (usa text)", label, strings.TrimSuffix(m, "(")))
+		}
+		if strings.Count(loc.CSS, "(") != strings.Count(loc.CSS, ")") ||
+			strings.Count(loc.CSS, "[") != strings.Count(loc.CSS, "]") {
+			problems = append(problems, label+": selector CSS mal formado")
+		}
+	}
+	return problems
+}
+
+// Validate comprueba que el perfil está completo y usa solo CSS estándar
+func (p *PortalProfile) Validate() error {
+	var problems []string
+	if strings.TrimSpace(p.Version) == "" {
+		problems = append(problems, "version: obligatoria")
+	}
+	problems = append(problems, p.Login.Username.validate("login.username", true)...)
+	problems = append(problems, p.Login.Password.validate("login.password", true)...)
+	problems = append(problems, p.Login.Submit.validate("login.submit", true)...)
+	problems = append(problems, p.Login.Success.validate("login.success", true)...)
+	problems = append(problems, p.Login.Error.validate("login.error", false)...)
+	problems = append(problems, p.Login.TOTP.validate("login.totp", false)...)
+	problems = append(problems, p.Login.TOTPSubmit.validate("login.totp_submit", false)...)
+	problems = append(problems, p.Logout.validate("logout", false)...)
+
+	for _, csvType := range []CSVType{CSVClientes, CSVPolizas, CSVRecibos, CSVSiniestros} {
+		dl, ok := p.Downloads[csvType]
+		prefix := "downloads." + string(csvType)
+		if !ok {
+			problems = append(problems, prefix+": falta la configuración")
+			continue
+		}
+		if dl.FileName == "" {
+			problems = append(problems, prefix+".file_name: obligatorio")
+		}
+		if len(dl.Menu) == 0 {
+			problems = append(problems, prefix+".menu: falta la ruta de menús")
+		}
+		for i, step := range dl.Menu {
+			problems = append(problems, step.validate(fmt.Sprintf("%s.menu[%d]", prefix, i), true)...)
+		}
+		problems = append(problems, dl.Wait.validate(prefix+".wait", false)...)
+		problems = append(problems, dl.Export.validate(prefix+".export", true)...)
+		if f := dl.DateFilter; f != nil {
+			problems = append(problems, f.From.validate(prefix+".date_filter.from", true)...)
+			problems = append(problems, f.To.validate(prefix+".date_filter.to", true)...)
+			problems = append(problems, f.Apply.validate(prefix+".date_filter.apply", false)...)
+			// El layout tiene que poder escribir y releer una fecha completa
+			sample := time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)
+			if parsed, err := time.Parse(f.layout(), sample.Format(f.layout())); err != nil || !parsed.Equal(sample) {
+				problems = append(problems, prefix+".date_filter.format: layout de fecha inválido (p. ej. 02/01/2006)")
+			}
+		}
+	}
+	for csvType := range p.Downloads {
+		switch csvType {
+		case CSVClientes, CSVPolizas, CSVRecibos, CSVSiniestros:
+		default:
+			problems = append(problems, fmt.Sprintf("downloads.%s: tipo desconocido", csvType))
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ProfileError{Problems: problems}
+	}
+	return nil
+}
+
+// ProfileError errores de validación de un perfil de portal
+type ProfileError struct {
+	Problems []string
+}
+
+func (e *ProfileError) Error() string {
+	return "perfil de portal inválido: " + strings.Join(e.Problems, "; ")
+}
+
+// ParsePortalProfile decodifica un perfil YAML o JSON (según la extensión) y lo valida.
+// Los campos desconocidos se rechazan para detectar erratas.
+func ParsePortalProfile(data []byte, name string) (*PortalProfile, error) {
+	var p PortalProfile
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&p); err != nil {
+			return nil, fmt.Errorf("error leyendo %s: %w", name, err)
+		}
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(&p); err != nil {
+			return nil, fmt.Errorf("error leyendo %s: %w", name, err)
+		}
+	default:
+		return nil, fmt.Errorf("formato de perfil no soportado: %s (yaml o json)", name)
+	}
+
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	for csvType, dl := range p.Downloads {
+		dl.Type = csvType
+		p.Downloads[csvType] = dl
+	}
+	return &p, nil
+}
+
+//go:embed profiles/gco_default.yaml
+var defaultPortalProfileYAML []byte
+
+// LoadedProfile perfil en uso y de dónde salió
+type LoadedProfile struct {
+	*PortalProfile
+	Source   string    `json:"source"`   // Ruta del archivo o "embedded"
+	Checksum string    `json:"checksum"` // SHA-256 del contenido
+	LoadedAt time.Time `json:"loaded_at"`
+}
+
+// profileRegistry perfil activo; se sustituye entero al recargar, así una
+// ejecución en curso sigue con el perfil con el que empezó
+type profileRegistry struct {
+	mu      sync.RWMutex
+	current *LoadedProfile
+}
+
+var portalProfiles = &profileRegistry{}
+
+// portalProfilePath GCO_PORTAL_PROFILE (vacío = perfil embebido)
+func portalProfilePath() string {
+	return os.Getenv("GCO_PORTAL_PROFILE")
+}
+
+// Current perfil activo (carga el inicial si aún no hay)
+func (r *profileRegistry) Current() *LoadedProfile {
+	r.mu.RLock()
+	current := r.current
+	r.mu.RUnlock()
+	if current != nil {
+		return current
+	}
+
+	if _, err := r.Reload(); err != nil {
+		log.Printf("[Scraper] ⚠️ Error cargando perfil de portal, usando el embebido: %v", err)
+		r.set(mustEmbeddedProfile())
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.current
+}
+
+// Reload vuelve a leer el perfil de GCO_PORTAL_PROFILE (o el embebido). Si el
+// perfil nuevo no es válido se mantiene el anterior.
+func (r *profileRegistry) Reload() (*LoadedProfile, error) {
+	path := portalProfilePath()
+	if path == "" {
+		loaded := mustEmbeddedProfile()
+		r.set(loaded)
+		return loaded, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo perfil de portal: %w", err)
+	}
+	profile, err := ParsePortalProfile(data, path)
+	if err != nil {
+		return nil, err
+	}
+
+	loaded := newLoadedProfile(profile, path, data)
+	r.set(loaded)
+	log.Printf("[Scraper] Perfil de portal %s cargado desde %s", profile.Version, path)
+	return loaded, nil
+}
+
+func (r *profileRegistry) set(p *LoadedProfile) {
+	r.mu.Lock()
+	r.current = p
+	r.mu.Unlock()
+}
+
+func newLoadedProfile(p *PortalProfile, source string, data []byte) *LoadedProfile {
+	sum := sha256.Sum256(data)
+	return &LoadedProfile{
+		PortalProfile: p,
+		Source:        source,
+		Checksum:      hex.EncodeToString(sum[:]),
+		LoadedAt:      time.Now(),
+	}
+}
+
+func mustEmbeddedProfile() *LoadedProfile {
+	profile, err := ParsePortalProfile(defaultPortalProfileYAML, "gco_default.yaml")
+	if err != nil {
+		panic(err)
+	}
+	return newLoadedProfile(profile, "embedded", defaultPortalProfileYAML)
+}
@@ -0,0 +1,131 @@
+package scraper
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestEmbeddedProfileIsValid(t *testing.T) {
+	loaded := mustEmbeddedProfile()
+	if loaded.Version == "" || loaded.Source != "embedded" {
+		t.Fatalf("perfil embebido: %+v", loaded)
+	}
+	for _, csvType := range []CSVType{CSVClientes, CSVPolizas, CSVRecibos, CSVSiniestros} {
+		dl := loaded.Downloads[csvType]
+		if dl.Type != csvType || len(dl.Menu) == 0 || len(dl.Export) == 0 {
+			t.Fatalf("%s: %+v", csvType, dl)
+		}
+	}
+	if got := loaded.Downloads[CSVPolizas].Menu[1][0]; got.Text != "Pólizas" || got.query() != defaultTextScope {
+		t.Fatalf("menú pólizas = %+v", got)
+	}
+}
+
+// minimalProfileJSON perfil JSON válido con un único locator por elemento
+func minimalProfileJSON(version string) string {
+	dl := func(name string) string {
+		return `{"file_name": "` + name + `.csv", "menu": [{"text": "Cartera"}, ["#m", {"css": "a", "text": "` + name + `"}]], "export": ".btn-export"}`
+	}
+	return `{
+		"version": "` + version + `",
+		"login": {"username": "#user", "password": "#pass", "submit": {"css": "button", "text": "Entrar"}, "success": "nav"},
+		"downloads": {
+			"clientes": ` + dl("CLIENTES") + `,
+			"polizas": ` + dl("POLIZAS") + `,
+			"recibos": ` + dl("RECIBOS") + `,
+			"siniestros": ` + dl("SINIESTROS") + `
+		}
+	}`
+}
+
+func TestParsePortalProfileJSON(t *testing.T) {
+	p, err := ParsePortalProfile([]byte(minimalProfileJSON("v2")), "gco.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	menu := p.Downloads[CSVRecibos].Menu
+	if len(menu) != 2 || menu[0][0].Text != "Cartera" || len(menu[1]) != 2 || menu[1][1].CSS != "a" || menu[1][1].Text != "RECIBOS" {
+		t.Fatalf("menú = %+v", menu)
+	}
+	if p.Login.Submit[0].Text != "Entrar" || p.Login.Username[0].CSS != "#user" {
+		t.Fatalf("login = %+v", p.Login)
+	}
+
+	if _, err := ParsePortalProfile([]byte(`{"version": "v1", "typo": 1}`), "gco.json"); err == nil {
+		t.Fatal("campo desconocido aceptado")
+	}
+	if _, err := ParsePortalProfile([]byte(`version: v1`), "gco.toml"); err == nil {
+		t.Fatal("extensión no soportada aceptada")
+	}
+}
+
+func TestParsePortalProfileRejectsJQuerySelectors(t *testing.T) {
+	data := strings.Replace(minimalProfileJSON("v2"), `"success": "nav"`, `"success": "button:contains(\"Salir\")"`, 1)
+	_, err := ParsePortalProfile([]byte(data), "gco.json")
+
+	var profileErr *ProfileError
+	if !errors.As(err, &profileErr) {
+		t.Fatalf("err = %v", err)
+	}
+	if len(profileErr.Problems) != 1 || !strings.Contains(profileErr.Problems[0], "login.success[0]: :contains") {
+		t.Fatalf("problemas = %v", profileErr.Problems)
+	}
+
+	yamlData := "version: v3\nlogin:\n  username: '#u'\n  password: '#p'\n  submit: button\n  success: nav\ndownloads: {}\n"
+	_, err = ParsePortalProfile([]byte(yamlData), "gco.yaml")
+	if !errors.As(err, &profileErr) || len(profileErr.Problems) != 4 {
+		t.Fatalf("faltan los tipos de CSV: %v", err)
+	}
+}
+
+func TestReloadProfileKeepsPreviousOnError(t *testing.T) {
+	prev := portalProfiles
+	portalProfiles = &profileRegistry{}
+	t.Cleanup(func() { portalProfiles = prev })
+
+	path := filepath.Join(t.TempDir(), "gco.json")
+	os.WriteFile(path, []byte(minimalProfileJSON("v2")), 0644)
+	t.Setenv("GCO_PORTAL_PROFILE", path)
+
+	app := fiber.New()
+	app.Get("/profile", GetProfileHandler)
+	app.Post("/profile/reload", ReloadProfileHandler)
+	call := func(method, url string) (int, map[string]interface{}) {
+		resp, err := app.Test(httptest.NewRequest(method, url, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, body
+	}
+
+	if code, body := call("GET", "/profile"); code != 200 || body["version"] != "v2" || body["source"] != path {
+		t.Fatalf("perfil inicial: %d %v", code, body)
+	}
+
+	// Perfil roto: 400 y sigue activo el anterior
+	os.WriteFile(path, []byte(`{"version": "v3", "login": {"username": "a:contains(x)"}}`), 0644)
+	code, body := call("POST", "/profile/reload")
+	if code != 400 || body["active_version"] != "v2" || len(body["problems"].([]interface{})) == 0 {
+		t.Fatalf("recarga inválida: %d %v", code, body)
+	}
+	if portalProfiles.Current().Version != "v2" {
+		t.Fatal("el perfil inválido sustituyó al activo")
+	}
+
+	os.WriteFile(path, []byte(minimalProfileJSON("v3")), 0644)
+	if code, body := call("POST", "/profile/reload"); code != 200 || body["version"] != "v3" || body["changed"] != true {
+		t.Fatalf("recarga: %d %v", code, body)
+	}
+	if portalProfiles.Current().Version != "v3" {
+		t.Fatal("no se aplicó el perfil nuevo")
+	}
+}
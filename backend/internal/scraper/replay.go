@@ -0,0 +1,291 @@
+package scraper
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ReplayManifest describe un directorio de fixtures del portal GCO
+// (GCO_REPLAY_DIR/replay.yaml): páginas HTML grabadas y CSVs de exportación
+type ReplayManifest struct {
+	Username  string `yaml:"username"`
+	Password  string `yaml:"password"`
+	LoginPath string `yaml:"login_path"` // POST del formulario de login
+	// TOTPSecret si se indica, tras la contraseña se pide el código en MFAPath
+	TOTPSecret string                  `yaml:"totp_secret"`
+	MFAPath    string                  `yaml:"mfa_path"`
+	HomePath   string                  `yaml:"home_path"` // Redirección tras un login correcto
+	Pages      map[string]string       `yaml:"pages"`     // Ruta → plantilla HTML
+	Exports    map[string]ReplayExport `yaml:"exports"`   // Ruta → CSV descargable
+}
+
+// ReplayExport archivo servido como descarga
+type ReplayExport struct {
+	File     string `yaml:"file"`     // Relativo a GCO_REPLAY_DIR
+	Filename string `yaml:"filename"` // Nombre en Content-Disposition
+}
+
+// replayPageData datos disponibles en las plantillas de las páginas
+type replayPageData struct {
+	Error string
+	User  string
+}
+
+const replaySessionCookie = "GCOREPLAYSESSION"
+
+// ReplayServer servidor local que imita el portal GCO con páginas grabadas,
+// para ejecutar el scraper completo (login, menús, descargas, reintentos) en
+// Chrome headless sin tocar el portal real
+type ReplayServer struct {
+	URL      string
+	Dir      string
+	Manifest ReplayManifest
+
+	mu         sync.Mutex
+	failLogins int
+	generation int    // Sesiones válidas: las de la generación actual
+	usedTOTP   uint64 // Última ventana TOTP aceptada (no se admite repetir)
+	hits       map[string]int
+	exports    map[string][]string // Query de cada exportación servida, por ruta
+	pages      map[string]*template.Template
+	listener   net.Listener
+	server     *http.Server
+}
+
+// StartReplayServer carga el manifest de dir y escucha en 127.0.0.1 (puerto libre)
+func StartReplayServer(dir string) (*ReplayServer, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "replay.yaml"))
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo manifest de replay: %w", err)
+	}
+
+	rs := &ReplayServer{
+		Dir:     dir,
+		hits:    make(map[string]int),
+		exports: make(map[string][]string),
+		pages:   make(map[string]*template.Template),
+	}
+	if err := yaml.Unmarshal(data, &rs.Manifest); err != nil {
+		return nil, fmt.Errorf("error en manifest de replay: %w", err)
+	}
+	if rs.Manifest.LoginPath == "" {
+		rs.Manifest.LoginPath = "/login"
+	}
+	if rs.Manifest.HomePath == "" {
+		rs.Manifest.HomePath = "/inicio"
+	}
+	if rs.Manifest.MFAPath == "" {
+		rs.Manifest.MFAPath = "/mfa"
+	}
+
+	// Las páginas pueden usar bloques comunes (menú, listados) de partials/
+	partials, _ := filepath.Glob(filepath.Join(dir, "partials", "*.html"))
+	for path, file := range rs.Manifest.Pages {
+		tmpl, err := template.ParseFiles(append([]string{filepath.Join(dir, file)}, partials...)...)
+		if err != nil {
+			return nil, fmt.Errorf("error en página %s: %w", path, err)
+		}
+		rs.pages[path] = tmpl
+	}
+	for path, export := range rs.Manifest.Exports {
+		if _, err := os.Stat(filepath.Join(dir, export.File)); err != nil {
+			return nil, fmt.Errorf("export %s: %w", path, err)
+		}
+	}
+
+	rs.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	rs.URL = "http://" + rs.listener.Addr().String()
+	rs.server = &http.Server{Handler: rs}
+	go rs.server.Serve(rs.listener)
+
+	log.Printf("[Replay] Portal GCO simulado en %s (fixtures: %s)", rs.URL, dir)
+	return rs, nil
+}
+
+// Close detiene el servidor
+func (rs *ReplayServer) Close() error {
+	return rs.server.Close()
+}
+
+// FailNextLogins hace que los próximos n logins devuelvan un error del portal
+func (rs *ReplayServer) FailNextLogins(n int) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.failLogins = n
+}
+
+// ExpireSessions invalida las sesiones abiertas (como un timeout del portal)
+func (rs *ReplayServer) ExpireSessions() {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.generation++
+}
+
+// Hits peticiones recibidas por ruta ("POST /login", "GET /export/recibos"...)
+func (rs *ReplayServer) Hits(route string) int {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.hits[route]
+}
+
+// ExportQueries filtros (query) con los que se pidió cada exportación de path
+func (rs *ReplayServer) ExportQueries(path string) []string {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return append([]string(nil), rs.exports[path]...)
+}
+
+func (rs *ReplayServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rs.mu.Lock()
+	rs.hits[r.Method+" "+r.URL.Path]++
+	rs.mu.Unlock()
+
+	switch {
+	case r.URL.Path == rs.Manifest.LoginPath && r.Method == http.MethodPost:
+		rs.login(w, r)
+	case r.URL.Path == rs.Manifest.MFAPath && r.Method == http.MethodPost:
+		rs.verifyTOTP(w, r)
+	case r.URL.Path == rs.Manifest.MFAPath:
+		rs.render(w, rs.Manifest.MFAPath, replayPageData{})
+	case r.URL.Path == "/logout":
+		http.SetCookie(w, &http.Cookie{Name: replaySessionCookie, Path: "/", MaxAge: -1})
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	case r.URL.Path == "/":
+		// Con sesión activa, la portada lleva al inicio (como el portal)
+		if rs.authenticated(r) {
+			http.Redirect(w, r, rs.Manifest.HomePath, http.StatusSeeOther)
+			return
+		}
+		rs.render(w, "/", replayPageData{})
+	default:
+		if !rs.authenticated(r) {
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+		if export, ok := rs.Manifest.Exports[r.URL.Path]; ok {
+			rs.mu.Lock()
+			rs.exports[r.URL.Path] = append(rs.exports[r.URL.Path], r.URL.RawQuery)
+			rs.mu.Unlock()
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, export.Filename))
+			http.ServeFile(w, r, filepath.Join(rs.Dir, export.File))
+			return
+		}
+		if _, ok := rs.pages[r.URL.Path]; !ok {
+			http.NotFound(w, r)
+			return
+		}
+		rs.render(w, r.URL.Path, replayPageData{User: rs.Manifest.Username})
+	}
+}
+
+func (rs *ReplayServer) login(w http.ResponseWriter, r *http.Request) {
+	rs.mu.Lock()
+	fail := rs.failLogins > 0
+	if fail {
+		rs.failLogins--
+	}
+	rs.mu.Unlock()
+
+	switch {
+	case fail:
+		rs.render(w, "/", replayPageData{Error: "Servicio no disponible temporalmente"})
+	case r.FormValue("username") != rs.Manifest.Username || r.FormValue("password") != rs.Manifest.Password:
+		rs.render(w, "/", replayPageData{Error: "Usuario o contraseña incorrectos"})
+	case rs.Manifest.TOTPSecret != "":
+		rs.setSession(w, "mfa")
+		http.Redirect(w, r, rs.Manifest.MFAPath, http.StatusSeeOther)
+	default:
+		rs.setSession(w, "ok")
+		http.Redirect(w, r, rs.Manifest.HomePath, http.StatusSeeOther)
+	}
+}
+
+// verifyTOTP segundo paso del login: código de la ventana actual o la anterior
+func (rs *ReplayServer) verifyTOTP(w http.ResponseWriter, r *http.Request) {
+	if rs.sessionState(r) != "mfa" {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	key, err := decodeTOTPSecret(rs.Manifest.TOTPSecret)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	code := r.FormValue("code")
+	now := totpCounter(time.Now())
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	for _, counter := range []uint64{now, now - 1} {
+		if counter > rs.usedTOTP && code == totpCode(key, counter) {
+			rs.usedTOTP = counter
+			rs.setSessionLocked(w, "ok")
+			http.Redirect(w, r, rs.Manifest.HomePath, http.StatusSeeOther)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if tmpl, ok := rs.pages[rs.Manifest.MFAPath]; ok {
+		tmpl.Execute(w, replayPageData{Error: "Código de verificación incorrecto"})
+	}
+}
+
+func (rs *ReplayServer) setSession(w http.ResponseWriter, state string) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.setSessionLocked(w, state)
+}
+
+func (rs *ReplayServer) setSessionLocked(w http.ResponseWriter, state string) {
+	value := fmt.Sprintf("%s-%d", state, rs.generation)
+	// Con caducidad: Chrome guarda y restaura la cookie como el portal real
+	http.SetCookie(w, &http.Cookie{
+		Name: replaySessionCookie, Value: value, Path: "/", HttpOnly: true,
+		Expires: time.Now().Add(8 * time.Hour),
+	})
+}
+
+// sessionState "ok", "mfa" (falta el código) o "" si no hay sesión vigente
+func (rs *ReplayServer) sessionState(r *http.Request) string {
+	cookie, err := r.Cookie(replaySessionCookie)
+	if err != nil {
+		return ""
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	for _, state := range []string{"ok", "mfa"} {
+		if cookie.Value == fmt.Sprintf("%s-%d", state, rs.generation) {
+			return state
+		}
+	}
+	return ""
+}
+
+func (rs *ReplayServer) authenticated(r *http.Request) bool {
+	return rs.sessionState(r) == "ok"
+}
+
+func (rs *ReplayServer) render(w http.ResponseWriter, path string, data replayPageData) {
+	tmpl, ok := rs.pages[path]
+	if !ok {
+		http.Error(w, "página no grabada: "+path, http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := tmpl.Execute(w, data); err != nil {
+		log.Printf("[Replay] Error en página %s: %v", path, err)
+	}
+}
@@ -0,0 +1,220 @@
+package scraper
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const replayFixtures = "testdata/gco"
+
+func startReplay(t *testing.T) *ReplayServer {
+	t.Helper()
+	rs, err := StartReplayServer(replayFixtures)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { rs.Close() })
+	return rs
+}
+
+// requireChrome salta el test si no hay un Chrome/Chromium que arranque
+func requireChrome(t *testing.T) {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("test de navegador omitido en -short")
+	}
+	for _, name := range []string{"headless-shell", "chromium", "chromium-browser", "google-chrome", "google-chrome-stable", "chrome"} {
+		path, err := exec.LookPath(name)
+		if err != nil {
+			continue
+		}
+		if err := exec.Command(path, "--headless", "--version").Run(); err != nil {
+			t.Skipf("Chrome no arranca (%s): %v", path, err)
+		}
+		return
+	}
+	t.Skip("Chrome no está instalado")
+}
+
+func TestReplayServerLoginAndExport(t *testing.T) {
+	rs := startReplay(t)
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar}
+	get := func(path string) (*http.Response, string) {
+		resp, err := client.Get(rs.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+	login := func(user, pass string) string {
+		resp, err := client.PostForm(rs.URL+"/login", url.Values{"username": {user}, "password": {pass}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	// Sin sesión, las páginas protegidas vuelven al login
+	if _, body := get("/export/recibos"); !strings.Contains(body, `name="username"`) {
+		t.Fatal("export sin sesión no redirigió al login")
+	}
+
+	rs.FailNextLogins(1)
+	if body := login("replay", "replay"); !strings.Contains(body, "Servicio no disponible") {
+		t.Fatal("no se simuló el fallo del portal")
+	}
+	if body := login("replay", "mal"); !strings.Contains(body, "Usuario o contraseña incorrectos") {
+		t.Fatal("credenciales incorrectas aceptadas")
+	}
+	if body := login("replay", "replay"); !strings.Contains(body, `name="code"`) {
+		t.Fatalf("login correcto no pidió el segundo factor: %s", body)
+	}
+	if _, body := get("/inicio"); strings.Contains(body, "menu-principal") {
+		t.Fatal("acceso sin segundo factor")
+	}
+	code, _ := TOTPCode(rs.Manifest.TOTPSecret, time.Now())
+	verify := func(code string) string {
+		resp, err := client.PostForm(rs.URL+"/mfa", url.Values{"code": {code}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+	if body := verify("0000000"); !strings.Contains(body, "Código de verificación incorrecto") {
+		t.Fatal("código incorrecto aceptado")
+	}
+	if body := verify(code); !strings.Contains(body, `class="menu-principal"`) {
+		t.Fatalf("login con TOTP no llegó al inicio: %s", body)
+	}
+
+	resp, body := get("/export/clientes")
+	if cd := resp.Header.Get("Content-Disposition"); !strings.Contains(cd, "DatosExportados_") {
+		t.Fatalf("Content-Disposition = %q", cd)
+	}
+	if !strings.HasPrefix(body, "NIF;Nombre completo;IdAccount") {
+		t.Fatalf("CSV = %q", body)
+	}
+	if _, body := get("/recibos/listado"); !strings.Contains(body, "/export/recibos") {
+		t.Fatal("el listado no enlaza la exportación")
+	}
+	if rs.Hits("POST /login") != 3 || rs.Hits("GET /export/clientes") != 1 {
+		t.Fatalf("hits login = %d", rs.Hits("POST /login"))
+	}
+
+	// Con sesión, la portada lleva al inicio; al caducar, vuelve el login
+	if _, body := get("/"); !strings.Contains(body, "menu-principal") {
+		t.Fatal("la portada no reconoce la sesión activa")
+	}
+	rs.ExpireSessions()
+	if _, body := get("/inicio"); !strings.Contains(body, `name="username"`) {
+		t.Fatal("la sesión caducada sigue activa")
+	}
+}
+
+func TestWaitForDownloadIgnoresPreviousFiles(t *testing.T) {
+	dir := t.TempDir()
+	d := &Downloader{DownloadDir: dir}
+
+	// Descarga anterior (otro tipo) y copia antigua del mismo tipo
+	old := time.Now().Add(-30 * time.Second)
+	for _, name := range []string{"CLIENTES.csv", "POLIZAS.csv"} {
+		path := writeCSV(t, dir, name, "antiguo")
+		os.Chtimes(path, old, old)
+	}
+
+	since := time.Now().Add(-time.Second)
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		os.WriteFile(filepath.Join(dir, "DatosExportados_1234.csv"), []byte("nuevo\n"), 0644)
+	}()
+
+	path, err := d.waitForDownload("POLIZAS.csv", since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	if filepath.Base(path) != "POLIZAS.csv" || string(data) != "nuevo\n" {
+		t.Fatalf("descargado %s = %q", path, data)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "CLIENTES.csv")); string(data) != "antiguo\n" {
+		t.Fatal("se tomó la descarga anterior como la nueva")
+	}
+}
+
+func TestRunWithRetryAgainstReplay(t *testing.T) {
+	requireChrome(t)
+	store := useMemoryRunStore(t)
+
+	prevChanges := saveRunChanges
+	saveRunChanges = func(string, []RunChange) error { return nil }
+	prevProfiles := portalProfiles
+	portalProfiles = &profileRegistry{}
+	t.Cleanup(func() {
+		saveRunChanges = prevChanges
+		portalProfiles = prevProfiles
+	})
+
+	t.Setenv("GCO_DOWNLOAD_DIR", t.TempDir())
+	t.Setenv("GCO_LOG_DIR", t.TempDir())
+	t.Setenv("GCO_REPLAY_DIR", replayFixtures)
+	t.Setenv("GCO_PORTAL_PROFILE", "")
+	t.Setenv("GCO_HEADLESS", "true")
+
+	s := NewGCOScraper()
+	if s.Replay == nil {
+		t.Fatal("no arrancó el modo replay")
+	}
+	defer s.Replay.Close()
+
+	// El primer login falla: RunWithRetry lo reintenta
+	s.Replay.FailNextLogins(1)
+	retry := RetryConfig{MaxRetries: 1, InitialDelay: 10 * time.Millisecond, MaxDelay: 10 * time.Millisecond, BackoffFactor: 1}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	result, err := s.RunWithRetry(ctx, retry, RunOptions{})
+	if err != nil || !result.Success {
+		t.Fatalf("ejecución: %v, errores = %v", err, result.Errors)
+	}
+	if s.Replay.Hits("POST /login") != 2 || s.Replay.Hits("GET /logout") != 1 {
+		t.Fatalf("logins = %d, logouts = %d", s.Replay.Hits("POST /login"), s.Replay.Hits("GET /logout"))
+	}
+
+	want := map[CSVType]string{
+		CSVClientes:   "clientes.csv",
+		CSVPolizas:    "polizas.csv",
+		CSVRecibos:    "recibos.csv",
+		CSVSiniestros: "siniestros.csv",
+	}
+	if len(result.Files) != len(want) {
+		t.Fatalf("archivos = %+v", result.Files)
+	}
+	for _, file := range result.Files {
+		fixture, _ := os.ReadFile(filepath.Join(replayFixtures, "downloads", want[file.Type]))
+		got, _ := os.ReadFile(file.Path)
+		if filepath.Base(file.Path) != strings.ToUpper(want[file.Type]) || string(got) != string(fixture) {
+			t.Fatalf("%s descargado en %s con %q", file.Type, file.Path, got)
+		}
+	}
+
+	runs, _, _ := store.List(RunFilter{})
+	if len(runs) != 2 {
+		t.Fatalf("ejecuciones guardadas = %d", len(runs))
+	}
+}
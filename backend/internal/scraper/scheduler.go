@@ -2,22 +2,30 @@ package scraper
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"sort"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/go-co-op/gocron"
 )
 
-// ScraperScheduler maneja la ejecución programada del scraper
+// ScraperScheduler ejecuta los schedules del scraper (tabla scraper_schedules):
+// un job de gocron por schedule habilitado, cada uno en su zona horaria
 type ScraperScheduler struct {
 	Scheduler *gocron.Scheduler
 	Scraper   *GCOScraper
-	CronExpr  string
-	Enabled   bool
-	Job       *gocron.Job
-	Location  *time.Location
+	Location  *time.Location // Zona horaria por defecto
+	// Notifications canales de aviso de las ejecuciones (GCO_NOTIFY_*)
+	Notifications *Notifications
+
+	mu        sync.Mutex
+	schedules map[int]*Schedule
+	jobs      map[int]*gocron.Job
 }
 
 // GlobalScheduler instancia global del scheduler
@@ -33,73 +41,252 @@ func InitScheduler() error {
 	return GlobalScheduler.Start()
 }
 
+// schedulerOrInit scheduler global (lo arranca si aún no existe)
+func schedulerOrInit() (*ScraperScheduler, error) {
+	if GlobalScheduler == nil {
+		if err := InitScheduler(); err != nil {
+			return nil, err
+		}
+	}
+	return GlobalScheduler, nil
+}
+
 // NewScraperScheduler crea un nuevo scheduler
 func NewScraperScheduler(scraper *GCOScraper) *ScraperScheduler {
 	// Usar zona horaria de España
-	loc, err := time.LoadLocation("Europe/Madrid")
+	loc, err := time.LoadLocation(defaultScheduleTimezone)
 	if err != nil {
 		log.Printf("[Scheduler] Error cargando timezone, usando UTC: %v", err)
 		loc = time.UTC
 	}
 
-	s := gocron.NewScheduler(loc)
+	return &ScraperScheduler{
+		Scheduler:     gocron.NewScheduler(loc),
+		Scraper:       scraper,
+		Location:      loc,
+		schedules:     make(map[int]*Schedule),
+		Notifications: NewNotificationsFromEnv(),
+		jobs:          make(map[int]*gocron.Job),
+	}
+}
 
+// envSchedule schedule "default" de GCO_SCHEDULE_CRON, GCO_SCHEDULE_ENABLED,
+// GCO_SCHEDULE_TYPES y GCO_SCHEDULE_DAYS (configuración anterior a la tabla)
+func (ss *ScraperScheduler) envSchedule() *Schedule {
 	cronExpr := os.Getenv("GCO_SCHEDULE_CRON")
 	if cronExpr == "" {
 		cronExpr = "0 6 * * *" // Default: 6:00 AM diario
 	}
+	sc := &Schedule{
+		Name:     "default",
+		Cron:     cronExpr,
+		Timezone: ss.Location.String(),
+		Enabled:  os.Getenv("GCO_SCHEDULE_ENABLED") == "true",
+	}
 
-	enabled := os.Getenv("GCO_SCHEDULE_ENABLED") == "true"
-
-	return &ScraperScheduler{
-		Scheduler: s,
-		Scraper:   scraper,
-		CronExpr:  cronExpr,
-		Enabled:   enabled,
-		Location:  loc,
+	var opts RunOptions
+	env := map[string]string{
+		"types":     os.Getenv("GCO_SCHEDULE_TYPES"),
+		"last_days": os.Getenv("GCO_SCHEDULE_DAYS"),
+	}
+	if err := parseRunSelection(func(key string) string { return env[key] }, &opts); err != nil {
+		log.Printf("[Scheduler] ⚠️ Selección programada inválida, se descarga todo: %v", err)
+	} else {
+		sc.Types = opts.Types
+		sc.LastDays = opts.LastDays
 	}
+	return sc
 }
 
-// Start inicia el scheduler
+// Start carga los schedules y arranca los habilitados. La primera vez (tabla
+// vacía) guarda como "default" el schedule de las variables GCO_SCHEDULE_*;
+// sin base de datos usa ese schedule solo en memoria.
 func (ss *ScraperScheduler) Start() error {
-	if !ss.Enabled {
-		log.Println("[Scheduler] Scheduler deshabilitado (GCO_SCHEDULE_ENABLED=false)")
-		return nil
+	schedules, err := scheduleStore.List()
+	switch {
+	case err != nil:
+		log.Printf("[Scheduler] ⚠️ Schedules no disponibles (%v), usando GCO_SCHEDULE_*", err)
+		sc := ss.envSchedule()
+		if err := sc.validate(); err != nil {
+			return err
+		}
+		schedules = []*Schedule{sc}
+	case len(schedules) == 0:
+		sc := ss.envSchedule()
+		if err := sc.validate(); err != nil {
+			return err
+		}
+		if err := scheduleStore.Save(sc); err != nil {
+			return fmt.Errorf("error guardando el schedule por defecto: %w", err)
+		}
+		log.Printf("[Scheduler] Schedule 'default' creado desde GCO_SCHEDULE_* (%s)", sc.Cron)
+		schedules = []*Schedule{sc}
+	}
+
+	for _, sc := range schedules {
+		if err := ss.register(sc); err != nil {
+			log.Printf("[Scheduler] ⚠️ Schedule '%s' no programado: %v", sc.Name, err)
+		}
 	}
+	ss.Scheduler.StartAsync()
+
+	log.Printf("[Scheduler] Scheduler iniciado con %d schedules (zona horaria por defecto: %s)", len(schedules), ss.Location)
+	for _, sc := range ss.Schedules() {
+		if sc.Enabled && sc.NextRun != nil {
+			log.Printf("[Scheduler]   - %s (%s): próxima ejecución %s", sc.Name, sc.Cron, sc.NextRun.Format("2006-01-02 15:04:05 MST"))
+		}
+	}
+	return nil
+}
+
+// scheduleTag etiqueta del job de gocron de un schedule
+func scheduleTag(id int) string {
+	return "schedule-" + strconv.Itoa(id)
+}
 
-	log.Printf("[Scheduler] Configurando scheduler con cron: %s", ss.CronExpr)
-	log.Printf("[Scheduler] Zona horaria: %s", ss.Location.String())
+// register guarda el schedule en memoria y (re)programa su job
+func (ss *ScraperScheduler) register(sc *Schedule) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
 
-	job, err := ss.Scheduler.Cron(ss.CronExpr).Do(ss.runTask)
+	ss.unregisterLocked(sc.ID)
+	copied := *sc
+	ss.schedules[sc.ID] = &copied
+	if !sc.Enabled {
+		return nil
+	}
+
+	job, err := ss.Scheduler.Cron(sc.cronSpec()).Tag(scheduleTag(sc.ID)).Do(ss.runSchedule, sc.ID, false)
 	if err != nil {
 		return err
 	}
+	ss.jobs[sc.ID] = job
+	return nil
+}
 
-	ss.Job = job
-	ss.Scheduler.StartAsync()
+func (ss *ScraperScheduler) unregisterLocked(id int) {
+	if _, ok := ss.jobs[id]; ok {
+		ss.Scheduler.RemoveByTag(scheduleTag(id))
+		delete(ss.jobs, id)
+	}
+}
+
+// Save valida, guarda y reprograma un schedule (ID 0 = nuevo). Conserva los
+// datos de la última ejecución.
+func (ss *ScraperScheduler) Save(sc *Schedule) (*Schedule, error) {
+	if err := sc.validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidSchedule, err)
+	}
+	if sc.ID != 0 {
+		if current := ss.Schedule(sc.ID); current != nil {
+			sc.LastRunAt, sc.LastRunID, sc.LastStatus = current.LastRunAt, current.LastRunID, current.LastStatus
+		}
+	}
+	if err := scheduleStore.Save(sc); err != nil {
+		return nil, err
+	}
+	if err := ss.register(sc); err != nil {
+		return nil, err
+	}
+	log.Printf("[Scheduler] Schedule '%s' guardado (cron: %s, %s, habilitado: %v)", sc.Name, sc.Cron, sc.Timezone, sc.Enabled)
+	return ss.Schedule(sc.ID), nil
+}
+
+// Delete elimina un schedule y su job
+func (ss *ScraperScheduler) Delete(id int) error {
+	if err := scheduleStore.Delete(id); err != nil {
+		return err
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.unregisterLocked(id)
+	delete(ss.schedules, id)
+	log.Printf("[Scheduler] Schedule %d eliminado", id)
+	return nil
+}
+
+// Schedule copia de un schedule con su próxima ejecución (nil si no existe)
+func (ss *ScraperScheduler) Schedule(id int) *Schedule {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	sc, ok := ss.schedules[id]
+	if !ok {
+		return nil
+	}
+	return ss.snapshotLocked(sc)
+}
+
+// Schedules copias de todos los schedules, por nombre
+func (ss *ScraperScheduler) Schedules() []*Schedule {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	schedules := make([]*Schedule, 0, len(ss.schedules))
+	for _, sc := range ss.schedules {
+		schedules = append(schedules, ss.snapshotLocked(sc))
+	}
+	sort.Slice(schedules, func(i, j int) bool { return schedules[i].Name < schedules[j].Name })
+	return schedules
+}
 
-	nextRun := job.NextRun()
-	log.Printf("[Scheduler] Scheduler iniciado")
-	log.Printf("[Scheduler] Próxima ejecución programada: %s", nextRun.Format("2006-01-02 15:04:05 MST"))
+func (ss *ScraperScheduler) snapshotLocked(sc *Schedule) *Schedule {
+	copied := *sc
+	copied.NextRun = nil
+	if sc.Enabled {
+		copied.NextRun = sc.nextRunAfter(time.Now())
+	}
+	return &copied
+}
 
+// scheduleByName schedule con ese nombre (nil si no existe)
+func (ss *ScraperScheduler) scheduleByName(name string) *Schedule {
+	for _, sc := range ss.Schedules() {
+		if sc.Name == name {
+			return sc
+		}
+	}
 	return nil
 }
 
-// runTask ejecuta el scraper como tarea programada
-func (ss *ScraperScheduler) runTask() {
+// runSchedule ejecuta un schedule; desde el cron se respeta enabled y las
+// ventanas sin ejecuciones, manual = lanzado con POST .../run
+func (ss *ScraperScheduler) runSchedule(id int, manual bool) {
+	sc := ss.Schedule(id)
+	if sc == nil || (!manual && !sc.Enabled) {
+		return
+	}
+
+	if !manual {
+		if w := sc.blackoutAt(time.Now()); w != nil {
+			log.Printf("[Scheduler] '%s' no se ejecuta: ventana sin ejecuciones %v %s-%s", sc.Name, w.Days, w.From, w.To)
+			ss.recordRun(sc, "", ScheduleBlackout)
+			return
+		}
+	}
+	if ss.Scraper.IsRunning {
+		log.Printf("[Scheduler] '%s' omitido: el scraper ya está en ejecución", sc.Name)
+		ss.recordRun(sc, "", ScheduleSkipped)
+		return
+	}
+
 	log.Println("[Scheduler] ========================================")
-	log.Println("[Scheduler] Ejecutando tarea programada")
+	log.Printf("[Scheduler] Ejecutando schedule '%s'", sc.Name)
 	log.Println("[Scheduler] ========================================")
 
 	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Minute)
 	defer cancel()
 
 	// Ejecutar con reintentos
-	result, err := ss.Scraper.RunWithRetry(ctx, DefaultRetryConfig)
+	result, err := ss.Scraper.RunWithRetry(ctx, DefaultRetryConfig, sc.runOptions())
+
+	var runID string
+	if result != nil {
+		runID = result.RunID
+	}
 
 	if err != nil {
 		log.Printf("[Scheduler] Error en ejecución programada: %v", err)
-		ss.sendErrorNotification(err)
+		ss.recordRun(sc, runID, ScheduleFailed)
+		ss.sendErrorNotification(sc, result, err)
 		return
 	}
 
@@ -107,85 +294,76 @@ func (ss *ScraperScheduler) runTask() {
 		result.Success, len(result.Files), len(result.Errors))
 
 	if result.Success {
-		ss.sendSuccessNotification(result)
+		ss.recordRun(sc, runID, ScheduleSuccess)
+		ss.sendSuccessNotification(sc, result)
 	} else if len(result.Files) > 0 {
 		// Parcialmente exitoso
-		ss.sendPartialSuccessNotification(result)
+		ss.recordRun(sc, runID, SchedulePartial)
+		ss.sendPartialSuccessNotification(sc, result)
 	} else {
-		ss.sendErrorNotification(fmt.Errorf("no se descargaron archivos"))
+		ss.recordRun(sc, runID, ScheduleFailed)
+		ss.sendErrorNotification(sc, result, fmt.Errorf("no se descargaron archivos"))
 	}
 
 	// Mostrar próxima ejecución
-	if ss.Job != nil {
-		log.Printf("[Scheduler] Próxima ejecución: %s", ss.Job.NextRun().Format("2006-01-02 15:04:05 MST"))
+	if next := ss.Schedule(id); next != nil && next.NextRun != nil {
+		log.Printf("[Scheduler] Próxima ejecución de '%s': %s", next.Name, next.NextRun.Format("2006-01-02 15:04:05 MST"))
 	}
 }
 
-// Stop detiene el scheduler
-func (ss *ScraperScheduler) Stop() {
-	ss.Scheduler.Stop()
-	log.Println("[Scheduler] Scheduler detenido")
-}
-
-// UpdateCron actualiza la expresión cron
-func (ss *ScraperScheduler) UpdateCron(cronExpr string) error {
-	log.Printf("[Scheduler] Actualizando cron de '%s' a '%s'", ss.CronExpr, cronExpr)
-
-	ss.Scheduler.Stop()
-	ss.CronExpr = cronExpr
-
-	if ss.Enabled {
-		return ss.Start()
+// recordRun guarda la última ejecución del schedule (en memoria y en la tabla)
+func (ss *ScraperScheduler) recordRun(sc *Schedule, runID, status string) {
+	now := time.Now()
+	ss.mu.Lock()
+	if current, ok := ss.schedules[sc.ID]; ok {
+		current.LastRunAt = &now
+		current.LastRunID = runID
+		current.LastStatus = status
 	}
+	ss.mu.Unlock()
 
-	return nil
-}
-
-// Enable habilita el scheduler
-func (ss *ScraperScheduler) Enable() error {
-	ss.Enabled = true
-	return ss.Start()
-}
-
-// Disable deshabilita el scheduler
-func (ss *ScraperScheduler) Disable() {
-	ss.Enabled = false
-	ss.Stop()
+	if sc.ID == 0 {
+		return // Schedule de GCO_SCHEDULE_* sin base de datos
+	}
+	if err := scheduleStore.RecordRun(sc.ID, runID, status, now); err != nil && !errors.Is(err, errNoDB) {
+		log.Printf("[Scheduler] ⚠️ Error guardando la ejecución de '%s': %v", sc.Name, err)
+	}
 }
 
-// GetNextRun devuelve la próxima ejecución programada
-func (ss *ScraperScheduler) GetNextRun() *time.Time {
-	if ss.Job == nil || !ss.Enabled {
-		return nil
-	}
-	nextRun := ss.Job.NextRun()
-	return &nextRun
+// Stop detiene el scheduler
+func (ss *ScraperScheduler) Stop() {
+	ss.Scheduler.Stop()
+	log.Println("[Scheduler] Scheduler detenido")
 }
 
 // GetStatus devuelve el estado del scheduler
 func (ss *ScraperScheduler) GetStatus() map[string]interface{} {
+	schedules := ss.Schedules()
 	status := map[string]interface{}{
-		"enabled":  ss.Enabled,
-		"cron":     ss.CronExpr,
-		"timezone": ss.Location.String(),
+		"timezone":  ss.Location.String(),
+		"schedules": schedules,
 	}
 
-	if nextRun := ss.GetNextRun(); nextRun != nil {
-		status["next_run"] = nextRun.Format("2006-01-02 15:04:05 MST")
-		status["next_run_in"] = time.Until(*nextRun).String()
+	// Próxima ejecución de entre todos los schedules
+	var next *Schedule
+	for _, sc := range schedules {
+		if sc.NextRun != nil && (next == nil || sc.NextRun.Before(*next.NextRun)) {
+			next = sc
+		}
+	}
+	status["enabled"] = next != nil
+	if next != nil {
+		status["next_run"] = next.NextRun.Format("2006-01-02 15:04:05 MST")
+		status["next_run_in"] = time.Until(*next.NextRun).String()
+		status["next_schedule"] = next.Name
 	}
 
 	return status
 }
 
-// RunNow ejecuta el scraper inmediatamente (fuera de schedule)
-func (ss *ScraperScheduler) RunNow() {
-	go ss.runTask()
-}
-
-// Funciones de notificación (pueden integrarse con email, Slack, etc.)
+// Funciones de notificación: log y aviso por los canales de GCO_NOTIFY_CHANNELS
 
-func (ss *ScraperScheduler) sendSuccessNotification(result *ScraperResult) {
+func (ss *ScraperScheduler) sendSuccessNotification(sc *Schedule, result *ScraperResult) {
 	log.Printf("[Notification] ✅ Scraper GCO completado exitosamente")
 	log.Printf("[Notification] Archivos descargados: %d", len(result.Files))
 	for _, file := range result.Files {
@@ -193,12 +371,10 @@ func (ss *ScraperScheduler) sendSuccessNotification(result *ScraperResult) {
 	}
 	log.Printf("[Notification] Duración: %s", result.Duration)
 
-	// TODO: Integrar con sistema de email (Microsoft Graph) para notificaciones
-	// Ejemplo:
-	// email.SendEmail("Scraper GCO - Éxito", formatSuccessEmail(result), "admin@sorianomediadores.es")
+	ss.notify(newRunNotification(NotifySuccess, sc, result, nil))
 }
 
-func (ss *ScraperScheduler) sendPartialSuccessNotification(result *ScraperResult) {
+func (ss *ScraperScheduler) sendPartialSuccessNotification(sc *Schedule, result *ScraperResult) {
 	log.Printf("[Notification] ⚠️ Scraper GCO parcialmente exitoso")
 	log.Printf("[Notification] Archivos descargados: %d", len(result.Files))
 	log.Printf("[Notification] Errores: %d", len(result.Errors))
@@ -206,14 +382,20 @@ func (ss *ScraperScheduler) sendPartialSuccessNotification(result *ScraperResult
 		log.Printf("[Notification]   - %s", errMsg)
 	}
 
-	// TODO: Enviar notificación por email
+	ss.notify(newRunNotification(NotifyPartial, sc, result, nil))
 }
 
-func (ss *ScraperScheduler) sendErrorNotification(err error) {
+func (ss *ScraperScheduler) sendErrorNotification(sc *Schedule, result *ScraperResult, err error) {
 	log.Printf("[Notification] ❌ Error en Scraper GCO: %v", err)
 
-	// TODO: Enviar notificación urgente por email
-	// email.SendEmail("⚠️ Scraper GCO - Error", formatErrorEmail(err), "admin@sorianomediadores.es")
+	ss.notify(newRunNotification(NotifyFailed, sc, result, err))
+}
+
+// notify envía el aviso; los errores de los canales ya se registran en Notify
+func (ss *ScraperScheduler) notify(n *Notification) {
+	if err := ss.Notifications.Notify(n); err != nil {
+		log.Printf("[Notification] ⚠️ Aviso de '%s' enviado con errores", n.ScheduleName)
+	}
 }
 
 // formatError formatea un error para logs
@@ -0,0 +1,550 @@
+package scraper
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"soriano-mediadores/internal/api"
+	"soriano-mediadores/internal/db"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lib/pq"
+	"github.com/robfig/cron/v3"
+)
+
+// Schedule ejecución programada del scraper con nombre (p. ej. "recibos cada
+// hora en días laborables" o "exportación completa nocturna")
+type Schedule struct {
+	ID         int              `json:"id"`
+	Name       string           `json:"name"`
+	Cron       string           `json:"cron"`     // Cron estándar de 5 campos
+	Timezone   string           `json:"timezone"` // Por defecto Europe/Madrid
+	Enabled    bool             `json:"enabled"`
+	Types      []CSVType        `json:"types"`       // Vacío = todos
+	LastDays   int              `json:"last_days"`   // 0 = exportación completa
+	Parallel   int              `json:"parallel"`    // 0 = GCO_PARALLEL_TABS
+	ImportMode string           `json:"import_mode"` // Vacío = GCO_IMPORT_MODE, none = no importar
+	Blackouts  []BlackoutWindow `json:"blackouts"`   // Ventanas en las que no se ejecuta
+	LastRunAt  *time.Time       `json:"last_run_at,omitempty"`
+	LastRunID  string           `json:"last_run_id,omitempty"`
+	LastStatus string           `json:"last_status,omitempty"`
+	NextRun    *time.Time       `json:"next_run,omitempty"` // Calculada (respeta las ventanas)
+	CreatedAt  time.Time        `json:"created_at"`
+	UpdatedAt  time.Time        `json:"updated_at"`
+}
+
+// Estados de la última ejecución de un schedule
+const (
+	ScheduleSuccess  = "success"
+	SchedulePartial  = "partial"
+	ScheduleFailed   = "failed"
+	ScheduleSkipped  = "skipped"  // El scraper ya estaba en ejecución
+	ScheduleBlackout = "blackout" // Dentro de una ventana sin ejecuciones
+)
+
+// defaultScheduleTimezone zona horaria de la oficina
+const defaultScheduleTimezone = "Europe/Madrid"
+
+// BlackoutWindow ventana sin ejecuciones, en la zona horaria del schedule.
+// Sin horas bloquea el día entero; si from > to cruza la medianoche y la
+// madrugada cuenta como parte del día en que empieza.
+type BlackoutWindow struct {
+	Days []string `json:"days,omitempty"` // mon..sun o lun..dom; vacío = todos los días
+	From string   `json:"from,omitempty"` // HH:MM
+	To   string   `json:"to,omitempty"`   // HH:MM (no incluida)
+}
+
+// weekdayNames días admitidos en las ventanas
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+	"dom": time.Sunday, "lun": time.Monday, "mar": time.Tuesday, "mie": time.Wednesday,
+	"jue": time.Thursday, "vie": time.Friday, "sab": time.Saturday,
+}
+
+// clockMinutes minutos desde medianoche de una hora HH:MM (-1 si no es válida)
+func clockMinutes(clock string) int {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return -1
+	}
+	return t.Hour()*60 + t.Minute()
+}
+
+func (w BlackoutWindow) validate() error {
+	for _, day := range w.Days {
+		if _, ok := weekdayNames[strings.ToLower(day)]; !ok {
+			return fmt.Errorf("día desconocido en blackout: %s (mon..sun o lun..dom)", day)
+		}
+	}
+	if (w.From == "") != (w.To == "") {
+		return fmt.Errorf("blackout: from y to van juntos (o ninguno para el día entero)")
+	}
+	if w.From != "" {
+		from, to := clockMinutes(w.From), clockMinutes(w.To)
+		if from < 0 || to < 0 {
+			return fmt.Errorf("blackout: horas en formato HH:MM (%s-%s)", w.From, w.To)
+		}
+		if from == to {
+			return fmt.Errorf("blackout: la ventana %s-%s está vacía", w.From, w.To)
+		}
+	}
+	if len(w.Days) == 0 && w.From == "" {
+		return fmt.Errorf("blackout: indica días, horas o ambos")
+	}
+	return nil
+}
+
+// onDay indica si la ventana aplica al día de la semana
+func (w BlackoutWindow) onDay(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, name := range w.Days {
+		if weekdayNames[strings.ToLower(name)] == day {
+			return true
+		}
+	}
+	return false
+}
+
+// contains indica si t (ya en la zona horaria del schedule) cae en la ventana
+func (w BlackoutWindow) contains(t time.Time) bool {
+	if w.From == "" {
+		return w.onDay(t.Weekday())
+	}
+	from, to := clockMinutes(w.From), clockMinutes(w.To)
+	now := t.Hour()*60 + t.Minute()
+	if from < to {
+		return now >= from && now < to && w.onDay(t.Weekday())
+	}
+	// Cruza la medianoche
+	if now >= from {
+		return w.onDay(t.Weekday())
+	}
+	if now < to {
+		return w.onDay((t.Weekday() + 6) % 7)
+	}
+	return false
+}
+
+// location zona horaria del schedule
+func (sc *Schedule) location() (*time.Location, error) {
+	if sc.Timezone == "" {
+		return time.LoadLocation(defaultScheduleTimezone)
+	}
+	return time.LoadLocation(sc.Timezone)
+}
+
+// cronSpec expresión con la zona horaria del schedule (formato de gocron/robfig)
+func (sc *Schedule) cronSpec() string {
+	tz := sc.Timezone
+	if tz == "" {
+		tz = defaultScheduleTimezone
+	}
+	return "CRON_TZ=" + tz + " " + sc.Cron
+}
+
+// blackoutAt ventana en la que cae t (nil si ninguna)
+func (sc *Schedule) blackoutAt(t time.Time) *BlackoutWindow {
+	loc, err := sc.location()
+	if err != nil {
+		return nil
+	}
+	local := t.In(loc)
+	for i := range sc.Blackouts {
+		if sc.Blackouts[i].contains(local) {
+			return &sc.Blackouts[i]
+		}
+	}
+	return nil
+}
+
+// nextRunAfter próxima ejecución real después de t (salta las ventanas)
+func (sc *Schedule) nextRunAfter(t time.Time) *time.Time {
+	spec, err := cron.ParseStandard(sc.cronSpec())
+	if err != nil {
+		return nil
+	}
+	// Un cron cada minuto con todo el fin de semana bloqueado necesita ~2900 pasos
+	for i := 0; i < 20000; i++ {
+		t = spec.Next(t)
+		if t.IsZero() {
+			return nil
+		}
+		if sc.blackoutAt(t) == nil {
+			return &t
+		}
+	}
+	return nil
+}
+
+// runOptions opciones de ejecución del schedule
+func (sc *Schedule) runOptions() RunOptions {
+	opts := DefaultRunOptions()
+	switch sc.ImportMode {
+	case "":
+	case "none":
+		opts.ImportMode = ""
+	default:
+		opts.ImportMode = api.ImportMode(sc.ImportMode)
+	}
+	opts.Types = sc.Types
+	opts.LastDays = sc.LastDays
+	opts.Parallel = sc.Parallel
+	opts.ScheduleID = sc.ID
+	return opts
+}
+
+// validate comprueba y normaliza el schedule antes de guardarlo
+func (sc *Schedule) validate() error {
+	sc.Name = strings.TrimSpace(sc.Name)
+	if sc.Name == "" || len(sc.Name) > 100 {
+		return fmt.Errorf("el nombre del schedule es obligatorio (máximo 100 caracteres)")
+	}
+	if sc.Timezone == "" {
+		sc.Timezone = defaultScheduleTimezone
+	}
+	if _, err := sc.location(); err != nil {
+		return fmt.Errorf("zona horaria desconocida: %s", sc.Timezone)
+	}
+	sc.Cron = strings.TrimSpace(sc.Cron)
+	if strings.HasPrefix(sc.Cron, "TZ=") || strings.HasPrefix(sc.Cron, "CRON_TZ=") {
+		return fmt.Errorf("la zona horaria va en timezone, no en el cron")
+	}
+	if _, err := cron.ParseStandard(sc.cronSpec()); err != nil || sc.Cron == "" {
+		return fmt.Errorf("expresión cron inválida: %q", sc.Cron)
+	}
+
+	types, err := ParseCSVTypes(joinTypes(sc.Types))
+	if err != nil {
+		return err
+	}
+	sc.Types = types
+	if sc.Types == nil {
+		sc.Types = []CSVType{}
+	}
+
+	if sc.ImportMode != "" && sc.ImportMode != "none" && !api.IsValidImportMode(api.ImportMode(sc.ImportMode)) {
+		return fmt.Errorf("import_mode inválido (add, update, replace o none)")
+	}
+	if err := sc.runOptions().validate(); err != nil {
+		return err
+	}
+
+	if sc.Blackouts == nil {
+		sc.Blackouts = []BlackoutWindow{}
+	}
+	for _, w := range sc.Blackouts {
+		if err := w.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ScheduleStore persistencia de los schedules
+type ScheduleStore interface {
+	List() ([]*Schedule, error)
+	Get(id int) (*Schedule, error)
+	// Save inserta (ID 0) o actualiza la configuración (no toca la última ejecución)
+	Save(sc *Schedule) error
+	Delete(id int) error
+	// RecordRun guarda el resultado de la última ejecución
+	RecordRun(id int, runID, status string, at time.Time) error
+}
+
+// scheduleStore almacén de schedules (sustituible en tests)
+var scheduleStore ScheduleStore = &postgresScheduleStore{}
+
+var (
+	// ErrScheduleNotFound no hay schedule con ese ID
+	ErrScheduleNotFound = errors.New("schedule no encontrado")
+	// ErrScheduleExists ya hay un schedule con ese nombre
+	ErrScheduleExists = errors.New("ya existe un schedule con ese nombre")
+	// errInvalidSchedule la configuración no pasa validate
+	errInvalidSchedule = errors.New("schedule inválido")
+)
+
+// postgresScheduleStore schedules en la tabla scraper_schedules
+type postgresScheduleStore struct{}
+
+const scheduleColumns = `
+	id, name, cron, timezone, enabled, types, last_days, parallel, COALESCE(import_mode, ''),
+	blackouts, last_run_at, COALESCE(last_run_id, ''), COALESCE(last_status, ''), created_at, updated_at
+`
+
+func scanSchedule(row rowScanner) (*Schedule, error) {
+	var sc Schedule
+	var typesJSON, blackoutsJSON []byte
+	var lastRunAt sql.NullTime
+	err := row.Scan(&sc.ID, &sc.Name, &sc.Cron, &sc.Timezone, &sc.Enabled, &typesJSON, &sc.LastDays,
+		&sc.Parallel, &sc.ImportMode, &blackoutsJSON, &lastRunAt, &sc.LastRunID, &sc.LastStatus,
+		&sc.CreatedAt, &sc.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if lastRunAt.Valid {
+		sc.LastRunAt = &lastRunAt.Time
+	}
+	if err := json.Unmarshal(typesJSON, &sc.Types); err != nil {
+		return nil, fmt.Errorf("types corrupto en schedule %d: %w", sc.ID, err)
+	}
+	if err := json.Unmarshal(blackoutsJSON, &sc.Blackouts); err != nil {
+		return nil, fmt.Errorf("blackouts corrupto en schedule %d: %w", sc.ID, err)
+	}
+	return &sc, nil
+}
+
+func (s *postgresScheduleStore) List() ([]*Schedule, error) {
+	if db.PostgresDB == nil {
+		return nil, errNoDB
+	}
+	rows, err := db.PostgresDB.Query("SELECT " + scheduleColumns + " FROM scraper_schedules ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules := []*Schedule{}
+	for rows.Next() {
+		sc, err := scanSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, sc)
+	}
+	return schedules, rows.Err()
+}
+
+func (s *postgresScheduleStore) Get(id int) (*Schedule, error) {
+	if db.PostgresDB == nil {
+		return nil, errNoDB
+	}
+	sc, err := scanSchedule(db.PostgresDB.QueryRow("SELECT "+scheduleColumns+" FROM scraper_schedules WHERE id = $1", id))
+	if err == sql.ErrNoRows {
+		return nil, ErrScheduleNotFound
+	}
+	return sc, err
+}
+
+func (s *postgresScheduleStore) Save(sc *Schedule) error {
+	if db.PostgresDB == nil {
+		return errNoDB
+	}
+	typesJSON, _ := json.Marshal(sc.Types)
+	blackoutsJSON, _ := json.Marshal(sc.Blackouts)
+
+	var err error
+	if sc.ID == 0 {
+		err = db.PostgresDB.QueryRow(`
+			INSERT INTO scraper_schedules (
+				name, cron, timezone, enabled, types, last_days, parallel, import_mode, blackouts
+			) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			RETURNING id, created_at, updated_at
+		`, sc.Name, sc.Cron, sc.Timezone, sc.Enabled, string(typesJSON), sc.LastDays, sc.Parallel,
+			nullString(sc.ImportMode), string(blackoutsJSON)).Scan(&sc.ID, &sc.CreatedAt, &sc.UpdatedAt)
+	} else {
+		err = db.PostgresDB.QueryRow(`
+			UPDATE scraper_schedules SET
+				name = $1, cron = $2, timezone = $3, enabled = $4, types = $5, last_days = $6,
+				parallel = $7, import_mode = $8, blackouts = $9, updated_at = NOW()
+			WHERE id = $10
+			RETURNING created_at, updated_at
+		`, sc.Name, sc.Cron, sc.Timezone, sc.Enabled, string(typesJSON), sc.LastDays, sc.Parallel,
+			nullString(sc.ImportMode), string(blackoutsJSON), sc.ID).Scan(&sc.CreatedAt, &sc.UpdatedAt)
+		if err == sql.ErrNoRows {
+			return ErrScheduleNotFound
+		}
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return ErrScheduleExists
+	}
+	return err
+}
+
+func (s *postgresScheduleStore) Delete(id int) error {
+	if db.PostgresDB == nil {
+		return errNoDB
+	}
+	res, err := db.PostgresDB.Exec("DELETE FROM scraper_schedules WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrScheduleNotFound
+	}
+	return nil
+}
+
+func (s *postgresScheduleStore) RecordRun(id int, runID, status string, at time.Time) error {
+	if db.PostgresDB == nil {
+		return errNoDB
+	}
+	_, err := db.PostgresDB.Exec(`
+		UPDATE scraper_schedules SET last_run_at = $2, last_run_id = $3, last_status = $4
+		WHERE id = $1
+	`, id, at, nullString(runID), status)
+	return err
+}
+
+// scheduleError respuesta de error de los endpoints de schedules
+func scheduleError(c *fiber.Ctx, err error) error {
+	status := 500
+	switch {
+	case errors.Is(err, ErrScheduleNotFound):
+		status = 404
+	case errors.Is(err, ErrScheduleExists):
+		status = 409
+	case errors.Is(err, errInvalidSchedule):
+		status = 400
+	}
+	return c.Status(status).JSON(fiber.Map{
+		"success": false,
+		"error":   err.Error(),
+	})
+}
+
+// scheduleIDParam ID del schedule de la ruta
+func scheduleIDParam(c *fiber.Ctx) (int, error) {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("%w: ID de schedule inválido", errInvalidSchedule)
+	}
+	return id, nil
+}
+
+// GetSchedulesHandler lista los schedules con su próxima ejecución
+// GET /api/scraper/schedules
+func GetSchedulesHandler(c *fiber.Ctx) error {
+	ss, err := schedulerOrInit()
+	if err != nil {
+		return scheduleError(c, err)
+	}
+	schedules := ss.Schedules()
+	return c.JSON(fiber.Map{
+		"success":   true,
+		"total":     len(schedules),
+		"schedules": schedules,
+	})
+}
+
+// GetScheduleHandler detalle de un schedule
+// GET /api/scraper/schedules/:id
+func GetScheduleHandler(c *fiber.Ctx) error {
+	id, err := scheduleIDParam(c)
+	if err != nil {
+		return scheduleError(c, err)
+	}
+	ss, err := schedulerOrInit()
+	if err != nil {
+		return scheduleError(c, err)
+	}
+	sc := ss.Schedule(id)
+	if sc == nil {
+		return scheduleError(c, ErrScheduleNotFound)
+	}
+	return c.JSON(sc)
+}
+
+// CreateScheduleHandler crea un schedule (habilitado si no se indica enabled)
+// POST /api/scraper/schedules
+func CreateScheduleHandler(c *fiber.Ctx) error {
+	sc := Schedule{Enabled: true}
+	if err := c.BodyParser(&sc); err != nil {
+		return c.Status(400).JSON(fiber.Map{"success": false, "error": "JSON inválido"})
+	}
+	sc.ID = 0
+
+	ss, err := schedulerOrInit()
+	if err != nil {
+		return scheduleError(c, err)
+	}
+	saved, err := ss.Save(&sc)
+	if err != nil {
+		return scheduleError(c, err)
+	}
+	return c.Status(201).JSON(saved)
+}
+
+// UpdateScheduleHandler reemplaza la configuración de un schedule
+// PUT /api/scraper/schedules/:id
+func UpdateScheduleHandler(c *fiber.Ctx) error {
+	id, err := scheduleIDParam(c)
+	if err != nil {
+		return scheduleError(c, err)
+	}
+	var sc Schedule
+	if err := c.BodyParser(&sc); err != nil {
+		return c.Status(400).JSON(fiber.Map{"success": false, "error": "JSON inválido"})
+	}
+	sc.ID = id
+
+	ss, err := schedulerOrInit()
+	if err != nil {
+		return scheduleError(c, err)
+	}
+	saved, err := ss.Save(&sc)
+	if err != nil {
+		return scheduleError(c, err)
+	}
+	return c.JSON(saved)
+}
+
+// DeleteScheduleHandler elimina un schedule
+// DELETE /api/scraper/schedules/:id
+func DeleteScheduleHandler(c *fiber.Ctx) error {
+	id, err := scheduleIDParam(c)
+	if err != nil {
+		return scheduleError(c, err)
+	}
+	ss, err := schedulerOrInit()
+	if err != nil {
+		return scheduleError(c, err)
+	}
+	if err := ss.Delete(id); err != nil {
+		return scheduleError(c, err)
+	}
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Schedule eliminado",
+	})
+}
+
+// RunScheduleHandler ejecuta ya un schedule (aunque esté deshabilitado o en
+// una ventana sin ejecuciones)
+// POST /api/scraper/schedules/:id/run
+func RunScheduleHandler(c *fiber.Ctx) error {
+	id, err := scheduleIDParam(c)
+	if err != nil {
+		return scheduleError(c, err)
+	}
+	ss, err := schedulerOrInit()
+	if err != nil {
+		return scheduleError(c, err)
+	}
+	sc := ss.Schedule(id)
+	if sc == nil {
+		return scheduleError(c, ErrScheduleNotFound)
+	}
+	if ss.Scraper.IsRunning {
+		return c.Status(409).JSON(fiber.Map{
+			"success": false,
+			"message": "El scraper ya está en ejecución",
+		})
+	}
+
+	go ss.runSchedule(id, true)
+
+	return c.JSON(fiber.Map{
+		"success":  true,
+		"message":  "Schedule " + sc.Name + " iniciado en background",
+		"schedule": sc,
+	})
+}
@@ -0,0 +1,266 @@
+package scraper
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// memoryScheduleStore schedules en memoria para los tests
+type memoryScheduleStore struct {
+	mu        sync.Mutex
+	nextID    int
+	schedules map[int]*Schedule
+}
+
+func useMemoryScheduleStore(t *testing.T) *memoryScheduleStore {
+	t.Helper()
+	store := &memoryScheduleStore{schedules: make(map[int]*Schedule)}
+	prev := scheduleStore
+	scheduleStore = store
+	t.Cleanup(func() { scheduleStore = prev })
+	return store
+}
+
+func (s *memoryScheduleStore) List() ([]*Schedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var schedules []*Schedule
+	for _, sc := range s.schedules {
+		copied := *sc
+		schedules = append(schedules, &copied)
+	}
+	return schedules, nil
+}
+
+func (s *memoryScheduleStore) Get(id int) (*Schedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sc, ok := s.schedules[id]
+	if !ok {
+		return nil, ErrScheduleNotFound
+	}
+	copied := *sc
+	return &copied, nil
+}
+
+func (s *memoryScheduleStore) Save(sc *Schedule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, other := range s.schedules {
+		if other.Name == sc.Name && id != sc.ID {
+			return ErrScheduleExists
+		}
+	}
+	if sc.ID == 0 {
+		s.nextID++
+		sc.ID = s.nextID
+		sc.CreatedAt = time.Now()
+	} else if _, ok := s.schedules[sc.ID]; !ok {
+		return ErrScheduleNotFound
+	}
+	sc.UpdatedAt = time.Now()
+	copied := *sc
+	s.schedules[sc.ID] = &copied
+	return nil
+}
+
+func (s *memoryScheduleStore) Delete(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.schedules[id]; !ok {
+		return ErrScheduleNotFound
+	}
+	delete(s.schedules, id)
+	return nil
+}
+
+func (s *memoryScheduleStore) RecordRun(id int, runID, status string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sc, ok := s.schedules[id]; ok {
+		sc.LastRunAt, sc.LastRunID, sc.LastStatus = &at, runID, status
+	}
+	return nil
+}
+
+func TestBlackoutWindows(t *testing.T) {
+	madrid, _ := time.LoadLocation("Europe/Madrid")
+	at := func(value string) time.Time {
+		tm, _ := time.ParseInLocation("2006-01-02 15:04", value, madrid)
+		return tm
+	}
+	sc := &Schedule{Timezone: "Europe/Madrid", Blackouts: []BlackoutWindow{
+		{Days: []string{"sat", "dom"}},                      // Fin de semana entero
+		{From: "14:00", To: "16:00"},                        // Comida, todos los días
+		{Days: []string{"vie"}, From: "22:00", To: "02:00"}, // Mantenimiento del portal
+	}}
+
+	// 2026-10-16 es viernes
+	for value, blocked := range map[string]bool{
+		"2026-10-16 09:00": false,
+		"2026-10-16 14:30": true,
+		"2026-10-16 16:00": false,
+		"2026-10-16 23:00": true,
+		"2026-10-17 01:00": true, // Madrugada del sábado (y además sábado)
+		"2026-10-18 10:00": true,
+		"2026-10-20 01:00": false, // Martes de madrugada: la ventana es solo del viernes
+		"2026-10-21 14:59": true,
+	} {
+		if got := sc.blackoutAt(at(value)) != nil; got != blocked {
+			t.Errorf("%s: bloqueado = %v", value, got)
+		}
+	}
+
+	// La hora se interpreta en la zona del schedule, no en la del servidor
+	if sc.blackoutAt(time.Date(2026, 10, 16, 12, 30, 0, 0, time.UTC)) == nil {
+		t.Error("12:30 UTC son las 14:30 en Madrid")
+	}
+
+	// Cada hora en punto de lunes a viernes: el viernes a las 21:00 la
+	// siguiente es el lunes a medianoche (22:00 y 23:00 caen en la ventana)
+	sc.Cron = "0 * * * 1-5"
+	next := sc.nextRunAfter(at("2026-10-16 21:00"))
+	if next == nil || !next.Equal(at("2026-10-19 00:00")) {
+		t.Fatalf("próxima ejecución = %v", next)
+	}
+}
+
+func TestScheduleValidate(t *testing.T) {
+	valid := Schedule{Name: " recibos ", Cron: "0 8-20 * * 1-5", Types: []CSVType{CSVRecibos}, LastDays: 7, ImportMode: "update"}
+	if err := valid.validate(); err != nil || valid.Name != "recibos" || valid.Timezone != "Europe/Madrid" {
+		t.Fatalf("schedule válido: %+v, %v", valid, err)
+	}
+
+	for name, sc := range map[string]Schedule{
+		"sin nombre":        {Cron: "0 6 * * *"},
+		"cron inválido":     {Name: "a", Cron: "cada hora"},
+		"tz en el cron":     {Name: "a", Cron: "CRON_TZ=UTC 0 6 * * *"},
+		"zona desconocida":  {Name: "a", Cron: "0 6 * * *", Timezone: "Marte/Olympus"},
+		"tipo desconocido":  {Name: "a", Cron: "0 6 * * *", Types: []CSVType{"cobros"}},
+		"replace con fecha": {Name: "a", Cron: "0 6 * * *", LastDays: 1, ImportMode: "replace"},
+		"blackout vacío":    {Name: "a", Cron: "0 6 * * *", Blackouts: []BlackoutWindow{{}}},
+		"blackout sin to":   {Name: "a", Cron: "0 6 * * *", Blackouts: []BlackoutWindow{{From: "10:00"}}},
+		"día desconocido":   {Name: "a", Cron: "0 6 * * *", Blackouts: []BlackoutWindow{{Days: []string{"festivo"}}}},
+	} {
+		if err := sc.validate(); err == nil {
+			t.Errorf("%s: aceptado", name)
+		}
+	}
+}
+
+func TestScheduleHandlers(t *testing.T) {
+	store := useMemoryScheduleStore(t)
+	t.Setenv("GCO_SCHEDULE_CRON", "30 5 * * *")
+	t.Setenv("GCO_SCHEDULE_ENABLED", "true")
+	t.Setenv("GCO_SCHEDULE_TYPES", "")
+	t.Setenv("GCO_SCHEDULE_DAYS", "")
+
+	prev := GlobalScheduler
+	GlobalScheduler = NewScraperScheduler(&GCOScraper{})
+	t.Cleanup(func() {
+		GlobalScheduler.Stop()
+		GlobalScheduler = prev
+	})
+	if err := GlobalScheduler.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	app.Post("/schedule", ConfigureScheduleHandler)
+	app.Get("/schedules", GetSchedulesHandler)
+	app.Post("/schedules", CreateScheduleHandler)
+	app.Get("/schedules/:id", GetScheduleHandler)
+	app.Put("/schedules/:id", UpdateScheduleHandler)
+	app.Delete("/schedules/:id", DeleteScheduleHandler)
+	call := func(method, url, body string) (int, map[string]interface{}) {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+	jobs := func() int { return len(GlobalScheduler.Scheduler.Jobs()) }
+
+	// Tabla vacía: se crea "default" desde GCO_SCHEDULE_*
+	if len(store.schedules) != 1 || store.schedules[1].Name != "default" || store.schedules[1].Cron != "30 5 * * *" || jobs() != 1 {
+		t.Fatalf("schedule inicial: %+v, jobs = %d", store.schedules, jobs())
+	}
+
+	code, body := call("POST", "/schedules", `{"name": "recibos", "cron": "0 8-20 * * 1-5", "types": ["recibos"],
+		"last_days": 7, "import_mode": "update", "blackouts": [{"from": "14:00", "to": "16:00"}]}`)
+	if code != 201 || body["enabled"] != true || body["next_run"] == nil || jobs() != 2 {
+		t.Fatalf("crear: %d %v, jobs = %d", code, body, jobs())
+	}
+	id := int(body["id"].(float64))
+
+	if code, _ := call("POST", "/schedules", `{"name": "recibos", "cron": "0 * * * *"}`); code != 409 {
+		t.Fatalf("nombre duplicado: %d", code)
+	}
+	if code, body := call("POST", "/schedules", `{"name": "x", "cron": "0 6 * * *", "last_days": 3, "import_mode": "replace"}`); code != 400 {
+		t.Fatalf("replace con fechas: %d %v", code, body)
+	}
+
+	// Deshabilitar quita el job pero conserva el schedule
+	code, body = call("PUT", "/schedules/"+strconv.Itoa(id), `{"name": "recibos", "cron": "0 8-20 * * 1-5", "types": ["recibos"], "enabled": false}`)
+	if code != 200 || body["enabled"] != false || body["next_run"] != nil || jobs() != 1 {
+		t.Fatalf("actualizar: %d %v, jobs = %d", code, body, jobs())
+	}
+	if store.schedules[id].Enabled {
+		t.Fatal("la actualización no se guardó")
+	}
+
+	// El endpoint anterior modifica el schedule "default"
+	if code, body := call("POST", "/schedule", `{"cron": "0 7 * * *", "types": ["polizas"]}`); code != 200 {
+		t.Fatalf("schedule legacy: %d %v", code, body)
+	}
+	if sc := store.schedules[1]; sc.Cron != "0 7 * * *" || joinTypes(sc.Types) != "polizas" {
+		t.Fatalf("default = %+v", sc)
+	}
+
+	if code, body := call("GET", "/schedules", ""); code != 200 || body["total"] != float64(2) {
+		t.Fatalf("listar: %d %v", code, body)
+	}
+	if code, _ := call("DELETE", "/schedules/"+strconv.Itoa(id), ""); code != 200 {
+		t.Fatalf("eliminar: %d", code)
+	}
+	if code, _ := call("GET", "/schedules/"+strconv.Itoa(id), ""); code != 404 {
+		t.Fatalf("eliminado sigue visible: %d", code)
+	}
+}
+
+func TestRunScheduleRecordsBlackoutAndSkip(t *testing.T) {
+	store := useMemoryScheduleStore(t)
+	ss := NewScraperScheduler(&GCOScraper{})
+
+	sc := &Schedule{Name: "siempre bloqueado", Cron: "* * * * *", Enabled: true,
+		Blackouts: []BlackoutWindow{{From: "00:00", To: "23:59"}, {From: "23:59", To: "00:00"}}}
+	if _, err := ss.Save(sc); err != nil {
+		t.Fatal(err)
+	}
+	if sc.nextRunAfter(time.Now()) != nil {
+		t.Fatal("un schedule siempre bloqueado no tiene próxima ejecución")
+	}
+
+	ss.runSchedule(sc.ID, false)
+	if got := store.schedules[sc.ID]; got.LastStatus != ScheduleBlackout || got.LastRunAt == nil {
+		t.Fatalf("cron en ventana: %+v", got)
+	}
+
+	// Manual ignora la ventana, pero no lanza una segunda ejecución
+	ss.Scraper.IsRunning = true
+	ss.runSchedule(sc.ID, true)
+	if got := ss.Schedule(sc.ID); got.LastStatus != ScheduleSkipped {
+		t.Fatalf("scraper ocupado: %+v", got)
+	}
+}
@@ -8,7 +8,9 @@ import (
 	"sync"
 	"time"
 
+	"github.com/chromedp/cdproto/browser"
 	"github.com/chromedp/chromedp"
+	"github.com/google/uuid"
 )
 
 // GCOScraper es el scraper principal para el portal GCO
@@ -23,6 +25,9 @@ type GCOScraper struct {
 	LastRun     time.Time
 	LastError   error
 	Status      ScraperStatus
+	LastResult  *ScraperResult // Última ejecución (archivos e import jobs)
+	current     *ScraperResult // Ejecución en curso (progreso por tipo)
+	Replay      *ReplayServer  // Portal simulado (GCO_REPLAY_DIR)
 	cancelFunc  context.CancelFunc
 }
 
@@ -32,9 +37,27 @@ var GlobalScraper *GCOScraper
 // InitScraper inicializa el scraper global
 func InitScraper() {
 	GlobalScraper = NewGCOScraper()
+	GlobalScraper.restoreHistory()
 	log.Println("[Scraper] Scraper GCO inicializado")
 }
 
+// restoreHistory marca las ejecuciones que quedaron a medias y recupera las
+// métricas del historial persistido
+func (s *GCOScraper) restoreHistory() {
+	if n, err := runStore.MarkInterrupted(); err != nil {
+		log.Printf("[Scraper] ⚠️ Historial no disponible: %v", err)
+		return
+	} else if n > 0 {
+		log.Printf("[Scraper] %d ejecuciones marcadas como interrumpidas", n)
+	}
+
+	s.Metrics.mu.Lock()
+	defer s.Metrics.mu.Unlock()
+	if err := runStore.LoadMetrics(s.Metrics); err != nil {
+		log.Printf("[Scraper] ⚠️ Error cargando métricas del historial: %v", err)
+	}
+}
+
 // NewGCOScraper crea un nuevo scraper
 func NewGCOScraper() *GCOScraper {
 	downloadDir := os.Getenv("GCO_DOWNLOAD_DIR")
@@ -51,14 +74,45 @@ func NewGCOScraper() *GCOScraper {
 	}
 	os.MkdirAll(logDir, 0755)
 
-	return &GCOScraper{
-		Auth:        NewAuthManager(),
-		Downloader:  NewDownloader(downloadDir),
+	downloader := NewDownloader(downloadDir)
+	auth := NewAuthManager()
+	auth.Screenshots = downloader.Screenshots
+	auth.SessionFile = sessionFilePath(downloadDir)
+
+	s := &GCOScraper{
+		Auth:        auth,
+		Downloader:  downloader,
 		Metrics:     &ScraperMetrics{},
 		BaseURL:     os.Getenv("GCO_BASE_URL"),
 		DownloadDir: downloadDir,
 		Status:      StatusIdle,
 	}
+
+	// Modo replay: navegar contra el portal simulado en vez del real
+	if dir := os.Getenv("GCO_REPLAY_DIR"); dir != "" {
+		if err := s.useReplay(dir); err != nil {
+			log.Printf("[Scraper] ⚠️ Modo replay no disponible: %v", err)
+		}
+	}
+
+	return s
+}
+
+// useReplay arranca el portal simulado de dir y apunta el scraper (URL y
+// credenciales) a él
+func (s *GCOScraper) useReplay(dir string) error {
+	replay, err := StartReplayServer(dir)
+	if err != nil {
+		return err
+	}
+	s.Replay = replay
+	s.BaseURL = replay.URL
+	s.Auth.BaseURL = replay.URL
+	s.Auth.Username = replay.Manifest.Username
+	s.Auth.Password = replay.Manifest.Password
+	s.Auth.TOTPSecret = replay.Manifest.TOTPSecret
+	log.Printf("[Scraper] Modo replay: usando %s en lugar de GCO_BASE_URL", replay.URL)
+	return nil
 }
 
 // createChromeContext crea un contexto de Chrome con las opciones necesarias
@@ -101,19 +155,26 @@ func createChromeContext(downloadDir string) (context.Context, context.CancelFun
 		allocCancel()
 	}
 
-	// Configurar el comportamiento de descarga
-	chromedp.Run(ctx,
-		chromedp.ActionFunc(func(ctx context.Context) error {
-			// Esto se ejecutará cuando se abra el navegador
-			return nil
-		}),
+	// Configurar el comportamiento de descarga: los flags download.* son
+	// preferencias de perfil y Chrome headless los ignora
+	err := chromedp.Run(ctx,
+		browser.SetDownloadBehavior(browser.SetDownloadBehaviorBehaviorAllow).
+			WithDownloadPath(downloadDir).
+			WithEventsEnabled(true),
 	)
+	if err != nil {
+		log.Printf("[Scraper] ⚠️ Error configurando descargas en Chrome: %v", err)
+	}
 
 	return ctx, cancel
 }
 
-// Run ejecuta el scraper completo
-func (s *GCOScraper) Run(parentCtx context.Context) (*ScraperResult, error) {
+// Run ejecuta el scraper completo: descarga e importa los archivos según opts
+func (s *GCOScraper) Run(parentCtx context.Context, opts RunOptions) (*ScraperResult, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
 	s.mu.Lock()
 	if s.IsRunning {
 		s.mu.Unlock()
@@ -131,65 +192,112 @@ func (s *GCOScraper) Run(parentCtx context.Context) (*ScraperResult, error) {
 	}()
 
 	result := &ScraperResult{
-		StartTime: time.Now(),
-		Files:     []DownloadedFile{},
-		Errors:    []string{},
+		RunID:      uuid.New().String(),
+		Status:     StatusRunning,
+		StartTime:  time.Now(),
+		Files:      []DownloadedFile{},
+		Errors:     []string{},
+		ImportMode: string(opts.ImportMode),
+		Types:      newTypeResults(opts.selectedTypes()),
+		DateRange:  opts.dateRangeAt(time.Now()),
+		ScheduleID: opts.ScheduleID,
 	}
+	s.Downloader.Screenshots.Reset(result.RunID[:8])
+	s.Downloader.BaseURL = s.BaseURL
+
+	s.mu.Lock()
+	s.current = result
+	s.mu.Unlock()
+
+	// Toda la ejecución navega con el mismo perfil aunque se recargue a mitad
+	profile := portalProfiles.Current()
+	s.Auth.Profile = profile.PortalProfile
+	s.Downloader.Profile = profile.PortalProfile
+	result.ProfileVersion = profile.Version
+	saveRun(result)
 
 	log.Println("[Scraper] ========================================")
 	log.Println("[Scraper] Iniciando ejecución del scraper GCO")
 	log.Println("[Scraper] ========================================")
+	if result.DateRange != nil {
+		log.Printf("[Scraper] Tipos: %s, fechas: %s", joinTypes(opts.selectedTypes()), result.DateRange)
+	} else {
+		log.Printf("[Scraper] Tipos: %s", joinTypes(opts.selectedTypes()))
+	}
+
+	// La importación puede durar más que el timeout de la descarga; solo la corta Stop
+	importCtx, cancelImport := context.WithCancel(context.WithoutCancel(parentCtx))
+	defer cancelImport()
 
 	// Crear contexto Chrome
 	chromeCtx, cancel := createChromeContext(s.DownloadDir)
-	s.cancelFunc = cancel
+	s.mu.Lock()
+	s.cancelFunc = func() {
+		cancel()
+		cancelImport()
+	}
+	s.mu.Unlock()
 	defer cancel()
 
 	// Timeout global de 15 minutos
 	chromeCtx, cancelTimeout := context.WithTimeout(chromeCtx, 15*time.Minute)
 	defer cancelTimeout()
 
-	// 1. Login
-	log.Println("[Scraper] Paso 1/3: Autenticación...")
-	err := s.Auth.Login(chromeCtx)
+	// 1. Login (o sesión guardada si el portal la sigue aceptando)
+	log.Println("[Scraper] Paso 1/5: Autenticación...")
+	err := s.Auth.EnsureSession(chromeCtx)
 	if err != nil {
 		errMsg := fmt.Sprintf("Error en login: %v", err)
 		log.Println("[Scraper] " + errMsg)
 		result.Errors = append(result.Errors, errMsg)
 		s.Status = StatusFailed
 		s.LastError = err
-		result.EndTime = time.Now()
-		result.Duration = result.EndTime.Sub(result.StartTime).String()
+		s.finishRun(result)
 		return result, err
 	}
+	result.SessionReused = s.Auth.SessionReused
 	log.Println("[Scraper] Login exitoso")
 
 	// 2. Descargar CSVs
-	log.Println("[Scraper] Paso 2/3: Descargando CSVs...")
-	csvTypes := []CSVType{CSVClientes, CSVPolizas, CSVRecibos, CSVSiniestros}
+	log.Println("[Scraper] Paso 2/5: Descargando CSVs...")
+	s.downloadTypes(chromeCtx, result, opts.parallelTabs())
 
-	for i, csvType := range csvTypes {
-		log.Printf("[Scraper] Descargando %s (%d/%d)...", csvType, i+1, len(csvTypes))
+	// Archivar las descargas (con checksum) antes de procesarlas
+	s.archiveFiles(result)
 
-		file, err := s.Downloader.DownloadCSV(chromeCtx, csvType)
-		if err != nil {
-			errMsg := fmt.Sprintf("Error descargando %s: %v", csvType, err)
-			log.Println("[Scraper] " + errMsg)
-			result.Errors = append(result.Errors, errMsg)
-			continue
+	// 3. Logout, salvo que la sesión se reutilice en la próxima ejecución
+	if s.Auth.ReusesSessions() {
+		log.Println("[Scraper] Paso 3/5: Guardando sesión para la próxima ejecución...")
+		if err := s.Auth.saveSession(chromeCtx); err != nil {
+			log.Printf("[Scraper] ⚠️ %v", err)
 		}
+	} else {
+		log.Println("[Scraper] Paso 3/5: Cerrando sesión...")
+		s.Auth.Logout(chromeCtx)
+	}
 
-		result.Files = append(result.Files, *file)
-		log.Printf("[Scraper] %s descargado exitosamente", csvType)
+	// 4. Cambios respecto a la ejecución anterior (antes de importar)
+	if result.DateRange != nil {
+		// Los snapshots son exportaciones completas: un listado filtrado daría
+		// por eliminados todos los registros fuera del rango
+		log.Println("[Scraper] Paso 4/5: Exportación filtrada por fechas, sin cálculo de cambios")
+	} else {
+		log.Println("[Scraper] Paso 4/5: Calculando cambios...")
+		s.computeChanges(result)
 	}
 
-	// 3. Logout
-	log.Println("[Scraper] Paso 3/3: Cerrando sesión...")
-	s.Auth.Logout(chromeCtx)
+	// 5. Importar en orden de dependencias
+	if opts.ImportMode != "" && len(result.Files) > 0 {
+		log.Println("[Scraper] Paso 5/5: Importando archivos...")
+		s.mu.Lock()
+		s.Status = StatusImporting
+		s.mu.Unlock()
+		s.importFiles(importCtx, result, opts)
+	} else {
+		log.Println("[Scraper] Paso 5/5: Importación automática desactivada")
+	}
 
 	// Finalizar
-	result.EndTime = time.Now()
-	result.Duration = result.EndTime.Sub(result.StartTime).String()
 	result.Success = len(result.Errors) == 0 && len(result.Files) > 0
 
 	if result.Success {
@@ -203,8 +311,10 @@ func (s *GCOScraper) Run(parentCtx context.Context) (*ScraperResult, error) {
 		log.Printf("[Scraper] Ejecución fallida. Errores: %d", len(result.Errors))
 	}
 
-	// Registrar métricas
+	// Registrar métricas e historial
+	s.finishRun(result)
 	s.Metrics.RecordRun(result)
+	s.purgeArchive(archiveRetentionFromEnv())
 
 	log.Println("[Scraper] ========================================")
 	log.Printf("[Scraper] Duración total: %s", result.Duration)
@@ -213,6 +323,134 @@ func (s *GCOScraper) Run(parentCtx context.Context) (*ScraperResult, error) {
 	return result, nil
 }
 
+// finishRun cierra la ejecución: duración, capturas, historial y /api/scraper/status
+func (s *GCOScraper) finishRun(result *ScraperResult) {
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime).String()
+	result.Screenshots = s.Downloader.Screenshots.Paths()
+
+	s.mu.Lock()
+	result.Status = s.Status
+	// Los tipos que no llegaron a descargarse (login fallido, cancelación)
+	for i := range result.Types {
+		if result.Types[i].Status == TypePending {
+			result.Types[i].Status = TypeCancelled
+		}
+	}
+	s.LastResult = result
+	s.current = nil
+	s.mu.Unlock()
+
+	saveRun(result)
+}
+
+// downloadTypes descarga los tipos de result.Types: en la pestaña del login o,
+// con parallel > 1, repartidos entre varias pestañas del mismo navegador (que
+// comparten la sesión). Los archivos quedan en el orden de la selección.
+func (s *GCOScraper) downloadTypes(ctx context.Context, result *ScraperResult, parallel int) {
+	n := len(result.Types)
+	files := make([]*DownloadedFile, n)
+	errs := make([]string, n)
+
+	download := func(tabCtx context.Context, i int) {
+		csvType := result.Types[i].Type
+		if ctx.Err() != nil {
+			s.updateType(result, i, func(t *TypeResult) { t.Status = TypeCancelled })
+			return
+		}
+
+		started := time.Now()
+		s.updateType(result, i, func(t *TypeResult) {
+			t.Status = TypeRunning
+			t.StartedAt = &started
+		})
+		log.Printf("[Scraper] Descargando %s (%d/%d)...", csvType, i+1, n)
+
+		file, err := s.Downloader.DownloadCSV(tabCtx, csvType, result.DateRange)
+		finished := time.Now()
+		s.updateType(result, i, func(t *TypeResult) {
+			t.FinishedAt = &finished
+			t.Duration = finished.Sub(started).Round(time.Millisecond).String()
+			switch {
+			case err == nil:
+				t.Status = TypeSuccess
+				t.Size = file.Size
+			case ctx.Err() != nil:
+				t.Status = TypeCancelled
+				t.Error = err.Error()
+			default:
+				t.Status = TypeFailed
+				t.Error = err.Error()
+			}
+		})
+
+		if err != nil {
+			errs[i] = fmt.Sprintf("Error descargando %s: %v", csvType, err)
+			log.Println("[Scraper] " + errs[i])
+			return
+		}
+		files[i] = file
+		log.Printf("[Scraper] %s descargado exitosamente", csvType)
+	}
+
+	if parallel > n {
+		parallel = n
+	}
+	if parallel <= 1 {
+		for i := 0; i < n; i++ {
+			download(ctx, i)
+		}
+	} else {
+		log.Printf("[Scraper] Descargando en %d pestañas", parallel)
+		jobs := make(chan int, n)
+		for i := 0; i < n; i++ {
+			jobs <- i
+		}
+		close(jobs)
+
+		var wg sync.WaitGroup
+		for w := 0; w < parallel; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				tabCtx := ctx
+				// La primera pestaña es la del login; si otra no abre, sus
+				// tipos se los quedan las demás
+				if w > 0 {
+					var closeTab context.CancelFunc
+					tabCtx, closeTab = chromedp.NewContext(ctx)
+					defer closeTab()
+					if err := s.Downloader.openHome(tabCtx); err != nil {
+						log.Printf("[Scraper] ⚠️ No se pudo abrir la pestaña %d: %v", w+1, err)
+						return
+					}
+				}
+				for i := range jobs {
+					download(tabCtx, i)
+				}
+			}(w)
+		}
+		wg.Wait()
+	}
+
+	for i := 0; i < n; i++ {
+		if files[i] != nil {
+			result.Files = append(result.Files, *files[i])
+		}
+		if errs[i] != "" {
+			result.Errors = append(result.Errors, errs[i])
+		}
+	}
+}
+
+// updateType actualiza el estado de un tipo (visible en /api/scraper/status
+// mientras la ejecución sigue en marcha)
+func (s *GCOScraper) updateType(result *ScraperResult, i int, update func(*TypeResult)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	update(&result.Types[i])
+}
+
 // Stop detiene la ejecución del scraper
 func (s *GCOScraper) Stop() error {
 	s.mu.Lock()
@@ -242,17 +480,27 @@ func (s *GCOScraper) GetStatus() map[string]interface{} {
 		lastErrorStr = s.LastError.Error()
 	}
 
-	return map[string]interface{}{
-		"status":     s.Status,
-		"is_running": s.IsRunning,
-		"last_run":   s.LastRun,
-		"last_error": lastErrorStr,
-		"base_url":   s.BaseURL,
+	status := map[string]interface{}{
+		"status":      s.Status,
+		"is_running":  s.IsRunning,
+		"last_run":    s.LastRun,
+		"last_error":  lastErrorStr,
+		"base_url":    s.BaseURL,
+		"import_mode": DefaultRunOptions().ImportMode,
+	}
+	if s.current != nil {
+		status["types"] = append([]TypeResult(nil), s.current.Types...)
+		status["date_range"] = s.current.DateRange
+	}
+	if s.LastResult != nil {
+		status["last_result"] = s.LastResult
+		status["import_job_ids"] = s.LastResult.ImportJobIDs
 	}
+	return status
 }
 
 // RunWithRetry ejecuta el scraper con reintentos
-func (s *GCOScraper) RunWithRetry(ctx context.Context, config RetryConfig) (*ScraperResult, error) {
+func (s *GCOScraper) RunWithRetry(ctx context.Context, config RetryConfig, opts RunOptions) (*ScraperResult, error) {
 	var lastResult *ScraperResult
 	var lastErr error
 
@@ -270,7 +518,7 @@ func (s *GCOScraper) RunWithRetry(ctx context.Context, config RetryConfig) (*Scr
 			}
 		}
 
-		result, err := s.Run(ctx)
+		result, err := s.Run(ctx, opts)
 		lastResult = result
 		lastErr = err
 
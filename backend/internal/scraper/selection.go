@@ -0,0 +1,241 @@
+package scraper
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"soriano-mediadores/internal/api"
+)
+
+// AllCSVTypes tipos que se descargan cuando no se indica selección
+var AllCSVTypes = []CSVType{CSVClientes, CSVPolizas, CSVRecibos, CSVSiniestros}
+
+// maxParallelTabs límite de pestañas simultáneas (el portal no aguanta más)
+const maxParallelTabs = 4
+
+const dateLayout = "2006-01-02"
+
+// DateRange rango de fechas (inclusive) con el que se filtra la exportación
+type DateRange struct {
+	From time.Time
+	To   time.Time
+}
+
+func (r DateRange) String() string {
+	return r.From.Format(dateLayout) + ".." + r.To.Format(dateLayout)
+}
+
+func (r DateRange) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]string{"from": r.From.Format(dateLayout), "to": r.To.Format(dateLayout)})
+}
+
+func (r *DateRange) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	rng, err := ParseDateRange(raw.From, raw.To)
+	if err != nil {
+		return err
+	}
+	*r = *rng
+	return nil
+}
+
+// ParseDateRange valida un rango YYYY-MM-DD (ambos extremos obligatorios)
+func ParseDateRange(from, to string) (*DateRange, error) {
+	if from == "" || to == "" {
+		return nil, fmt.Errorf("el rango de fechas necesita from y to")
+	}
+	f, err := time.ParseInLocation(dateLayout, from, time.Local)
+	if err != nil {
+		return nil, fmt.Errorf("fecha from inválida (YYYY-MM-DD): %s", from)
+	}
+	t, err := time.ParseInLocation(dateLayout, to, time.Local)
+	if err != nil {
+		return nil, fmt.Errorf("fecha to inválida (YYYY-MM-DD): %s", to)
+	}
+	if t.Before(f) {
+		return nil, fmt.Errorf("el rango de fechas está invertido: %s > %s", from, to)
+	}
+	return &DateRange{From: f, To: t}, nil
+}
+
+// lastDaysRange últimos n días contando hoy: ambos extremos están incluidos,
+// así que last_days=7 va de hace 6 días a hoy y last_days=1 es solo hoy
+func lastDaysRange(n int, now time.Time) *DateRange {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	return &DateRange{From: today.AddDate(0, 0, -(n - 1)), To: today}
+}
+
+// ParseCSVTypes lista separada por comas ("recibos,polizas"); vacío o "all" =
+// todos. Devuelve los tipos sin duplicados y en el orden de descarga.
+func ParseCSVTypes(list string) ([]CSVType, error) {
+	list = strings.TrimSpace(strings.ToLower(list))
+	if list == "" || list == "all" {
+		return nil, nil
+	}
+	requested := make(map[CSVType]bool)
+	for _, name := range strings.Split(list, ",") {
+		csvType := CSVType(strings.TrimSpace(name))
+		if !isCSVType(csvType) {
+			return nil, fmt.Errorf("tipo de CSV desconocido: %s (clientes, polizas, recibos, siniestros)", name)
+		}
+		requested[csvType] = true
+	}
+	var types []CSVType
+	for _, csvType := range AllCSVTypes {
+		if requested[csvType] {
+			types = append(types, csvType)
+		}
+	}
+	return types, nil
+}
+
+func isCSVType(csvType CSVType) bool {
+	for _, t := range AllCSVTypes {
+		if t == csvType {
+			return true
+		}
+	}
+	return false
+}
+
+// validate comprueba una selección de tipos, fechas y pestañas
+func (o RunOptions) validate() error {
+	for _, csvType := range o.Types {
+		if !isCSVType(csvType) {
+			return fmt.Errorf("tipo de CSV desconocido: %s", csvType)
+		}
+	}
+	if o.DateRange != nil && o.LastDays > 0 {
+		return fmt.Errorf("indica un rango de fechas o last_days, no ambos")
+	}
+	if o.LastDays < 0 || o.LastDays > 366 {
+		return fmt.Errorf("last_days debe estar entre 1 y 366")
+	}
+	if o.Parallel < 0 || o.Parallel > maxParallelTabs {
+		return fmt.Errorf("parallel debe estar entre 1 y %d", maxParallelTabs)
+	}
+	// replace borra lo que no viene en el archivo: con un listado filtrado por
+	// fechas se perdería todo lo de fuera del rango
+	if o.ImportMode == api.ModeReplace && (o.DateRange != nil || o.LastDays > 0) {
+		return fmt.Errorf("import_mode replace no admite rango de fechas (usa add o update)")
+	}
+	return nil
+}
+
+// selectedTypes tipos a descargar en orden (todos si no hay selección)
+func (o RunOptions) selectedTypes() []CSVType {
+	if len(o.Types) == 0 {
+		return AllCSVTypes
+	}
+	types, _ := ParseCSVTypes(joinTypes(o.Types))
+	return types
+}
+
+// dateRangeAt rango efectivo en el momento de la ejecución (last_days se
+// resuelve al ejecutar, así un schedule horario siempre mira la última semana)
+func (o RunOptions) dateRangeAt(now time.Time) *DateRange {
+	if o.LastDays > 0 {
+		return lastDaysRange(o.LastDays, now)
+	}
+	return o.DateRange
+}
+
+// parallelTabs pestañas simultáneas (GCO_PARALLEL_TABS si no se indica)
+func (o RunOptions) parallelTabs() int {
+	n := o.Parallel
+	if n == 0 {
+		n, _ = strconv.Atoi(os.Getenv("GCO_PARALLEL_TABS"))
+	}
+	if n < 1 {
+		return 1
+	}
+	if n > maxParallelTabs {
+		log.Printf("[Scraper] Máximo %d pestañas en paralelo (pedidas %d)", maxParallelTabs, n)
+		return maxParallelTabs
+	}
+	return n
+}
+
+func joinTypes(types []CSVType) string {
+	names := make([]string, len(types))
+	for i, t := range types {
+		names[i] = string(t)
+	}
+	return strings.Join(names, ",")
+}
+
+// parseRunSelection lee de la petición (query o form) la selección de tipos,
+// fechas y paralelismo: types=recibos,polizas&from=2026-10-01&to=2026-10-16
+// o last_days=7, parallel=2
+func parseRunSelection(get func(key string) string, opts *RunOptions) error {
+	types, err := ParseCSVTypes(get("types"))
+	if err != nil {
+		return err
+	}
+	opts.Types = types
+
+	if get("from") != "" || get("to") != "" {
+		rng, err := ParseDateRange(get("from"), get("to"))
+		if err != nil {
+			return err
+		}
+		opts.DateRange = rng
+	}
+	if v := get("last_days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return fmt.Errorf("last_days inválido: %s", v)
+		}
+		opts.LastDays = n
+	}
+	if v := get("parallel"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return fmt.Errorf("parallel inválido: %s", v)
+		}
+		opts.Parallel = n
+	}
+	return opts.validate()
+}
+
+// TypeStatus estado de la descarga de un tipo dentro de una ejecución
+type TypeStatus string
+
+const (
+	TypePending   TypeStatus = "pending"
+	TypeRunning   TypeStatus = "running"
+	TypeSuccess   TypeStatus = "success"
+	TypeFailed    TypeStatus = "failed"
+	TypeCancelled TypeStatus = "cancelled"
+)
+
+// TypeResult resultado de un tipo de CSV en una ejecución
+type TypeResult struct {
+	Type       CSVType    `json:"type"`
+	Status     TypeStatus `json:"status"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Duration   string     `json:"duration,omitempty"`
+	Size       int64      `json:"size,omitempty"`
+}
+
+// newTypeResults estado inicial (pendiente) de los tipos seleccionados
+func newTypeResults(types []CSVType) []TypeResult {
+	results := make([]TypeResult, len(types))
+	for i, csvType := range types {
+		results[i] = TypeResult{Type: csvType, Status: TypePending}
+	}
+	return results
+}
@@ -0,0 +1,135 @@
+package scraper
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"soriano-mediadores/internal/api"
+)
+
+func TestParseRunSelection(t *testing.T) {
+	parse := func(query string, mode api.ImportMode) (RunOptions, error) {
+		values, _ := url.ParseQuery(query)
+		opts := RunOptions{ImportMode: mode}
+		err := parseRunSelection(values.Get, &opts)
+		return opts, err
+	}
+
+	opts, err := parse("types=siniestros, RECIBOS,recibos&last_days=7&parallel=2", api.ModeUpdate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := joinTypes(opts.selectedTypes()); got != "recibos,siniestros" || opts.parallelTabs() != 2 {
+		t.Fatalf("tipos = %s, pestañas = %d", got, opts.parallelTabs())
+	}
+	now := time.Date(2026, 10, 16, 9, 30, 0, 0, time.Local)
+	if rng := opts.dateRangeAt(now); rng.String() != "2026-10-10..2026-10-16" {
+		t.Fatalf("last_days=7 → %s", rng)
+	}
+
+	opts, err = parse("types=all&from=2026-10-01&to=2026-10-15", "")
+	if err != nil || len(opts.selectedTypes()) != 4 || opts.dateRangeAt(now).String() != "2026-10-01..2026-10-15" {
+		t.Fatalf("opts = %+v, %v", opts, err)
+	}
+
+	for query, mode := range map[string]api.ImportMode{
+		"types=recibos,cobros":                      "",
+		"from=2026-10-15&to=2026-10-01":             "",
+		"from=2026-10-15":                           "",
+		"from=2026-10-01&to=2026-10-15&last_days=3": "",
+		"parallel=9":                                "",
+		"last_days=0":                               "",
+		"last_days=7":                               api.ModeReplace,
+	} {
+		if _, err := parse(query, mode); err == nil {
+			t.Errorf("%s (%s) aceptado", query, mode)
+		}
+	}
+
+	t.Setenv("GCO_PARALLEL_TABS", "3")
+	if n := (RunOptions{}).parallelTabs(); n != 3 {
+		t.Fatalf("GCO_PARALLEL_TABS=3 → %d", n)
+	}
+}
+
+func TestLastDaysRangeBoundaries(t *testing.T) {
+	// Justo antes de medianoche y a principio de mes: cuenta días naturales
+	now := time.Date(2026, 3, 2, 23, 59, 0, 0, time.Local)
+	for n, want := range map[int]string{
+		1:   "2026-03-02..2026-03-02",
+		2:   "2026-03-01..2026-03-02",
+		3:   "2026-02-28..2026-03-02",
+		366: "2025-03-02..2026-03-02",
+	} {
+		rng := lastDaysRange(n, now)
+		if rng.String() != want {
+			t.Errorf("last_days=%d → %s, want %s", n, rng, want)
+		}
+		if days := int(rng.To.Sub(rng.From).Hours()/24) + 1; days != n {
+			t.Errorf("last_days=%d cubre %d días", n, days)
+		}
+	}
+}
+
+func TestDateFilterProfileValidation(t *testing.T) {
+	profile := *mustEmbeddedProfile().PortalProfile
+	profile.Downloads = map[CSVType]DownloadConfig{}
+	for csvType, dl := range mustEmbeddedProfile().Downloads {
+		profile.Downloads[csvType] = dl
+	}
+	if profile.Downloads[CSVRecibos].DateFilter == nil {
+		t.Fatal("el perfil por defecto no filtra recibos por fechas")
+	}
+
+	dl := profile.Downloads[CSVRecibos]
+	dl.DateFilter = &DateFilterProfile{From: dl.DateFilter.From, Format: "2006-01"}
+	profile.Downloads[CSVRecibos] = dl
+
+	err, ok := profile.Validate().(*ProfileError)
+	if !ok || len(err.Problems) != 2 {
+		t.Fatalf("problemas = %v", err)
+	}
+}
+
+func TestRunSelectionAgainstReplay(t *testing.T) {
+	requireChrome(t)
+	useMemoryRunStore(t)
+
+	t.Setenv("GCO_DOWNLOAD_DIR", t.TempDir())
+	t.Setenv("GCO_LOG_DIR", t.TempDir())
+	t.Setenv("GCO_REPLAY_DIR", replayFixtures)
+	t.Setenv("GCO_PORTAL_PROFILE", "")
+
+	s := NewGCOScraper()
+	defer s.Replay.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	rng, _ := ParseDateRange("2026-10-01", "2026-10-15")
+	result, err := s.Run(ctx, RunOptions{Types: []CSVType{CSVSiniestros, CSVRecibos}, DateRange: rng, Parallel: 2})
+	if err != nil || !result.Success {
+		t.Fatalf("ejecución: %v, errores = %v", err, result.Errors)
+	}
+
+	if len(result.Files) != 2 || result.Files[0].Type != CSVRecibos || result.Files[1].Type != CSVSiniestros {
+		t.Fatalf("archivos = %+v", result.Files)
+	}
+	for _, typ := range result.Types {
+		if typ.Status != TypeSuccess || typ.StartedAt == nil || typ.Size == 0 {
+			t.Fatalf("estado por tipo = %+v", result.Types)
+		}
+	}
+	if s.Replay.Hits("GET /export/clientes") != 0 || s.Replay.Hits("GET /export/polizas") != 0 {
+		t.Fatal("se exportaron tipos no seleccionados")
+	}
+	for _, path := range []string{"/export/recibos", "/export/siniestros"} {
+		if q := s.Replay.ExportQueries(path); len(q) != 1 || q[0] != "desde=01%2F10%2F2026&hasta=15%2F10%2F2026" {
+			t.Fatalf("%s exportado con %q", path, q)
+		}
+	}
+	if len(result.Changes) != 0 {
+		t.Fatalf("cambios calculados sobre un listado filtrado: %v", result.Changes)
+	}
+}
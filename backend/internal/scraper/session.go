@@ -0,0 +1,205 @@
+package scraper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"soriano-mediadores/internal/secretbox"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/storage"
+	"github.com/chromedp/chromedp"
+)
+
+// savedSession cookies del portal guardadas entre ejecuciones. El archivo va
+// cifrado con GCO_SESSION_KEY: sin clave no se guarda nada.
+type savedSession struct {
+	BaseURL string            `json:"base_url"`
+	SavedAt time.Time         `json:"saved_at"`
+	Cookies []*network.Cookie `json:"cookies"`
+}
+
+// sessionFilePath GCO_SESSION_FILE o <downloadDir>/.gco_session
+func sessionFilePath(downloadDir string) string {
+	if path := os.Getenv("GCO_SESSION_FILE"); path != "" {
+		return path
+	}
+	return filepath.Join(downloadDir, ".gco_session")
+}
+
+// sessionBox cifrado del archivo de sesión (nil = no se reutilizan sesiones)
+func (am *AuthManager) sessionBox() *secretbox.Box {
+	if am.SessionFile == "" {
+		return nil
+	}
+	am.boxOnce.Do(func() {
+		box, err := secretbox.FromEnv("GCO_SESSION_KEY")
+		if err != nil {
+			if !errors.Is(err, secretbox.ErrNoKey) {
+				log.Printf("[Auth] ⚠️ GCO_SESSION_KEY inválida, no se reutilizan sesiones: %v", err)
+			}
+			return
+		}
+		am.box = box
+	})
+	return am.box
+}
+
+// ReusesSessions indica si la sesión del portal se guarda entre ejecuciones
+func (am *AuthManager) ReusesSessions() bool {
+	return am.sessionBox() != nil
+}
+
+// saveSession guarda cifradas las cookies actuales del navegador
+func (am *AuthManager) saveSession(ctx context.Context) error {
+	box := am.sessionBox()
+	if box == nil {
+		return nil
+	}
+
+	var cookies []*network.Cookie
+	err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		var err error
+		cookies, err = storage.GetCookies().Do(ctx)
+		return err
+	}))
+	if err != nil {
+		return fmt.Errorf("error leyendo cookies: %w", err)
+	}
+
+	data, err := json.Marshal(savedSession{BaseURL: am.BaseURL, SavedAt: time.Now(), Cookies: cookies})
+	if err != nil {
+		return err
+	}
+	sealed, err := box.Seal(data)
+	if err != nil {
+		return err
+	}
+
+	tmp := am.SessionFile + ".tmp"
+	if err := os.WriteFile(tmp, sealed, 0600); err != nil {
+		return fmt.Errorf("error guardando sesión: %w", err)
+	}
+	if err := os.Rename(tmp, am.SessionFile); err != nil {
+		return fmt.Errorf("error guardando sesión: %w", err)
+	}
+	log.Printf("[Auth] Sesión guardada (%d cookies)", len(cookies))
+	return nil
+}
+
+// loadSession lee el archivo de sesión; nil si no hay sesión utilizable
+func (am *AuthManager) loadSession() (*savedSession, error) {
+	box := am.sessionBox()
+	if box == nil {
+		return nil, nil
+	}
+	sealed, err := os.ReadFile(am.SessionFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, err := box.Open(sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	var session savedSession
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, err
+	}
+	if session.BaseURL != am.BaseURL {
+		return nil, nil // Sesión de otro portal (p. ej. del modo replay)
+	}
+
+	// Descartar las cookies caducadas
+	now := float64(time.Now().Unix())
+	valid := session.Cookies[:0]
+	for _, c := range session.Cookies {
+		if c.Session || c.Expires <= 0 || c.Expires > now {
+			valid = append(valid, c)
+		}
+	}
+	session.Cookies = valid
+	if len(valid) == 0 {
+		return nil, nil
+	}
+	return &session, nil
+}
+
+// restoreSession carga en el navegador las cookies guardadas
+func (am *AuthManager) restoreSession(ctx context.Context) (bool, error) {
+	session, err := am.loadSession()
+	if err != nil || session == nil {
+		return false, err
+	}
+
+	params := make([]*network.CookieParam, 0, len(session.Cookies))
+	for _, c := range session.Cookies {
+		param := &network.CookieParam{
+			Name:     c.Name,
+			Value:    c.Value,
+			Domain:   c.Domain,
+			Path:     c.Path,
+			Secure:   c.Secure,
+			HTTPOnly: c.HTTPOnly,
+			SameSite: c.SameSite,
+		}
+		if !c.Session && c.Expires > 0 {
+			expires := cdp.TimeSinceEpoch(time.Unix(int64(c.Expires), 0))
+			param.Expires = &expires
+		}
+		params = append(params, param)
+	}
+
+	err = chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		return storage.SetCookies(params).Do(ctx)
+	}))
+	if err != nil {
+		return false, fmt.Errorf("error restaurando cookies: %w", err)
+	}
+	log.Printf("[Auth] Sesión del %s restaurada (%d cookies)", session.SavedAt.Format("02/01 15:04"), len(params))
+	return true, nil
+}
+
+// ClearSession borra la sesión guardada
+func (am *AuthManager) ClearSession() {
+	if am.SessionFile == "" {
+		return
+	}
+	if err := os.Remove(am.SessionFile); err != nil && !os.IsNotExist(err) {
+		log.Printf("[Auth] ⚠️ Error borrando sesión guardada: %v", err)
+	}
+}
+
+// EnsureSession deja el navegador autenticado: reutiliza la sesión guardada si
+// el portal la sigue aceptando y, si no, hace login completo (con TOTP)
+func (am *AuthManager) EnsureSession(ctx context.Context) error {
+	am.SessionReused = false
+
+	restored, err := am.restoreSession(ctx)
+	if err != nil {
+		log.Printf("[Auth] ⚠️ Sesión guardada no utilizable: %v", err)
+		am.ClearSession()
+	}
+	if restored {
+		if am.IsSessionValid(ctx) {
+			am.IsLoggedIn = true
+			am.SessionReused = true
+			log.Println("[Auth] Sesión reutilizada, sin login")
+			return nil
+		}
+		log.Println("[Auth] La sesión guardada ha caducado, login completo")
+		am.ClearSession()
+	}
+
+	return am.Login(ctx)
+}
@@ -0,0 +1,138 @@
+package scraper
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// Vectores de RFC 6238 (SHA1, secreto "12345678901234567890"), 6 dígitos
+	secret := "gezd gnbv gy3t qojq gezd gnbv gy3t qojq"
+	for unix, want := range map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	} {
+		got, err := TOTPCode(secret, time.Unix(unix, 0))
+		if err != nil || got != want {
+			t.Fatalf("t=%d: %q, %v (want %s)", unix, got, err, want)
+		}
+	}
+	if _, err := TOTPCode("no-es-base32!", time.Now()); err == nil {
+		t.Fatal("secreto inválido aceptado")
+	}
+}
+
+func writeSession(t *testing.T, am *AuthManager, session savedSession) {
+	t.Helper()
+	data, _ := json.Marshal(session)
+	sealed, err := am.sessionBox().Seal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(am.SessionFile, sealed, 0600)
+}
+
+func TestLoadSession(t *testing.T) {
+	t.Setenv("GCO_SESSION_KEY", base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)))
+	am := &AuthManager{BaseURL: "https://gco.example", SessionFile: filepath.Join(t.TempDir(), ".gco_session")}
+	if !am.ReusesSessions() {
+		t.Fatal("con GCO_SESSION_KEY se deben reutilizar sesiones")
+	}
+
+	if s, err := am.loadSession(); s != nil || err != nil {
+		t.Fatalf("sin archivo: %v, %v", s, err)
+	}
+
+	now := float64(time.Now().Unix())
+	cookie := func(name string, expires float64) *network.Cookie {
+		return &network.Cookie{Name: name, Value: "x", Expires: expires, Session: expires == 0,
+			Priority: network.CookiePriorityMedium, SourceScheme: network.CookieSourceSchemeSecure}
+	}
+	writeSession(t, am, savedSession{BaseURL: am.BaseURL, SavedAt: time.Now(), Cookies: []*network.Cookie{
+		cookie("caducada", now-60), cookie("vigente", now+3600), cookie("de_sesion", 0),
+	}})
+	s, err := am.loadSession()
+	if err != nil || s == nil || len(s.Cookies) != 2 || s.Cookies[0].Name != "vigente" {
+		t.Fatalf("sesión = %+v, %v", s, err)
+	}
+
+	// Sesión de otro portal: se ignora
+	writeSession(t, am, savedSession{BaseURL: "http://127.0.0.1:9999", Cookies: []*network.Cookie{cookie("a", 0)}})
+	if s, err := am.loadSession(); s != nil || err != nil {
+		t.Fatalf("otro portal: %v, %v", s, err)
+	}
+
+	// El archivo va cifrado: manipulado o con otra clave no se usa
+	os.WriteFile(am.SessionFile, []byte(`{"base_url":"https://gco.example"}`), 0600)
+	if _, err := am.loadSession(); err == nil {
+		t.Fatal("archivo en claro aceptado")
+	}
+
+	am.ClearSession()
+	if _, err := os.Stat(am.SessionFile); !os.IsNotExist(err) {
+		t.Fatal("ClearSession no borró el archivo")
+	}
+
+	t.Setenv("GCO_SESSION_KEY", "")
+	plain := &AuthManager{SessionFile: am.SessionFile}
+	if plain.ReusesSessions() {
+		t.Fatal("sin clave no se deben guardar sesiones")
+	}
+}
+
+func TestRunReusesSessionAgainstReplay(t *testing.T) {
+	requireChrome(t)
+	useMemoryRunStore(t)
+
+	prevChanges := saveRunChanges
+	saveRunChanges = func(string, []RunChange) error { return nil }
+	t.Cleanup(func() { saveRunChanges = prevChanges })
+
+	t.Setenv("GCO_DOWNLOAD_DIR", t.TempDir())
+	t.Setenv("GCO_LOG_DIR", t.TempDir())
+	t.Setenv("GCO_REPLAY_DIR", replayFixtures)
+	t.Setenv("GCO_SESSION_KEY", base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32)))
+
+	s := NewGCOScraper()
+	defer s.Replay.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	run := func() *ScraperResult {
+		t.Helper()
+		result, err := s.Run(ctx, RunOptions{})
+		if err != nil || !result.Success {
+			t.Fatalf("ejecución: %v, errores = %v", err, result.Errors)
+		}
+		return result
+	}
+
+	// Primera: login con TOTP; la sesión queda guardada
+	if first := run(); first.SessionReused || s.Replay.Hits("POST /mfa") != 1 {
+		t.Fatalf("primera ejecución: reused = %v, mfa = %d", first.SessionReused, s.Replay.Hits("POST /mfa"))
+	}
+	if s.Replay.Hits("GET /logout") != 0 {
+		t.Fatal("se cerró la sesión que se iba a reutilizar")
+	}
+
+	// Segunda: sin login
+	if second := run(); !second.SessionReused || s.Replay.Hits("POST /login") != 1 {
+		t.Fatalf("segunda ejecución: reused = %v, logins = %d", second.SessionReused, s.Replay.Hits("POST /login"))
+	}
+
+	// Caducada en el portal: se detecta al sondear y se vuelve a entrar
+	s.Replay.ExpireSessions()
+	if third := run(); third.SessionReused || s.Replay.Hits("POST /login") != 2 || s.Replay.Hits("POST /mfa") != 2 {
+		t.Fatalf("tercera ejecución: reused = %v, logins = %d", third.SessionReused, s.Replay.Hits("POST /login"))
+	}
+}
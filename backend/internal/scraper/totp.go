@@ -0,0 +1,53 @@
+package scraper
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// totpPeriod ventana de validez de cada código (RFC 6238)
+const totpPeriod = 30 * time.Second
+
+// decodeTOTPSecret secreto base32 tal y como lo muestra el alta del segundo
+// factor (admite espacios, minúsculas y sin relleno)
+func decodeTOTPSecret(secret string) ([]byte, error) {
+	clean := strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(secret), " ", ""))
+	clean = strings.TrimRight(clean, "=")
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(clean)
+	if err != nil || len(key) == 0 {
+		return nil, fmt.Errorf("secreto TOTP inválido (se espera base32)")
+	}
+	return key, nil
+}
+
+// totpCounter ventana de 30s que contiene t
+func totpCounter(t time.Time) uint64 {
+	return uint64(t.Unix()) / uint64(totpPeriod/time.Second)
+}
+
+// totpCode código de 6 dígitos (HMAC-SHA1) para la ventana counter
+func totpCode(key []byte, counter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000)
+}
+
+// TOTPCode código actual para el secreto base32
+func TOTPCode(secret string, t time.Time) (string, error) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return totpCode(key, totpCounter(t)), nil
+}
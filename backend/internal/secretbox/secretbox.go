@@ -0,0 +1,108 @@
+package secretbox
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ErrNoKey la variable de entorno con la clave no está configurada
+var ErrNoKey = errors.New("clave de cifrado no configurada")
+
+// Box cifra y descifra secretos con AES-256-GCM (nonce aleatorio por mensaje)
+type Box struct {
+	aead cipher.AEAD
+}
+
+// New crea un Box con una clave de 32 bytes
+func New(key []byte) (*Box, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("la clave debe tener 32 bytes (tiene %d)", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Box{aead: aead}, nil
+}
+
+// FromEnv crea un Box con la clave de la variable indicada (32 bytes en
+// base64 o hex, p. ej. `openssl rand -base64 32`). ErrNoKey si está vacía.
+func FromEnv(name string) (*Box, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return nil, fmt.Errorf("%s: %w", name, ErrNoKey)
+	}
+	key, err := decodeKey(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return New(key)
+}
+
+func decodeKey(value string) ([]byte, error) {
+	if key, err := hex.DecodeString(value); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(value); err == nil {
+		return key, nil
+	}
+	if key, err := base64.RawURLEncoding.DecodeString(value); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("la clave debe ir en base64 o hex")
+}
+
+// Seal cifra plaintext; el resultado lleva el nonce delante
+func (b *Box) Seal(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return b.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Open descifra un mensaje de Seal (falla si se manipuló o la clave es otra)
+func (b *Box) Open(sealed []byte) ([]byte, error) {
+	size := b.aead.NonceSize()
+	if len(sealed) < size {
+		return nil, errors.New("mensaje cifrado demasiado corto")
+	}
+	plaintext, err := b.aead.Open(nil, sealed[:size], sealed[size:], nil)
+	if err != nil {
+		return nil, errors.New("no se pudo descifrar (clave distinta o datos manipulados)")
+	}
+	return plaintext, nil
+}
+
+// SealString cifra un texto y lo devuelve en base64 (para guardarlo en BD)
+func (b *Box) SealString(plaintext string) (string, error) {
+	sealed, err := b.Seal([]byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// OpenString descifra un texto de SealString
+func (b *Box) OpenString(sealed string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", fmt.Errorf("texto cifrado inválido: %w", err)
+	}
+	plaintext, err := b.Open(data)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
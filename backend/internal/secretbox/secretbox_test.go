@@ -0,0 +1,42 @@
+package secretbox
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+func TestSealOpen(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	t.Setenv("TEST_BOX_KEY", base64.StdEncoding.EncodeToString(key))
+	box, err := FromEnv("TEST_BOX_KEY")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := box.SealString("cookie=valor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := box.SealString("cookie=valor"); again == sealed {
+		t.Fatal("el nonce no es aleatorio")
+	}
+	if plain, err := box.OpenString(sealed); err != nil || plain != "cookie=valor" {
+		t.Fatalf("open = %q, %v", plain, err)
+	}
+
+	other, _ := New(bytes.Repeat([]byte{8}, 32))
+	if _, err := other.OpenString(sealed); err == nil {
+		t.Fatal("descifrado con otra clave")
+	}
+
+	t.Setenv("TEST_BOX_KEY", "")
+	if _, err := FromEnv("TEST_BOX_KEY"); !errors.Is(err, ErrNoKey) {
+		t.Fatalf("sin clave: %v", err)
+	}
+	t.Setenv("TEST_BOX_KEY", "corta")
+	if _, err := FromEnv("TEST_BOX_KEY"); err == nil {
+		t.Fatal("clave corta aceptada")
+	}
+}
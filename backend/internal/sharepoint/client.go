@@ -8,9 +8,7 @@ import (
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	msgraphsdk "github.com/microsoftgraph/msgraph-sdk-go"
-	"github.com/microsoftgraph/msgraph-sdk-go/drives"
 	"github.com/microsoftgraph/msgraph-sdk-go/models"
-	"github.com/microsoftgraph/msgraph-sdk-go/sites"
 )
 
 // Client es el cliente de SharePoint que encapsula el SDK de Microsoft Graph
@@ -116,8 +114,8 @@ func (c *Client) getSiteIDByURL(siteURL string) (string, error) {
 		return "", fmt.Errorf("URL de sitio inválida: %w", err)
 	}
 
-	// Llamar a Graph API para obtener el sitio
-	site, err := c.graphClient.Sites().ByHostnamePath(hostname, sitePath).Get(ctx, nil)
+	// Llamar a Graph API para obtener el sitio (GET /sites/{hostname}:{path})
+	site, err := c.graphClient.Sites().BySiteId(hostname+":"+sitePath).Get(ctx, nil)
 	if err != nil {
 		return "", fmt.Errorf("error obteniendo sitio: %w", err)
 	}
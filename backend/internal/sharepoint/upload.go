@@ -1,10 +1,8 @@
 package sharepoint
 
 import (
-	"bytes"
 	"context"
 	"fmt"
-	"io"
 	"log"
 	"path/filepath"
 	"strings"
@@ -70,8 +68,6 @@ func (c *Client) UploadFile(opts *UploadFileOptions) (*UploadResult, error) {
 // uploadSmallFile sube un archivo pequeño (<4MB) directamente
 func (c *Client) uploadSmallFile(ctx context.Context, itemPath string, opts *UploadFileOptions) (*UploadResult, error) {
 	// PUT /drives/{drive-id}/root:/{item-path}:/content
-	requestBody := bytes.NewReader(opts.FileContent)
-
 	requestConfig := &drives.ItemItemsItemContentRequestBuilderPutRequestConfiguration{}
 
 	uploadedItem, err := c.graphClient.
@@ -80,7 +76,7 @@ func (c *Client) uploadSmallFile(ctx context.Context, itemPath string, opts *Upl
 		Items().
 		ByDriveItemId("root:" + itemPath + ":").
 		Content().
-		Put(ctx, requestBody, requestConfig)
+		Put(ctx, opts.FileContent, requestConfig)
 
 	if err != nil {
 		return nil, fmt.Errorf("error subiendo archivo: %w", err)
@@ -97,11 +93,14 @@ func (c *Client) uploadLargeFile(ctx context.Context, itemPath string, opts *Upl
 	log.Printf("   Archivo grande detectado (%d MB), usando upload session...", len(opts.FileContent)/(1024*1024))
 
 	// Crear sesión de upload
-	uploadSessionBody := models.NewDriveItemUploadableProperties()
+	uploadProperties := models.NewDriveItemUploadableProperties()
 	if opts.Overwrite {
-		conflictBehavior := models.REPLACE_DRIVEITEMCONFLICTBEHAVIOR
-		uploadSessionBody.SetConflictBehavior(&conflictBehavior)
+		uploadProperties.SetAdditionalData(map[string]any{
+			"@microsoft.graph.conflictBehavior": "replace",
+		})
 	}
+	uploadSessionBody := drives.NewItemItemsItemCreateUploadSessionPostRequestBody()
+	uploadSessionBody.SetItem(uploadProperties)
 
 	uploadSession, err := c.graphClient.
 		Drives().
@@ -296,7 +295,7 @@ func (c *Client) DownloadFile(itemPath string) ([]byte, error) {
 	fullPath := "root:/" + strings.Trim(itemPath, "/")
 
 	// Obtener contenido del archivo
-	stream, err := c.graphClient.
+	content, err := c.graphClient.
 		Drives().
 		ByDriveId(c.driveID).
 		Items().
@@ -308,14 +307,6 @@ func (c *Client) DownloadFile(itemPath string) ([]byte, error) {
 		return nil, fmt.Errorf("error descargando archivo: %w", err)
 	}
 
-	defer stream.Close()
-
-	// Leer todo el contenido
-	content, err := io.ReadAll(stream)
-	if err != nil {
-		return nil, fmt.Errorf("error leyendo contenido: %w", err)
-	}
-
 	return content, nil
 }
 
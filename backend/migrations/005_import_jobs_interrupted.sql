@@ -0,0 +1,10 @@
+-- Migration: Track interrupted import jobs
+-- Created: 2026-10-16
+
+-- Jobs that were pending/processing when the server stopped are marked
+-- as 'interrupted' on boot (see api.RecoverInterruptedImports)
+COMMENT ON COLUMN import_jobs.status IS 'Current status: pending, processing, completed, failed, cancelled, interrupted';
+
+-- Recovery on boot looks up unfinished jobs
+CREATE INDEX IF NOT EXISTS idx_import_jobs_unfinished ON import_jobs(status)
+    WHERE status IN ('pending', 'processing');
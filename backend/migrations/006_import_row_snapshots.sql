@@ -0,0 +1,21 @@
+-- Migration: Row-level tracking for reverting imports
+-- Created: 2026-10-16
+
+-- One row per record an import inserted or modified.
+-- action = 'insert': the import created the row (revert deactivates it)
+-- action = 'update': before_data holds the row as it was before the import (revert restores it)
+CREATE TABLE IF NOT EXISTS import_row_snapshots (
+    id BIGSERIAL PRIMARY KEY,
+    import_id VARCHAR(255) NOT NULL REFERENCES import_jobs(id) ON DELETE CASCADE,
+    table_name VARCHAR(50) NOT NULL,  -- clientes, polizas, recibos, siniestros
+    row_id INTEGER NOT NULL,
+    action VARCHAR(20) NOT NULL,      -- insert, update
+    before_data JSONB,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (import_id, table_name, row_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_import_row_snapshots_import ON import_row_snapshots(import_id, table_name, action);
+
+COMMENT ON TABLE import_row_snapshots IS 'Before-images of rows touched by each import job, used by RevertImport';
+COMMENT ON COLUMN import_jobs.status IS 'Current status: pending, processing, completed, failed, cancelled, interrupted, reverted';
@@ -0,0 +1,9 @@
+-- Migration: Commit mode for batched imports
+-- Created: 2026-10-16
+
+-- commit_mode = 'job': the whole file is applied in one transaction (any failing row rolls back everything)
+-- commit_mode = 'chunk': every chunk_size rows are committed independently
+ALTER TABLE import_jobs ADD COLUMN IF NOT EXISTS commit_mode VARCHAR(20) NOT NULL DEFAULT 'job';
+ALTER TABLE import_jobs ADD COLUMN IF NOT EXISTS chunk_size INTEGER;
+
+COMMENT ON COLUMN import_jobs.commit_mode IS 'job (all or nothing) or chunk (commit every chunk_size rows)';
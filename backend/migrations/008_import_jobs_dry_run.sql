@@ -0,0 +1,9 @@
+-- Migration: Dry-run validation for imports
+-- Created: 2026-10-16
+
+-- dry_run = TRUE: the file is only validated (status ends as 'validated', nothing is written)
+-- accept_errors = TRUE: with validate_first, rows that failed validation are skipped and the rest imported
+ALTER TABLE import_jobs ADD COLUMN IF NOT EXISTS dry_run BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE import_jobs ADD COLUMN IF NOT EXISTS accept_errors BOOLEAN NOT NULL DEFAULT FALSE;
+
+COMMENT ON COLUMN import_jobs.status IS 'Current status: pending, processing, completed, failed, cancelled, interrupted, reverted, validated';
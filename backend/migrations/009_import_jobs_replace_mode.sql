@@ -0,0 +1,10 @@
+-- Migration: Replace mode (full snapshot imports)
+-- Created: 2026-10-16
+
+-- In replace mode every active record missing from the file is soft-deleted
+-- (activo = FALSE, import_id = the import). The import aborts if that would
+-- deactivate more than max_deactivate_pct percent of the active records.
+ALTER TABLE import_jobs ADD COLUMN IF NOT EXISTS deactivated_rows INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE import_jobs ADD COLUMN IF NOT EXISTS max_deactivate_pct NUMERIC(5,2);
+
+COMMENT ON COLUMN import_jobs.deactivated_rows IS 'Replace mode: records absent from the file that were deactivated';
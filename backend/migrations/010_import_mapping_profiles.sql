@@ -0,0 +1,26 @@
+-- Migration: Column-mapping profiles for CSV imports from other insurers
+-- Created: 2026-10-16
+
+CREATE TABLE IF NOT EXISTS import_mapping_profiles (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL UNIQUE,
+    type VARCHAR(50) NOT NULL,                          -- clientes, polizas, recibos, siniestros
+    description TEXT,
+    column_map JSONB NOT NULL,                          -- {"CSV column": "canonical field"}
+    delimiter VARCHAR(2) NOT NULL DEFAULT ';',          -- ; , | or \t
+    decimal_separator CHAR(1) NOT NULL DEFAULT ',',
+    date_format VARCHAR(20) NOT NULL DEFAULT 'DD/MM/YYYY',
+    encoding VARCHAR(20) NOT NULL DEFAULT 'utf-8',
+    header_fingerprint VARCHAR(40) NOT NULL,            -- sha1 of the normalized, sorted headers
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_import_mapping_profiles_type ON import_mapping_profiles(type);
+CREATE INDEX IF NOT EXISTS idx_import_mapping_profiles_fingerprint ON import_mapping_profiles(header_fingerprint);
+
+ALTER TABLE import_jobs ADD COLUMN IF NOT EXISTS profile_id INTEGER
+    REFERENCES import_mapping_profiles(id) ON DELETE SET NULL;
+
+COMMENT ON TABLE import_mapping_profiles IS 'Named CSV layouts (column mapping, delimiter, number/date format, encoding) selectable on import';
+COMMENT ON COLUMN import_jobs.profile_id IS 'Mapping profile used to read the file (NULL = Occident layout)';
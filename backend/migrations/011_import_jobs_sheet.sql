@@ -0,0 +1,8 @@
+-- Migration: Excel workbook imports
+-- Created: 2026-10-16
+
+-- Imports accept .xlsx workbooks as well as CSV. sheet records which sheet of
+-- the workbook was imported (NULL for CSV files).
+ALTER TABLE import_jobs ADD COLUMN IF NOT EXISTS sheet VARCHAR(100);
+
+COMMENT ON COLUMN import_jobs.sheet IS 'Workbook sheet imported (xlsx files only)';
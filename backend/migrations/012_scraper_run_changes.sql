@@ -0,0 +1,24 @@
+-- Migration: Change-data diff between consecutive scraper runs
+-- Created: 2026-10-16
+
+-- Each scraper run compares every downloaded export with the previous run's
+-- file of the same type and stores the records that changed: new clients,
+-- cancelled policies, receipts flipped to Devuelto/Retornado, new claims.
+CREATE TABLE IF NOT EXISTS scraper_run_changes (
+    id BIGSERIAL PRIMARY KEY,
+    run_id VARCHAR(255) NOT NULL,
+    csv_type VARCHAR(20) NOT NULL,
+    change_type VARCHAR(40) NOT NULL,
+    entity_key VARCHAR(255) NOT NULL,
+    previous_value TEXT,
+    current_value TEXT,
+    data JSONB,
+    detected_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_scraper_run_changes_run ON scraper_run_changes(run_id, change_type);
+CREATE INDEX IF NOT EXISTS idx_scraper_run_changes_detected ON scraper_run_changes(detected_at DESC);
+
+COMMENT ON TABLE scraper_run_changes IS 'Records that changed between consecutive GCO scraper snapshots';
+COMMENT ON COLUMN scraper_run_changes.change_type IS 'nuevo_cliente, poliza_anulada, recibo_devuelto, nuevo_siniestro';
+COMMENT ON COLUMN scraper_run_changes.previous_value IS 'Status in the previous snapshot (NULL if the record is new)';
@@ -0,0 +1,44 @@
+-- Migration: Persistent scraper run history and download archive
+-- Created: 2026-10-16
+
+-- One row per GCO scraper run. The run is inserted when it starts (status
+-- running) and updated when it ends; runs still running at startup are marked
+-- interrupted.
+CREATE TABLE IF NOT EXISTS scraper_runs (
+    id VARCHAR(255) PRIMARY KEY,
+    status VARCHAR(20) NOT NULL,
+    success BOOLEAN NOT NULL DEFAULT FALSE,
+    started_at TIMESTAMP NOT NULL,
+    ended_at TIMESTAMP,
+    duration_ms BIGINT,
+    errors JSONB NOT NULL DEFAULT '[]',
+    import_mode VARCHAR(20),
+    import_job_ids JSONB NOT NULL DEFAULT '[]',
+    changes JSONB,
+    screenshots JSONB NOT NULL DEFAULT '[]'
+);
+
+CREATE INDEX IF NOT EXISTS idx_scraper_runs_started ON scraper_runs(started_at DESC);
+CREATE INDEX IF NOT EXISTS idx_scraper_runs_status ON scraper_runs(status);
+
+-- Files downloaded by each run. archive_path is the archived copy; it is set
+-- to NULL (and purged_at filled) when the retention policy deletes it.
+CREATE TABLE IF NOT EXISTS scraper_run_files (
+    id BIGSERIAL PRIMARY KEY,
+    run_id VARCHAR(255) NOT NULL REFERENCES scraper_runs(id) ON DELETE CASCADE,
+    csv_type VARCHAR(20) NOT NULL,
+    path TEXT NOT NULL,
+    size BIGINT NOT NULL DEFAULT 0,
+    checksum VARCHAR(64),
+    archive_path TEXT,
+    import_job_id VARCHAR(255),
+    downloaded_at TIMESTAMP NOT NULL,
+    purged_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_scraper_run_files_run ON scraper_run_files(run_id);
+CREATE INDEX IF NOT EXISTS idx_scraper_run_files_archived ON scraper_run_files(downloaded_at) WHERE archive_path IS NOT NULL;
+
+COMMENT ON TABLE scraper_runs IS 'GCO scraper run history (timings, errors, imports, screenshots)';
+COMMENT ON COLUMN scraper_runs.status IS 'running, importing, success, failed, cancelled, interrupted';
+COMMENT ON COLUMN scraper_run_files.checksum IS 'SHA-256 of the downloaded file, checked before re-importing the archived copy';
@@ -0,0 +1,6 @@
+-- Migration: Record the portal profile version used by each scraper run
+-- Created: 2026-10-16
+
+-- Version of the GCO portal profile (selector configuration) the run navigated
+-- with, so a failing run can be traced back to a selector change.
+ALTER TABLE scraper_runs ADD COLUMN IF NOT EXISTS profile_version VARCHAR(50);
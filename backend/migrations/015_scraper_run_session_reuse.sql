@@ -0,0 +1,6 @@
+-- Migration: Record whether a scraper run reused the saved portal session
+-- Created: 2026-10-16
+
+-- TRUE when the run restored the encrypted session cookies and the portal
+-- still accepted them, so no login (and no TOTP code) was needed.
+ALTER TABLE scraper_runs ADD COLUMN IF NOT EXISTS session_reused BOOLEAN NOT NULL DEFAULT FALSE;
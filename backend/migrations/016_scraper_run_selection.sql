@@ -0,0 +1,11 @@
+-- Migration: Per-type status and date range of scraper runs
+-- Created: 2026-10-16
+
+-- Runs can download a subset of the CSV types, optionally filtered by date.
+-- types holds the status of each selected type:
+--   [{"type": "recibos", "status": "success", "duration": "41s", ...}]
+ALTER TABLE scraper_runs ADD COLUMN IF NOT EXISTS types JSONB NOT NULL DEFAULT '[]';
+
+-- Date filter applied in the portal before exporting (NULL = full export)
+ALTER TABLE scraper_runs ADD COLUMN IF NOT EXISTS date_from DATE;
+ALTER TABLE scraper_runs ADD COLUMN IF NOT EXISTS date_to DATE;
@@ -0,0 +1,32 @@
+-- Migration: Named, persisted scraper schedules
+-- Created: 2026-10-16
+
+-- Each schedule is one cron job of the GCO scraper with its own selection
+-- (types, last N days, parallel tabs, import mode) and timezone.
+-- blackouts lists windows in which the job does not run, in the schedule's
+-- timezone: [{"days": ["sat", "sun"]}, {"from": "13:30", "to": "16:00"}]
+CREATE TABLE IF NOT EXISTS scraper_schedules (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL UNIQUE,
+    cron VARCHAR(100) NOT NULL,                         -- Standard 5-field cron expression
+    timezone VARCHAR(64) NOT NULL DEFAULT 'Europe/Madrid',
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    types JSONB NOT NULL DEFAULT '[]',                  -- [] = all CSV types
+    last_days INTEGER NOT NULL DEFAULT 0,               -- 0 = full export
+    parallel INTEGER NOT NULL DEFAULT 0,                -- 0 = GCO_PARALLEL_TABS
+    import_mode VARCHAR(20),                            -- NULL = GCO_IMPORT_MODE, 'none' = no import
+    blackouts JSONB NOT NULL DEFAULT '[]',
+    last_run_at TIMESTAMP,
+    last_run_id VARCHAR(36),
+    last_status VARCHAR(20),                            -- success, partial, failed, skipped, blackout
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE scraper_runs ADD COLUMN IF NOT EXISTS schedule_id INTEGER
+    REFERENCES scraper_schedules(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_scraper_runs_schedule ON scraper_runs(schedule_id);
+
+COMMENT ON TABLE scraper_schedules IS 'Cron jobs of the GCO scraper, managed through /api/scraper/schedules';
+COMMENT ON COLUMN scraper_runs.schedule_id IS 'Schedule that triggered the run (NULL = manual)';
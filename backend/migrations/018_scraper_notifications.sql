@@ -0,0 +1,22 @@
+-- Migration: In-app notifications of scheduled scraper runs
+-- Created: 2026-10-16
+
+-- One row per notification that went through the in-app channel
+-- (GCO_NOTIFY_CHANNELS=inapp). payload keeps the full notification: files,
+-- sizes, per-type status, errors and screenshot paths.
+CREATE TABLE IF NOT EXISTS scraper_notifications (
+    id BIGSERIAL PRIMARY KEY,
+    event VARCHAR(20) NOT NULL,                         -- success, partial, failed, recovered
+    title VARCHAR(255) NOT NULL,
+    summary TEXT NOT NULL,
+    run_id VARCHAR(36),
+    schedule_id INTEGER REFERENCES scraper_schedules(id) ON DELETE SET NULL,
+    payload JSONB NOT NULL,
+    read_at TIMESTAMP,                                  -- NULL = unread
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_scraper_notifications_created ON scraper_notifications(created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_scraper_notifications_unread ON scraper_notifications(created_at DESC) WHERE read_at IS NULL;
+
+COMMENT ON TABLE scraper_notifications IS 'In-app notifications of the GCO scraper, served by /api/scraper/notifications';
@@ -0,0 +1,25 @@
+-- Migration: API keys for N8N and other machine clients
+-- Created: 2026-10-16
+
+-- The full key (smk_<prefix>_<secret>) is shown once at creation; only its
+-- SHA-256 is stored. scopes lists the permissions the key grants, e.g.
+-- ["n8n:call"] or ["analytics:read", "stats:read"].
+-- Keys with require_signature must sign every request with the signing
+-- secret, stored encrypted with API_KEY_ENCRYPTION_KEY.
+CREATE TABLE IF NOT EXISTS api_keys (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    prefix VARCHAR(16) NOT NULL UNIQUE,                 -- Lookup part of the key
+    key_hash CHAR(64) NOT NULL,                         -- SHA-256 hex of the full key
+    scopes JSONB NOT NULL DEFAULT '[]',
+    require_signature BOOLEAN NOT NULL DEFAULT FALSE,
+    signing_secret TEXT,                                -- Encrypted HMAC secret (NULL = no signing)
+    created_by VARCHAR(255),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP,                               -- NULL = never expires
+    last_used_at TIMESTAMP,
+    last_used_ip VARCHAR(64),
+    revoked_at TIMESTAMP
+);
+
+COMMENT ON TABLE api_keys IS 'API keys for integrations, managed through /api/api-keys';
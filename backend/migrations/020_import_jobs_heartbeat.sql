@@ -0,0 +1,13 @@
+-- Migration: Import job ownership and heartbeat
+-- Created: 2026-10-17
+
+-- With several server instances, recovery on boot must not mark another
+-- instance's live jobs as interrupted. instance_id records which instance
+-- processes the job and heartbeat_at is refreshed on every save (and
+-- periodically while it runs); only unfinished jobs with a stale heartbeat
+-- are recovered (see api.RecoverInterruptedImports).
+ALTER TABLE import_jobs ADD COLUMN IF NOT EXISTS instance_id VARCHAR(255);
+ALTER TABLE import_jobs ADD COLUMN IF NOT EXISTS heartbeat_at TIMESTAMP;
+
+COMMENT ON COLUMN import_jobs.instance_id IS 'Server instance (host:pid) processing the job';
+COMMENT ON COLUMN import_jobs.heartbeat_at IS 'Last sign of life of the processing instance';
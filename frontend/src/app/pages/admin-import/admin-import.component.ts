@@ -205,8 +205,8 @@ export class AdminImportComponent implements OnInit, OnDestroy {
     this.logger.debug('Archivo seleccionado', { fileName: file.name, size: file.size });
 
     // Validate file type
-    if (!file.name.endsWith('.csv')) {
-      this.snackBar.open('Por favor, selecciona un archivo CSV válido', 'Cerrar', {
+    if (!/\.(csv|xlsx)$/i.test(file.name)) {
+      this.snackBar.open('Por favor, selecciona un archivo CSV o Excel (.xlsx) válido', 'Cerrar', {
         duration: 3000,
         panelClass: ['error-snackbar']
       });